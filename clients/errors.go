@@ -0,0 +1,75 @@
+package clients
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Errors a price fetch fails with, besides ErrExchangeMaintenance and
+// breaker.ErrOpen. Callers match them with errors.Is to tell a client
+// mistake from an upstream failure, whatever error wraps them.
+var (
+	// ErrPairNotSupported is returned for pairs Kraken does not list
+	ErrPairNotSupported = errors.New("pair not supported")
+	// ErrUpstreamTimeout is returned when Kraken does not answer in time
+	ErrUpstreamTimeout = errors.New("upstream timed out")
+	// ErrUpstreamRateLimited is returned when Kraken answers 429
+	ErrUpstreamRateLimited = errors.New("upstream rate limited")
+	// ErrParse is returned for responses that are not a Ticker holding a
+	// valid price for the requested pair
+	ErrParse = errors.New("invalid upstream response")
+	// ErrResponseTooLarge is returned when reading an upstream response
+	// body larger than HTTPClientOptions.MaxResponseBytes
+	ErrResponseTooLarge = errors.New("upstream response too large")
+	// ErrBypassLimited is returned for a cached price older than the
+	// caller accepts when the caller may not skip the cache again yet
+	ErrBypassLimited = errors.New("too many requests bypassing the cache")
+)
+
+// StatusError is returned when an upstream answers with an HTTP status
+// other than the one expected; a 429 matches ErrUpstreamRateLimited
+type StatusError struct {
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected status %d", e.StatusCode)
+}
+
+func (e *StatusError) Is(target error) bool {
+	return target == ErrUpstreamRateLimited && e.StatusCode == http.StatusTooManyRequests
+}
+
+// ContentTypeError is returned when an upstream answers with something
+// other than JSON, such as an HTML error page; it matches ErrParse
+type ContentTypeError struct {
+	ContentType string
+}
+
+func (e *ContentTypeError) Error() string {
+	return fmt.Sprintf("unexpected content type %q", e.ContentType)
+}
+
+func (e *ContentTypeError) Is(target error) bool {
+	return target == ErrParse
+}
+
+// PriceParseError is returned when an exchange reports a price that is not
+// a positive decimal number; it matches ErrParse
+type PriceParseError struct {
+	Value string
+	Err   error
+}
+
+func (e *PriceParseError) Error() string {
+	return fmt.Sprintf("invalid price %q: %v", e.Value, e.Err)
+}
+
+func (e *PriceParseError) Unwrap() error {
+	return e.Err
+}
+
+func (e *PriceParseError) Is(target error) bool {
+	return target == ErrParse
+}
@@ -0,0 +1,177 @@
+package clients
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+
+	"github.com/chesskiss/btc-service/internal/middleware"
+	"github.com/chesskiss/btc-service/internal/version"
+)
+
+// HTTPClientOptions bounds how long each stage of an upstream call may
+// take and how many connections are kept open between calls. Zero values
+// take the defaults of DefaultHTTPClientOptions.
+type HTTPClientOptions struct {
+	// Timeout bounds a whole call, including reading the body
+	Timeout time.Duration
+	// DialTimeout bounds connecting, TLSHandshakeTimeout the handshake
+	// after it, and ResponseHeaderTimeout the wait for the response once
+	// the request is written
+	DialTimeout           time.Duration
+	TLSHandshakeTimeout   time.Duration
+	ResponseHeaderTimeout time.Duration
+	// KeepAlive is the TCP keep-alive period of open connections; up to
+	// MaxIdleConnsPerHost of them stay open for IdleConnTimeout between
+	// calls
+	KeepAlive           time.Duration
+	MaxIdleConnsPerHost int
+	IdleConnTimeout     time.Duration
+	// MaxResponseBytes bounds how much of a response body is read; reading
+	// past it fails with ErrResponseTooLarge
+	MaxResponseBytes int64
+	// UserAgent identifies the service to upstreams
+	UserAgent string
+	// Transport, when set, makes the calls instead of a transport built
+	// from the options above, e.g. to go through a proxy, add
+	// logging or replay recorded responses; Timeout, MaxResponseBytes and
+	// tracing still apply
+	Transport http.RoundTripper
+}
+
+// DefaultHTTPClientOptions keeps a hung exchange from stalling a request
+// for longer than a few seconds
+var DefaultHTTPClientOptions = HTTPClientOptions{
+	Timeout:               10 * time.Second,
+	DialTimeout:           3 * time.Second,
+	TLSHandshakeTimeout:   3 * time.Second,
+	ResponseHeaderTimeout: 5 * time.Second,
+	KeepAlive:             30 * time.Second,
+	MaxIdleConnsPerHost:   10,
+	IdleConnTimeout:       90 * time.Second,
+	MaxResponseBytes:      1 << 20,
+	UserAgent:             "btc-service/" + version.Version,
+}
+
+// NewHTTPClient returns a client for upstream calls with the given
+// timeouts and connection reuse. Each call is traced as an HTTP client
+// span, a child of the span in the request context, and carries that
+// trace to the upstream in its headers, along with the service's
+// User-Agent and, when made while serving a request, its X-Request-ID.
+func NewHTTPClient(opts HTTPClientOptions) *http.Client {
+	opts = opts.withDefaults()
+	transport := opts.Transport
+	if transport == nil {
+		transport = NewTransport(opts)
+	}
+	return &http.Client{
+		Timeout: opts.Timeout,
+		Transport: otelhttp.NewTransport(&upstreamTransport{
+			base:      transport,
+			userAgent: opts.UserAgent,
+			maxBytes:  opts.MaxResponseBytes,
+		}),
+	}
+}
+
+// upstreamTransport identifies the service and the request being served
+// on calls made by base, and caps the size of their response bodies, so a
+// misbehaving upstream cannot make the service buffer unbounded data
+type upstreamTransport struct {
+	base      http.RoundTripper
+	userAgent string
+	maxBytes  int64
+}
+
+func (t *upstreamTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	if req.Header.Get("User-Agent") == "" {
+		req.Header.Set("User-Agent", t.userAgent)
+	}
+	if requestID := middleware.GetRequestID(req.Context()); requestID != "" && req.Header.Get(middleware.RequestIDHeader) == "" {
+		req.Header.Set(middleware.RequestIDHeader, requestID)
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = &limitedBody{
+		ReadCloser: resp.Body,
+		reader:     io.LimitReader(resp.Body, t.maxBytes+1),
+		remaining:  t.maxBytes,
+	}
+	return resp, nil
+}
+
+// limitedBody fails reads past its limit with ErrResponseTooLarge
+type limitedBody struct {
+	io.ReadCloser
+	reader    io.Reader
+	remaining int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.reader.Read(p)
+	b.remaining -= int64(n)
+	if b.remaining < 0 {
+		return n + int(b.remaining), ErrResponseTooLarge
+	}
+	return n, err
+}
+
+// NewTransport returns the transport NewHTTPClient builds from opts, for
+// callers wrapping it in their own RoundTripper. It honours the
+// HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables.
+func NewTransport(opts HTTPClientOptions) *http.Transport {
+	opts = opts.withDefaults()
+	dialer := &net.Dialer{
+		Timeout:   opts.DialTimeout,
+		KeepAlive: opts.KeepAlive,
+	}
+	return &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		TLSHandshakeTimeout:   opts.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: opts.ResponseHeaderTimeout,
+		MaxIdleConns:          opts.MaxIdleConnsPerHost,
+		MaxIdleConnsPerHost:   opts.MaxIdleConnsPerHost,
+		IdleConnTimeout:       opts.IdleConnTimeout,
+	}
+}
+
+func (o HTTPClientOptions) withDefaults() HTTPClientOptions {
+	defaults := DefaultHTTPClientOptions
+	if o.Timeout <= 0 {
+		o.Timeout = defaults.Timeout
+	}
+	if o.DialTimeout <= 0 {
+		o.DialTimeout = defaults.DialTimeout
+	}
+	if o.TLSHandshakeTimeout <= 0 {
+		o.TLSHandshakeTimeout = defaults.TLSHandshakeTimeout
+	}
+	if o.ResponseHeaderTimeout <= 0 {
+		o.ResponseHeaderTimeout = defaults.ResponseHeaderTimeout
+	}
+	if o.KeepAlive <= 0 {
+		o.KeepAlive = defaults.KeepAlive
+	}
+	if o.MaxIdleConnsPerHost <= 0 {
+		o.MaxIdleConnsPerHost = defaults.MaxIdleConnsPerHost
+	}
+	if o.IdleConnTimeout <= 0 {
+		o.IdleConnTimeout = defaults.IdleConnTimeout
+	}
+	if o.MaxResponseBytes <= 0 {
+		o.MaxResponseBytes = defaults.MaxResponseBytes
+	}
+	if o.UserAgent == "" {
+		o.UserAgent = defaults.UserAgent
+	}
+	return o
+}
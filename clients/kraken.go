@@ -2,19 +2,31 @@ package clients
 
 import (
     "context"
+    "crypto/tls"
     "encoding/json"
+    "errors"
     "fmt"
     "io"
     "log/slog"
+    "mime"
+    "net"
     "net/http"
+    "strconv"
+    "strings"
+    "sync"
     "time"
 
     "go.opentelemetry.io/otel"
     "go.opentelemetry.io/otel/attribute"
     "go.opentelemetry.io/otel/codes"
+    "go.opentelemetry.io/otel/trace"
 
-    "github.com/chesskiss/btc-service/internal/metrics"
     "github.com/redis/go-redis/v9"
+
+    "github.com/chesskiss/btc-service/internal/breaker"
+    "github.com/chesskiss/btc-service/internal/cache"
+    "github.com/chesskiss/btc-service/internal/clock"
+    "github.com/chesskiss/btc-service/internal/metrics"
 )
 
 // For encoding/decoding Kraken JSON
@@ -30,22 +42,134 @@ type KrakenPair struct {
 // CachedPrice represents cached price data
 type CachedPrice struct {
     Price     float64   `json:"price"`
+    Decimal   string    `json:"decimal,omitempty"` // price exactly as reported by Kraken
     Timestamp time.Time `json:"timestamp"`
 }
 
-var redisClient *redis.Client
-var ctx = context.Background()
+// Quote is a price together with where it came from and how old it is
+type Quote struct {
+    Price     float64
+    Decimal   string    // exact decimal string reported by the exchange
+    Timestamp time.Time // when the price was fetched from the exchange
+    Source    string
+    Cached    bool
+    // Stale marks a last known price served because the exchange could
+    // not be reached
+    Stale bool
+    // FetchDuration is how long the upstream call took; zero for cache hits
+    FetchDuration time.Duration
+}
 
-// InitRedis initializes the Redis client
-func InitRedis(host, port, password string) *redis.Client {
-    redisClient = redis.NewClient(&redis.Options{
-        Addr:     fmt.Sprintf("%s:%s", host, port),
-        Password: password,
-        DB:       0,
-    })
+// parsePrice parses a price as reported by an exchange, rejecting
+// anything but a plain positive decimal such as "65000.10": no signs,
+// exponents, hex, NaN or Inf, and no trailing characters
+func parsePrice(value string) (float64, error) {
+    if strings.Trim(value, "0123456789.") != "" || strings.Count(value, ".") > 1 {
+        return 0, &PriceParseError{Value: value, Err: errors.New("not a decimal number")}
+    }
+    price, err := strconv.ParseFloat(value, 64)
+    if err != nil {
+        return 0, &PriceParseError{Value: value, Err: err}
+    }
+    if price <= 0 {
+        return 0, &PriceParseError{Value: value, Err: errors.New("not positive")}
+    }
+    return price, nil
+}
+
+// SourceKraken identifies prices fetched from the Kraken REST API
+const SourceKraken = "kraken"
+
+// RedisOptions says how to reach Redis: a single server at Addr; when
+// SentinelMaster is set, whichever server the sentinels at SentinelAddrs
+// report as that master's primary; or, when ClusterAddrs is set, a Redis
+// Cluster discovered from those nodes. Zero pool, timeout and retry
+// settings keep the go-redis defaults.
+type RedisOptions struct {
+    Addr     string
+    Username string
+    Password string
+    DB       int
+    TLS      bool
+
+    SentinelMaster   string
+    SentinelAddrs    []string
+    SentinelPassword string
+
+    ClusterAddrs []string
+
+    // PoolSize and MinIdleConns are per node
+    PoolSize     int
+    MinIdleConns int
+    ReadTimeout  time.Duration
+    WriteTimeout time.Duration
+    // MaxRetries of -1 disables retries
+    MaxRetries      int
+    MinRetryBackoff time.Duration
+    MaxRetryBackoff time.Duration
+}
+
+// NewRedisClient connects to Redis, logging whether it is reachable. With
+// Sentinel the client follows failovers to the new primary; with Cluster
+// each key goes to the shard owning its slot. Every cache command touches
+// a single key, so keys need no hash tags to stay on one shard.
+func NewRedisClient(opts RedisOptions) redis.UniversalClient {
+    var tlsConfig *tls.Config
+    if opts.TLS {
+        tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+    }
+
+    var redisClient redis.UniversalClient
+    if len(opts.ClusterAddrs) > 0 {
+        redisClient = redis.NewClusterClient(&redis.ClusterOptions{
+            Addrs:           opts.ClusterAddrs,
+            Username:        opts.Username,
+            Password:        opts.Password,
+            TLSConfig:       tlsConfig,
+            PoolSize:        opts.PoolSize,
+            MinIdleConns:    opts.MinIdleConns,
+            ReadTimeout:     opts.ReadTimeout,
+            WriteTimeout:    opts.WriteTimeout,
+            MaxRetries:      opts.MaxRetries,
+            MinRetryBackoff: opts.MinRetryBackoff,
+            MaxRetryBackoff: opts.MaxRetryBackoff,
+        })
+    } else if opts.SentinelMaster != "" {
+        redisClient = redis.NewFailoverClient(&redis.FailoverOptions{
+            MasterName:       opts.SentinelMaster,
+            SentinelAddrs:    opts.SentinelAddrs,
+            SentinelPassword: opts.SentinelPassword,
+            Username:         opts.Username,
+            Password:         opts.Password,
+            DB:               opts.DB,
+            TLSConfig:        tlsConfig,
+            PoolSize:         opts.PoolSize,
+            MinIdleConns:     opts.MinIdleConns,
+            ReadTimeout:      opts.ReadTimeout,
+            WriteTimeout:     opts.WriteTimeout,
+            MaxRetries:       opts.MaxRetries,
+            MinRetryBackoff:  opts.MinRetryBackoff,
+            MaxRetryBackoff:  opts.MaxRetryBackoff,
+        })
+    } else {
+        redisClient = redis.NewClient(&redis.Options{
+            Addr:            opts.Addr,
+            Username:        opts.Username,
+            Password:        opts.Password,
+            DB:              opts.DB,
+            TLSConfig:       tlsConfig,
+            PoolSize:        opts.PoolSize,
+            MinIdleConns:    opts.MinIdleConns,
+            ReadTimeout:     opts.ReadTimeout,
+            WriteTimeout:    opts.WriteTimeout,
+            MaxRetries:      opts.MaxRetries,
+            MinRetryBackoff: opts.MinRetryBackoff,
+            MaxRetryBackoff: opts.MaxRetryBackoff,
+        })
+    }
 
     // Test connection
-    _, err := redisClient.Ping(ctx).Result()
+    _, err := redisClient.Ping(context.Background()).Result()
     if err != nil {
         slog.Warn("failed to connect to Redis",
             "error", err,
@@ -58,15 +182,106 @@ func InitRedis(host, port, password string) *redis.Client {
     return redisClient
 }
 
+type maxAgeKey struct{}
+
+// maxAgeBound is the freshness a caller asked for, and whether it may
+// skip the cache to get it
+type maxAgeBound struct {
+    maxAge time.Duration
+    allow  func() bool
+}
+
+// WithMaxAge makes price lookups with the returned context skip cached
+// prices older than maxAge and fetch them live; zero always fetches. Each
+// time a cached price is too old, allow, which may be nil, is asked first,
+// and if it refuses the lookup fails with ErrBypassLimited. During
+// exchange maintenance cached prices are served regardless.
+func WithMaxAge(ctx context.Context, maxAge time.Duration, allow func() bool) context.Context {
+    return context.WithValue(ctx, maxAgeKey{}, maxAgeBound{maxAge: maxAge, allow: allow})
+}
+
+func maxAgeFrom(ctx context.Context) (maxAgeBound, bool) {
+    bound, ok := ctx.Value(maxAgeKey{}).(maxAgeBound)
+    return bound, ok
+}
+
 // GetBTCPrice fetches the BTC price in the given currency from Kraken API
-// with Redis caching support
-func GetBTCPrice(ctx context.Context, currency string) (float64, error) {
+// with caching support
+func (s *PriceService) GetBTCPrice(ctx context.Context, currency string) (float64, error) {
+    quote, err := s.GetBTCQuote(ctx, currency)
+    if err != nil {
+        return 0, err
+    }
+    return quote.Price, nil
+}
+
+// GetBTCQuote is like GetBTCPrice but also reports the exact decimal
+// price, when it was fetched and whether it was served from cache
+func (s *PriceService) GetBTCQuote(ctx context.Context, currency string) (Quote, error) {
+    return s.getQuote(ctx, currency, s.getFromCache)
+}
+
+// GetBTCQuotes is GetBTCQuote for several currencies, reading all their
+// cached prices in one round trip and fetching the missing ones
+// concurrently, up to MaxConcurrentFetches at a time. The i-th quote and
+// error are for the i-th currency.
+func (s *PriceService) GetBTCQuotes(ctx context.Context, currencies []string) ([]Quote, []error) {
+    lookup := s.getFromCache
+    if s.cache != nil && len(currencies) > 1 {
+        keys := make([]string, len(currencies))
+        for i, currency := range currencies {
+            keys[i] = priceCacheKey(fmt.Sprintf("BTC/%s", currency))
+        }
+        if cached, err := s.getManyFromCache(ctx, keys); err == nil {
+            lookup = func(_ context.Context, key string) (*CachedPrice, error) {
+                if price, ok := cached[key]; ok {
+                    return price, nil
+                }
+                return nil, cache.ErrMiss
+            }
+        } else {
+            slog.Warn("batched cache read error",
+                "keys", len(keys),
+                "error", err,
+            )
+        }
+    }
+
+    quotes := make([]Quote, len(currencies))
+    errs := make([]error, len(currencies))
+    workers := min(s.opts.MaxConcurrentFetches, len(currencies))
+    if workers <= 1 {
+        for i, currency := range currencies {
+            quotes[i], errs[i] = s.getQuote(ctx, currency, lookup)
+        }
+        return quotes, errs
+    }
+
+    next := make(chan int)
+    var wg sync.WaitGroup
+    for range workers {
+        wg.Go(func() {
+            for i := range next {
+                quotes[i], errs[i] = s.getQuote(ctx, currencies[i], lookup)
+            }
+        })
+    }
+    for i := range currencies {
+        next <- i
+    }
+    close(next)
+    wg.Wait()
+    return quotes, errs
+}
+
+// getQuote implements GetBTCQuote, reading the cached price with lookup
+func (s *PriceService) getQuote(ctx context.Context, currency string, lookup func(ctx context.Context, key string) (*CachedPrice, error)) (Quote, error) {
     tracer := otel.Tracer("btc-service")
     ctx, span := tracer.Start(ctx, "get_btc_price")
     defer span.End()
 
     pair := fmt.Sprintf("BTC/%s", currency)
-    cacheKey := fmt.Sprintf("price:%s", pair)
+    cacheKey := priceCacheKey(pair)
 
     span.SetAttributes(
         attribute.String("currency", currency),
@@ -74,26 +289,63 @@ func GetBTCPrice(ctx context.Context, currency string) (float64, error) {
         attribute.String("cache_key", cacheKey),
     )
 
-    // Try to get from cache first
-    if redisClient != nil {
+    window, inMaintenance := ActiveMaintenance()
+    span.SetAttributes(attribute.Bool("exchange.maintenance", inMaintenance))
+    circuitOpen := s.breaker != nil && s.breaker.State() == breaker.StateOpen
+    span.SetAttributes(attribute.Bool("exchange.circuit_open", circuitOpen))
+
+    // Try to get from cache first; during maintenance or while the circuit
+    // breaker is open any cached price is served, however old, rather than
+    // calling the exchange
+    if s.cache != nil {
         _, cacheSpan := tracer.Start(ctx, "check_cache")
-        cachedPrice, err := getFromCache(cacheKey)
+        cachedPrice, err := lookup(ctx, cacheKey)
         cacheSpan.End()
 
-        if err == nil && isCacheFresh(cachedPrice) {
+        // Older than the caller accepts: fetch it live, if the caller may
+        if bound, bounded := maxAgeFrom(ctx); err == nil && bounded && !inMaintenance && clock.Since(cachedPrice.Timestamp) > bound.maxAge {
+            if bound.allow != nil && !bound.allow() {
+                span.SetStatus(codes.Error, "cache bypass limited")
+                return Quote{}, fmt.Errorf("%s: %w", pair, ErrBypassLimited)
+            }
+            span.SetAttributes(attribute.Bool("cache_bypassed", true))
+            cachedPrice, err = nil, cache.ErrMiss
+        }
+
+        if err == nil && (inMaintenance || circuitOpen || s.isCacheFresh(cachedPrice)) {
             slog.Info("cache hit",
                 "pair", pair,
                 "price", cachedPrice.Price,
             )
-            metrics.CacheHitsTotal.Inc()
+            metrics.RecordCacheHit(pair)
             span.SetAttributes(
                 attribute.Bool("cache_hit", true),
                 attribute.Float64("price", cachedPrice.Price),
             )
             span.SetStatus(codes.Ok, "cache hit")
-            return cachedPrice.Price, nil
+            s.markActive(currency)
+            return cachedQuote(cachedPrice), nil
         }
-        if err != nil && err != redis.Nil {
+        // Past its freshness but within the stale window: answer now and
+        // let a background fetch replace it
+        if err == nil && s.isCacheRevalidatable(cachedPrice) {
+            slog.Info("serving stale price while refreshing",
+                "pair", pair,
+                "price", cachedPrice.Price,
+            )
+            metrics.RecordCacheHit(pair)
+            metrics.CacheStaleHitsTotal.Inc()
+            span.SetAttributes(
+                attribute.Bool("cache_hit", true),
+                attribute.Bool("cache_stale", true),
+                attribute.Float64("price", cachedPrice.Price),
+            )
+            span.SetStatus(codes.Ok, "stale cache hit")
+            s.refreshInBackground(pair, currency, cacheKey)
+            s.markActive(currency)
+            return cachedQuote(cachedPrice), nil
+        }
+        if err != nil && !errors.Is(err, cache.ErrMiss) {
             slog.Warn("cache read error",
                 "key", cacheKey,
                 "error", err,
@@ -102,41 +354,143 @@ func GetBTCPrice(ctx context.Context, currency string) (float64, error) {
     }
 
     // Cache miss - fetch from Kraken API
-    metrics.CacheMissesTotal.Inc()
+    metrics.RecordCacheMiss()
+
+    if inMaintenance {
+        metrics.KrakenMaintenanceSkippedTotal.Inc()
+        slog.Warn("skipping Kraken fetch during maintenance",
+            "pair", pair,
+            "maintenance", window.Name,
+        )
+        if quote, ok := s.lastKnownQuote(ctx, pair, cacheKey); ok {
+            span.SetStatus(codes.Ok, "last known price")
+            return quote, nil
+        }
+        span.SetStatus(codes.Error, "exchange maintenance")
+        span.RecordError(ErrExchangeMaintenance)
+        return Quote{}, ErrExchangeMaintenance
+    }
+
     slog.Info("cache miss, fetching from Kraken",
         "pair", pair,
     )
 
     span.SetAttributes(attribute.Bool("cache_hit", false))
 
-    _, krakenSpan := tracer.Start(ctx, "fetch_from_kraken")
-    krakenSpan.SetAttributes(
-        attribute.String("pair", pair),
-        attribute.String("currency", currency),
+    // Let one caller across replicas fetch the pair while the rest wait
+    cached, release := s.acquireFetch(ctx, cacheKey)
+    if cached != nil {
+        span.SetAttributes(attribute.Bool("fetch_lock_waited", true))
+        span.SetStatus(codes.Ok, "fetched by lock holder")
+        s.markActive(currency)
+        return cachedQuote(cached), nil
+    }
+    if release != nil {
+        defer release()
+    }
+
+    quote, err := s.fetchAndCache(ctx, pair, currency, cacheKey)
+    if err == nil {
+        s.markActive(currency)
+    }
+    if err != nil && !errors.Is(err, ErrPairNotSupported) {
+        if lastKnown, ok := s.lastKnownQuote(ctx, pair, cacheKey); ok {
+            lastKnown.FetchDuration = quote.FetchDuration
+            span.SetStatus(codes.Ok, "last known price")
+            return lastKnown, nil
+        }
+    }
+    return quote, err
+}
+
+// lastKnownQuote returns the pair's last known good price, flagged as
+// stale, if one is kept and is no older than LastKnownMaxAge
+func (s *PriceService) lastKnownQuote(ctx context.Context, pair, cacheKey string) (Quote, bool) {
+    if s.cache == nil || s.opts.LastKnownMaxAge <= 0 {
+        return Quote{}, false
+    }
+
+    cached, err := s.getFromCache(ctx, lastKnownKey(cacheKey))
+    if err != nil || clock.Since(cached.Timestamp) > s.opts.LastKnownMaxAge {
+        return Quote{}, false
+    }
+
+    slog.Warn("serving last known price",
+        "pair", pair,
+        "price", cached.Price,
+        "fetched_at", cached.Timestamp,
     )
-    price, err := fetchFromKraken(currency)
+    metrics.LastKnownPricesServedTotal.Inc()
+    quote := cachedQuote(cached)
+    quote.Stale = true
+    return quote, true
+}
+
+// priceCacheKey is where the pair's price is cached
+func priceCacheKey(pair string) string {
+    return "price:" + pair
+}
+
+// lastKnownKey is where the long-lived copy of a cached price is kept
+func lastKnownKey(cacheKey string) string {
+    return "last_known:" + cacheKey
+}
+
+// fetchAndCache fetches the pair's price from Kraken and caches it,
+// recording the outcome on the span in ctx. While the circuit breaker is
+// open Kraken is not called.
+func (s *PriceService) fetchAndCache(ctx context.Context, pair, currency, cacheKey string) (Quote, error) {
+    span := trace.SpanFromContext(ctx)
+
+    if s.breaker != nil {
+        if err := s.breaker.Allow(); err != nil {
+            err = fmt.Errorf("kraken: %w", err)
+            span.SetStatus(codes.Error, "circuit breaker open")
+            span.RecordError(err)
+            return Quote{}, err
+        }
+    }
+
+    // Each attempt is traced by the HTTP client as a child of span
+    fetchStart := time.Now()
+    decimal, price, err := s.fetchWithRetry(ctx, currency)
+    fetchDuration := time.Since(fetchStart)
+    fetchedAt := clock.Now()
+
+    // A call the caller gave up on says nothing about Kraken
+    if err != nil && ctx.Err() != nil {
+        if s.breaker != nil {
+            s.breaker.Abandon()
+        }
+        slog.Info("kraken call cancelled",
+            "pair", pair,
+            "error", ctx.Err(),
+        )
+        span.SetStatus(codes.Error, "cancelled")
+        return Quote{FetchDuration: fetchDuration}, err
+    }
+
+    recordKrakenResult(err)
+    if s.breaker != nil {
+        s.breaker.Record(err == nil || errors.Is(err, ErrPairNotSupported))
+    }
     if err != nil {
         metrics.KrakenAPIErrorsTotal.Inc()
         slog.Error("kraken API error",
             "pair", pair,
             "error", err,
         )
-        krakenSpan.SetStatus(codes.Error, "kraken API error")
-        krakenSpan.RecordError(err)
-        krakenSpan.End()
         span.SetStatus(codes.Error, "failed to fetch price")
         span.RecordError(err)
-        return 0, err
+        return Quote{FetchDuration: fetchDuration}, err
     }
 
     metrics.KrakenAPICallsTotal.Inc()
-    krakenSpan.SetAttributes(attribute.Float64("price", price))
-    krakenSpan.SetStatus(codes.Ok, "success")
-    krakenSpan.End()
 
-    // Cache the result
-    if redisClient != nil {
-        if err := saveToCache(cacheKey, price); err != nil {
+    // Cache the result, even if the caller has since given up, as other
+    // callers want it too
+    if s.cache != nil {
+        if err := s.saveToCache(context.WithoutCancel(ctx), cacheKey, price, decimal, fetchedAt); err != nil {
             slog.Warn("cache write error",
                 "key", cacheKey,
                 "error", err,
@@ -146,34 +500,105 @@ func GetBTCPrice(ctx context.Context, currency string) (float64, error) {
 
     span.SetAttributes(attribute.Float64("price", price))
     span.SetStatus(codes.Ok, "success")
-    return price, nil
+    return Quote{
+        Price:         price,
+        Decimal:       decimal,
+        Timestamp:     fetchedAt,
+        Source:        SourceKraken,
+        FetchDuration: fetchDuration,
+    }, nil
+}
+
+// refreshInBackground fetches and caches the pair's price without blocking
+// the caller, unless a refresh of the pair is already running
+func (s *PriceService) refreshInBackground(pair, currency, cacheKey string) {
+    if _, running := s.refreshing.LoadOrStore(pair, struct{}{}); running {
+        return
+    }
+
+    go func() {
+        defer s.refreshing.Delete(pair)
+
+        ctx, span := otel.Tracer("btc-service").Start(context.Background(), "refresh_price")
+        defer span.End()
+        span.SetAttributes(attribute.String("pair", pair))
+
+        s.fetchAndCache(ctx, pair, currency, cacheKey)
+    }()
+}
+
+// cachedQuote builds the quote for a price served from cache
+func cachedQuote(cached *CachedPrice) Quote {
+    return Quote{
+        Price:     cached.Price,
+        Decimal:   decimalOrFormatted(cached.Decimal, cached.Price),
+        Timestamp: cached.Timestamp,
+        Source:    SourceKraken,
+        Cached:    true,
+    }
+}
+
+// decimalOrFormatted returns the exact decimal string when known, falling
+// back to formatting the float for entries cached before it was stored
+func decimalOrFormatted(decimal string, price float64) string {
+    if decimal != "" {
+        return decimal
+    }
+    return strconv.FormatFloat(price, 'f', -1, 64)
 }
 
-// getFromCache retrieves cached price data from Redis
-func getFromCache(key string) (*CachedPrice, error) {
-    val, err := redisClient.Get(ctx, key).Result()
+// getFromCache retrieves cached price data
+func (s *PriceService) getFromCache(ctx context.Context, key string) (*CachedPrice, error) {
+    val, err := s.cache.Get(ctx, key)
     if err != nil {
         return nil, err
     }
 
     var cached CachedPrice
-    if err := json.Unmarshal([]byte(val), &cached); err != nil {
+    if err := json.Unmarshal(val, &cached); err != nil {
         return nil, fmt.Errorf("failed to unmarshal cached data: %w", err)
     }
 
     return &cached, nil
 }
 
-// isCacheFresh checks if cached data is less than 60 seconds old
-func isCacheFresh(cached *CachedPrice) bool {
-    return time.Since(cached.Timestamp) < 60*time.Second
+// getManyFromCache retrieves the cached price data under each of keys;
+// missing and unreadable entries are left out
+func (s *PriceService) getManyFromCache(ctx context.Context, keys []string) (map[string]*CachedPrice, error) {
+    values, err := s.cache.GetMany(ctx, keys)
+    if err != nil {
+        return nil, err
+    }
+
+    cached := make(map[string]*CachedPrice, len(values))
+    for key, val := range values {
+        var price CachedPrice
+        if err := json.Unmarshal(val, &price); err != nil {
+            continue
+        }
+        cached[key] = &price
+    }
+    return cached, nil
+}
+
+// isCacheFresh checks if cached data is younger than the cache TTL
+func (s *PriceService) isCacheFresh(cached *CachedPrice) bool {
+    return clock.Since(cached.Timestamp) < s.opts.CacheTTL
 }
 
-// saveToCache stores price data in Redis with 60-second TTL
-func saveToCache(key string, price float64) error {
+// isCacheRevalidatable checks if stale cached data may still be served
+// while it is refreshed
+func (s *PriceService) isCacheRevalidatable(cached *CachedPrice) bool {
+    return clock.Since(cached.Timestamp) < s.opts.CacheTTL+s.opts.StaleWhileRevalidate
+}
+
+// saveToCache stores price data for the cache TTL plus the stale window,
+// and a last known good copy when that fallback is enabled
+func (s *PriceService) saveToCache(ctx context.Context, key string, price float64, decimal string, fetchedAt time.Time) error {
     cached := CachedPrice{
         Price:     price,
-        Timestamp: time.Now(),
+        Decimal:   decimal,
+        Timestamp: fetchedAt,
     }
 
     data, err := json.Marshal(cached)
@@ -186,43 +611,74 @@ func saveToCache(key string, price float64) error {
         "price", price,
     )
 
-    return redisClient.Set(ctx, key, data, 60*time.Second).Err()
+    if s.opts.LastKnownMaxAge > 0 {
+        if err := s.cache.Set(ctx, lastKnownKey(key), data, s.opts.LastKnownMaxAge); err != nil {
+            return err
+        }
+    }
+    return s.cache.Set(ctx, key, data, s.opts.CacheTTL+s.opts.StaleWhileRevalidate)
 }
 
-// fetchFromKraken fetches price from Kraken API
-func fetchFromKraken(currency string) (float64, error) {
-    pair := fmt.Sprintf("XBT%s", currency)
-    url := fmt.Sprintf("https://api.kraken.com/0/public/Ticker?pair=%s", pair)
+// fetchFromKraken fetches price from Kraken API, returning both the raw
+// decimal string and its parsed value. The call is abandoned when ctx is
+// done.
+func (s *PriceService) fetchFromKraken(ctx context.Context, currency string) (string, float64, error) {
+    pair := KrakenSymbolFor(currency).Request
+    url := fmt.Sprintf("%s/0/public/Ticker?pair=%s", s.opts.KrakenBaseURL, pair)
 
-    resp, err := http.Get(url)
+    req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
     if err != nil {
-        return 0, fmt.Errorf("failed to make request: %w", err)
+        return "", 0, fmt.Errorf("failed to build request: %w", err)
+    }
+
+    resp, err := s.opts.HTTPClient.Do(req)
+    if err != nil {
+        var netErr net.Error
+        if errors.As(err, &netErr) && netErr.Timeout() {
+            return "", 0, fmt.Errorf("%w: %w", ErrUpstreamTimeout, err)
+        }
+        return "", 0, fmt.Errorf("failed to make request: %w", err)
     }
     defer resp.Body.Close()
 
+    // Rate limiting, server errors and the HTML pages proxies answer with
+    // carry no ticker to parse
+    if resp.StatusCode != http.StatusOK {
+        return "", 0, &StatusError{StatusCode: resp.StatusCode}
+    }
+    if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType != "application/json" {
+        return "", 0, &ContentTypeError{ContentType: resp.Header.Get("Content-Type")}
+    }
+
     body, err := io.ReadAll(resp.Body)
     if err != nil {
-        return 0, fmt.Errorf("failed to read response: %w", err)
+        return "", 0, fmt.Errorf("failed to read response: %w", err)
     }
 
     var krakenResp KrakenResponse
     if err := json.Unmarshal(body, &krakenResp); err != nil {
-        return 0, fmt.Errorf("failed to parse response: %w", err)
+        return "", 0, fmt.Errorf("%w: %w", ErrParse, err)
     }
 
     if len(krakenResp.Error) > 0 {
-        return 0, fmt.Errorf("kraken API error: %v", krakenResp.Error)
-    }
-
-    for _, pairData := range krakenResp.Result {
-        if len(pairData.C) > 0 {
-            var price float64
-            if _, err := fmt.Sscanf(pairData.C[0], "%f", &price); err != nil {
-                return 0, fmt.Errorf("failed to parse price: %w", err)
+        for _, krakenErr := range krakenResp.Error {
+            if strings.HasPrefix(krakenErr, "EQuery:Unknown asset pair") {
+                return "", 0, fmt.Errorf("%w: %s", ErrPairNotSupported, pair)
             }
-            return price, nil
         }
+        return "", 0, fmt.Errorf("kraken API error: %v", krakenResp.Error)
     }
 
-    return 0, fmt.Errorf("no price data found")
+    // Pick the requested pair's entry by name rather than taking whichever
+    // comes first, as a response may hold several pairs
+    pairData, ok := krakenResult(krakenResp.Result, currency)
+    if !ok || len(pairData.C) == 0 {
+        return "", 0, fmt.Errorf("%w: no price data found for %s", ErrParse, pair)
+    }
+    price, err := parsePrice(pairData.C[0])
+    if err != nil {
+        return "", 0, fmt.Errorf("failed to parse price: %w", err)
+    }
+    s.cacheRawTicker(context.WithoutCancel(ctx), currency, body, clock.Now())
+    return pairData.C[0], price, nil
 }
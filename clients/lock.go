@@ -0,0 +1,70 @@
+package clients
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/chesskiss/btc-service/internal/metrics"
+)
+
+// fetchLockPollInterval is how often a caller waiting on another replica's
+// fetch checks whether the price has been cached
+const fetchLockPollInterval = 50 * time.Millisecond
+
+// acquireFetch decides who fetches a missing price. It returns a release
+// function when the caller should fetch it, or the price cached by the
+// lock holder when the caller waited for it instead. If the wait ran out,
+// or no lock could be taken, the price is nil and the release function a
+// no-op; the caller then fetches without the lock.
+func (s *PriceService) acquireFetch(ctx context.Context, cacheKey string) (*CachedPrice, func()) {
+	noop := func() {}
+	if s.cache == nil || s.opts.FetchLockTTL <= 0 {
+		return nil, noop
+	}
+
+	lockKey := fetchLockKey(cacheKey)
+	acquired, err := s.cache.SetNX(ctx, lockKey, []byte("1"), s.opts.FetchLockTTL)
+	if err != nil {
+		slog.Warn("fetch lock error",
+			"key", lockKey,
+			"error", err,
+		)
+		return nil, noop
+	}
+	if acquired {
+		return nil, func() {
+			if err := s.cache.Delete(context.Background(), lockKey); err != nil {
+				slog.Warn("fetch lock release error",
+					"key", lockKey,
+					"error", err,
+				)
+			}
+		}
+	}
+
+	timeout := time.NewTimer(s.opts.FetchLockWait)
+	defer timeout.Stop()
+	poll := time.NewTicker(fetchLockPollInterval)
+	defer poll.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, noop
+		case <-timeout.C:
+			metrics.FetchLockWaitsTotal.WithLabelValues("timeout").Inc()
+			return nil, noop
+		case <-poll.C:
+			if cached, err := s.getFromCache(ctx, cacheKey); err == nil && s.isCacheFresh(cached) {
+				metrics.FetchLockWaitsTotal.WithLabelValues("filled").Inc()
+				return cached, nil
+			}
+		}
+	}
+}
+
+// fetchLockKey is the lock taken while the price under cacheKey is fetched
+func fetchLockKey(cacheKey string) string {
+	return "lock:" + cacheKey
+}
@@ -0,0 +1,122 @@
+package clients
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/chesskiss/btc-service/internal/clock"
+	"github.com/chesskiss/btc-service/internal/metrics"
+	"github.com/chesskiss/btc-service/internal/subsystems"
+)
+
+// ErrExchangeMaintenance is returned when a price is needed during an
+// announced maintenance window and no cached value is available
+var ErrExchangeMaintenance = errors.New("kraken is in scheduled maintenance")
+
+// MaintenanceWindow is an announced exchange maintenance period
+type MaintenanceWindow struct {
+	Name   string    `json:"name"`
+	Status string    `json:"status"`
+	Start  time.Time `json:"scheduled_for"`
+	End    time.Time `json:"scheduled_until"`
+}
+
+// statusPageResponse is the Statuspage scheduled-maintenances feed format
+type statusPageResponse struct {
+	ScheduledMaintenances []MaintenanceWindow `json:"scheduled_maintenances"`
+}
+
+var (
+	maintenanceMu      sync.RWMutex
+	maintenanceWindows []MaintenanceWindow
+)
+
+// StartMaintenanceMonitor polls Kraken's maintenance calendar with client
+// every interval until ctx is cancelled
+func StartMaintenanceMonitor(ctx context.Context, client *http.Client, feedURL string, interval time.Duration) {
+	go func() {
+		ticker := clock.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			if subsystems.Paused(ctx, subsystems.MaintenanceMonitor) {
+				slog.Debug("maintenance monitor paused")
+			} else if err := refreshMaintenanceWindows(ctx, client, feedURL); err != nil {
+				slog.Warn("failed to refresh maintenance calendar",
+					"error", err,
+				)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C():
+			}
+		}
+	}()
+
+	slog.Info("maintenance monitor started",
+		"feed_url", feedURL,
+		"interval", interval.String(),
+	)
+}
+
+func refreshMaintenanceWindows(ctx context.Context, client *http.Client, feedURL string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, feedURL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to build request: %w", err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	var feed statusPageResponse
+	if err := json.NewDecoder(resp.Body).Decode(&feed); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	SetMaintenanceWindows(feed.ScheduledMaintenances)
+	return nil
+}
+
+// SetMaintenanceWindows replaces the known maintenance windows
+func SetMaintenanceWindows(windows []MaintenanceWindow) {
+	maintenanceMu.Lock()
+	maintenanceWindows = windows
+	maintenanceMu.Unlock()
+
+	ActiveMaintenance()
+}
+
+// ActiveMaintenance returns the maintenance window in effect right now, if
+// any. A window counts as active while the exchange reports it in progress
+// or, unless already completed, while the clock is inside its schedule.
+func ActiveMaintenance() (MaintenanceWindow, bool) {
+	maintenanceMu.RLock()
+	defer maintenanceMu.RUnlock()
+
+	now := clock.Now()
+	for _, window := range maintenanceWindows {
+		inSchedule := !now.Before(window.Start) && now.Before(window.End)
+		if window.Status == "in_progress" || (inSchedule && window.Status != "completed") {
+			metrics.KrakenMaintenanceActive.Set(1)
+			return window, true
+		}
+	}
+
+	metrics.KrakenMaintenanceActive.Set(0)
+	return MaintenanceWindow{}, false
+}
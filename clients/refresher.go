@@ -0,0 +1,92 @@
+package clients
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/chesskiss/btc-service/internal/cache"
+	"github.com/chesskiss/btc-service/internal/clock"
+	"github.com/chesskiss/btc-service/internal/subsystems"
+)
+
+// markActive records that the pair for currency was just requested
+func (s *PriceService) markActive(currency string) {
+	if s.tracking.Load() {
+		s.activePairs.Store(currency, clock.Now())
+	}
+}
+
+// StartCacheRefresher refreshes, every interval until ctx is cancelled,
+// the cached prices of pairs requested within activeWindow that expire
+// within lead, so requests for them keep hitting the cache
+func (s *PriceService) StartCacheRefresher(ctx context.Context, interval, lead, activeWindow time.Duration) {
+	s.tracking.Store(true)
+
+	go func() {
+		ticker := clock.NewTicker(interval)
+		defer ticker.Stop()
+		defer func() {
+			s.tracking.Store(false)
+			s.activePairs.Clear()
+		}()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C():
+			}
+
+			if subsystems.Paused(ctx, subsystems.CacheRefresher) {
+				slog.Debug("cache refresher paused")
+				continue
+			}
+			if _, inMaintenance := ActiveMaintenance(); inMaintenance {
+				continue
+			}
+			s.refreshExpiring(ctx, lead, activeWindow)
+		}
+	}()
+
+	slog.Info("cache refresher started",
+		"interval", interval.String(),
+		"lead", lead.String(),
+		"active_window", activeWindow.String(),
+	)
+}
+
+// refreshExpiring refreshes the active pairs whose cached price is missing
+// or due to expire within lead, and forgets pairs no longer requested
+func (s *PriceService) refreshExpiring(ctx context.Context, lead, activeWindow time.Duration) {
+	if s.cache == nil {
+		return
+	}
+
+	s.activePairs.Range(func(key, value any) bool {
+		currency := key.(string)
+		if clock.Since(value.(time.Time)) > activeWindow {
+			s.activePairs.Delete(currency)
+			return true
+		}
+
+		pair := fmt.Sprintf("BTC/%s", currency)
+		cacheKey := priceCacheKey(pair)
+		cached, err := s.getFromCache(ctx, cacheKey)
+		if err != nil && !errors.Is(err, cache.ErrMiss) {
+			slog.Warn("cache read error",
+				"key", cacheKey,
+				"error", err,
+			)
+			return true
+		}
+		if err == nil && clock.Since(cached.Timestamp) < s.opts.CacheTTL-lead {
+			return true
+		}
+
+		s.refreshInBackground(pair, currency, cacheKey)
+		return true
+	})
+}
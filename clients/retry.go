@@ -0,0 +1,67 @@
+package clients
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/chesskiss/btc-service/internal/metrics"
+	"github.com/chesskiss/btc-service/internal/retry"
+)
+
+// RetryPolicy controls how failed Kraken calls are retried
+type RetryPolicy struct {
+	retry.Policy
+	// RetryableStatuses are the HTTP statuses worth retrying; timeouts and
+	// connection failures always are
+	RetryableStatuses []int
+}
+
+// DefaultRetryableStatuses are the statuses of transient upstream failures
+var DefaultRetryableStatuses = []int{429, 500, 502, 503, 504}
+
+// retryable reports whether err is transient: a retryable status, or a
+// timeout or connection failure not caused by the caller giving up
+func (p RetryPolicy) retryable(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return slices.Contains(p.RetryableStatuses, statusErr.StatusCode)
+	}
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
+
+// fetchWithRetry fetches the price from Kraken, retrying transient
+// failures as the retry policy allows
+func (s *PriceService) fetchWithRetry(ctx context.Context, currency string) (string, float64, error) {
+	policy := s.opts.Retry
+	for attempt := 1; ; attempt++ {
+		decimal, price, err := s.fetchFromKraken(ctx, currency)
+		if err == nil || attempt >= policy.MaxAttempts || !policy.retryable(err) {
+			return decimal, price, err
+		}
+
+		backoff := policy.Backoff(attempt)
+		slog.Warn("retrying kraken call",
+			"currency", currency,
+			"attempt", attempt,
+			"backoff", backoff.String(),
+			"error", err,
+		)
+		metrics.KrakenAPIRetriesTotal.Inc()
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return "", 0, err
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package clients
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/chesskiss/btc-service/internal/breaker"
+	"github.com/chesskiss/btc-service/internal/cache"
+	"github.com/chesskiss/btc-service/internal/metrics"
+)
+
+// DefaultKrakenBaseURL is the public Kraken REST API
+const DefaultKrakenBaseURL = "https://api.kraken.com"
+
+// PriceServiceOptions configures a PriceService. An empty KrakenBaseURL,
+// nil HTTPClient and zero CacheTTL or RawTickerTTL take their defaults;
+// the other zero values disable their feature.
+type PriceServiceOptions struct {
+	KrakenBaseURL string
+	// HTTPClient makes the calls to Kraken
+	HTTPClient *http.Client
+	// CacheTTL is how long cached prices stay fresh
+	CacheTTL time.Duration
+	// StaleWhileRevalidate is how long past its TTL a cached price is
+	// still served while a background fetch refreshes it
+	StaleWhileRevalidate time.Duration
+	// LastKnownMaxAge is how old a last known good price may be and still
+	// be served, flagged stale, when the exchange is unreachable
+	LastKnownMaxAge time.Duration
+	// FetchLockTTL makes callers missing the cache for the same pair take
+	// a lock in the cache, held for at most this long, so only one calls
+	// Kraken while the others wait up to FetchLockWait for the result
+	FetchLockTTL  time.Duration
+	FetchLockWait time.Duration
+	// RawTickerEnabled also caches Kraken's full Ticker payloads, for
+	// their own, usually shorter, RawTickerTTL, so derived data (spread,
+	// VWAP, ...) can be served from the fetches LTP already makes
+	RawTickerEnabled bool
+	RawTickerTTL     time.Duration
+	// Retry retries transient Kraken failures
+	Retry RetryPolicy
+	// BreakerFailureThreshold consecutive failed Kraken calls open a
+	// circuit breaker that skips Kraken, serving cached or last known
+	// prices however old, for BreakerOpenDuration; zero disables it
+	BreakerFailureThreshold int
+	BreakerOpenDuration     time.Duration
+	// MaxConcurrentFetches caps how many of the prices GetBTCQuotes asks
+	// for are looked up at once; one or less looks them up in turn
+	MaxConcurrentFetches int
+}
+
+// PriceService fetches BTC prices from Kraken through a cache. Each service
+// holds its own cache and settings, so differently configured services can
+// run side by side in one process.
+type PriceService struct {
+	cache   cache.Cache
+	opts    PriceServiceOptions
+	breaker *breaker.Breaker
+
+	// refreshing holds the pairs being refreshed in the background, so a
+	// burst of stale reads makes a single upstream call
+	refreshing sync.Map
+	// activePairs maps the currency of each recently requested pair to
+	// when it was last requested, while the cache refresher runs
+	activePairs sync.Map
+	tracking    atomic.Bool
+}
+
+// NewPriceService returns a service caching prices in c, timing its
+// operations; a nil c disables caching
+func NewPriceService(c cache.Cache, opts PriceServiceOptions) *PriceService {
+	if opts.KrakenBaseURL == "" {
+		opts.KrakenBaseURL = DefaultKrakenBaseURL
+	}
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = NewHTTPClient(DefaultHTTPClientOptions)
+	}
+	if opts.CacheTTL <= 0 {
+		opts.CacheTTL = 60 * time.Second
+	}
+	if opts.RawTickerTTL <= 0 {
+		opts.RawTickerTTL = 10 * time.Second
+	}
+	if c != nil {
+		c = cache.NewInstrumented(c)
+	}
+	s := &PriceService{cache: c, opts: opts}
+	if opts.BreakerFailureThreshold > 0 {
+		s.breaker = breaker.New(SourceKraken, opts.BreakerFailureThreshold, opts.BreakerOpenDuration)
+	}
+	return s
+}
+
+var defaultService atomic.Pointer[PriceService]
+
+func init() {
+	defaultService.Store(NewPriceService(nil, PriceServiceOptions{}))
+}
+
+// DefaultService returns the service behind the package-level functions
+func DefaultService() *PriceService {
+	return defaultService.Load()
+}
+
+// SetDefaultService makes s serve the package-level functions, and exports
+// the size of its cache
+func SetDefaultService(s *PriceService) {
+	defaultService.Store(s)
+	if s.cache == nil {
+		metrics.SetCacheSize(nil)
+		return
+	}
+	metrics.SetCacheSize(func() float64 {
+		ctx, cancel := context.WithTimeout(context.Background(), cacheSizeTimeout)
+		defer cancel()
+		size, err := s.cache.Size(ctx)
+		if err != nil {
+			return 0
+		}
+		return float64(size)
+	})
+}
+
+// cacheSizeTimeout bounds counting cached keys on a metrics scrape
+const cacheSizeTimeout = time.Second
+
+// GetBTCPrice calls GetBTCPrice on the default service
+func GetBTCPrice(ctx context.Context, currency string) (float64, error) {
+	return DefaultService().GetBTCPrice(ctx, currency)
+}
+
+// GetBTCQuote calls GetBTCQuote on the default service
+func GetBTCQuote(ctx context.Context, currency string) (Quote, error) {
+	return DefaultService().GetBTCQuote(ctx, currency)
+}
+
+// GetBTCQuotes calls GetBTCQuotes on the default service
+func GetBTCQuotes(ctx context.Context, currencies []string) ([]Quote, []error) {
+	return DefaultService().GetBTCQuotes(ctx, currencies)
+}
+
+// GetRawTicker calls GetRawTicker on the default service
+func GetRawTicker(ctx context.Context, currency string) (RawTicker, error) {
+	return DefaultService().GetRawTicker(ctx, currency)
+}
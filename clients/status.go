@@ -0,0 +1,38 @@
+package clients
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/chesskiss/btc-service/internal/clock"
+)
+
+// Unix nanoseconds of the last Kraken call that succeeded or failed; zero
+// until the first call
+var krakenLastSuccess atomic.Int64
+var krakenLastFailure atomic.Int64
+
+// recordKrakenResult notes the outcome of a Kraken call. An unknown pair is
+// a well-formed answer, so it counts as the exchange being reachable.
+func recordKrakenResult(err error) {
+	now := clock.Now().UnixNano()
+	if err == nil || errors.Is(err, ErrPairNotSupported) {
+		krakenLastSuccess.Store(now)
+	} else {
+		krakenLastFailure.Store(now)
+	}
+}
+
+// KrakenStatus returns when Kraken last answered successfully and when a
+// call to it last failed; either is zero if it has not happened yet
+func KrakenStatus() (lastSuccess, lastFailure time.Time) {
+	return unixNanoTime(krakenLastSuccess.Load()), unixNanoTime(krakenLastFailure.Load())
+}
+
+func unixNanoTime(nanos int64) time.Time {
+	if nanos == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos)
+}
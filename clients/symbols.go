@@ -0,0 +1,78 @@
+package clients
+
+import "strings"
+
+// KrakenSymbol is how Kraken names a pair: Request is the name it accepts
+// in the pair parameter and Result the key it returns the pair's data
+// under, which for its oldest markets carries X and Z asset class
+// prefixes
+type KrakenSymbol struct {
+	Request string
+	Result  string
+}
+
+// krakenSymbols maps canonical pairs to their Kraken names
+var krakenSymbols = map[string]KrakenSymbol{
+	"BTC/USD":  {Request: "XBTUSD", Result: "XXBTZUSD"},
+	"BTC/EUR":  {Request: "XBTEUR", Result: "XXBTZEUR"},
+	"BTC/GBP":  {Request: "XBTGBP", Result: "XXBTZGBP"},
+	"BTC/JPY":  {Request: "XBTJPY", Result: "XXBTZJPY"},
+	"BTC/CAD":  {Request: "XBTCAD", Result: "XXBTZCAD"},
+	"BTC/CHF":  {Request: "XBTCHF", Result: "XBTCHF"},
+	"BTC/AUD":  {Request: "XBTAUD", Result: "XBTAUD"},
+	"BTC/USDT": {Request: "XBTUSDT", Result: "XBTUSDT"},
+	"BTC/USDC": {Request: "XBTUSDC", Result: "XBTUSDC"},
+}
+
+// krakenPairs maps each Kraken name in krakenSymbols back to its
+// canonical pair
+var krakenPairs = func() map[string]string {
+	pairs := make(map[string]string, 2*len(krakenSymbols))
+	for pair, symbol := range krakenSymbols {
+		pairs[symbol.Request] = pair
+		pairs[symbol.Result] = pair
+	}
+	return pairs
+}()
+
+// KrakenSymbolFor returns Kraken's names for BTC/<currency>. Pairs missing
+// from the table are assumed to follow Kraken's naming for newer markets,
+// XBT followed by the currency, so Kraken itself decides whether they
+// exist.
+func KrakenSymbolFor(currency string) KrakenSymbol {
+	if symbol, ok := krakenSymbols["BTC/"+currency]; ok {
+		return symbol
+	}
+	return KrakenSymbol{Request: "XBT" + currency, Result: "XBT" + currency}
+}
+
+// CanonicalPair returns the canonical pair a Kraken pair name or result key
+// stands for, such as BTC/USD for XXBTZUSD or XBTUSD, and false for names
+// that are not bitcoin pairs
+func CanonicalPair(name string) (string, bool) {
+	if pair, ok := krakenPairs[name]; ok {
+		return pair, true
+	}
+	for _, base := range []string{"XXBTZ", "XXBT", "XBT"} {
+		if quote, ok := strings.CutPrefix(name, base); ok && quote != "" {
+			return "BTC/" + quote, true
+		}
+	}
+	return "", false
+}
+
+// krakenResult returns the entry of a Ticker result map belonging to
+// BTC/<currency>, whichever Kraken name it is keyed by
+func krakenResult[T any](result map[string]T, currency string) (T, bool) {
+	if entry, ok := result[KrakenSymbolFor(currency).Result]; ok {
+		return entry, true
+	}
+	want := "BTC/" + currency
+	for name, entry := range result {
+		if pair, ok := CanonicalPair(name); ok && pair == want {
+			return entry, true
+		}
+	}
+	var zero T
+	return zero, false
+}
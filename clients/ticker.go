@@ -0,0 +1,109 @@
+package clients
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/chesskiss/btc-service/internal/cache"
+)
+
+// ErrTickerNotCached is returned when no raw ticker payload is cached for
+// a pair, either because raw caching is off or the entry has expired
+var ErrTickerNotCached = errors.New("raw ticker not cached")
+
+// KrakenTicker is Kraken's full Ticker entry for one pair; each field is
+// reported as strings, most as [today, last 24 hours]
+type KrakenTicker struct {
+	Ask    []string `json:"a"` // [price, whole lot volume, lot volume]
+	Bid    []string `json:"b"` // [price, whole lot volume, lot volume]
+	Close  []string `json:"c"` // last trade closed: [price, lot volume]
+	Volume []string `json:"v"`
+	VWAP   []string `json:"p"`
+	Trades []int    `json:"t"`
+	Low    []string `json:"l"`
+	High   []string `json:"h"`
+	Open   string   `json:"o"`
+}
+
+// RawTicker is a pair's Ticker payload exactly as Kraken returned it
+type RawTicker struct {
+	Pair      string          `json:"pair"`
+	Payload   json.RawMessage `json:"payload"`
+	FetchedAt time.Time       `json:"fetched_at"`
+}
+
+// Ticker decodes the payload into its fields
+func (t RawTicker) Ticker() (KrakenTicker, error) {
+	var ticker KrakenTicker
+	if err := json.Unmarshal(t.Payload, &ticker); err != nil {
+		return KrakenTicker{}, fmt.Errorf("failed to decode ticker: %w", err)
+	}
+	return ticker, nil
+}
+
+// GetRawTicker returns the cached raw Ticker payload for BTC/<currency>.
+// It never calls Kraken; ErrTickerNotCached means no fresh payload is held.
+func (s *PriceService) GetRawTicker(ctx context.Context, currency string) (RawTicker, error) {
+	if !s.opts.RawTickerEnabled || s.cache == nil {
+		return RawTicker{}, ErrTickerNotCached
+	}
+
+	val, err := s.cache.Get(ctx, rawTickerKey(currency))
+	if errors.Is(err, cache.ErrMiss) {
+		return RawTicker{}, ErrTickerNotCached
+	}
+	if err != nil {
+		return RawTicker{}, fmt.Errorf("failed to read raw ticker: %w", err)
+	}
+
+	var ticker RawTicker
+	if err := json.Unmarshal(val, &ticker); err != nil {
+		return RawTicker{}, fmt.Errorf("failed to unmarshal raw ticker: %w", err)
+	}
+	return ticker, nil
+}
+
+// cacheRawTicker stores the pair's entry from a Ticker response body when
+// raw caching is enabled; failures are logged, never returned
+func (s *PriceService) cacheRawTicker(ctx context.Context, currency string, body []byte, fetchedAt time.Time) {
+	if !s.opts.RawTickerEnabled || s.cache == nil {
+		return
+	}
+
+	var resp struct {
+		Result map[string]json.RawMessage `json:"result"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return
+	}
+
+	// Kraken keys the result by its own pair name, e.g. XXBTZUSD
+	payload, ok := krakenResult(resp.Result, currency)
+	if !ok {
+		return
+	}
+	data, err := json.Marshal(RawTicker{
+		Pair:      fmt.Sprintf("BTC/%s", currency),
+		Payload:   payload,
+		FetchedAt: fetchedAt.UTC(),
+	})
+	if err != nil {
+		return
+	}
+
+	key := rawTickerKey(currency)
+	if err := s.cache.Set(ctx, key, data, s.opts.RawTickerTTL); err != nil {
+		slog.Warn("raw ticker cache write error",
+			"key", key,
+			"error", err,
+		)
+	}
+}
+
+func rawTickerKey(currency string) string {
+	return fmt.Sprintf("ticker:BTC/%s", currency)
+}
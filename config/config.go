@@ -1,38 +1,1029 @@
 package config
 
 import (
+	"errors"
+	"fmt"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chesskiss/btc-service/internal/cache"
+	"github.com/chesskiss/btc-service/internal/database"
+	"github.com/chesskiss/btc-service/internal/middleware"
 )
 
 type Config struct {
-	Port          string
-	RedisHost     string
-	RedisPort     string
-	RedisPassword string
-	DBHost        string
-	DBPort        string
-	DBUser        string
-	DBPassword    string
-	DBName        string
-}
-
-func Load() *Config {
-	return &Config{
-		Port:          getEnv("PORT", "8080"),
-		RedisHost:     getEnv("REDIS_HOST", "localhost"),
-		RedisPort:     getEnv("REDIS_PORT", "6379"),
-		RedisPassword: getEnv("REDIS_PASSWORD", ""),
-		DBHost:        getEnv("DB_HOST", "localhost"),
-		DBPort:        getEnv("DB_PORT", "5432"),
-		DBUser:        getEnv("DB_USER", "postgres"),
-		DBPassword:    getEnv("DB_PASSWORD", "postgres"),
-		DBName:        getEnv("DB_NAME", "btc_service"),
-	}
-}
-
-func getEnv(key, defaultValue string) string {
+	Server    ServerConfig
+	Redis     RedisConfig
+	DB        DBConfig
+	Tracing   TracingConfig
+	Cache     CacheConfig
+	Providers ProvidersConfig
+	Auth      AuthConfig
+	RateLimit RateLimitConfig
+	Alerts    AlertsConfig
+	Health    HealthConfig
+	Prices    PricesConfig
+}
+
+type ServerConfig struct {
+	Port         string
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	IdleTimeout  time.Duration
+	// ShutdownTimeout is how long in-flight requests may take to finish
+	// after SIGTERM or SIGINT
+	ShutdownTimeout time.Duration
+	// Request size limits; oversized requests get 413, 414 or 431
+	MaxHeaderBytes int
+	MaxBodyBytes   int64
+	MaxURLLength   int
+	// MaxInFlight caps the API requests served at once; more are shed with
+	// 503 and a Retry-After of ShedRetryAfter. Zero disables the cap.
+	MaxInFlight    int
+	ShedRetryAfter time.Duration
+	// CompressionEnabled gzip- or deflate-encodes responses of at least
+	// CompressionMinBytes for clients that accept it
+	CompressionEnabled  bool
+	CompressionMinBytes int
+	// TrustedProxies are the addresses and CIDR ranges of the proxies in
+	// front of the service, whose X-Forwarded-For headers are believed
+	TrustedProxies []string
+}
+
+type RedisConfig struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	DB       int
+	// TLSEnabled encrypts connections, as managed offerings require
+	TLSEnabled bool
+	// SentinelMaster switches to Sentinel discovery of that master through
+	// SentinelAddrs, ignoring Host and Port
+	SentinelMaster   string
+	SentinelAddrs    []string
+	SentinelPassword string
+	// ClusterAddrs switches to Redis Cluster, seeded from these nodes and
+	// ignoring Host and Port
+	ClusterAddrs []string
+	// Connection pool, timeout and retry tuning; zero keeps the client's
+	// defaults (a pool of 10 per CPU, 3s timeouts, 3 retries backing off
+	// 8ms to 512ms) and MaxRetries of -1 disables retries
+	PoolSize        int
+	MinIdleConns    int
+	ReadTimeout     time.Duration
+	WriteTimeout    time.Duration
+	MaxRetries      int
+	MinRetryBackoff time.Duration
+	MaxRetryBackoff time.Duration
+}
+
+type DBConfig struct {
+	// Driver is the database the store is kept in, postgres or mysql
+	// (which covers MariaDB)
+	Driver   string
+	Host     string
+	Port     string
+	User     string
+	Password string
+	Name     string
+	// ReadReplicaDSN, when set, is the libpq connection string or URL of a
+	// read replica that serves request log queries instead of the primary;
+	// PostgreSQL only
+	ReadReplicaDSN string
+	// SSLMode is the libpq sslmode, from disable to verify-full, also
+	// applied to MySQL; SSLRootCert verifies the server, and SSLCert and
+	// SSLKey authenticate the service
+	SSLMode     string
+	SSLRootCert string
+	SSLCert     string
+	SSLKey      string
+	// Migrate applies pending schema migrations on startup; those up to
+	// MigrationsBaseline are recorded as applied without running, for
+	// databases set up by hand
+	Migrate            bool
+	MigrationsBaseline int
+	// Request logs are queued, up to LogBufferSize of them, and written
+	// LogBatchSize at a time, at least every LogFlushInterval
+	LogBufferSize    int
+	LogBatchSize     int
+	LogFlushInterval time.Duration
+	// LogSinks are where request logs are written: the database, as
+	// postgres or mysql, clickhouse for high-volume analytical queries,
+	// and kafka for data pipelines
+	LogSinks   []string
+	ClickHouse ClickHouseConfig
+	Kafka      KafkaConfig
+	// A LogSuccessSampleRate fraction of successful requests is logged,
+	// and a LogErrorSampleRate fraction of failed ones
+	LogSuccessSampleRate float64
+	LogErrorSampleRate   float64
+	// LogIPMode stores client IPs in full, truncated to their network, or
+	// as an HMAC keyed by LogIPHMACSecret and a period of
+	// LogIPHMACRotation
+	LogIPMode         string
+	LogIPHMACSecret   string
+	LogIPHMACRotation time.Duration
+	// Batches that fail to be written are appended to the file at
+	// LogSpoolPath, up to LogSpoolMaxBytes, and replayed once writes
+	// succeed again; they are dropped when it is empty
+	LogSpoolPath     string
+	LogSpoolMaxBytes int
+	// Request logs older than LogRetention are deleted every
+	// LogRetentionInterval, LogRetentionBatchSize rows at a time (all at
+	// once when zero); a zero LogRetention keeps them forever
+	LogRetention          time.Duration
+	LogRetentionInterval  time.Duration
+	LogRetentionBatchSize int
+	// LogArchive, when its bucket is set, keeps expiring request logs in
+	// object storage before they are deleted
+	LogArchive ArchiveConfig
+}
+
+// ArchiveConfig locates the S3-compatible bucket expiring request logs
+// are archived to
+type ArchiveConfig struct {
+	Bucket          string
+	Prefix          string
+	Endpoint        string
+	Region          string
+	AccessKeyID     string
+	SecretAccessKey string
+	PathStyle       bool
+	Timeout         time.Duration
+}
+
+// ClickHouseConfig locates the ClickHouse table request logs are written
+// to with clickhouse among REQUEST_LOG_SINK, over its HTTP interface
+type ClickHouseConfig struct {
+	URL      string
+	Database string
+	Table    string
+	User     string
+	Password string
+	Timeout  time.Duration
+}
+
+// KafkaConfig locates the topic request log events are published to with
+// kafka among REQUEST_LOG_SINK
+type KafkaConfig struct {
+	Brokers []string
+	Topic   string
+	Timeout time.Duration
+}
+
+type TracingConfig struct {
+	Enabled     bool
+	ServiceName string
+	Endpoint    string
+}
+
+type CacheConfig struct {
+	// Backend is redis (shared by replicas) or memory (per process)
+	Backend string
+	// Namespace prefixes every key, e.g. btc-service:staging, so
+	// environments can share a Redis
+	Namespace string
+	// MemoryMaxEntries bounds the memory backend and the L1
+	MemoryMaxEntries int
+	// L1TTL puts a per-process cache, holding entries this long, in front
+	// of Redis; zero disables it
+	L1TTL time.Duration
+	// FallbackEnabled switches to a per-process cache while Redis is
+	// unreachable, pinging it every FallbackProbeInterval to recover
+	FallbackEnabled       bool
+	FallbackProbeInterval time.Duration
+	// SnapshotPath saves the in-process cache there on shutdown and
+	// reloads it on startup; empty disables it
+	SnapshotPath string
+	// StaleWhileRevalidate serves prices this long past TTL while they are
+	// refreshed in the background; zero disables it
+	StaleWhileRevalidate time.Duration
+	// LastKnownMaxAge keeps each price this long as a fallback, served
+	// flagged stale when Kraken is unavailable; zero disables it
+	LastKnownMaxAge time.Duration
+	TTL             time.Duration
+	// RawTickerEnabled also caches Kraken's full Ticker payload per pair,
+	// for RawTickerTTL, so derived data needs no extra upstream calls
+	RawTickerEnabled bool
+	RawTickerTTL     time.Duration
+	// WarmEnabled prefetches the default pairs, plus the WarmFrequentPairs
+	// most requested over WarmLookback, before the server starts listening;
+	// WarmTimeout bounds how long startup waits for it
+	WarmEnabled       bool
+	WarmFrequentPairs int
+	WarmLookback      time.Duration
+	WarmTimeout       time.Duration
+	// RefreshEnabled re-fetches, every RefreshInterval, the prices of pairs
+	// requested within RefreshActiveWindow that expire within RefreshLead
+	RefreshEnabled      bool
+	RefreshInterval     time.Duration
+	RefreshLead         time.Duration
+	RefreshActiveWindow time.Duration
+	// BypassPerMinute and BypassBurst limit requests that skip the cache
+	// with max_age or fresh
+	BypassPerMinute int
+	BypassBurst     int
+	// FetchLockTTL makes replicas missing the same price take a lock so
+	// only one calls Kraken, the others waiting up to FetchLockWait for
+	// the result; zero disables it
+	FetchLockTTL  time.Duration
+	FetchLockWait time.Duration
+}
+
+type ProvidersConfig struct {
+	Kraken KrakenConfig
+}
+
+type KrakenConfig struct {
+	BaseURL string
+	// MaintenanceFeedURL is Kraken's Statuspage scheduled-maintenances
+	// feed; empty disables maintenance awareness
+	MaintenanceFeedURL       string
+	MaintenanceCheckInterval time.Duration
+	// Timeout bounds a whole Ticker call; DialTimeout connecting and
+	// ResponseHeaderTimeout waiting for the response once connected.
+	// MaxIdleConns keep-alive connections stay open for IdleConnTimeout.
+	Timeout               time.Duration
+	DialTimeout           time.Duration
+	ResponseHeaderTimeout time.Duration
+	MaxIdleConns          int
+	IdleConnTimeout       time.Duration
+	// MaxResponseBytes bounds how much of a Kraken response is read
+	MaxResponseBytes int64
+	// RetryMaxAttempts counts the first call; failures with one of
+	// RetryableStatuses, timeouts and connection errors are retried after
+	// RetryBackoff, doubling up to MaxRetryBackoff
+	RetryMaxAttempts  int
+	RetryBackoff      time.Duration
+	MaxRetryBackoff   time.Duration
+	RetryableStatuses []int
+	// BreakerFailureThreshold consecutive failures open a circuit breaker
+	// that stops calling Kraken for BreakerOpenDuration; zero disables it
+	BreakerFailureThreshold int
+	BreakerOpenDuration     time.Duration
+	// MaxConcurrentFetches caps how many of a request's pairs are fetched
+	// from Kraken at once
+	MaxConcurrentFetches int
+}
+
+type AuthConfig struct {
+	Enabled bool
+	// APIKeys are the keys accepted in X-API-Key, each a bare key or
+	// id:key to name it in request logs
+	APIKeys []string
+	// AdminAPIKeys are the keys, in the same form, that may also call the
+	// admin endpoints, which require one whether or not auth is enabled
+	AdminAPIKeys []string
+}
+
+// RateLimitConfig limits each API key, or client IP without one, to
+// PerMinute requests a minute in bursts of up to Burst. API key usage is
+// written to the database every UsageFlushInterval.
+type RateLimitConfig struct {
+	Enabled            bool
+	PerMinute          int
+	Burst              int
+	UsageFlushInterval time.Duration
+}
+
+type AlertsConfig struct {
+	Enabled            bool
+	EvaluationInterval time.Duration
+	WebhookTimeout     time.Duration
+	// DeliveryInterval is how often queued webhooks are sent; failed ones
+	// are retried with exponential backoff until MaxAttempts is reached
+	DeliveryInterval time.Duration
+	MaxAttempts      int
+	RetryBackoff     time.Duration
+	MaxRetryBackoff  time.Duration
+	// AllowPrivateCallbacks lets webhooks reach loopback, private and
+	// other special-use addresses
+	AllowPrivateCallbacks bool
+}
+
+// HealthConfig sets the readiness hysteresis: a dependency turns unhealthy
+// after FailureThreshold consecutive failed probes and recovers after
+// RecoveryThreshold consecutive successful ones
+type HealthConfig struct {
+	FailureThreshold  int
+	RecoveryThreshold int
+}
+
+// PricesConfig sets how prices are formatted when a request doesn't ask
+// for a precision; a negative Precision keeps the exchange's precision
+type PricesConfig struct {
+	Precision int
+	Rounding  string
+	// UpstreamBudget bounds how long a request waits on the exchange for
+	// all its pairs; zero disables it
+	UpstreamBudget time.Duration
+}
+
+// Load reads the configuration from the environment, applying defaults and
+// validating every section
+func Load() (*Config, error) {
+	env := &envReader{}
+
+	dbDriver := env.String("DB_DRIVER", database.DriverPostgres)
+	dbPort := "5432"
+	if dbDriver == database.DriverMySQL {
+		dbPort = "3306"
+	}
+
+	cfg := &Config{
+		Server: ServerConfig{
+			Port:                env.String("PORT", "8080"),
+			ReadTimeout:         env.Duration("SERVER_READ_TIMEOUT", 10*time.Second),
+			WriteTimeout:        env.Duration("SERVER_WRITE_TIMEOUT", 30*time.Second),
+			IdleTimeout:         env.Duration("SERVER_IDLE_TIMEOUT", 120*time.Second),
+			ShutdownTimeout:     env.Duration("SERVER_SHUTDOWN_TIMEOUT", 15*time.Second),
+			MaxHeaderBytes:      env.Int("SERVER_MAX_HEADER_BYTES", 8<<10),
+			MaxBodyBytes:        int64(env.Int("SERVER_MAX_BODY_BYTES", 64<<10)),
+			MaxURLLength:        env.Int("SERVER_MAX_URL_LENGTH", 2048),
+			MaxInFlight:         env.Int("SERVER_MAX_IN_FLIGHT", 0),
+			ShedRetryAfter:      env.Duration("SERVER_SHED_RETRY_AFTER", time.Second),
+			CompressionEnabled:  env.Bool("SERVER_COMPRESSION_ENABLED", true),
+			CompressionMinBytes: env.Int("SERVER_COMPRESSION_MIN_BYTES", 1024),
+			TrustedProxies:      env.List("SERVER_TRUSTED_PROXIES", nil),
+		},
+		Redis: RedisConfig{
+			Host:     env.String("REDIS_HOST", "localhost"),
+			Port:     env.String("REDIS_PORT", "6379"),
+			Username: env.String("REDIS_USERNAME", ""),
+			Password: env.String("REDIS_PASSWORD", ""),
+			DB:       env.Int("REDIS_DB", 0),
+
+			TLSEnabled: env.Bool("REDIS_TLS_ENABLED", false),
+
+			SentinelMaster:   env.String("REDIS_SENTINEL_MASTER", ""),
+			SentinelAddrs:    env.List("REDIS_SENTINEL_ADDRS", nil),
+			SentinelPassword: env.String("REDIS_SENTINEL_PASSWORD", ""),
+			ClusterAddrs:     env.List("REDIS_CLUSTER_ADDRS", nil),
+
+			PoolSize:        env.Int("REDIS_POOL_SIZE", 0),
+			MinIdleConns:    env.Int("REDIS_MIN_IDLE_CONNS", 0),
+			ReadTimeout:     env.Duration("REDIS_READ_TIMEOUT", 0),
+			WriteTimeout:    env.Duration("REDIS_WRITE_TIMEOUT", 0),
+			MaxRetries:      env.Int("REDIS_MAX_RETRIES", 0),
+			MinRetryBackoff: env.Duration("REDIS_MIN_RETRY_BACKOFF", 0),
+			MaxRetryBackoff: env.Duration("REDIS_MAX_RETRY_BACKOFF", 0),
+		},
+		DB: DBConfig{
+			Driver:   dbDriver,
+			Host:     env.String("DB_HOST", "localhost"),
+			Port:     env.String("DB_PORT", dbPort),
+			User:     env.String("DB_USER", "postgres"),
+			Password: env.String("DB_PASSWORD", "postgres"),
+			Name:     env.String("DB_NAME", "btc_service"),
+
+			ReadReplicaDSN: env.String("DB_READ_REPLICA_DSN", ""),
+
+			SSLMode:     env.String("DB_SSLMODE", "disable"),
+			SSLRootCert: env.String("DB_SSLROOTCERT", ""),
+			SSLCert:     env.String("DB_SSLCERT", ""),
+			SSLKey:      env.String("DB_SSLKEY", ""),
+
+			Migrate:            env.Bool("DB_MIGRATE", false),
+			MigrationsBaseline: env.Int("DB_MIGRATIONS_BASELINE", 0),
+
+			LogBufferSize:        env.Int("REQUEST_LOG_BUFFER_SIZE", 10000),
+			LogBatchSize:         env.Int("REQUEST_LOG_BATCH_SIZE", 100),
+			LogFlushInterval:     env.Duration("REQUEST_LOG_FLUSH_INTERVAL", time.Second),
+			LogSinks:             env.List("REQUEST_LOG_SINK", []string{dbDriver}),
+			LogSuccessSampleRate: env.Float("REQUEST_LOG_SUCCESS_SAMPLE_RATE", 1),
+			LogErrorSampleRate:   env.Float("REQUEST_LOG_ERROR_SAMPLE_RATE", 1),
+			LogIPMode:            env.String("REQUEST_LOG_IP_MODE", database.IPModeFull),
+			LogIPHMACSecret:      env.String("REQUEST_LOG_IP_HMAC_SECRET", ""),
+			LogIPHMACRotation:    env.Duration("REQUEST_LOG_IP_HMAC_ROTATION", 24*time.Hour),
+			LogSpoolPath:         env.String("REQUEST_LOG_SPOOL_PATH", ""),
+			LogSpoolMaxBytes:     env.Int("REQUEST_LOG_SPOOL_MAX_BYTES", 100<<20),
+			ClickHouse: ClickHouseConfig{
+				URL:      env.String("CLICKHOUSE_URL", "http://localhost:8123"),
+				Database: env.String("CLICKHOUSE_DATABASE", "default"),
+				Table:    env.String("CLICKHOUSE_TABLE", "request_logs"),
+				User:     env.String("CLICKHOUSE_USER", ""),
+				Password: env.String("CLICKHOUSE_PASSWORD", ""),
+				Timeout:  env.Duration("CLICKHOUSE_TIMEOUT", 5*time.Second),
+			},
+			Kafka: KafkaConfig{
+				Brokers: env.List("KAFKA_BROKERS", nil),
+				Topic:   env.String("KAFKA_TOPIC", "request-logs"),
+				Timeout: env.Duration("KAFKA_TIMEOUT", 10*time.Second),
+			},
+
+			LogRetention:          env.Duration("REQUEST_LOG_RETENTION", 0),
+			LogRetentionInterval:  env.Duration("REQUEST_LOG_RETENTION_INTERVAL", time.Hour),
+			LogRetentionBatchSize: env.Int("REQUEST_LOG_RETENTION_BATCH_SIZE", 10000),
+			LogArchive: ArchiveConfig{
+				Bucket:          env.String("REQUEST_LOG_ARCHIVE_BUCKET", ""),
+				Prefix:          env.String("REQUEST_LOG_ARCHIVE_PREFIX", "request_logs/"),
+				Endpoint:        env.String("REQUEST_LOG_ARCHIVE_ENDPOINT", ""),
+				Region:          env.String("REQUEST_LOG_ARCHIVE_REGION", "us-east-1"),
+				AccessKeyID:     env.String("REQUEST_LOG_ARCHIVE_ACCESS_KEY_ID", ""),
+				SecretAccessKey: env.String("REQUEST_LOG_ARCHIVE_SECRET_ACCESS_KEY", ""),
+				PathStyle:       env.Bool("REQUEST_LOG_ARCHIVE_PATH_STYLE", false),
+				Timeout:         env.Duration("REQUEST_LOG_ARCHIVE_TIMEOUT", 30*time.Second),
+			},
+		},
+		Tracing: TracingConfig{
+			Enabled:     env.Bool("TRACING_ENABLED", true),
+			ServiceName: env.String("TRACING_SERVICE_NAME", "btc-service"),
+			Endpoint:    env.String("JAEGER_ENDPOINT", "jaeger:4318"),
+		},
+		Cache: CacheConfig{
+			Backend:               env.String("CACHE_BACKEND", cache.BackendRedis),
+			Namespace:             env.String("CACHE_NAMESPACE", ""),
+			MemoryMaxEntries:      env.Int("CACHE_MEMORY_MAX_ENTRIES", 10000),
+			L1TTL:                 env.Duration("CACHE_L1_TTL", 0),
+			FallbackEnabled:       env.Bool("CACHE_FALLBACK_ENABLED", true),
+			FallbackProbeInterval: env.Duration("CACHE_FALLBACK_PROBE_INTERVAL", 5*time.Second),
+			SnapshotPath:          env.String("CACHE_SNAPSHOT_PATH", ""),
+			StaleWhileRevalidate:  env.Duration("CACHE_STALE_WHILE_REVALIDATE", 0),
+			LastKnownMaxAge:       env.Duration("CACHE_LAST_KNOWN_MAX_AGE", 0),
+			TTL:                   env.Duration("CACHE_TTL", 60*time.Second),
+			RawTickerEnabled:      env.Bool("CACHE_RAW_TICKER_ENABLED", false),
+			RawTickerTTL:          env.Duration("CACHE_RAW_TICKER_TTL", 10*time.Second),
+			WarmEnabled:           env.Bool("CACHE_WARM_ENABLED", true),
+			WarmFrequentPairs:     env.Int("CACHE_WARM_FREQUENT_PAIRS", 10),
+			WarmLookback:          env.Duration("CACHE_WARM_LOOKBACK", 24*time.Hour),
+			WarmTimeout:           env.Duration("CACHE_WARM_TIMEOUT", 10*time.Second),
+			RefreshEnabled:        env.Bool("CACHE_REFRESH_ENABLED", false),
+			RefreshInterval:       env.Duration("CACHE_REFRESH_INTERVAL", 5*time.Second),
+			RefreshLead:           env.Duration("CACHE_REFRESH_LEAD", 10*time.Second),
+			RefreshActiveWindow:   env.Duration("CACHE_REFRESH_ACTIVE_WINDOW", 10*time.Minute),
+			BypassPerMinute:       env.Int("CACHE_BYPASS_PER_MINUTE", 60),
+			BypassBurst:           env.Int("CACHE_BYPASS_BURST", 10),
+			FetchLockTTL:          env.Duration("CACHE_FETCH_LOCK_TTL", 0),
+			FetchLockWait:         env.Duration("CACHE_FETCH_LOCK_WAIT", 2*time.Second),
+		},
+		Providers: ProvidersConfig{
+			Kraken: KrakenConfig{
+				BaseURL:                  env.String("KRAKEN_BASE_URL", "https://api.kraken.com"),
+				MaintenanceFeedURL:       env.String("KRAKEN_MAINTENANCE_FEED_URL", "https://status.kraken.com/api/v2/scheduled-maintenances.json"),
+				MaintenanceCheckInterval: env.Duration("KRAKEN_MAINTENANCE_CHECK_INTERVAL", 5*time.Minute),
+				Timeout:                  env.Duration("KRAKEN_TIMEOUT", 10*time.Second),
+				DialTimeout:              env.Duration("KRAKEN_DIAL_TIMEOUT", 3*time.Second),
+				ResponseHeaderTimeout:    env.Duration("KRAKEN_RESPONSE_HEADER_TIMEOUT", 5*time.Second),
+				MaxIdleConns:             env.Int("KRAKEN_MAX_IDLE_CONNS", 10),
+				IdleConnTimeout:          env.Duration("KRAKEN_IDLE_CONN_TIMEOUT", 90*time.Second),
+				MaxResponseBytes:         int64(env.Int("KRAKEN_MAX_RESPONSE_BYTES", 1<<20)),
+				RetryMaxAttempts:         env.Int("KRAKEN_RETRY_MAX_ATTEMPTS", 3),
+				RetryBackoff:             env.Duration("KRAKEN_RETRY_BACKOFF", 100*time.Millisecond),
+				MaxRetryBackoff:          env.Duration("KRAKEN_MAX_RETRY_BACKOFF", time.Second),
+				RetryableStatuses:        env.IntList("KRAKEN_RETRYABLE_STATUSES", []int{429, 500, 502, 503, 504}),
+				BreakerFailureThreshold:  env.Int("KRAKEN_BREAKER_FAILURE_THRESHOLD", 5),
+				BreakerOpenDuration:      env.Duration("KRAKEN_BREAKER_OPEN_DURATION", 30*time.Second),
+				MaxConcurrentFetches:     env.Int("KRAKEN_MAX_CONCURRENT_FETCHES", 4),
+			},
+		},
+		Auth: AuthConfig{
+			Enabled:      env.Bool("AUTH_ENABLED", false),
+			APIKeys:      env.List("AUTH_API_KEYS", nil),
+			AdminAPIKeys: env.List("ADMIN_API_KEYS", nil),
+		},
+		RateLimit: RateLimitConfig{
+			Enabled:            env.Bool("RATE_LIMIT_ENABLED", false),
+			PerMinute:          env.Int("RATE_LIMIT_PER_MINUTE", 600),
+			Burst:              env.Int("RATE_LIMIT_BURST", 100),
+			UsageFlushInterval: env.Duration("RATE_LIMIT_USAGE_FLUSH_INTERVAL", time.Minute),
+		},
+		Alerts: AlertsConfig{
+			Enabled:               env.Bool("ALERTS_ENABLED", true),
+			EvaluationInterval:    env.Duration("ALERTS_EVALUATION_INTERVAL", 30*time.Second),
+			WebhookTimeout:        env.Duration("ALERTS_WEBHOOK_TIMEOUT", 5*time.Second),
+			DeliveryInterval:      env.Duration("ALERTS_DELIVERY_INTERVAL", 5*time.Second),
+			MaxAttempts:           env.Int("ALERTS_WEBHOOK_MAX_ATTEMPTS", 8),
+			RetryBackoff:          env.Duration("ALERTS_WEBHOOK_RETRY_BACKOFF", 10*time.Second),
+			MaxRetryBackoff:       env.Duration("ALERTS_WEBHOOK_MAX_RETRY_BACKOFF", time.Hour),
+			AllowPrivateCallbacks: env.Bool("ALERTS_ALLOW_PRIVATE_CALLBACKS", false),
+		},
+		Health: HealthConfig{
+			FailureThreshold:  env.Int("HEALTH_FAILURE_THRESHOLD", 3),
+			RecoveryThreshold: env.Int("HEALTH_RECOVERY_THRESHOLD", 2),
+		},
+		Prices: PricesConfig{
+			Precision:      env.Int("PRICE_PRECISION", -1),
+			Rounding:       env.String("PRICE_ROUNDING", "half_even"),
+			UpstreamBudget: env.Duration("PRICE_UPSTREAM_BUDGET", 0),
+		},
+	}
+
+	if err := errors.Join(env.errs...); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// Validate checks every section and reports all problems at once
+func (c *Config) Validate() error {
+	return errors.Join(
+		c.Server.Validate(),
+		c.Redis.Validate(),
+		c.DB.Validate(),
+		c.Tracing.Validate(),
+		c.Cache.Validate(),
+		c.Providers.Validate(),
+		c.Auth.Validate(),
+		c.RateLimit.Validate(),
+		c.Alerts.Validate(),
+		c.Health.Validate(),
+		c.Prices.Validate(),
+	)
+}
+
+// EnabledFeatures names the optional subsystems this configuration turns on
+func (c *Config) EnabledFeatures() []string {
+	var features []string
+	if c.Tracing.Enabled {
+		features = append(features, "tracing")
+	}
+	if c.Auth.Enabled {
+		features = append(features, "auth")
+	}
+	if c.RateLimit.Enabled {
+		features = append(features, "rate_limit")
+	}
+	if c.Alerts.Enabled {
+		features = append(features, "alerts")
+	}
+	if c.Providers.Kraken.MaintenanceFeedURL != "" {
+		features = append(features, "maintenance_monitor")
+	}
+	if c.Cache.RefreshEnabled {
+		features = append(features, "cache_refresher")
+	}
+	if c.DB.LogRetention > 0 {
+		features = append(features, "request_log_janitor")
+	}
+	return features
+}
+
+func (c ServerConfig) Validate() error {
+	var errs []error
+	if err := validatePort(c.Port); err != nil {
+		errs = append(errs, fmt.Errorf("server: %w", err))
+	}
+	if c.ReadTimeout <= 0 || c.WriteTimeout <= 0 || c.IdleTimeout <= 0 || c.ShutdownTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("server: timeouts must be positive"))
+	}
+	if c.MaxHeaderBytes <= 0 || c.MaxBodyBytes <= 0 || c.MaxURLLength <= 0 {
+		errs = append(errs, fmt.Errorf("server: request size limits must be positive"))
+	}
+	if c.MaxInFlight < 0 {
+		errs = append(errs, fmt.Errorf("server: max in flight must not be negative"))
+	}
+	if c.ShedRetryAfter <= 0 {
+		errs = append(errs, fmt.Errorf("server: shed retry after must be positive"))
+	}
+	if c.CompressionMinBytes < 0 {
+		errs = append(errs, fmt.Errorf("server: compression min bytes must not be negative"))
+	}
+	if _, err := middleware.ParseTrustedProxies(c.TrustedProxies); err != nil {
+		errs = append(errs, fmt.Errorf("server: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+func (c RedisConfig) Validate() error {
+	if c.DB < 0 {
+		return fmt.Errorf("redis: db must not be negative")
+	}
+	if c.PoolSize < 0 || c.MinIdleConns < 0 {
+		return fmt.Errorf("redis: pool size and min idle connections must not be negative")
+	}
+	if c.PoolSize > 0 && c.MinIdleConns > c.PoolSize {
+		return fmt.Errorf("redis: min idle connections must not exceed the pool size")
+	}
+	if c.ReadTimeout < 0 || c.WriteTimeout < 0 || c.MinRetryBackoff < 0 || c.MaxRetryBackoff < 0 {
+		return fmt.Errorf("redis: timeouts and retry backoffs must not be negative")
+	}
+	if c.MaxRetries < -1 {
+		return fmt.Errorf("redis: max retries must be -1 (disabled) or more")
+	}
+	if c.MaxRetryBackoff > 0 && c.MinRetryBackoff > c.MaxRetryBackoff {
+		return fmt.Errorf("redis: min retry backoff must not exceed max retry backoff")
+	}
+	if len(c.ClusterAddrs) > 0 {
+		if c.SentinelMaster != "" {
+			return fmt.Errorf("redis: sentinel and cluster modes are mutually exclusive")
+		}
+		if c.DB != 0 {
+			return fmt.Errorf("redis: cluster mode only supports db 0")
+		}
+		return nil
+	}
+	if c.SentinelMaster != "" {
+		if len(c.SentinelAddrs) == 0 {
+			return fmt.Errorf("redis: sentinel addresses are required with a sentinel master")
+		}
+		return nil
+	}
+	if c.Host == "" {
+		return fmt.Errorf("redis: host is required")
+	}
+	if err := validatePort(c.Port); err != nil {
+		return fmt.Errorf("redis: %w", err)
+	}
+	return nil
+}
+
+func (c DBConfig) Validate() error {
+	if c.Driver != database.DriverPostgres && c.Driver != database.DriverMySQL {
+		return fmt.Errorf("db: driver must be %s or %s, got %q", database.DriverPostgres, database.DriverMySQL, c.Driver)
+	}
+	if c.Host == "" || c.User == "" || c.Name == "" {
+		return fmt.Errorf("db: host, user and name are required")
+	}
+	if c.ReadReplicaDSN != "" && c.Driver != database.DriverPostgres {
+		return fmt.Errorf("db: read replicas are only supported with %s", database.DriverPostgres)
+	}
+	if err := validatePort(c.Port); err != nil {
+		return fmt.Errorf("db: %w", err)
+	}
+	switch c.SSLMode {
+	case "disable", "allow", "prefer", "require", "verify-ca", "verify-full":
+	default:
+		return fmt.Errorf("db: sslmode must be disable, allow, prefer, require, verify-ca or verify-full, got %q", c.SSLMode)
+	}
+	if (c.SSLCert == "") != (c.SSLKey == "") {
+		return fmt.Errorf("db: client certificate and key must be set together")
+	}
+	if c.MigrationsBaseline < 0 {
+		return fmt.Errorf("db: migrations baseline must not be negative")
+	}
+	if c.LogBufferSize < 1 || c.LogBatchSize < 1 || c.LogFlushInterval <= 0 {
+		return fmt.Errorf("db: request log buffer size, batch size and flush interval must be positive")
+	}
+	// Written so NaN fails too
+	if !(c.LogSuccessSampleRate >= 0 && c.LogSuccessSampleRate <= 1) || !(c.LogErrorSampleRate >= 0 && c.LogErrorSampleRate <= 1) {
+		return fmt.Errorf("db: request log sample rates must be between 0 and 1")
+	}
+	switch c.LogIPMode {
+	case database.IPModeFull, database.IPModeTruncate:
+	case database.IPModeHMAC:
+		if len(c.LogIPHMACSecret) < 16 {
+			return fmt.Errorf("db: request log IP HMAC secret must be at least 16 characters")
+		}
+		if c.LogIPHMACRotation <= 0 {
+			return fmt.Errorf("db: request log IP HMAC rotation must be positive")
+		}
+	default:
+		return fmt.Errorf("db: request log IP mode must be %s, %s or %s, got %q", database.IPModeFull, database.IPModeTruncate, database.IPModeHMAC, c.LogIPMode)
+	}
+	if c.LogSpoolPath != "" && c.LogSpoolMaxBytes < 1 {
+		return fmt.Errorf("db: request log spool max bytes must be positive")
+	}
+	if len(c.LogSinks) == 0 {
+		return fmt.Errorf("db: at least one request log sink is required")
+	}
+	seen := make(map[string]bool)
+	for _, sink := range c.LogSinks {
+		if seen[sink] {
+			return fmt.Errorf("db: request log sink %q is listed twice", sink)
+		}
+		seen[sink] = true
+		switch sink {
+		case database.SinkPostgres, database.SinkMySQL:
+			if sink != c.Driver {
+				return fmt.Errorf("db: request log sink %q needs the %s driver, not %s", sink, sink, c.Driver)
+			}
+		case database.SinkClickHouse:
+			if err := c.ClickHouse.Validate(); err != nil {
+				return err
+			}
+		case database.SinkKafka:
+			if err := c.Kafka.Validate(); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("db: request log sinks must be %s, %s, %s or %s, got %q", database.SinkPostgres, database.SinkMySQL, database.SinkClickHouse, database.SinkKafka, sink)
+		}
+	}
+	if c.LogRetention < 0 || c.LogRetentionInterval <= 0 || c.LogRetentionBatchSize < 0 {
+		return fmt.Errorf("db: request log retention and batch size must not be negative and the retention interval must be positive")
+	}
+	if c.LogArchive.Bucket != "" {
+		if c.LogRetention == 0 {
+			return fmt.Errorf("db: request log archiving requires a request log retention")
+		}
+		if err := c.LogArchive.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c ArchiveConfig) Validate() error {
+	if c.Endpoint != "" {
+		u, err := url.Parse(c.Endpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("archive: endpoint must be an http or https URL, got %q", c.Endpoint)
+		}
+	}
+	if c.Region == "" || c.AccessKeyID == "" || c.SecretAccessKey == "" {
+		return fmt.Errorf("archive: region, access key ID and secret access key are required")
+	}
+	if c.Timeout <= 0 {
+		return fmt.Errorf("archive: timeout must be positive")
+	}
+	return nil
+}
+
+func (c ClickHouseConfig) Validate() error {
+	u, err := url.Parse(c.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("clickhouse: url must be an http or https URL, got %q", c.URL)
+	}
+	if c.Database == "" || c.Table == "" {
+		return fmt.Errorf("clickhouse: database and table are required")
+	}
+	if c.Timeout <= 0 {
+		return fmt.Errorf("clickhouse: timeout must be positive")
+	}
+	return nil
+}
+
+func (c KafkaConfig) Validate() error {
+	if len(c.Brokers) == 0 || c.Topic == "" {
+		return fmt.Errorf("kafka: brokers and topic are required")
+	}
+	if c.Timeout <= 0 {
+		return fmt.Errorf("kafka: timeout must be positive")
+	}
+	return nil
+}
+
+func (c TracingConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.ServiceName == "" || c.Endpoint == "" {
+		return fmt.Errorf("tracing: service name and endpoint are required when enabled")
+	}
+	return nil
+}
+
+func (c CacheConfig) Validate() error {
+	if c.Backend != cache.BackendRedis && c.Backend != cache.BackendMemory {
+		return fmt.Errorf("cache: backend must be %s or %s, got %q", cache.BackendRedis, cache.BackendMemory, c.Backend)
+	}
+	usesMemory := c.Backend == cache.BackendMemory || c.L1TTL > 0 || c.FallbackEnabled
+	if usesMemory && c.MemoryMaxEntries <= 0 {
+		return fmt.Errorf("cache: memory max entries must be positive")
+	}
+	if c.L1TTL < 0 {
+		return fmt.Errorf("cache: L1 TTL must not be negative")
+	}
+	if c.FallbackEnabled && c.FallbackProbeInterval <= 0 {
+		return fmt.Errorf("cache: fallback probe interval must be positive")
+	}
+	if c.StaleWhileRevalidate < 0 {
+		return fmt.Errorf("cache: stale-while-revalidate window must not be negative")
+	}
+	if c.LastKnownMaxAge < 0 {
+		return fmt.Errorf("cache: last known max age must not be negative")
+	}
+	if c.TTL <= 0 {
+		return fmt.Errorf("cache: TTL must be positive")
+	}
+	if c.RawTickerEnabled && c.RawTickerTTL <= 0 {
+		return fmt.Errorf("cache: raw ticker TTL must be positive")
+	}
+	if c.WarmEnabled && (c.WarmFrequentPairs < 0 || c.WarmLookback <= 0 || c.WarmTimeout <= 0) {
+		return fmt.Errorf("cache: warm frequent pairs must not be negative, and warm lookback and timeout must be positive")
+	}
+	if c.BypassPerMinute < 0 || c.BypassBurst < 0 {
+		return fmt.Errorf("cache: bypass limits must not be negative")
+	}
+	if c.FetchLockTTL < 0 || (c.FetchLockTTL > 0 && c.FetchLockWait <= 0) {
+		return fmt.Errorf("cache: fetch lock TTL must not be negative, and its wait must be positive")
+	}
+	if c.RefreshEnabled {
+		if c.RefreshInterval <= 0 || c.RefreshActiveWindow <= 0 {
+			return fmt.Errorf("cache: refresh interval and active window must be positive")
+		}
+		if c.RefreshLead < 0 || c.RefreshLead >= c.TTL {
+			return fmt.Errorf("cache: refresh lead must be non-negative and shorter than the TTL")
+		}
+	}
+	return nil
+}
+
+func (c ProvidersConfig) Validate() error {
+	u, err := url.Parse(c.Kraken.BaseURL)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("providers: invalid Kraken base URL %q", c.Kraken.BaseURL)
+	}
+	if c.Kraken.MaintenanceFeedURL != "" && c.Kraken.MaintenanceCheckInterval <= 0 {
+		return fmt.Errorf("providers: Kraken maintenance check interval must be positive")
+	}
+	if c.Kraken.Timeout <= 0 || c.Kraken.DialTimeout <= 0 || c.Kraken.ResponseHeaderTimeout <= 0 || c.Kraken.IdleConnTimeout <= 0 {
+		return fmt.Errorf("providers: Kraken timeouts must be positive")
+	}
+	if c.Kraken.MaxIdleConns <= 0 {
+		return fmt.Errorf("providers: Kraken max idle connections must be positive")
+	}
+	if c.Kraken.MaxResponseBytes <= 0 {
+		return fmt.Errorf("providers: Kraken max response bytes must be positive")
+	}
+	if c.Kraken.RetryMaxAttempts < 1 {
+		return fmt.Errorf("providers: Kraken retry max attempts must be at least 1")
+	}
+	if c.Kraken.RetryMaxAttempts > 1 && (c.Kraken.RetryBackoff <= 0 || c.Kraken.MaxRetryBackoff < c.Kraken.RetryBackoff) {
+		return fmt.Errorf("providers: Kraken retry backoff must be positive and at most the max retry backoff")
+	}
+	if c.Kraken.BreakerFailureThreshold < 0 {
+		return fmt.Errorf("providers: Kraken breaker failure threshold must not be negative")
+	}
+	if c.Kraken.BreakerFailureThreshold > 0 && c.Kraken.BreakerOpenDuration <= 0 {
+		return fmt.Errorf("providers: Kraken breaker open duration must be positive")
+	}
+	if c.Kraken.MaxConcurrentFetches < 1 {
+		return fmt.Errorf("providers: Kraken max concurrent fetches must be at least 1")
+	}
+	for _, status := range c.Kraken.RetryableStatuses {
+		if status < 100 || status > 599 {
+			return fmt.Errorf("providers: invalid retryable status %d", status)
+		}
+	}
+	return nil
+}
+
+func (c AuthConfig) Validate() error {
+	if c.Enabled && len(c.APIKeys) == 0 {
+		return fmt.Errorf("auth: at least one API key is required when enabled")
+	}
+	if _, err := middleware.NewStaticKeyStore(c.APIKeys, c.AdminAPIKeys); err != nil {
+		return fmt.Errorf("auth: %w", err)
+	}
+	return nil
+}
+
+func (c RateLimitConfig) Validate() error {
+	var errs []error
+	if c.PerMinute <= 0 {
+		errs = append(errs, fmt.Errorf("rate limit: per minute must be positive"))
+	}
+	if c.Burst <= 0 {
+		errs = append(errs, fmt.Errorf("rate limit: burst must be positive"))
+	}
+	if c.UsageFlushInterval <= 0 {
+		errs = append(errs, fmt.Errorf("rate limit: usage flush interval must be positive"))
+	}
+	return errors.Join(errs...)
+}
+
+func (c AlertsConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	var errs []error
+	if c.EvaluationInterval <= 0 || c.WebhookTimeout <= 0 || c.DeliveryInterval <= 0 {
+		errs = append(errs, fmt.Errorf("alerts: evaluation interval, webhook timeout and delivery interval must be positive"))
+	}
+	if c.MaxAttempts < 1 {
+		errs = append(errs, fmt.Errorf("alerts: max attempts must be at least 1"))
+	}
+	if c.RetryBackoff <= 0 || c.MaxRetryBackoff < c.RetryBackoff {
+		errs = append(errs, fmt.Errorf("alerts: retry backoff must be positive and no greater than the max retry backoff"))
+	}
+	return errors.Join(errs...)
+}
+
+func (c HealthConfig) Validate() error {
+	if c.FailureThreshold < 1 || c.RecoveryThreshold < 1 {
+		return fmt.Errorf("health: failure and recovery thresholds must be at least 1")
+	}
+	return nil
+}
+
+func (c PricesConfig) Validate() error {
+	var errs []error
+	if c.Precision > 8 {
+		errs = append(errs, fmt.Errorf("prices: precision must be at most 8"))
+	}
+	switch c.Rounding {
+	case "half_up", "half_even", "down", "up":
+	default:
+		errs = append(errs, fmt.Errorf("prices: invalid rounding mode %q", c.Rounding))
+	}
+	if c.UpstreamBudget < 0 {
+		errs = append(errs, fmt.Errorf("prices: upstream budget must not be negative"))
+	}
+	return errors.Join(errs...)
+}
+
+func validatePort(port string) error {
+	n, err := strconv.Atoi(port)
+	if err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("invalid port %q", port)
+	}
+	return nil
+}
+
+// envReader reads typed values from the environment, collecting parse
+// errors so they can be reported together
+type envReader struct {
+	errs []error
+}
+
+func (e *envReader) String(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
 	}
 	return defaultValue
 }
+
+func (e *envReader) Bool(key string, defaultValue bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		e.errs = append(e.errs, fmt.Errorf("%s: invalid boolean %q", key, value))
+		return defaultValue
+	}
+	return b
+}
+
+func (e *envReader) Duration(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		e.errs = append(e.errs, fmt.Errorf("%s: invalid duration %q", key, value))
+		return defaultValue
+	}
+	return d
+}
+
+func (e *envReader) Float(key string, defaultValue float64) float64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		e.errs = append(e.errs, fmt.Errorf("%s: invalid number %q", key, value))
+		return defaultValue
+	}
+	return f
+}
+
+func (e *envReader) Int(key string, defaultValue int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		e.errs = append(e.errs, fmt.Errorf("%s: invalid integer %q", key, value))
+		return defaultValue
+	}
+	return n
+}
+
+// IntList splits a comma-separated list of integers, dropping empty entries
+func (e *envReader) IntList(key string, defaultValue []int) []int {
+	items := e.List(key, nil)
+	if items == nil {
+		return defaultValue
+	}
+	numbers := make([]int, 0, len(items))
+	for _, item := range items {
+		n, err := strconv.Atoi(item)
+		if err != nil {
+			e.errs = append(e.errs, fmt.Errorf("%s: invalid integer %q", key, item))
+			return defaultValue
+		}
+		numbers = append(numbers, n)
+	}
+	return numbers
+}
+
+// List splits a comma-separated value, dropping empty entries
+func (e *envReader) List(key string, defaultValue []string) []string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
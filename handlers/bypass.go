@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/chesskiss/btc-service/internal/middleware"
+)
+
+// maxBypassCallers bounds how many callers' buckets are kept before those
+// that have refilled are forgotten
+const maxBypassCallers = 10000
+
+// bypassLimiters gives each caller, an API key or else a client IP, its
+// own bucket of requests that skip the cache on this replica, so no
+// caller can flood Kraken or spend the others' allowance
+type bypassLimiters struct {
+	mu      sync.Mutex
+	limit   rate.Limit
+	burst   int
+	callers map[string]*rate.Limiter
+}
+
+var bypassLimits = &bypassLimiters{
+	limit:   rate.Limit(1),
+	burst:   10,
+	callers: make(map[string]*rate.Limiter),
+}
+
+// SetCacheBypassLimit sets how many cache-bypassing requests each caller
+// is allowed per minute, with bursts of up to burst
+func SetCacheBypassLimit(perMinute, burst int) {
+	bypassLimits.mu.Lock()
+	defer bypassLimits.mu.Unlock()
+	bypassLimits.limit = rate.Limit(float64(perMinute) / 60)
+	bypassLimits.burst = burst
+	bypassLimits.callers = make(map[string]*rate.Limiter)
+}
+
+// limiter returns the caller's bucket
+func (l *bypassLimiters) limiter(caller string) *rate.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if limiter, ok := l.callers[caller]; ok {
+		return limiter
+	}
+	if len(l.callers) >= maxBypassCallers {
+		// A refilled bucket is no different from a new one
+		now := time.Now()
+		for key, limiter := range l.callers {
+			if limiter.TokensAt(now) >= float64(limiter.Burst()) {
+				delete(l.callers, key)
+			}
+		}
+	}
+	limiter := rate.NewLimiter(l.limit, l.burst)
+	l.callers[caller] = limiter
+	return limiter
+}
+
+// bypassGrant takes at most one token from a caller's bucket for a
+// request, when the first of its pairs turns out to be older in the cache
+// than the caller accepts. Requests whose cached prices are fresh enough
+// spend nothing.
+type bypassGrant struct {
+	limiter *rate.Limiter
+
+	once       sync.Once
+	allowed    bool
+	retryAfter int
+}
+
+// newBypassGrant returns the grant for a request, drawing on the bucket of
+// the API key it authenticated with or else its client IP
+func newBypassGrant(r *http.Request) *bypassGrant {
+	caller := "ip:" + middleware.ClientIP(r)
+	if apiKeyID := middleware.GetAPIKeyID(r.Context()); apiKeyID != "" {
+		caller = "key:" + apiKeyID
+	}
+	return &bypassGrant{limiter: bypassLimits.limiter(caller)}
+}
+
+// allow reports whether the request may skip the cache, taking the token
+// on the first call
+func (g *bypassGrant) allow() bool {
+	g.once.Do(func() {
+		g.allowed, g.retryAfter = takeBypassToken(g.limiter)
+	})
+	return g.allowed
+}
+
+// parseMaxAge reads the max_age query parameter, the oldest cached price in
+// seconds the client accepts, or fresh=true, which is max_age=0. ok is
+// false when neither is given.
+func parseMaxAge(r *http.Request) (maxAge time.Duration, ok bool, err error) {
+	query := r.URL.Query()
+
+	if value := query.Get("max_age"); value != "" {
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds < 0 {
+			return 0, false, fmt.Errorf("invalid max_age %q: must be a non-negative number of seconds", value)
+		}
+		maxAge, ok = time.Duration(seconds)*time.Second, true
+	}
+
+	if value := query.Get("fresh"); value != "" {
+		fresh, err := strconv.ParseBool(value)
+		if err != nil {
+			return 0, false, fmt.Errorf("invalid fresh %q: must be true or false", value)
+		}
+		if fresh {
+			maxAge, ok = 0, true
+		}
+	}
+
+	return maxAge, ok, nil
+}
+
+// takeBypassToken takes a token for a cache-bypassing request, or returns
+// how many seconds to wait before retrying
+func takeBypassToken(limiter *rate.Limiter) (bool, int) {
+	reservation := limiter.Reserve()
+	delay := reservation.Delay()
+	if delay == 0 {
+		return true, 0
+	}
+	reservation.Cancel()
+	if delay == rate.InfDuration {
+		return false, 60
+	}
+	return false, int(math.Ceil(delay.Seconds()))
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/chesskiss/btc-service/internal/clock"
+	"github.com/chesskiss/btc-service/internal/pb"
+	"github.com/chesskiss/btc-service/services"
+)
+
+const (
+	formatJSON     = "json"
+	formatCSV      = "csv"
+	formatProtobuf = "protobuf"
+	formatMsgpack  = "msgpack"
+)
+
+// contentTypes maps each response format to its media type
+var contentTypes = map[string]string{
+	formatJSON:     "application/json",
+	formatCSV:      "text/csv; charset=utf-8",
+	formatProtobuf: "application/x-protobuf",
+	formatMsgpack:  "application/msgpack",
+}
+
+// negotiateFormat picks the response format from the format query
+// parameter, falling back to the Accept header and then JSON
+func negotiateFormat(r *http.Request) (string, error) {
+	switch format := strings.ToLower(r.URL.Query().Get("format")); format {
+	case formatJSON, formatCSV, formatProtobuf, formatMsgpack:
+		return format, nil
+	case "":
+	default:
+		return "", fmt.Errorf("unsupported format %q: must be json, csv, protobuf or msgpack", format)
+	}
+
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType := strings.TrimSpace(strings.SplitN(accepted, ";", 2)[0])
+		switch mediaType {
+		case "application/json":
+			return formatJSON, nil
+		case "text/csv":
+			return formatCSV, nil
+		case "application/x-protobuf", "application/protobuf":
+			return formatProtobuf, nil
+		case "application/msgpack", "application/x-msgpack":
+			return formatMsgpack, nil
+		}
+	}
+
+	return formatJSON, nil
+}
+
+// writeQuotesCSV writes one pair,price,timestamp,stale row per quote
+func writeQuotesCSV(w io.Writer, quotes []services.PairQuote) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"pair", "price", "timestamp", "stale"}); err != nil {
+		return err
+	}
+	for _, pq := range quotes {
+		if err := cw.Write([]string{
+			pq.Pair,
+			pq.Quote.Decimal,
+			pq.Quote.Timestamp.UTC().Format(time.RFC3339),
+			strconv.FormatBool(pq.Quote.Stale),
+		}); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeQuotesProtobuf writes the prices and per-pair errors as a
+// pb.LTPResponse message
+func writeQuotesProtobuf(w io.Writer, result services.PriceResult) error {
+	now := clock.Now()
+	msg := &pb.LTPResponse{}
+	for _, pq := range result.Quotes {
+		msg.Ltp = append(msg.Ltp, &pb.PairPrice{
+			Pair:       pq.Pair,
+			Amount:     pq.Quote.Price,
+			Price:      pq.Quote.Decimal,
+			Timestamp:  timestamppb.New(pq.Quote.Timestamp),
+			Source:     pq.Quote.Source,
+			AgeSeconds: int64(now.Sub(pq.Quote.Timestamp).Seconds()),
+			Cached:     pq.Quote.Cached,
+			Stale:      pq.Quote.Stale,
+		})
+	}
+	for _, pairErr := range result.Errors {
+		msg.Errors = append(msg.Errors, &pb.PairError{
+			Pair:   pairErr.Pair,
+			Reason: pairErr.Reason,
+		})
+	}
+
+	body, err := proto.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(body)
+	return err
+}
+
+// writeMsgpack encodes v as MessagePack using its JSON field names, so the
+// keys match the JSON response
+func writeMsgpack(w io.Writer, v interface{}) error {
+	enc := msgpack.NewEncoder(w)
+	enc.SetCustomStructTag("json")
+	return enc.Encode(v)
+}
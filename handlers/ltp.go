@@ -1,10 +1,12 @@
 package handlers
 
 import (
+    "bytes"
     "encoding/json"
     "fmt"
     "log/slog"
     "net/http"
+    "strconv"
     "strings"
     "time"
 
@@ -12,16 +14,33 @@ import (
     "go.opentelemetry.io/otel/attribute"
     "go.opentelemetry.io/otel/codes"
 
+    "github.com/chesskiss/btc-service/clients"
     "github.com/chesskiss/btc-service/internal/database"
     "github.com/chesskiss/btc-service/internal/metrics"
     "github.com/chesskiss/btc-service/internal/middleware"
+    "github.com/chesskiss/btc-service/internal/problem"
+    "github.com/chesskiss/btc-service/internal/projection"
     "github.com/chesskiss/btc-service/services"
 )
 
 var cacheHits int
 var krakenCalls int
 
-func LTPHandler(w http.ResponseWriter, r *http.Request) {
+// LTPHandler serves /api/v1/ltp, queuing a request log with logs, which
+// may be nil to log nothing
+func LTPHandler(logs *database.RequestLogWriter) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        serveLTP(w, r, logs, services.PairPrice{}, func(result services.PriceResult) interface{} {
+            return services.LTPResponse{LTP: result.Prices, Errors: result.Errors}
+        })
+    }
+}
+
+// serveLTP fetches the requested prices, records metrics, traces and the
+// request log, and writes the body built by render, or CSV rows when the
+// client asks for them. element is the type of the rendered ltp entries,
+// which the fields parameter selects from.
+func serveLTP(w http.ResponseWriter, r *http.Request, logs *database.RequestLogWriter, element interface{}, render func(services.PriceResult) interface{}) {
     // Start tracing span
     tracer := otel.Tracer("btc-service")
     ctx, span := tracer.Start(r.Context(), "handle_ltp_request")
@@ -30,7 +49,39 @@ func LTPHandler(w http.ResponseWriter, r *http.Request) {
     startTime := time.Now()
     requestID := middleware.GetRequestID(ctx)
 
-    w.Header().Set("Content-Type", "application/json")
+    format, err := negotiateFormat(r)
+    if err != nil {
+        problem.Write(w, r, problem.New(http.StatusBadRequest, problem.CodeUnsupportedFormat, err.Error()))
+        return
+    }
+
+    precision, err := parsePrecision(r)
+    if err != nil {
+        problem.Write(w, r, problem.New(http.StatusBadRequest, problem.CodeInvalidParameter, err.Error()))
+        return
+    }
+
+    fields, err := projection.Parse(r, element)
+    if err != nil {
+        problem.Write(w, r, problem.New(http.StatusBadRequest, problem.CodeInvalidParameter, err.Error()))
+        return
+    }
+
+    // Clients needing a fresher price than the cache holds may fetch it
+    // live, within their bypass rate limit
+    maxAge, bypass, err := parseMaxAge(r)
+    if err != nil {
+        problem.Write(w, r, problem.New(http.StatusBadRequest, problem.CodeInvalidParameter, err.Error()))
+        return
+    }
+    var grant *bypassGrant
+    if bypass {
+        grant = newBypassGrant(r)
+        ctx = clients.WithMaxAge(ctx, maxAge, grant.allow)
+        span.SetAttributes(attribute.Int64("request.max_age_seconds", int64(maxAge.Seconds())))
+    }
+
+    w.Header().Set("Content-Type", contentTypes[format])
 
     pairsParam := r.URL.Query().Get("pairs")
 
@@ -49,6 +100,13 @@ func LTPHandler(w http.ResponseWriter, r *http.Request) {
     )
 
     result := services.GetPrices(ctx, pairsParam)
+    applyPrecision(&result, precision)
+
+    // Let clients know prices may be stale while the exchange is down
+    if window, ok := clients.ActiveMaintenance(); ok {
+        w.Header().Set("X-Exchange-Status", "maintenance")
+        span.SetAttributes(attribute.String("exchange.maintenance", window.Name))
+    }
 
     // Calculate response time
     duration := time.Since(startTime)
@@ -59,17 +117,41 @@ func LTPHandler(w http.ResponseWriter, r *http.Request) {
     successCount := len(result.Prices)
     errorOccurred := result.ErrorsCount > 0
 
-    // Determine cache hit (if any prices were returned and response was fast)
-    cacheHit := successCount > 0 && responseTime < 100
+    // A cache hit means every returned price came from the cache
+    cacheHit := successCount > 0 && len(result.CachedPairs) == successCount
+    cachedPairs := strings.Join(result.CachedPairs, ",")
 
     // Get client IP
-    userIP := getClientIP(r)
+    userIP := middleware.ClientIP(r)
 
     // Determine HTTP status code
     statusCode := http.StatusOK
-    if errorOccurred && successCount == 0 {
+    var failure *problem.Details
+    if errorOccurred && successCount == 0 && len(result.InvalidPairs) > 0 {
+        // Nothing usable was requested - client error
+        statusCode = http.StatusBadRequest
+        details := problem.New(statusCode, problem.CodeInvalidPair, "one or more requested pairs are invalid or not supported").
+            WithFailedPairs(result.InvalidPairs)
+        failure = &details
+    } else if errorOccurred && successCount == 0 && allFailedWith(result.Errors, services.ReasonBypassRateLimited) {
+        // Every cached price was too old and the caller is out of bypasses
+        statusCode = http.StatusTooManyRequests
+        w.Header().Set("Retry-After", strconv.Itoa(grant.retryAfter))
+        details := problem.New(statusCode, problem.CodeRateLimited, "too many requests bypassing the cache; retry later or accept cached prices").
+            WithFailedPairs(result.FailedPairs)
+        failure = &details
+    } else if errorOccurred && successCount == 0 && allFailedWith(result.Errors, services.ReasonUpstreamRateLimited) {
+        // Kraken is throttling us - the client should back off too
+        statusCode = http.StatusTooManyRequests
+        details := problem.New(statusCode, problem.CodeUpstreamRateLimited, "the exchange is rate limiting price requests; retry later").
+            WithFailedPairs(result.FailedPairs)
+        failure = &details
+    } else if errorOccurred && successCount == 0 {
         // All requests failed - service unavailable
         statusCode = http.StatusServiceUnavailable
+        details := problem.New(statusCode, problem.CodeUpstreamUnavailable, "prices could not be fetched from the exchange").
+            WithFailedPairs(result.FailedPairs)
+        failure = &details
     } else if errorOccurred {
         // Partial failure - still return 200 with partial data
         statusCode = http.StatusOK
@@ -81,7 +163,9 @@ func LTPHandler(w http.ResponseWriter, r *http.Request) {
         attribute.Int("response.pairs_count", successCount),
         attribute.Int("response.errors_count", result.ErrorsCount),
         attribute.Bool("response.cache_hit", cacheHit),
+        attribute.StringSlice("response.cached_pairs", result.CachedPairs),
         attribute.Int("response.kraken_calls", totalRequests),
+        attribute.Bool("response.budget_exceeded", result.BudgetExceeded),
         attribute.Int("response.time_ms", responseTime),
     )
 
@@ -104,52 +188,90 @@ func LTPHandler(w http.ResponseWriter, r *http.Request) {
         "pairs_count", successCount,
         "errors_count", result.ErrorsCount,
         "cache_hit", cacheHit,
+        "cached_pairs", cachedPairs,
         "duration_ms", responseTime,
     )
 
-    // Log request to database (don't fail if DB is down)
-    go func() {
-        _ = database.LogRequest(database.RequestLog{
-            RequestID:      requestID,
-            Method:         r.Method,
-            Endpoint:       r.URL.Path,
-            PairsRequested: pairsParam,
-            UserIP:         userIP,
-            StatusCode:     statusCode,
-            ResponseTimeMs: responseTime,
-            CacheHit:       cacheHit,
-            KrakenCalls:    totalRequests,
-            ErrorOccurred:  errorOccurred,
-            ErrorMessage:   result.ErrorMessage,
-        })
-    }()
+    // Encode the body up front so its size can be logged
+    var body bytes.Buffer
+    if failure != nil {
+        w.Header().Set("Content-Type", problem.ContentType)
+        json.NewEncoder(&body).Encode(failure.ForRequest(r))
+    } else if format == formatCSV {
+        writeQuotesCSV(&body, result.Quotes)
+    } else if format == formatProtobuf {
+        writeQuotesProtobuf(&body, result)
+    } else if format == formatMsgpack {
+        writeMsgpack(&body, fields.Apply(render(result), "ltp"))
+    } else {
+        json.NewEncoder(&body).Encode(fields.Apply(render(result), "ltp"))
+    }
+    responseBytes := body.Len()
+
+    traceID := ""
+    if spanContext := span.SpanContext(); spanContext.HasTraceID() {
+        traceID = spanContext.TraceID().String()
+    }
+    tenantID := middleware.GetTenantID(r.Context())
+    userAgent := r.UserAgent()
+
+    // Queue the request log for the background writer, which drops it
+    // rather than blocking if the database can't keep up
+    logs.Enqueue(database.RequestLog{
+        RequestID:         requestID,
+        Method:            r.Method,
+        Endpoint:          r.URL.Path,
+        PairsRequested:    pairsParam,
+        UserIP:            userIP,
+        StatusCode:        statusCode,
+        ResponseTimeMs:    responseTime,
+        CacheHit:          cacheHit,
+        CachedPairs:       cachedPairs,
+        KrakenCalls:       totalRequests,
+        ErrorOccurred:     errorOccurred,
+        ErrorMessage:      result.ErrorMessage,
+        TraceID:           traceID,
+        TenantID:          tenantID,
+        UserAgent:         userAgent,
+        ResponseBytes:     responseBytes,
+        UpstreamLatencyMs: int(result.UpstreamLatency.Milliseconds()),
+        APIKeyID:          middleware.GetAPIKeyID(r.Context()),
+        PairOutcomes:      pairOutcomes(result),
+    })
 
     // Set response status
     w.WriteHeader(statusCode)
 
     // Return response
-    json.NewEncoder(w).Encode(
-        services.LTPResponse{LTP: result.Prices},
-    )
+    w.Write(body.Bytes())
 }
 
-func getClientIP(r *http.Request) string {
-    // Check X-Forwarded-For header first (for proxied requests)
-    if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
-        ips := strings.Split(forwarded, ",")
-        return strings.TrimSpace(ips[0])
+// pairOutcomes lists what happened to each requested pair, priced pairs
+// first
+func pairOutcomes(result services.PriceResult) []database.PairOutcome {
+    outcomes := make([]database.PairOutcome, 0, len(result.Prices)+len(result.Errors))
+    for _, price := range result.Prices {
+        outcomes = append(outcomes, database.PairOutcome{
+            Pair:     price.Pair,
+            Success:  true,
+            CacheHit: price.Cached,
+        })
     }
-
-    // Check X-Real-IP header
-    if realIP := r.Header.Get("X-Real-IP"); realIP != "" {
-        return realIP
+    for _, pairErr := range result.Errors {
+        outcomes = append(outcomes, database.PairOutcome{
+            Pair:   pairErr.Pair,
+            Reason: pairErr.Reason,
+        })
     }
+    return outcomes
+}
 
-    // Fall back to RemoteAddr
-    ip := r.RemoteAddr
-    if colonIndex := strings.LastIndex(ip, ":"); colonIndex != -1 {
-        ip = ip[:colonIndex]
+// allFailedWith reports whether every pair in errs failed for reason
+func allFailedWith(errs []services.PairError, reason string) bool {
+    for _, pairErr := range errs {
+        if pairErr.Reason != reason {
+            return false
+        }
     }
-
-    return ip
+    return len(errs) > 0
 }
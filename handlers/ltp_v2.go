@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/chesskiss/btc-service/internal/clock"
+	"github.com/chesskiss/btc-service/internal/database"
+	"github.com/chesskiss/btc-service/services"
+)
+
+// PairPriceV2 reports a price as an exact decimal string with its provenance
+type PairPriceV2 struct {
+	Pair       string    `json:"pair"`
+	Price      string    `json:"price"`
+	Timestamp  time.Time `json:"timestamp"`
+	Source     string    `json:"source"`
+	AgeSeconds int64     `json:"age_seconds"`
+	Cached     bool      `json:"cached"`
+	// Stale marks a last known price served because Kraken is unavailable
+	Stale bool `json:"stale,omitempty"`
+}
+
+type LTPV2Response struct {
+	LTP    []PairPriceV2        `json:"ltp"`
+	Errors []services.PairError `json:"errors,omitempty"`
+}
+
+// LTPV2Handler serves /api/v2/ltp, which returns prices as decimal strings
+// so consumers never see float64 rounding. Request logs are queued with
+// logs, as by LTPHandler.
+func LTPV2Handler(logs *database.RequestLogWriter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		serveLTP(w, r, logs, PairPriceV2{}, func(result services.PriceResult) interface{} {
+			now := clock.Now()
+			prices := make([]PairPriceV2, 0, len(result.Quotes))
+			for _, pq := range result.Quotes {
+				prices = append(prices, PairPriceV2{
+					Pair:       pq.Pair,
+					Price:      pq.Quote.Decimal,
+					Timestamp:  pq.Quote.Timestamp.UTC(),
+					Source:     pq.Quote.Source,
+					AgeSeconds: int64(now.Sub(pq.Quote.Timestamp).Seconds()),
+					Cached:     pq.Quote.Cached,
+					Stale:      pq.Quote.Stale,
+				})
+			}
+			return LTPV2Response{LTP: prices, Errors: result.Errors}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/chesskiss/btc-service/services"
+)
+
+// Rounding modes for prices formatted to a fixed precision
+const (
+	RoundHalfUp   = "half_up"
+	RoundHalfEven = "half_even"
+	RoundDown     = "down"
+	RoundUp       = "up"
+)
+
+// MaxPrecision is the most decimal places a price can be formatted to
+const MaxPrecision = 8
+
+// defaultPrecision applies when a request has no precision parameter; a
+// negative value keeps the precision reported by the exchange
+var defaultPrecision = -1
+var roundingMode = RoundHalfEven
+
+// SetPricePrecision sets the default number of decimal places and how
+// prices are rounded to them
+func SetPricePrecision(places int, mode string) {
+	defaultPrecision = places
+	roundingMode = mode
+}
+
+// parsePrecision reads the precision query parameter, falling back to the
+// server default
+func parsePrecision(r *http.Request) (int, error) {
+	value := r.URL.Query().Get("precision")
+	if value == "" {
+		return defaultPrecision, nil
+	}
+	places, err := strconv.Atoi(value)
+	if err != nil || places < 0 || places > MaxPrecision {
+		return 0, fmt.Errorf("invalid precision %q: must be an integer between 0 and %d", value, MaxPrecision)
+	}
+	return places, nil
+}
+
+// applyPrecision rounds every price in result to places decimal places,
+// keeping the float and decimal forms consistent; negative places leave
+// the prices untouched
+func applyPrecision(result *services.PriceResult, places int) {
+	if places < 0 {
+		return
+	}
+
+	// Prices and Quotes are built together, one entry per fetched pair
+	for i := range result.Quotes {
+		quote := &result.Quotes[i].Quote
+		price, err := decimal.NewFromString(quote.Decimal)
+		if err != nil {
+			price = decimal.NewFromFloat(quote.Price)
+		}
+
+		rounded := roundDecimal(price, int32(places))
+		quote.Decimal = rounded.StringFixed(int32(places))
+		quote.Price = rounded.InexactFloat64()
+		if i < len(result.Prices) {
+			result.Prices[i].Amount = quote.Price
+		}
+	}
+}
+
+func roundDecimal(d decimal.Decimal, places int32) decimal.Decimal {
+	switch roundingMode {
+	case RoundHalfUp:
+		return d.Round(places)
+	case RoundDown:
+		return d.RoundDown(places)
+	case RoundUp:
+		return d.RoundUp(places)
+	default:
+		return d.RoundBank(places)
+	}
+}
@@ -0,0 +1,256 @@
+package alerts
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/chesskiss/btc-service/clients"
+	"github.com/chesskiss/btc-service/internal/clock"
+	"github.com/chesskiss/btc-service/internal/database"
+	"github.com/chesskiss/btc-service/internal/subsystems"
+)
+
+// SignatureHeader carries the hex HMAC-SHA256 of the webhook body, keyed
+// with the subscription secret, as "sha256=<hex>"
+const SignatureHeader = "X-Signature-256"
+
+// Event is the webhook payload sent when a threshold is crossed
+type Event struct {
+	SubscriptionID int64     `json:"subscription_id"`
+	Pair           string    `json:"pair"`
+	Direction      string    `json:"direction"`
+	Threshold      float64   `json:"threshold"`
+	Price          float64   `json:"price"`
+	Timestamp      time.Time `json:"timestamp"`
+	// Coalesced counts earlier crossings within the subscription's batch
+	// window that this event supersedes
+	Coalesced int `json:"coalesced,omitempty"`
+}
+
+// NewSecret returns a random webhook signing secret
+func NewSecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate secret: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// Sign returns the signature header value for a webhook body
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Crossed reports whether the price moved across the threshold in the
+// subscription's direction between two evaluations
+func Crossed(direction string, threshold, previous, current float64) bool {
+	switch direction {
+	case database.AlertAbove:
+		return previous < threshold && current >= threshold
+	case database.AlertBelow:
+		return previous > threshold && current <= threshold
+	default:
+		return false
+	}
+}
+
+// Deliver POSTs a signed payload to a callback URL
+func Deliver(ctx context.Context, client *http.Client, callbackURL, secret string, payload []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, callbackURL, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, Sign(secret, payload))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call webhook: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// evaluatorLock is held by the replica evaluating alerts, so replicas
+// don't each queue a webhook for the same crossing
+const evaluatorLock = "alert_evaluator"
+
+// StartEvaluator checks every subscription in store against the latest
+// prices each interval until ctx is cancelled, queueing a webhook for each
+// crossing. A pass is skipped while another replica is evaluating.
+func StartEvaluator(ctx context.Context, store database.Store, interval time.Duration) {
+	go func() {
+		ticker := clock.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C():
+				if subsystems.Paused(ctx, subsystems.AlertEvaluator) {
+					continue
+				}
+				ran, err := store.RunExclusive(ctx, evaluatorLock, func(ctx context.Context) error {
+					return Evaluate(ctx, store)
+				})
+				if err != nil {
+					slog.Warn("failed to evaluate alerts",
+						"error", err,
+					)
+				} else if !ran {
+					slog.Debug("alerts are being evaluated by another replica")
+				}
+			}
+		}
+	}()
+
+	slog.Info("alert evaluator started",
+		"interval", interval.String(),
+	)
+}
+
+// Evaluate runs one pass over all subscriptions, queueing webhooks for
+// those whose threshold was crossed since the previous pass
+func Evaluate(ctx context.Context, store database.Store) error {
+	subs, err := store.ListAlertSubscriptions(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Fetch each pair once, however many subscriptions watch it
+	prices := map[string]float64{}
+	for _, sub := range subs {
+		if _, ok := prices[sub.Pair]; ok {
+			continue
+		}
+		quote, err := clients.GetBTCQuote(ctx, strings.TrimPrefix(sub.Pair, "BTC/"))
+		if err != nil {
+			slog.Warn("failed to fetch price for alerts",
+				"pair", sub.Pair,
+				"error", err,
+			)
+			continue
+		}
+		prices[sub.Pair] = quote.Price
+	}
+
+	for _, sub := range subs {
+		price, ok := prices[sub.Pair]
+		if !ok {
+			continue
+		}
+
+		// The first evaluation only records a baseline to cross from
+		triggered := sub.LastPrice != nil && Crossed(sub.Direction, sub.Threshold, *sub.LastPrice, price)
+		if triggered {
+			if err := enqueue(ctx, store, sub, price); err != nil {
+				slog.Warn("failed to queue alert webhook",
+					"subscription_id", sub.ID,
+					"error", err,
+				)
+				// Keep the old baseline so the crossing is retried
+				continue
+			}
+		}
+
+		if err := store.RecordAlertEvaluation(ctx, sub.ID, price, triggered); err != nil {
+			slog.Warn("failed to record alert evaluation",
+				"subscription_id", sub.ID,
+				"error", err,
+			)
+		}
+	}
+
+	return nil
+}
+
+// enqueue queues a webhook for the crossing. With a batch window the
+// webhook is held back for the window, and crossings that happen meanwhile
+// replace its payload instead of queueing another call.
+func enqueue(ctx context.Context, store database.Store, sub database.AlertSubscription, price float64) error {
+	event := Event{
+		SubscriptionID: sub.ID,
+		Pair:           sub.Pair,
+		Direction:      sub.Direction,
+		Threshold:      sub.Threshold,
+		Price:          price,
+		Timestamp:      clock.Now().UTC(),
+	}
+
+	if sub.BatchWindowSeconds > 0 {
+		coalesced, err := coalesce(ctx, store, sub, event)
+		if err != nil || coalesced {
+			return err
+		}
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+
+	delay := time.Duration(sub.BatchWindowSeconds) * time.Second
+	id, err := store.EnqueueAlertDelivery(ctx, sub.ID, payload, delay)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("alert triggered",
+		"subscription_id", sub.ID,
+		"delivery_id", id,
+		"pair", sub.Pair,
+		"price", price,
+	)
+	return nil
+}
+
+// coalesce folds the event into the subscription's held-back webhook,
+// reporting false if there is none to fold into
+func coalesce(ctx context.Context, store database.Store, sub database.AlertSubscription, event Event) (bool, error) {
+	pending, ok, err := store.BatchingAlertDelivery(ctx, sub.ID)
+	if err != nil || !ok {
+		return false, err
+	}
+
+	var previous Event
+	if err := json.Unmarshal(pending.Payload, &previous); err != nil {
+		return false, fmt.Errorf("failed to decode queued event: %w", err)
+	}
+	event.Coalesced = previous.Coalesced + 1
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return false, fmt.Errorf("failed to encode event: %w", err)
+	}
+
+	replaced, err := store.ReplaceAlertDeliveryPayload(ctx, pending.ID, payload)
+	if err != nil || !replaced {
+		return false, err
+	}
+
+	slog.Info("alert coalesced",
+		"subscription_id", sub.ID,
+		"delivery_id", pending.ID,
+		"pair", sub.Pair,
+		"price", event.Price,
+		"coalesced", event.Coalesced,
+	)
+	return true, nil
+}
@@ -0,0 +1,106 @@
+package alerts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// ErrForbiddenCallback is returned for a callback whose host is, or
+// resolves to, an address webhooks may not be sent to
+var ErrForbiddenCallback = errors.New("callback host is not a public address")
+
+// forbiddenPrefixes are the special-use ranges netip.Addr has no
+// predicate for
+var forbiddenPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),       // this network
+	netip.MustParsePrefix("100.64.0.0/10"),   // carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),    // IETF protocol assignments
+	netip.MustParsePrefix("192.0.2.0/24"),    // documentation
+	netip.MustParsePrefix("198.18.0.0/15"),   // benchmarking
+	netip.MustParsePrefix("198.51.100.0/24"), // documentation
+	netip.MustParsePrefix("203.0.113.0/24"),  // documentation
+	netip.MustParsePrefix("240.0.0.0/4"),     // reserved, and broadcast
+	netip.MustParsePrefix("2001:db8::/32"),   // documentation
+}
+
+// CallbackPolicy decides where webhooks may be sent. Unless AllowPrivate
+// is set, callbacks to loopback, private, link-local (including cloud
+// metadata at 169.254.169.254) and other special-use addresses are
+// refused, so a subscription can't make the service call into its own
+// network.
+type CallbackPolicy struct {
+	// AllowPrivate lifts the restriction, for development against a
+	// receiver on the local network
+	AllowPrivate bool
+}
+
+// CheckURL resolves the host of a callback URL and fails if any of its
+// addresses is forbidden
+func (p CallbackPolicy) CheckURL(ctx context.Context, rawURL string) error {
+	if p.AllowPrivate {
+		return nil
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	host := u.Hostname()
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return fmt.Errorf("failed to resolve callback host %s: %w", host, err)
+	}
+	for _, addr := range addrs {
+		if ForbiddenAddr(addr) {
+			return fmt.Errorf("%w: %s is %s", ErrForbiddenCallback, host, addr)
+		}
+	}
+	return nil
+}
+
+// Client returns the HTTP client webhooks are sent with. Unless private
+// addresses are allowed, it refuses to connect to a forbidden address,
+// which also stops redirects to one and hosts that resolve differently
+// than when they were checked.
+func (p CallbackPolicy) Client(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout}
+	if !p.AllowPrivate {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if ForbiddenAddr(addrPort.Addr()) {
+				return fmt.Errorf("%w: %s", ErrForbiddenCallback, addrPort.Addr())
+			}
+			return nil
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// Through a proxy the dialled address would be the proxy's
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
+
+// ForbiddenAddr reports whether addr is not a public unicast address
+func ForbiddenAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return true
+	}
+	for _, prefix := range forbiddenPrefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,143 @@
+package alerts
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/chesskiss/btc-service/internal/clock"
+	"github.com/chesskiss/btc-service/internal/database"
+	"github.com/chesskiss/btc-service/internal/metrics"
+	"github.com/chesskiss/btc-service/internal/retry"
+	"github.com/chesskiss/btc-service/internal/subsystems"
+)
+
+// deliveryBatchSize bounds how many queued webhooks one pass attempts
+const deliveryBatchSize = 100
+
+// defaultLeaseTimeout stands in for the webhook timeout of a client
+// without one when working out how long to claim deliveries for
+const defaultLeaseTimeout = 30 * time.Second
+
+// StartDeliveryWorker sends the webhooks queued in store every interval,
+// to the addresses callbacks allows, until ctx is cancelled. A delivery
+// still failing after policy.MaxAttempts is dead-lettered.
+func StartDeliveryWorker(ctx context.Context, store database.Store, interval, webhookTimeout time.Duration, policy retry.Policy, callbacks CallbackPolicy) {
+	client := callbacks.Client(webhookTimeout)
+
+	go func() {
+		ticker := clock.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C():
+				if subsystems.Paused(ctx, subsystems.AlertDelivery) {
+					continue
+				}
+				if err := ProcessDeliveries(ctx, store, client, policy); err != nil {
+					slog.Warn("failed to process alert deliveries",
+						"error", err,
+					)
+				}
+			}
+		}
+	}()
+
+	slog.Info("alert delivery worker started",
+		"interval", interval.String(),
+		"max_attempts", policy.MaxAttempts,
+	)
+}
+
+// ProcessDeliveries claims the due deliveries and attempts each once,
+// scheduling failed ones for retry or dead-lettering them after the last
+// attempt. The claim lasts long enough to attempt every delivery in the
+// batch, so replicas running workers never send the same one twice; if
+// this one dies meanwhile, another picks them up once it lapses.
+func ProcessDeliveries(ctx context.Context, store database.Store, client *http.Client, policy retry.Policy) error {
+	timeout := client.Timeout
+	if timeout <= 0 {
+		timeout = defaultLeaseTimeout
+	}
+	deliveries, err := store.ClaimAlertDeliveries(ctx, deliveryBatchSize, deliveryBatchSize*timeout)
+	if err != nil {
+		return err
+	}
+
+	for _, delivery := range deliveries {
+		if deferred := throttle(ctx, store, delivery); deferred {
+			continue
+		}
+
+		err := Deliver(ctx, client, delivery.CallbackURL, delivery.Secret, delivery.Payload)
+		if err == nil {
+			metrics.AlertWebhooksTotal.WithLabelValues("delivered").Inc()
+			if err := store.MarkAlertDelivered(ctx, delivery.ID); err != nil {
+				slog.Warn("failed to record alert delivery",
+					"delivery_id", delivery.ID,
+					"error", err,
+				)
+			}
+			continue
+		}
+
+		attempts := delivery.Attempts + 1
+		var retryAfter time.Duration
+		if attempts < policy.MaxAttempts {
+			retryAfter = policy.Backoff(attempts)
+			metrics.AlertWebhooksTotal.WithLabelValues("retrying").Inc()
+		} else {
+			metrics.AlertWebhooksTotal.WithLabelValues("dead_letter").Inc()
+		}
+
+		slog.Warn("alert webhook failed",
+			"delivery_id", delivery.ID,
+			"subscription_id", delivery.SubscriptionID,
+			"attempts", attempts,
+			"retry_after", retryAfter.String(),
+			"error", err,
+		)
+
+		if err := store.MarkAlertDeliveryFailed(ctx, delivery.ID, err.Error(), retryAfter); err != nil {
+			slog.Warn("failed to record alert delivery failure",
+				"delivery_id", delivery.ID,
+				"error", err,
+			)
+		}
+	}
+
+	return nil
+}
+
+// throttle postpones the delivery if its subscription has used up its
+// deliveries for the last minute, spacing it out at the allowed rate
+func throttle(ctx context.Context, store database.Store, delivery database.AlertDelivery) bool {
+	if delivery.MaxDeliveriesPerMinute <= 0 {
+		return false
+	}
+
+	attempts, err := store.CountAlertDeliveryAttempts(ctx, delivery.SubscriptionID, time.Minute)
+	if err != nil {
+		slog.Warn("failed to check alert delivery rate",
+			"subscription_id", delivery.SubscriptionID,
+			"error", err,
+		)
+		return false
+	}
+	if attempts < delivery.MaxDeliveriesPerMinute {
+		return false
+	}
+
+	spacing := time.Minute / time.Duration(delivery.MaxDeliveriesPerMinute)
+	if err := store.DeferAlertDelivery(ctx, delivery.ID, spacing); err != nil {
+		slog.Warn("failed to defer alert delivery",
+			"delivery_id", delivery.ID,
+			"error", err,
+		)
+	}
+	return true
+}
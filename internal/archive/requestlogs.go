@@ -0,0 +1,103 @@
+package archive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/chesskiss/btc-service/internal/database"
+	"github.com/chesskiss/btc-service/internal/metrics"
+)
+
+// requestLogHeader names the CSV columns, as in request_logs
+var requestLogHeader = []string{
+	"id", "request_id", "timestamp", "method", "endpoint", "pairs_requested",
+	"user_ip", "status_code", "response_time_ms", "cache_hit", "kraken_calls",
+	"error_occurred", "error_message", "trace_id", "tenant_id", "user_agent",
+	"response_bytes", "upstream_latency_ms", "cached_pairs", "api_key_id",
+	"pair_outcomes",
+}
+
+// RequestLogArchiver writes expiring request logs to an object store as
+// gzip-compressed CSV, one object per batch, under
+// <prefix>dt=<date>/<first id>-<last id>.csv.gz so a day's logs can be
+// listed or loaded together
+type RequestLogArchiver struct {
+	store  ObjectStore
+	prefix string
+}
+
+// NewRequestLogArchiver returns an archiver writing to store under prefix
+func NewRequestLogArchiver(store ObjectStore, prefix string) *RequestLogArchiver {
+	return &RequestLogArchiver{store: store, prefix: prefix}
+}
+
+// Archive writes reqLogs, oldest first, to a single object. It is a
+// database.ArchiveFunc.
+func (a *RequestLogArchiver) Archive(ctx context.Context, reqLogs []database.RequestLog) error {
+	if len(reqLogs) == 0 {
+		return nil
+	}
+	body, err := encodeRequestLogs(reqLogs)
+	if err != nil {
+		return err
+	}
+
+	first, last := reqLogs[0], reqLogs[len(reqLogs)-1]
+	key := fmt.Sprintf("%sdt=%s/%d-%d.csv.gz", a.prefix, first.Timestamp.UTC().Format(time.DateOnly), first.ID, last.ID)
+	if err := a.store.Put(ctx, key, body, "application/gzip"); err != nil {
+		return err
+	}
+	metrics.RequestLogsArchivedTotal.Add(float64(len(reqLogs)))
+	return nil
+}
+
+// encodeRequestLogs renders reqLogs as gzip-compressed CSV with a header
+func encodeRequestLogs(reqLogs []database.RequestLog) ([]byte, error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	w := csv.NewWriter(gz)
+	w.Write(requestLogHeader)
+	for _, reqLog := range reqLogs {
+		outcomes, err := json.Marshal(reqLog.PairOutcomes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode pair outcomes: %w", err)
+		}
+		w.Write([]string{
+			strconv.FormatInt(reqLog.ID, 10),
+			reqLog.RequestID,
+			reqLog.Timestamp.UTC().Format(time.RFC3339Nano),
+			reqLog.Method,
+			reqLog.Endpoint,
+			reqLog.PairsRequested,
+			reqLog.UserIP,
+			strconv.Itoa(reqLog.StatusCode),
+			strconv.Itoa(reqLog.ResponseTimeMs),
+			strconv.FormatBool(reqLog.CacheHit),
+			strconv.Itoa(reqLog.KrakenCalls),
+			strconv.FormatBool(reqLog.ErrorOccurred),
+			reqLog.ErrorMessage,
+			reqLog.TraceID,
+			reqLog.TenantID,
+			reqLog.UserAgent,
+			strconv.Itoa(reqLog.ResponseBytes),
+			strconv.Itoa(reqLog.UpstreamLatencyMs),
+			reqLog.CachedPairs,
+			reqLog.APIKeyID,
+			string(outcomes),
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return nil, fmt.Errorf("failed to encode request logs: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return nil, fmt.Errorf("failed to compress request logs: %w", err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,173 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/chesskiss/btc-service/internal/clock"
+)
+
+// ObjectStore stores archived objects under a key
+type ObjectStore interface {
+	Put(ctx context.Context, key string, body []byte, contentType string) error
+}
+
+// S3Options locates the bucket objects are archived to. Any store with an
+// S3-compatible API works, such as Google Cloud Storage at
+// https://storage.googleapis.com with HMAC keys.
+type S3Options struct {
+	// Endpoint defaults to AWS's endpoint for Region
+	Endpoint        string
+	Region          string
+	Bucket          string
+	AccessKeyID     string
+	SecretAccessKey string
+	// PathStyle addresses the bucket in the path rather than the host name
+	PathStyle bool
+	Timeout   time.Duration
+}
+
+// S3Store is an ObjectStore in an S3 bucket, writing objects with
+// requests signed with AWS Signature Version 4
+type S3Store struct {
+	client *http.Client
+	opts   S3Options
+}
+
+var _ ObjectStore = (*S3Store)(nil)
+
+// NewS3Store returns a store writing to the bucket opts locates
+func NewS3Store(opts S3Options) *S3Store {
+	if opts.Endpoint == "" {
+		opts.Endpoint = "https://s3." + opts.Region + ".amazonaws.com"
+	}
+	return &S3Store{
+		client: &http.Client{Timeout: opts.Timeout},
+		opts:   opts,
+	}
+}
+
+// Put writes body to the object at key, replacing any object there
+func (s *S3Store) Put(ctx context.Context, key string, body []byte, contentType string) error {
+	endpoint, err := url.Parse(s.opts.Endpoint)
+	if err != nil {
+		return fmt.Errorf("invalid endpoint: %w", err)
+	}
+	if s.opts.PathStyle {
+		endpoint.Path = "/" + s.opts.Bucket + "/" + key
+	} else {
+		endpoint.Host = s.opts.Bucket + "." + endpoint.Host
+		endpoint.Path = "/" + key
+	}
+	// Send the path encoded as it is signed
+	endpoint.RawPath = encodePath(endpoint.Path)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, endpoint.String(), bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to build request: %w", err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	sum := sha256.Sum256(body)
+	SignRequest(req, hex.EncodeToString(sum[:]), s.opts.AccessKeyID, s.opts.SecretAccessKey, s.opts.Region, clock.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to put %s: %w", key, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		// S3 explains the failure in an XML body
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("put %s returned status %d: %s", key, resp.StatusCode, strings.TrimSpace(string(detail)))
+	}
+	return nil
+}
+
+// SignRequest signs req for S3 with AWS Signature Version 4, covering its
+// host and every header it already has. payloadHash is the hex SHA-256 of
+// the body.
+func SignRequest(req *http.Request, payloadHash, accessKeyID, secretAccessKey, region string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		encodePath(req.URL.Path),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+secretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// encodePath percent-encodes everything in path but unreserved characters
+// and slashes, as Signature Version 4 requires
+func encodePath(path string) string {
+	if path == "" {
+		return "/"
+	}
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if c == '/' || c == '-' || c == '_' || c == '.' || c == '~' ||
+			('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+// canonicalQuery sorts and encodes query parameters
+func canonicalQuery(query url.Values) string {
+	return strings.ReplaceAll(query.Encode(), "+", "%20")
+}
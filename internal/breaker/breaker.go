@@ -0,0 +1,125 @@
+// Package breaker stops calls to an upstream that keeps failing, so a down
+// provider is given time to recover instead of being hammered.
+package breaker
+
+import (
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/chesskiss/btc-service/internal/clock"
+	"github.com/chesskiss/btc-service/internal/metrics"
+)
+
+// ErrOpen is returned by Allow while the breaker rejects calls
+var ErrOpen = errors.New("circuit breaker open")
+
+// States of a breaker, as exported by the circuit_breaker_state gauge
+const (
+	StateClosed   = 0
+	StateHalfOpen = 1
+	StateOpen     = 2
+)
+
+// Breaker opens after failureThreshold consecutive failed calls and then
+// rejects calls for openDuration. After that a single trial call is let
+// through: it closes the breaker if it succeeds and reopens it if not.
+type Breaker struct {
+	name             string
+	failureThreshold int
+	openDuration     time.Duration
+
+	mu       sync.Mutex
+	state    int
+	failures int
+	openedAt time.Time
+	trial    bool
+}
+
+// New returns a closed breaker for the named provider
+func New(name string, failureThreshold int, openDuration time.Duration) *Breaker {
+	metrics.CircuitBreakerState.WithLabelValues(name).Set(StateClosed)
+	return &Breaker{name: name, failureThreshold: failureThreshold, openDuration: openDuration}
+}
+
+// Allow returns ErrOpen if a call must not be made now; otherwise the
+// caller makes it and reports its outcome with Record
+func (b *Breaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == StateOpen && clock.Since(b.openedAt) >= b.openDuration {
+		b.setState(StateHalfOpen)
+	}
+	switch b.state {
+	case StateOpen:
+		return ErrOpen
+	case StateHalfOpen:
+		if b.trial {
+			return ErrOpen
+		}
+		b.trial = true
+	}
+	return nil
+}
+
+// Record reports whether an allowed call succeeded
+func (b *Breaker) Record(success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.trial = false
+	if success {
+		b.failures = 0
+		b.setState(StateClosed)
+		return
+	}
+
+	b.failures++
+	if b.state == StateHalfOpen || b.failures >= b.failureThreshold {
+		b.openedAt = clock.Now()
+		b.setState(StateOpen)
+	}
+}
+
+// Abandon reports that an allowed call was given up by its caller, which
+// says nothing about the upstream
+func (b *Breaker) Abandon() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trial = false
+}
+
+// State returns StateClosed, StateHalfOpen or StateOpen
+func (b *Breaker) State() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == StateOpen && clock.Since(b.openedAt) >= b.openDuration {
+		return StateHalfOpen
+	}
+	return b.state
+}
+
+// setState moves to state, logging and exporting changes; b.mu must be
+// held
+func (b *Breaker) setState(state int) {
+	if state == b.state {
+		return
+	}
+	b.state = state
+	metrics.CircuitBreakerState.WithLabelValues(b.name).Set(float64(state))
+
+	switch state {
+	case StateOpen:
+		slog.Warn("circuit breaker opened",
+			"provider", b.name,
+			"failures", b.failures,
+			"open_for", b.openDuration.String(),
+		)
+	case StateClosed:
+		slog.Info("circuit breaker closed",
+			"provider", b.name,
+		)
+	}
+}
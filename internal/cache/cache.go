@@ -0,0 +1,41 @@
+// Package cache stores short-lived values such as prices behind a small
+// interface, backed by Redis or by process memory for deployments without
+// Redis.
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Backends selectable by configuration
+const (
+	BackendRedis  = "redis"
+	BackendMemory = "memory"
+)
+
+// ErrMiss is returned by Get when the key is absent or has expired
+var ErrMiss = errors.New("cache miss")
+
+// Cache is a key-value store whose entries expire after a TTL
+type Cache interface {
+	// Get returns the value stored under key, or ErrMiss
+	Get(ctx context.Context, key string) ([]byte, error)
+	// GetMany returns the values stored under keys in one round trip,
+	// leaving out keys that are absent or have expired
+	GetMany(ctx context.Context, keys []string) (map[string][]byte, error)
+	// Set stores value under key for ttl
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// SetNX stores value under key for ttl only if key is absent, and
+	// reports whether it did
+	SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error)
+	// Delete removes key
+	Delete(ctx context.Context, key string) error
+	// Ping reports whether the cache is reachable
+	Ping(ctx context.Context) error
+	// Backend names the implementation, e.g. redis or memory
+	Backend() string
+	// Size reports how many keys are stored
+	Size(ctx context.Context) (int64, error)
+}
@@ -0,0 +1,144 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/chesskiss/btc-service/internal/metrics"
+)
+
+// Fallback serves from a shared primary cache and, once a primary
+// operation fails, switches to a bounded in-process cache so an outage
+// degrades to per-replica caching rather than a cache miss on every
+// request. While switched, the primary is pinged every probeInterval and
+// used again as soon as it answers.
+type Fallback struct {
+	primary       Cache
+	local         *Memory
+	probeInterval time.Duration
+	down          atomic.Bool
+}
+
+// NewFallback returns a Cache using primary while it is reachable and
+// local while it is not
+func NewFallback(primary Cache, local *Memory, probeInterval time.Duration) *Fallback {
+	return &Fallback{primary: primary, local: local, probeInterval: probeInterval}
+}
+
+func (c *Fallback) Get(ctx context.Context, key string) ([]byte, error) {
+	if !c.down.Load() {
+		val, err := c.primary.Get(ctx, key)
+		if !c.failed(ctx, err) {
+			return val, err
+		}
+	}
+	return c.local.Get(ctx, key)
+}
+
+func (c *Fallback) GetMany(ctx context.Context, keys []string) (map[string][]byte, error) {
+	if !c.down.Load() {
+		values, err := c.primary.GetMany(ctx, keys)
+		if !c.failed(ctx, err) {
+			return values, err
+		}
+	}
+	return c.local.GetMany(ctx, keys)
+}
+
+func (c *Fallback) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if !c.down.Load() {
+		err := c.primary.Set(ctx, key, value, ttl)
+		if !c.failed(ctx, err) {
+			return err
+		}
+	}
+	return c.local.Set(ctx, key, value, ttl)
+}
+
+// SetNX falls back to a lock held by this replica only, so replicas may
+// fetch the same key at once while the primary is down
+func (c *Fallback) SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	if !c.down.Load() {
+		ok, err := c.primary.SetNX(ctx, key, value, ttl)
+		if !c.failed(ctx, err) {
+			return ok, err
+		}
+	}
+	return c.local.SetNX(ctx, key, value, ttl)
+}
+
+func (c *Fallback) Delete(ctx context.Context, key string) error {
+	if !c.down.Load() {
+		err := c.primary.Delete(ctx, key)
+		if !c.failed(ctx, err) {
+			return err
+		}
+	}
+	return c.local.Delete(ctx, key)
+}
+
+// Ping checks the primary, so health checks still report its outage
+func (c *Fallback) Ping(ctx context.Context) error {
+	return c.primary.Ping(ctx)
+}
+
+// Size counts whichever cache is in use
+func (c *Fallback) Size(ctx context.Context) (int64, error) {
+	if c.down.Load() {
+		return c.local.Size(ctx)
+	}
+	return c.primary.Size(ctx)
+}
+
+// Backend names the primary, which determines what can fail
+func (c *Fallback) Backend() string {
+	return c.primary.Backend()
+}
+
+// Active reports whether the in-process cache is in use
+func (c *Fallback) Active() bool {
+	return c.down.Load()
+}
+
+// failed reports whether err means the primary is unreachable, and if so
+// switches to the local cache until a probe succeeds. Misses and requests
+// the caller gave up on say nothing about the primary.
+func (c *Fallback) failed(ctx context.Context, err error) bool {
+	if err == nil || errors.Is(err, ErrMiss) || ctx.Err() != nil {
+		return false
+	}
+	if c.down.CompareAndSwap(false, true) {
+		slog.Warn("cache unreachable, falling back to in-process cache",
+			"backend", c.primary.Backend(),
+			"error", err,
+		)
+		metrics.CacheFallbackActive.Set(1)
+		go c.probe()
+	}
+	return true
+}
+
+// probe pings the primary every probeInterval until it answers, then
+// switches back to it
+func (c *Fallback) probe() {
+	ticker := time.NewTicker(c.probeInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), c.probeInterval)
+		err := c.primary.Ping(ctx)
+		cancel()
+		if err == nil {
+			break
+		}
+	}
+
+	c.down.Store(false)
+	metrics.CacheFallbackActive.Set(0)
+	slog.Info("cache reachable again, leaving in-process cache",
+		"backend", c.primary.Backend(),
+	)
+}
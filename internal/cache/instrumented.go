@@ -0,0 +1,59 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	"github.com/chesskiss/btc-service/internal/metrics"
+)
+
+// Instrumented records the latency of reads and writes to another Cache
+type Instrumented struct {
+	next Cache
+}
+
+// NewInstrumented returns next with its Get and Set calls timed
+func NewInstrumented(next Cache) *Instrumented {
+	return &Instrumented{next: next}
+}
+
+func (c *Instrumented) Get(ctx context.Context, key string) ([]byte, error) {
+	defer c.observe("get", time.Now())
+	return c.next.Get(ctx, key)
+}
+
+func (c *Instrumented) GetMany(ctx context.Context, keys []string) (map[string][]byte, error) {
+	defer c.observe("get_many", time.Now())
+	return c.next.GetMany(ctx, keys)
+}
+
+func (c *Instrumented) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	defer c.observe("set", time.Now())
+	return c.next.Set(ctx, key, value, ttl)
+}
+
+func (c *Instrumented) SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	defer c.observe("set_nx", time.Now())
+	return c.next.SetNX(ctx, key, value, ttl)
+}
+
+func (c *Instrumented) Delete(ctx context.Context, key string) error {
+	defer c.observe("delete", time.Now())
+	return c.next.Delete(ctx, key)
+}
+
+func (c *Instrumented) Ping(ctx context.Context) error {
+	return c.next.Ping(ctx)
+}
+
+func (c *Instrumented) Backend() string {
+	return c.next.Backend()
+}
+
+func (c *Instrumented) Size(ctx context.Context) (int64, error) {
+	return c.next.Size(ctx)
+}
+
+func (c *Instrumented) observe(operation string, start time.Time) {
+	metrics.CacheOperationDuration.WithLabelValues(operation, c.next.Backend()).Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,139 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/chesskiss/btc-service/internal/clock"
+)
+
+// Memory is an in-process Cache holding at most a fixed number of entries,
+// evicting the least recently used first. It is not shared between
+// replicas.
+type Memory struct {
+	mu         sync.Mutex
+	maxEntries int
+	order      *list.List // front is most recently used
+	entries    map[string]*list.Element
+}
+
+type memoryEntry struct {
+	key       string
+	value     []byte
+	expiresAt time.Time
+}
+
+// NewMemory returns an empty in-process cache holding up to maxEntries
+func NewMemory(maxEntries int) *Memory {
+	return &Memory{
+		maxEntries: maxEntries,
+		order:      list.New(),
+		entries:    map[string]*list.Element{},
+	}
+}
+
+func (c *Memory) Get(ctx context.Context, key string) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, ErrMiss
+	}
+	entry := elem.Value.(*memoryEntry)
+	if c.expired(entry) {
+		c.remove(elem)
+		return nil, ErrMiss
+	}
+
+	c.order.MoveToFront(elem)
+	return entry.value, nil
+}
+
+func (c *Memory) GetMany(ctx context.Context, keys []string) (map[string][]byte, error) {
+	values := make(map[string][]byte, len(keys))
+	for _, key := range keys {
+		if val, err := c.Get(ctx, key); err == nil {
+			values[key] = val
+		}
+	}
+	return values, nil
+}
+
+func (c *Memory) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.set(key, value, ttl)
+	return nil
+}
+
+func (c *Memory) SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok && !c.expired(elem.Value.(*memoryEntry)) {
+		return false, nil
+	}
+	c.set(key, value, ttl)
+	return true, nil
+}
+
+func (c *Memory) Delete(ctx context.Context, key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+	return nil
+}
+
+// Ping always succeeds; process memory is always reachable
+func (c *Memory) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (c *Memory) Backend() string {
+	return BackendMemory
+}
+
+// Size returns Len; expired entries not yet evicted are counted
+func (c *Memory) Size(ctx context.Context) (int64, error) {
+	return int64(c.Len()), nil
+}
+
+// Len returns the number of entries held, including expired ones not yet
+// evicted
+func (c *Memory) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// set stores an entry, evicting the least recently used beyond maxEntries;
+// c.mu must be held
+func (c *Memory) set(key string, value []byte, ttl time.Duration) {
+	entry := &memoryEntry{key: key, value: value, expiresAt: clock.Now().Add(ttl)}
+	if elem, ok := c.entries[key]; ok {
+		elem.Value = entry
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(entry)
+	for c.order.Len() > c.maxEntries {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *Memory) expired(entry *memoryEntry) bool {
+	return !clock.Now().Before(entry.expiresAt)
+}
+
+func (c *Memory) remove(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.entries, elem.Value.(*memoryEntry).key)
+}
@@ -0,0 +1,73 @@
+package cache
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// Namespaced prefixes every key of another Cache, so several environments
+// can share one Redis without their keys colliding
+type Namespaced struct {
+	next   Cache
+	prefix string
+}
+
+// NewNamespaced returns next with its keys stored under namespace
+func NewNamespaced(next Cache, namespace string) *Namespaced {
+	return &Namespaced{next: next, prefix: Key(namespace, "")}
+}
+
+// Key returns key within namespace; an empty namespace leaves it as is
+func Key(namespace, key string) string {
+	if namespace == "" {
+		return key
+	}
+	return namespace + ":" + key
+}
+
+func (c *Namespaced) Get(ctx context.Context, key string) ([]byte, error) {
+	return c.next.Get(ctx, c.prefix+key)
+}
+
+func (c *Namespaced) GetMany(ctx context.Context, keys []string) (map[string][]byte, error) {
+	prefixed := make([]string, len(keys))
+	for i, key := range keys {
+		prefixed[i] = c.prefix + key
+	}
+
+	values, err := c.next.GetMany(ctx, prefixed)
+	if err != nil {
+		return nil, err
+	}
+	unprefixed := make(map[string][]byte, len(values))
+	for key, val := range values {
+		unprefixed[strings.TrimPrefix(key, c.prefix)] = val
+	}
+	return unprefixed, nil
+}
+
+func (c *Namespaced) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return c.next.Set(ctx, c.prefix+key, value, ttl)
+}
+
+func (c *Namespaced) SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	return c.next.SetNX(ctx, c.prefix+key, value, ttl)
+}
+
+func (c *Namespaced) Delete(ctx context.Context, key string) error {
+	return c.next.Delete(ctx, c.prefix+key)
+}
+
+func (c *Namespaced) Ping(ctx context.Context) error {
+	return c.next.Ping(ctx)
+}
+
+func (c *Namespaced) Backend() string {
+	return c.next.Backend()
+}
+
+// Size counts every key of the underlying cache, whatever its namespace
+func (c *Namespaced) Size(ctx context.Context) (int64, error) {
+	return c.next.Size(ctx)
+}
@@ -0,0 +1,81 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis is a Cache shared by every replica using the same Redis server,
+// Sentinel-managed primary or cluster
+type Redis struct {
+	client redis.UniversalClient
+}
+
+// NewRedis returns a Cache backed by client
+func NewRedis(client redis.UniversalClient) *Redis {
+	return &Redis{client: client}
+}
+
+// Client returns the underlying Redis client
+func (c *Redis) Client() redis.UniversalClient {
+	return c.client
+}
+
+func (c *Redis) Get(ctx context.Context, key string) ([]byte, error) {
+	val, err := c.client.Get(ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrMiss
+	}
+	return val, err
+}
+
+// GetMany pipelines one GET per key rather than using MGET, which a
+// cluster rejects when the keys hash to different slots
+func (c *Redis) GetMany(ctx context.Context, keys []string) (map[string][]byte, error) {
+	cmds := make([]*redis.StringCmd, len(keys))
+	_, err := c.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			cmds[i] = pipe.Get(ctx, key)
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+
+	values := make(map[string][]byte, len(keys))
+	for i, cmd := range cmds {
+		if val, err := cmd.Bytes(); err == nil {
+			values[keys[i]] = val
+		}
+	}
+	return values, nil
+}
+
+func (c *Redis) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return c.client.Set(ctx, key, value, ttl).Err()
+}
+
+func (c *Redis) SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	return c.client.SetNX(ctx, key, value, ttl).Result()
+}
+
+func (c *Redis) Delete(ctx context.Context, key string) error {
+	return c.client.Del(ctx, key).Err()
+}
+
+func (c *Redis) Ping(ctx context.Context) error {
+	return c.client.Ping(ctx).Err()
+}
+
+// Size counts every key in the database, across all shards of a cluster
+func (c *Redis) Size(ctx context.Context) (int64, error) {
+	return c.client.DBSize(ctx).Result()
+}
+
+func (c *Redis) Backend() string {
+	return BackendRedis
+}
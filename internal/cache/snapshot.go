@@ -0,0 +1,88 @@
+package cache
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/chesskiss/btc-service/internal/clock"
+)
+
+// snapshotEntry is a Memory entry as saved to disk
+type snapshotEntry struct {
+	Key       string    `json:"key"`
+	Value     []byte    `json:"value"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// SaveSnapshot writes the unexpired entries to path, least recently used
+// first, replacing any earlier snapshot atomically, and returns how many
+// it wrote
+func (c *Memory) SaveSnapshot(path string) (int, error) {
+	c.mu.Lock()
+	entries := make([]snapshotEntry, 0, c.order.Len())
+	for elem := c.order.Back(); elem != nil; elem = elem.Prev() {
+		entry := elem.Value.(*memoryEntry)
+		if !c.expired(entry) {
+			entries = append(entries, snapshotEntry{Key: entry.key, Value: entry.value, ExpiresAt: entry.expiresAt})
+		}
+	}
+	c.mu.Unlock()
+
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode cache snapshot: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return 0, fmt.Errorf("failed to create cache snapshot: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return 0, fmt.Errorf("failed to write cache snapshot: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, fmt.Errorf("failed to write cache snapshot: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return 0, fmt.Errorf("failed to replace cache snapshot: %w", err)
+	}
+	return len(entries), nil
+}
+
+// LoadSnapshot adds the entries saved at path that have not expired since,
+// keeping their recency, and returns how many it loaded; a missing
+// snapshot loads nothing
+func (c *Memory) LoadSnapshot(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read cache snapshot: %w", err)
+	}
+
+	var entries []snapshotEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return 0, fmt.Errorf("failed to decode cache snapshot: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	loaded := 0
+	now := clock.Now()
+	for _, entry := range entries {
+		if ttl := entry.ExpiresAt.Sub(now); ttl > 0 {
+			c.set(entry.Key, entry.Value, ttl)
+			loaded++
+		}
+	}
+	return loaded, nil
+}
@@ -0,0 +1,93 @@
+package cache
+
+import (
+	"context"
+	"time"
+)
+
+// Tiered serves reads from a small in-process L1 in front of a shared L2,
+// so hot keys skip the L2 round trip. L1 entries live for at most l1TTL,
+// which bounds how long a replica can lag behind writes made by others.
+type Tiered struct {
+	l1    *Memory
+	l2    Cache
+	l1TTL time.Duration
+}
+
+// NewTiered returns a Cache reading through l1 to l2. l1 may also be the
+// local cache of a Fallback l2, whose entries keep their full TTL while
+// the fallback is active, as then l1 is the only cache there is.
+func NewTiered(l1 *Memory, l2 Cache, l1TTL time.Duration) *Tiered {
+	return &Tiered{l1: l1, l2: l2, l1TTL: l1TTL}
+}
+
+func (c *Tiered) Get(ctx context.Context, key string) ([]byte, error) {
+	if val, err := c.l1.Get(ctx, key); err == nil {
+		return val, nil
+	}
+
+	val, err := c.l2.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	c.l1.Set(ctx, key, val, c.l1TTL)
+	return val, nil
+}
+
+// GetMany reads what it can from the L1 and the rest from the L2 in one
+// round trip
+func (c *Tiered) GetMany(ctx context.Context, keys []string) (map[string][]byte, error) {
+	values, _ := c.l1.GetMany(ctx, keys)
+	var missing []string
+	for _, key := range keys {
+		if _, ok := values[key]; !ok {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) == 0 {
+		return values, nil
+	}
+
+	fetched, err := c.l2.GetMany(ctx, missing)
+	if err != nil {
+		return nil, err
+	}
+	for key, val := range fetched {
+		c.l1.Set(ctx, key, val, c.l1TTL)
+		values[key] = val
+	}
+	return values, nil
+}
+
+// Set writes through to both tiers; the L1 copy never outlives the L2 one
+func (c *Tiered) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	c.l1.Set(ctx, key, value, min(ttl, c.l1TTL))
+	return c.l2.Set(ctx, key, value, ttl)
+}
+
+// SetNX goes to the shared tier only, so every replica sees the same
+// outcome; the value is not copied into the L1
+func (c *Tiered) SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	return c.l2.SetNX(ctx, key, value, ttl)
+}
+
+// Delete removes key from both tiers
+func (c *Tiered) Delete(ctx context.Context, key string) error {
+	c.l1.Delete(ctx, key)
+	return c.l2.Delete(ctx, key)
+}
+
+// Ping checks the shared tier; the L1 is always reachable
+func (c *Tiered) Ping(ctx context.Context) error {
+	return c.l2.Ping(ctx)
+}
+
+// Size counts the shared tier, which holds every key
+func (c *Tiered) Size(ctx context.Context) (int64, error) {
+	return c.l2.Size(ctx)
+}
+
+// Backend names the shared tier, which determines what can fail
+func (c *Tiered) Backend() string {
+	return c.l2.Backend()
+}
@@ -0,0 +1,57 @@
+// Package clock is the service's source of time. Normal builds use the
+// system clock; builds with the simulation tag use a virtual clock that
+// only moves when advanced, so end-to-end tests can drive cache expiry and
+// scheduler ticks without sleeping.
+package clock
+
+import "time"
+
+// Clock tells the time and schedules periodic ticks
+type Clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker delivers ticks on C until stopped, like time.Ticker
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// Now returns the current time of the service clock
+func Now() time.Time {
+	return current.Now()
+}
+
+// Since returns the time elapsed since t on the service clock
+func Since(t time.Time) time.Duration {
+	return current.Now().Sub(t)
+}
+
+// NewTicker returns a ticker driven by the service clock
+func NewTicker(d time.Duration) Ticker {
+	return current.NewTicker(d)
+}
+
+// systemClock is the real wall clock
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) NewTicker(d time.Duration) Ticker {
+	return systemTicker{time.NewTicker(d)}
+}
+
+type systemTicker struct {
+	ticker *time.Ticker
+}
+
+func (t systemTicker) C() <-chan time.Time {
+	return t.ticker.C
+}
+
+func (t systemTicker) Stop() {
+	t.ticker.Stop()
+}
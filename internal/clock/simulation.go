@@ -0,0 +1,18 @@
+//go:build simulation
+
+package clock
+
+import "time"
+
+// simulationStart is a fixed epoch so simulated runs are reproducible
+var simulationStart = time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+var simulated = NewVirtual(simulationStart)
+
+var current Clock = simulated
+
+// Simulated returns the virtual clock driving the service in simulation
+// builds; it is always false otherwise
+func Simulated() (*Virtual, bool) {
+	return simulated, true
+}
@@ -0,0 +1,11 @@
+//go:build !simulation
+
+package clock
+
+var current Clock = systemClock{}
+
+// Simulated returns the virtual clock driving the service in simulation
+// builds; it is always false otherwise
+func Simulated() (*Virtual, bool) {
+	return nil, false
+}
@@ -0,0 +1,94 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Virtual is a clock that stands still until Advance is called
+type Virtual struct {
+	mu      sync.Mutex
+	now     time.Time
+	tickers []*virtualTicker
+}
+
+// NewVirtual returns a virtual clock set to start
+func NewVirtual(start time.Time) *Virtual {
+	return &Virtual{now: start}
+}
+
+// Now returns the virtual time
+func (v *Virtual) Now() time.Time {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.now
+}
+
+// NewTicker returns a ticker that fires each time the virtual clock passes
+// another multiple of d
+func (v *Virtual) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("clock: non-positive interval for NewTicker")
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	t := &virtualTicker{clock: v, c: make(chan time.Time, 1), period: d, next: v.now.Add(d)}
+	v.tickers = append(v.tickers, t)
+	return t
+}
+
+// Advance moves the clock forward by d, firing due ticks in time order.
+// As with time.Ticker, a tick is dropped if the previous one hasn't been
+// received yet.
+func (v *Virtual) Advance(d time.Duration) time.Time {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	target := v.now.Add(d)
+	for {
+		var due *virtualTicker
+		for _, t := range v.tickers {
+			if !t.next.After(target) && (due == nil || t.next.Before(due.next)) {
+				due = t
+			}
+		}
+		if due == nil {
+			break
+		}
+
+		v.now = due.next
+		select {
+		case due.c <- v.now:
+		default:
+		}
+		due.next = due.next.Add(due.period)
+	}
+
+	v.now = target
+	return v.now
+}
+
+type virtualTicker struct {
+	clock  *Virtual
+	c      chan time.Time
+	period time.Duration
+	next   time.Time
+}
+
+func (t *virtualTicker) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *virtualTicker) Stop() {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	for i, other := range t.clock.tickers {
+		if other == t {
+			t.clock.tickers = append(t.clock.tickers[:i], t.clock.tickers[i+1:]...)
+			return
+		}
+	}
+}
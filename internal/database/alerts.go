@@ -0,0 +1,489 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/chesskiss/btc-service/internal/pagination"
+)
+
+// Alert directions: above fires when the price rises to or past the
+// threshold, below when it falls to or past it
+const (
+	AlertAbove = "above"
+	AlertBelow = "below"
+)
+
+// AlertSubscription is a webhook to call when a pair's price crosses a
+// threshold
+type AlertSubscription struct {
+	ID          int64     `json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	Pair        string    `json:"pair"`
+	Threshold   float64   `json:"threshold"`
+	Direction   string    `json:"direction"`
+	CallbackURL string    `json:"callback_url"`
+	// MaxDeliveriesPerMinute caps webhook calls and BatchWindowSeconds
+	// holds each webhook back so later crossings are coalesced into it;
+	// zero disables either
+	MaxDeliveriesPerMinute int `json:"max_deliveries_per_minute,omitempty"`
+	BatchWindowSeconds     int `json:"batch_window_seconds,omitempty"`
+	// Secret signs webhook payloads; it is only returned on creation
+	Secret          string     `json:"secret,omitempty"`
+	LastPrice       *float64   `json:"last_price,omitempty"`
+	LastTriggeredAt *time.Time `json:"last_triggered_at,omitempty"`
+	// Owner is the API key or tenant that created the subscription; only
+	// it can list, inspect or delete the subscription
+	Owner string `json:"-"`
+}
+
+// CreateAlertSubscription stores a new subscription and returns it with its
+// ID and creation time
+func (s *PostgresStore) CreateAlertSubscription(ctx context.Context, sub AlertSubscription) (AlertSubscription, error) {
+	if s.pool == nil {
+		return sub, fmt.Errorf("database not initialized")
+	}
+
+	err := s.pool.QueryRow(ctx, `
+		INSERT INTO alert_subscriptions (
+			pair, threshold, direction, callback_url, secret,
+			max_deliveries_per_minute, batch_window_seconds, owner
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id, created_at
+	`, sub.Pair, sub.Threshold, sub.Direction, sub.CallbackURL, sub.Secret,
+		sub.MaxDeliveriesPerMinute, sub.BatchWindowSeconds, sub.Owner).Scan(&sub.ID, &sub.CreatedAt)
+	if err != nil {
+		return sub, fmt.Errorf("failed to create alert subscription: %w", err)
+	}
+	sub.CreatedAt = sub.CreatedAt.UTC()
+
+	return sub, nil
+}
+
+// ListAlertSubscriptions returns every subscription, oldest first
+func (s *PostgresStore) ListAlertSubscriptions(ctx context.Context) ([]AlertSubscription, error) {
+	if s.pool == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+
+	rows, err := s.pool.Query(ctx, alertSubscriptionSelect+" ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query alert subscriptions: %w", err)
+	}
+	defer rows.Close()
+
+	return scanAlertSubscriptions(rows)
+}
+
+// AlertSubscriptionSorts are the fields subscriptions can be sorted by
+var AlertSubscriptionSorts = pagination.Sorts{
+	"id":         pagination.Int64Value,
+	"created_at": pagination.TimeValue,
+	"pair":       pagination.TextValue,
+	"threshold":  pagination.FloatValue,
+}
+
+// DefaultAlertSubscriptionSort lists the oldest subscriptions first
+var DefaultAlertSubscriptionSort = pagination.Sort{Field: "id"}
+
+var alertSubscriptionSortColumns = map[string]string{
+	"id":         "id",
+	"created_at": "created_at",
+	"pair":       "pair",
+	"threshold":  "threshold",
+}
+
+// QueryAlertSubscriptions returns one page of owner's subscriptions
+func (s *PostgresStore) QueryAlertSubscriptions(ctx context.Context, owner string, page pagination.Page) ([]AlertSubscription, error) {
+	if s.pool == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+
+	conditions, order, args := pageClauses(page, alertSubscriptionSortColumns, []string{"owner = $1"}, []interface{}{owner})
+	query := alertSubscriptionSelect + " WHERE " + strings.Join(conditions, " AND ")
+
+	rows, err := s.pool.Query(ctx, query+order, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query alert subscriptions: %w", err)
+	}
+	defer rows.Close()
+
+	return scanAlertSubscriptions(rows)
+}
+
+// AlertSubscriptionSortValue returns the value of sub's sort field, as used
+// in a pagination cursor
+func AlertSubscriptionSortValue(sub AlertSubscription, sort pagination.Sort) string {
+	switch sort.Field {
+	case "created_at":
+		return sub.CreatedAt.Format(time.RFC3339Nano)
+	case "pair":
+		return sub.Pair
+	case "threshold":
+		return strconv.FormatFloat(sub.Threshold, 'f', -1, 64)
+	default:
+		return strconv.FormatInt(sub.ID, 10)
+	}
+}
+
+// alertSubscriptionSelect is shared by the subscription list queries
+const alertSubscriptionSelect = `
+	SELECT id, created_at, pair, threshold, direction, callback_url,
+	       max_deliveries_per_minute, batch_window_seconds,
+	       secret, last_price, last_triggered_at
+	FROM alert_subscriptions`
+
+func scanAlertSubscriptions(rows pgx.Rows) ([]AlertSubscription, error) {
+	subs := []AlertSubscription{}
+	for rows.Next() {
+		var sub AlertSubscription
+		var lastPrice sql.NullFloat64
+		var lastTriggeredAt sql.NullTime
+		if err := rows.Scan(
+			&sub.ID,
+			&sub.CreatedAt,
+			&sub.Pair,
+			&sub.Threshold,
+			&sub.Direction,
+			&sub.CallbackURL,
+			&sub.MaxDeliveriesPerMinute,
+			&sub.BatchWindowSeconds,
+			&sub.Secret,
+			&lastPrice,
+			&lastTriggeredAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan alert subscription: %w", err)
+		}
+		if lastPrice.Valid {
+			sub.LastPrice = &lastPrice.Float64
+		}
+		sub.CreatedAt = sub.CreatedAt.UTC()
+		sub.LastTriggeredAt = nullTimeUTC(lastTriggeredAt)
+		subs = append(subs, sub)
+	}
+
+	return subs, rows.Err()
+}
+
+// DeleteAlertSubscription removes one of owner's subscriptions, reporting
+// whether it existed
+func (s *PostgresStore) DeleteAlertSubscription(ctx context.Context, owner string, id int64) (bool, error) {
+	if s.pool == nil {
+		return false, fmt.Errorf("database not initialized")
+	}
+
+	tag, err := s.pool.Exec(ctx, "DELETE FROM alert_subscriptions WHERE id = $1 AND owner = $2", id, owner)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete alert subscription: %w", err)
+	}
+	rows := tag.RowsAffected()
+
+	return rows > 0, nil
+}
+
+// RecordAlertEvaluation stores the price a subscription was evaluated
+// against and, if it fired, when
+func (s *PostgresStore) RecordAlertEvaluation(ctx context.Context, id int64, price float64, triggered bool) error {
+	if s.pool == nil {
+		return fmt.Errorf("database not initialized")
+	}
+
+	_, err := s.pool.Exec(ctx, `
+		UPDATE alert_subscriptions
+		SET last_price = $2,
+		    last_triggered_at = CASE WHEN $3 THEN NOW() ELSE last_triggered_at END
+		WHERE id = $1
+	`, id, price, triggered)
+	if err != nil {
+		return fmt.Errorf("failed to record alert evaluation: %w", err)
+	}
+
+	return nil
+}
+
+// Alert delivery states: pending deliveries are (re)tried until they are
+// delivered or dead-lettered after the last allowed attempt
+const (
+	DeliveryPending    = "pending"
+	DeliveryDelivered  = "delivered"
+	DeliveryDeadLetter = "dead_letter"
+)
+
+// AlertDelivery is one queued webhook call and its delivery status
+type AlertDelivery struct {
+	ID             int64           `json:"id"`
+	SubscriptionID int64           `json:"subscription_id"`
+	CreatedAt      time.Time       `json:"created_at"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  *time.Time      `json:"next_attempt_at,omitempty"`
+	LastError      string          `json:"last_error,omitempty"`
+	DeliveredAt    *time.Time      `json:"delivered_at,omitempty"`
+
+	// Where and how to deliver, joined from the subscription
+	CallbackURL            string `json:"-"`
+	Secret                 string `json:"-"`
+	MaxDeliveriesPerMinute int    `json:"-"`
+}
+
+// alertDeliverySelect selects the columns scanned by queryAlertDeliveries
+const alertDeliverySelect = `
+	SELECT d.id, d.subscription_id, d.created_at, d.payload, d.status,
+	       d.attempts, d.next_attempt_at, COALESCE(d.last_error, ''),
+	       d.delivered_at, s.callback_url, s.secret,
+	       s.max_deliveries_per_minute
+	FROM alert_deliveries d
+	JOIN alert_subscriptions s ON s.id = d.subscription_id`
+
+// EnqueueAlertDelivery queues a webhook payload for delivery after delay
+func (s *PostgresStore) EnqueueAlertDelivery(ctx context.Context, subscriptionID int64, payload []byte, delay time.Duration) (int64, error) {
+	if s.pool == nil {
+		return 0, fmt.Errorf("database not initialized")
+	}
+
+	var id int64
+	err := s.pool.QueryRow(ctx, `
+		INSERT INTO alert_deliveries (subscription_id, payload, next_attempt_at)
+		VALUES ($1, $2, NOW() + make_interval(secs => $3))
+		RETURNING id
+	`, subscriptionID, string(payload), delay.Seconds()).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to enqueue alert delivery: %w", err)
+	}
+
+	return id, nil
+}
+
+// ClaimAlertDeliveries claims up to limit pending deliveries whose next
+// attempt is due and that no one else has claimed, oldest first. Other
+// replicas skip them until they are marked or deferred, or the lease
+// lapses, so each is attempted by one worker at a time.
+func (s *PostgresStore) ClaimAlertDeliveries(ctx context.Context, limit int, lease time.Duration) ([]AlertDelivery, error) {
+	if s.pool == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+
+	deliveries, err := s.queryAlertDeliveries(ctx, `
+		UPDATE alert_deliveries d
+		SET claimed_until = NOW() + make_interval(secs => $3)
+		FROM alert_subscriptions s
+		WHERE s.id = d.subscription_id AND d.id IN (
+			SELECT id FROM alert_deliveries
+			WHERE status = $1 AND next_attempt_at <= NOW()
+			  AND (claimed_until IS NULL OR claimed_until <= NOW())
+			ORDER BY next_attempt_at, id
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING d.id, d.subscription_id, d.created_at, d.payload, d.status,
+		          d.attempts, d.next_attempt_at, COALESCE(d.last_error, ''),
+		          d.delivered_at, s.callback_url, s.secret,
+		          s.max_deliveries_per_minute
+	`, DeliveryPending, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	sortDueAlertDeliveries(deliveries)
+
+	return deliveries, nil
+}
+
+// sortDueAlertDeliveries puts claimed deliveries oldest first, as
+// UPDATE ... RETURNING doesn't keep the order they were claimed in
+func sortDueAlertDeliveries(deliveries []AlertDelivery) {
+	sort.Slice(deliveries, func(i, j int) bool {
+		a, b := deliveries[i], deliveries[j]
+		if a.NextAttemptAt != nil && b.NextAttemptAt != nil && !a.NextAttemptAt.Equal(*b.NextAttemptAt) {
+			return a.NextAttemptAt.Before(*b.NextAttemptAt)
+		}
+		return a.ID < b.ID
+	})
+}
+
+// ListAlertDeliveries returns the delivery history of one of owner's
+// subscriptions, newest first
+func (s *PostgresStore) ListAlertDeliveries(ctx context.Context, owner string, subscriptionID int64, limit int) ([]AlertDelivery, error) {
+	if s.pool == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+
+	return s.queryAlertDeliveries(ctx, alertDeliverySelect+`
+		WHERE d.subscription_id = $1 AND s.owner = $2
+		ORDER BY d.id DESC
+		LIMIT $3
+	`, subscriptionID, owner, limit)
+}
+
+func (s *PostgresStore) queryAlertDeliveries(ctx context.Context, query string, args ...interface{}) ([]AlertDelivery, error) {
+	rows, err := s.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query alert deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	deliveries := []AlertDelivery{}
+	for rows.Next() {
+		var delivery AlertDelivery
+		var payload string
+		var nextAttemptAt, deliveredAt sql.NullTime
+		if err := rows.Scan(
+			&delivery.ID,
+			&delivery.SubscriptionID,
+			&delivery.CreatedAt,
+			&payload,
+			&delivery.Status,
+			&delivery.Attempts,
+			&nextAttemptAt,
+			&delivery.LastError,
+			&deliveredAt,
+			&delivery.CallbackURL,
+			&delivery.Secret,
+			&delivery.MaxDeliveriesPerMinute,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan alert delivery: %w", err)
+		}
+		delivery.Payload = json.RawMessage(payload)
+		delivery.CreatedAt = delivery.CreatedAt.UTC()
+		delivery.NextAttemptAt = nullTimeUTC(nextAttemptAt)
+		delivery.DeliveredAt = nullTimeUTC(deliveredAt)
+		deliveries = append(deliveries, delivery)
+	}
+
+	return deliveries, rows.Err()
+}
+
+// BatchingAlertDelivery returns the subscription's delivery that is still
+// held back by its batch window, if any
+func (s *PostgresStore) BatchingAlertDelivery(ctx context.Context, subscriptionID int64) (AlertDelivery, bool, error) {
+	if s.pool == nil {
+		return AlertDelivery{}, false, fmt.Errorf("database not initialized")
+	}
+
+	deliveries, err := s.queryAlertDeliveries(ctx, alertDeliverySelect+`
+		WHERE d.subscription_id = $1 AND d.status = $2 AND d.attempts = 0
+		  AND d.next_attempt_at > NOW()
+		ORDER BY d.id DESC
+		LIMIT 1
+	`, subscriptionID, DeliveryPending)
+	if err != nil || len(deliveries) == 0 {
+		return AlertDelivery{}, false, err
+	}
+
+	return deliveries[0], true, nil
+}
+
+// ReplaceAlertDeliveryPayload swaps the payload of a delivery still held
+// back by its batch window, reporting false if it has since become due
+func (s *PostgresStore) ReplaceAlertDeliveryPayload(ctx context.Context, id int64, payload []byte) (bool, error) {
+	if s.pool == nil {
+		return false, fmt.Errorf("database not initialized")
+	}
+
+	tag, err := s.pool.Exec(ctx, `
+		UPDATE alert_deliveries
+		SET payload = $2
+		WHERE id = $1 AND status = $3 AND attempts = 0 AND next_attempt_at > NOW()
+	`, id, string(payload), DeliveryPending)
+	if err != nil {
+		return false, fmt.Errorf("failed to replace alert delivery payload: %w", err)
+	}
+	rows := tag.RowsAffected()
+
+	return rows > 0, nil
+}
+
+// CountAlertDeliveryAttempts returns how many of the subscription's
+// deliveries were attempted within the last window
+func (s *PostgresStore) CountAlertDeliveryAttempts(ctx context.Context, subscriptionID int64, window time.Duration) (int, error) {
+	if s.pool == nil {
+		return 0, fmt.Errorf("database not initialized")
+	}
+
+	var count int
+	err := s.pool.QueryRow(ctx, `
+		SELECT COUNT(*) FROM alert_deliveries
+		WHERE subscription_id = $1 AND last_attempt_at > NOW() - make_interval(secs => $2)
+	`, subscriptionID, window.Seconds()).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count alert delivery attempts: %w", err)
+	}
+
+	return count, nil
+}
+
+// DeferAlertDelivery postpones a pending delivery without counting an
+// attempt
+func (s *PostgresStore) DeferAlertDelivery(ctx context.Context, id int64, after time.Duration) error {
+	if s.pool == nil {
+		return fmt.Errorf("database not initialized")
+	}
+
+	_, err := s.pool.Exec(ctx, `
+		UPDATE alert_deliveries
+		SET next_attempt_at = NOW() + make_interval(secs => $2), claimed_until = NULL
+		WHERE id = $1
+	`, id, after.Seconds())
+	if err != nil {
+		return fmt.Errorf("failed to defer alert delivery: %w", err)
+	}
+
+	return nil
+}
+
+// MarkAlertDelivered records a successful delivery attempt
+func (s *PostgresStore) MarkAlertDelivered(ctx context.Context, id int64) error {
+	if s.pool == nil {
+		return fmt.Errorf("database not initialized")
+	}
+
+	_, err := s.pool.Exec(ctx, `
+		UPDATE alert_deliveries
+		SET status = $2, attempts = attempts + 1, delivered_at = NOW(),
+		    last_attempt_at = NOW(), next_attempt_at = NULL, last_error = NULL,
+		    claimed_until = NULL
+		WHERE id = $1
+	`, id, DeliveryDelivered)
+	if err != nil {
+		return fmt.Errorf("failed to mark alert delivered: %w", err)
+	}
+
+	return nil
+}
+
+// MarkAlertDeliveryFailed records a failed attempt, scheduling a retry
+// after retryAfter or, if retryAfter is zero, dead-lettering the delivery
+func (s *PostgresStore) MarkAlertDeliveryFailed(ctx context.Context, id int64, lastError string, retryAfter time.Duration) error {
+	if s.pool == nil {
+		return fmt.Errorf("database not initialized")
+	}
+
+	// The retry time is computed by the database so it shares a clock with
+	// the NOW() that ClaimAlertDeliveries compares against
+	retry := retryAfter > 0
+	status := DeliveryPending
+	if !retry {
+		status = DeliveryDeadLetter
+	}
+
+	_, err := s.pool.Exec(ctx, `
+		UPDATE alert_deliveries
+		SET status = $2, attempts = attempts + 1, last_error = $3,
+		    last_attempt_at = NOW(), claimed_until = NULL,
+		    next_attempt_at = CASE WHEN $4 THEN NOW() + make_interval(secs => $5) END
+		WHERE id = $1
+	`, id, status, lastError, retry, retryAfter.Seconds())
+	if err != nil {
+		return fmt.Errorf("failed to record alert delivery failure: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,90 @@
+package database
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How client IPs are stored in request logs, chosen by REQUEST_LOG_IP_MODE
+const (
+	IPModeFull     = "full"
+	IPModeTruncate = "truncate"
+	IPModeHMAC     = "hmac"
+)
+
+// IPAnonymizer rewrites the client IP of request logs before they are
+// queued. Truncating keeps the network, zeroing the last octet of an IPv4
+// address and all but the first 48 bits of an IPv6 one. HMAC replaces the
+// IP with a keyed hash whose key changes every rotation, so a client can
+// be followed within a period but not across them.
+type IPAnonymizer struct {
+	mode     string
+	secret   []byte
+	rotation time.Duration
+}
+
+// NewIPAnonymizer returns an anonymizer for mode. secret and rotation are
+// used by IPModeHMAC alone.
+func NewIPAnonymizer(mode, secret string, rotation time.Duration) *IPAnonymizer {
+	return &IPAnonymizer{mode: mode, secret: []byte(secret), rotation: rotation}
+}
+
+// Anonymize returns ip as it is stored at now. Values that are not IPs
+// are hashed or, when truncating, dropped.
+func (a *IPAnonymizer) Anonymize(ip string, now time.Time) string {
+	if ip == "" {
+		return ""
+	}
+	switch a.mode {
+	case IPModeTruncate:
+		parsed := net.ParseIP(strings.Trim(ip, "[]"))
+		if parsed == nil {
+			return ""
+		}
+		if v4 := parsed.To4(); v4 != nil {
+			return v4.Mask(net.CIDRMask(24, 32)).String()
+		}
+		return parsed.Mask(net.CIDRMask(48, 128)).String()
+	case IPModeHMAC:
+		return a.hmac(ip, now.UnixNano()/int64(a.rotation))
+	default:
+		return ip
+	}
+}
+
+// StoredForms returns every value ip is stored as in request logs
+// timestamped from since to until: one keyed hash per rotation period
+// when hashing, else the one value Anonymize gives. A zero since stands
+// for until.
+func (a *IPAnonymizer) StoredForms(ip string, since, until time.Time) []string {
+	if a.mode != IPModeHMAC || ip == "" || since.IsZero() || !since.Before(until) {
+		if stored := a.Anonymize(ip, until); stored != "" {
+			return []string{stored}
+		}
+		return nil
+	}
+
+	rotation := int64(a.rotation)
+	var forms []string
+	for period := since.UnixNano() / rotation; period <= until.UnixNano()/rotation; period++ {
+		forms = append(forms, a.hmac(ip, period))
+	}
+	return forms
+}
+
+// hmac hashes ip with the key of a rotation period
+func (a *IPAnonymizer) hmac(ip string, period int64) string {
+	keyMAC := hmac.New(sha256.New, a.secret)
+	keyMAC.Write([]byte(strconv.FormatInt(period, 10)))
+
+	mac := hmac.New(sha256.New, keyMAC.Sum(nil))
+	mac.Write([]byte(ip))
+	// 128 bits fit the column and make collisions between clients
+	// negligible
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
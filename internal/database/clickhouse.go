@@ -0,0 +1,164 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chesskiss/btc-service/internal/clock"
+)
+
+// ClickHouseOptions locates the ClickHouse table request logs are written
+// to. URL is the HTTP interface, such as http://localhost:8123.
+type ClickHouseOptions struct {
+	URL      string
+	Database string
+	Table    string
+	User     string
+	Password string
+	Timeout  time.Duration
+}
+
+// ClickHouseSink writes request logs to ClickHouse over its HTTP
+// interface, for deployments running analytical queries over more requests
+// than PostgreSQL handles comfortably
+type ClickHouseSink struct {
+	client *http.Client
+	opts   ClickHouseOptions
+}
+
+// NewClickHouseSink returns a sink writing to the table opts locates
+func NewClickHouseSink(opts ClickHouseOptions) *ClickHouseSink {
+	return &ClickHouseSink{
+		client: &http.Client{Timeout: opts.Timeout},
+		opts:   opts,
+	}
+}
+
+// LogRequests inserts request log entries with a single INSERT in
+// JSONEachRow format. Columns the table lacks, such as id, are skipped.
+func (s *ClickHouseSink) LogRequests(ctx context.Context, reqLogs []RequestLog) error {
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, reqLog := range reqLogs {
+		if reqLog.Timestamp.IsZero() {
+			reqLog.Timestamp = clock.Now()
+		}
+		if err := enc.Encode(reqLog); err != nil {
+			return fmt.Errorf("failed to encode request log: %w", err)
+		}
+	}
+
+	query := url.Values{}
+	query.Set("query", fmt.Sprintf("INSERT INTO %s FORMAT JSONEachRow", quoteClickHouseIdentifier(s.opts.Table)))
+	query.Set("date_time_input_format", "best_effort")
+	query.Set("input_format_skip_unknown_fields", "1")
+
+	if _, err := s.post(ctx, query, "application/x-ndjson", &body); err != nil {
+		return fmt.Errorf("failed to insert request logs into clickhouse: %w", err)
+	}
+	return nil
+}
+
+// PurgeRequestLogs deletes the subject's request logs with a lightweight
+// DELETE, in either purge mode. ClickHouse hides the rows at once and
+// removes them from disk as parts are merged.
+func (s *ClickHouseSink) PurgeRequestLogs(ctx context.Context, req PurgeRequest) error {
+	var match string
+	switch req.SubjectType {
+	case SubjectTenant:
+		match = "tenant_id = {subject:String}"
+	case SubjectIP:
+		match = "user_ip IN {ips:Array(String)}"
+	case SubjectIPHash:
+		match = "lower(hex(SHA256(user_ip))) = lower({subject:String})"
+	case SubjectAPIKey:
+		match = "api_key_id = {subject:String}"
+	default:
+		return fmt.Errorf("unsupported subject type %q", req.SubjectType)
+	}
+
+	query := url.Values{}
+	query.Set("query", fmt.Sprintf("DELETE FROM %s WHERE %s", quoteClickHouseIdentifier(s.opts.Table), match))
+	query.Set("param_subject", req.Subject)
+	if req.SubjectType == SubjectIP {
+		oldest, err := s.oldestTimestamp(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to purge request logs from clickhouse: %w", err)
+		}
+		query.Set("param_ips", clickHouseArray(req.storedIPs(oldest, clock.Now())))
+	}
+
+	if _, err := s.post(ctx, query, "text/plain", nil); err != nil {
+		return fmt.Errorf("failed to purge request logs from clickhouse: %w", err)
+	}
+	return nil
+}
+
+// oldestTimestamp returns when the oldest request log in the table was
+// written, or the zero time if it is empty
+func (s *ClickHouseSink) oldestTimestamp(ctx context.Context) (time.Time, error) {
+	query := url.Values{}
+	query.Set("query", fmt.Sprintf("SELECT toUnixTimestamp(min(timestamp)) FROM %s HAVING count() > 0", quoteClickHouseIdentifier(s.opts.Table)))
+	out, err := s.post(ctx, query, "text/plain", nil)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if len(bytes.TrimSpace(out)) == 0 {
+		return time.Time{}, nil
+	}
+	seconds, err := strconv.ParseInt(string(bytes.TrimSpace(out)), 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("unexpected oldest timestamp %q", out)
+	}
+	return time.Unix(seconds, 0), nil
+}
+
+// clickHouseArray formats values as an Array(String) query parameter
+func clickHouseArray(values []string) string {
+	quoted := make([]string, len(values))
+	for i, value := range values {
+		quoted[i] = "'" + strings.ReplaceAll(strings.ReplaceAll(value, `\`, `\\`), "'", `\'`) + "'"
+	}
+	return "[" + strings.Join(quoted, ",") + "]"
+}
+
+// post sends a statement, given in query with its settings, to the HTTP
+// interface with body as its input, and returns its output
+func (s *ClickHouseSink) post(ctx context.Context, query url.Values, contentType string, body io.Reader) ([]byte, error) {
+	query.Set("database", s.opts.Database)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(s.opts.URL, "/")+"/?"+query.Encode(), body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build clickhouse request: %w", err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	if s.opts.User != "" {
+		req.Header.Set("X-ClickHouse-User", s.opts.User)
+		req.Header.Set("X-ClickHouse-Key", s.opts.Password)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		// ClickHouse explains the failure in the body
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("clickhouse returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(detail)))
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// quoteClickHouseIdentifier quotes a table name for use in a query
+func quoteClickHouseIdentifier(name string) string {
+	return "`" + strings.ReplaceAll(strings.ReplaceAll(name, `\`, `\\`), "`", "\\`") + "`"
+}
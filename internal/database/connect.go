@@ -0,0 +1,71 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+// ErrNotReady is returned by a ReadySink until its database is reachable
+// and its schema up to date
+var ErrNotReady = errors.New("database not ready")
+
+// AwaitConnection pings the database every interval until it answers,
+// then runs setup, such as applying migrations, retrying it until it
+// succeeds. setup may be nil. The returned channel is closed once both
+// have succeeded, or never if ctx is cancelled first.
+func AwaitConnection(ctx context.Context, ping func(context.Context) error, setup func(context.Context) error, interval time.Duration) <-chan struct{} {
+	ready := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		connected := false
+		for {
+			if !connected {
+				connected = ping(ctx) == nil
+				if connected {
+					slog.Info("database reachable")
+				}
+			}
+			if connected {
+				var err error
+				if setup != nil {
+					err = setup(ctx)
+				}
+				if err == nil {
+					close(ready)
+					return
+				}
+				slog.Error("database setup failed, retrying",
+					"error", err,
+				)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return ready
+}
+
+// ReadySink writes to Sink once Ready is closed. Until then every batch
+// fails, without being a database rejection, so the writer spools it
+// rather than replaying it into a schema that isn't there yet.
+type ReadySink struct {
+	Sink  RequestLogSink
+	Ready <-chan struct{}
+}
+
+func (s ReadySink) LogRequests(ctx context.Context, reqLogs []RequestLog) error {
+	select {
+	case <-s.Ready:
+		return s.Sink.LogRequests(ctx, reqLogs)
+	default:
+		return ErrNotReady
+	}
+}
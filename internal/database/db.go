@@ -1,66 +1,263 @@
 package database
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
+	"strconv"
+	"strings"
+	"time"
 
-	_ "github.com/lib/pq"
-)
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
 
-var db *sql.DB
+	"github.com/chesskiss/btc-service/internal/clock"
+	"github.com/chesskiss/btc-service/internal/metrics"
+	"github.com/chesskiss/btc-service/internal/pagination"
+)
 
 type RequestLog struct {
-	RequestID      string
-	Method         string
-	Endpoint       string
-	PairsRequested string
-	UserIP         string
-	StatusCode     int
-	ResponseTimeMs int
-	CacheHit       bool
-	KrakenCalls    int
-	ErrorOccurred  bool
-	ErrorMessage   string
-}
-
-// InitDB initializes the PostgreSQL database connection
-func InitDB(host, port, user, password, dbname string) (*sql.DB, error) {
-	connStr := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
-		host, port, user, password, dbname)
-
-	var err error
-	db, err = sql.Open("postgres", connStr)
+	ID             int64     `json:"id"`
+	RequestID      string    `json:"request_id"`
+	Timestamp      time.Time `json:"timestamp"`
+	Method         string    `json:"method"`
+	Endpoint       string    `json:"endpoint"`
+	PairsRequested string    `json:"pairs_requested"`
+	UserIP         string    `json:"user_ip"`
+	StatusCode     int       `json:"status_code"`
+	ResponseTimeMs int       `json:"response_time_ms"`
+	CacheHit       bool      `json:"cache_hit"`
+	KrakenCalls    int       `json:"kraken_calls"`
+	ErrorOccurred  bool      `json:"error_occurred"`
+	ErrorMessage   string    `json:"error_message"`
+
+	TraceID           string `json:"trace_id"`
+	TenantID          string `json:"tenant_id"`
+	UserAgent         string `json:"user_agent"`
+	ResponseBytes     int    `json:"response_bytes"`
+	UpstreamLatencyMs int    `json:"upstream_latency_ms"`
+	// CachedPairs lists, comma separated, the pairs served from cache
+	CachedPairs string `json:"cached_pairs"`
+	// APIKeyID identifies the API key the request authenticated with
+	APIKeyID string `json:"api_key_id"`
+	// PairOutcomes records what happened to each requested pair
+	PairOutcomes []PairOutcome `json:"pair_outcomes"`
+}
+
+// PairOutcome is what happened to one pair of a request: priced, from
+// cache or not, or failed for Reason
+type PairOutcome struct {
+	Pair     string `json:"pair"`
+	Success  bool   `json:"success"`
+	CacheHit bool   `json:"cache_hit"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// RequestLogFilter narrows a request log query. Zero values are ignored.
+type RequestLogFilter struct {
+	From          time.Time
+	To            time.Time
+	StatusCode    int
+	ErrorOccurred *bool
+	// Pair, in canonical form such as BTC/USD, matches requests with an
+	// outcome recorded for it
+	Pair     string
+	APIKeyID string
+	Limit    int
+	Offset   int
+	// Sort defaults to newest first; After continues from a cursor
+	Sort  pagination.Sort
+	After *pagination.Cursor
+}
+
+// RequestLogSorts are the fields request logs can be sorted by
+var RequestLogSorts = pagination.Sorts{
+	"timestamp":        pagination.TimeValue,
+	"id":               pagination.Int64Value,
+	"status_code":      pagination.Int32Value,
+	"response_time_ms": pagination.Int32Value,
+}
+
+// DefaultRequestLogSort lists the newest requests first
+var DefaultRequestLogSort = pagination.Sort{Field: "timestamp", Desc: true}
+
+var requestLogSortColumns = map[string]string{
+	"timestamp":        "timestamp",
+	"id":               "id",
+	"status_code":      "status_code",
+	"response_time_ms": "response_time_ms",
+}
+
+// RequestLogSortValue returns the value of reqLog's sort field, as used in a
+// pagination cursor
+func RequestLogSortValue(reqLog RequestLog, sort pagination.Sort) string {
+	switch sort.Field {
+	case "id":
+		return strconv.FormatInt(reqLog.ID, 10)
+	case "status_code":
+		return strconv.Itoa(reqLog.StatusCode)
+	case "response_time_ms":
+		return strconv.Itoa(reqLog.ResponseTimeMs)
+	default:
+		return reqLog.Timestamp.Format(time.RFC3339Nano)
+	}
+}
+
+// TLSOptions configures TLS to PostgreSQL. SSLMode takes the libpq values,
+// from disable to verify-full; RootCert verifies the server, and Cert and
+// Key, both PEM files, authenticate the service to it.
+type TLSOptions struct {
+	SSLMode  string
+	RootCert string
+	Cert     string
+	Key      string
+}
+
+// InitDB opens and checks a connection pool to PostgreSQL, for
+// NewPostgresStore
+func InitDB(ctx context.Context, host, port, user, password, dbname string, tls TLSOptions) (*pgxpool.Pool, error) {
+	pool, err := OpenDB(ctx, host, port, user, password, dbname, tls)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open database: %w", err)
+		return nil, err
 	}
 
-	if err := db.Ping(); err != nil {
+	if err := pool.Ping(ctx); err != nil {
 		log.Printf("Warning: Failed to connect to PostgreSQL: %v", err)
 		log.Println("Continuing without request logging...")
+		pool.Close()
 		return nil, err
 	}
 
 	log.Println("PostgreSQL connected successfully")
-	return db, nil
+	return pool, nil
+}
+
+// OpenDB opens a connection pool to PostgreSQL without checking that the
+// server is reachable. The pool connects on first use, so a store built on
+// it starts working once PostgreSQL is up. It fails only for settings that
+// can't be used, such as a missing certificate.
+func OpenDB(ctx context.Context, host, port, user, password, dbname string, tls TLSOptions) (*pgxpool.Pool, error) {
+	sslMode := tls.SSLMode
+	if sslMode == "" {
+		sslMode = "disable"
+	}
+	params := [][2]string{
+		{"host", host},
+		{"port", port},
+		{"user", user},
+		{"password", password},
+		{"dbname", dbname},
+		{"sslmode", sslMode},
+		{"sslrootcert", tls.RootCert},
+		{"sslcert", tls.Cert},
+		{"sslkey", tls.Key},
+	}
+	var connStr strings.Builder
+	for _, param := range params {
+		if param[1] == "" && param[0] != "password" {
+			continue
+		}
+		fmt.Fprintf(&connStr, "%s=%s ", param[0], quoteConnValue(param[1]))
+	}
+
+	pool, err := pgxpool.New(ctx, strings.TrimSpace(connStr.String()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	return pool, nil
+}
+
+// InitReadReplica opens and checks a connection pool to a read replica,
+// given as a libpq connection string or URL, for the store behind
+// analytics queries
+func InitReadReplica(ctx context.Context, dsn string) (*pgxpool.Pool, error) {
+	config, err := pgxpool.ParseConfig(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse read replica DSN: %w", err)
+	}
+	// Keep writes off it even when the DSN points at a primary
+	config.ConnConfig.RuntimeParams["default_transaction_read_only"] = "on"
+
+	pool, err := pgxpool.NewWithConfig(ctx, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open read replica: %w", err)
+	}
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("failed to connect to read replica: %w", err)
+	}
+	return pool, nil
 }
 
-// LogRequest inserts a request log entry into the database
-func LogRequest(reqLog RequestLog) error {
-	if db == nil {
+// PoolStats reports pool's connections for the DB pool metrics
+func PoolStats(pool *pgxpool.Pool) metrics.DBPoolStats {
+	stat := pool.Stat()
+	return metrics.DBPoolStats{
+		Open:         int(stat.TotalConns()),
+		InUse:        int(stat.AcquiredConns()),
+		Idle:         int(stat.IdleConns()),
+		Max:          int(stat.MaxConns()),
+		WaitCount:    stat.EmptyAcquireCount(),
+		WaitDuration: stat.EmptyAcquireWaitTime(),
+	}
+}
+
+// quoteConnValue quotes a connection string value, which may then hold
+// spaces and quotes
+func quoteConnValue(value string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(value) + "'"
+}
+
+// LogRequest inserts a request log entry into the database. An entry whose
+// request ID is already stored, as when a write is retried, is skipped.
+func (s *PostgresStore) LogRequest(ctx context.Context, reqLog RequestLog) error {
+	if s.pool == nil {
 		return fmt.Errorf("database not initialized")
 	}
 
-	query := `
-		INSERT INTO request_logs (
-			request_id, method, endpoint, pairs_requested, user_ip,
-			status_code, response_time_ms, cache_hit, kraken_calls,
-			error_occurred, error_message
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
-	`
+	if _, err := s.pool.Exec(ctx, insertRequestLogSQL, requestLogValues(reqLog)...); err != nil {
+		log.Printf("Failed to log request to database: %v", err)
+		return err
+	}
+
+	return nil
+}
 
-	_, err := db.Exec(query,
+// requestLogColumns are the columns LogRequests fills, in the order
+// of requestLogValues
+var requestLogColumns = []string{
+	"request_id", "timestamp", "method", "endpoint", "pairs_requested", "user_ip",
+	"status_code", "response_time_ms", "cache_hit", "kraken_calls",
+	"error_occurred", "error_message", "trace_id", "tenant_id",
+	"user_agent", "response_bytes", "upstream_latency_ms", "cached_pairs",
+	"api_key_id", "pair_outcomes",
+}
+
+// insertRequestLogSQL inserts one request log entry unless its request ID
+// is already stored
+var insertRequestLogSQL = func() string {
+	placeholders := make([]string, len(requestLogColumns))
+	for i := range placeholders {
+		placeholders[i] = "$" + strconv.Itoa(i+1)
+	}
+	return "INSERT INTO request_logs (" + strings.Join(requestLogColumns, ", ") +
+		") VALUES (" + strings.Join(placeholders, ", ") + ") ON CONFLICT (request_id) DO NOTHING"
+}()
+
+// uniqueViolation is the SQLSTATE of an insert breaking a unique constraint
+const uniqueViolation = "23505"
+
+func requestLogValues(reqLog RequestLog) []interface{} {
+	timestamp := reqLog.Timestamp
+	if timestamp.IsZero() {
+		timestamp = clock.Now()
+	}
+	return []interface{}{
 		reqLog.RequestID,
+		timestamp,
 		reqLog.Method,
 		reqLog.Endpoint,
 		reqLog.PairsRequested,
@@ -71,19 +268,193 @@ func LogRequest(reqLog RequestLog) error {
 		reqLog.KrakenCalls,
 		reqLog.ErrorOccurred,
 		reqLog.ErrorMessage,
-	)
+		reqLog.TraceID,
+		reqLog.TenantID,
+		reqLog.UserAgent,
+		reqLog.ResponseBytes,
+		reqLog.UpstreamLatencyMs,
+		reqLog.CachedPairs,
+		reqLog.APIKeyID,
+		reqLog.PairOutcomes,
+	}
+}
 
+// LogRequests copies request log entries into the table with COPY, in one
+// round trip however many there are; if one is rejected none are stored.
+// COPY cannot skip conflicting rows, so when an entry's request ID is
+// already stored, as when a batch that was partly written is retried, the
+// entries are inserted again one statement each, skipping duplicates.
+func (s *PostgresStore) LogRequests(ctx context.Context, reqLogs []RequestLog) error {
+	if s.pool == nil {
+		return fmt.Errorf("database not initialized")
+	}
+
+	_, err := s.pool.CopyFrom(ctx, pgx.Identifier{"request_logs"}, requestLogColumns,
+		pgx.CopyFromSlice(len(reqLogs), func(i int) ([]any, error) {
+			return requestLogValues(reqLogs[i]), nil
+		}))
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+		return s.insertRequestLogs(ctx, reqLogs)
+	}
 	if err != nil {
-		log.Printf("Failed to log request to database: %v", err)
-		return err
+		return fmt.Errorf("failed to copy request logs: %w", err)
 	}
+	return nil
+}
 
+// insertRequestLogs inserts request log entries skipping those already
+// stored. The statements are sent as one batch, so they take a single
+// round trip and are applied together or not at all.
+func (s *PostgresStore) insertRequestLogs(ctx context.Context, reqLogs []RequestLog) error {
+	batch := &pgx.Batch{}
+	for _, reqLog := range reqLogs {
+		batch.Queue(insertRequestLogSQL, requestLogValues(reqLog)...)
+	}
+	if err := s.pool.SendBatch(ctx, batch).Close(); err != nil {
+		return fmt.Errorf("failed to insert request logs: %w", err)
+	}
 	return nil
 }
 
-// Close closes the database connection
-func Close() {
-	if db != nil {
-		db.Close()
+// requestLogSelectColumns are the columns scanRequestLogs reads
+const requestLogSelectColumns = `id, request_id, timestamp, method, endpoint, pairs_requested,
+	user_ip, status_code, response_time_ms, cache_hit, kraken_calls,
+	error_occurred, COALESCE(error_message, ''),
+	COALESCE(trace_id, ''), COALESCE(tenant_id, ''),
+	COALESCE(user_agent, ''), COALESCE(response_bytes, 0),
+	COALESCE(upstream_latency_ms, 0), COALESCE(cached_pairs, ''),
+	COALESCE(api_key_id, ''), COALESCE(pair_outcomes, '[]')`
+
+// scanRequestLogs reads rows of requestLogSelectColumns
+func scanRequestLogs(rows pgx.Rows) ([]RequestLog, error) {
+	logs := []RequestLog{}
+	for rows.Next() {
+		var reqLog RequestLog
+		if err := rows.Scan(
+			&reqLog.ID,
+			&reqLog.RequestID,
+			&reqLog.Timestamp,
+			&reqLog.Method,
+			&reqLog.Endpoint,
+			&reqLog.PairsRequested,
+			&reqLog.UserIP,
+			&reqLog.StatusCode,
+			&reqLog.ResponseTimeMs,
+			&reqLog.CacheHit,
+			&reqLog.KrakenCalls,
+			&reqLog.ErrorOccurred,
+			&reqLog.ErrorMessage,
+			&reqLog.TraceID,
+			&reqLog.TenantID,
+			&reqLog.UserAgent,
+			&reqLog.ResponseBytes,
+			&reqLog.UpstreamLatencyMs,
+			&reqLog.CachedPairs,
+			&reqLog.APIKeyID,
+			&reqLog.PairOutcomes,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan request log: %w", err)
+		}
+		reqLog.Timestamp = reqLog.Timestamp.UTC()
+		logs = append(logs, reqLog)
+	}
+	return logs, rows.Err()
+}
+
+// QueryRequests returns request log entries matching the filter, newest first
+func (s *PostgresStore) QueryRequests(ctx context.Context, filter RequestLogFilter) ([]RequestLog, error) {
+	if s.pool == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+
+	// Soft-deleted rows are hidden until they are purged for good
+	conditions := []string{"deleted_at IS NULL"}
+	var args []interface{}
+	addCondition := func(clause string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(clause, len(args)))
+	}
+
+	if !filter.From.IsZero() {
+		addCondition("timestamp >= $%d", filter.From)
+	}
+	if !filter.To.IsZero() {
+		addCondition("timestamp < $%d", filter.To)
+	}
+	if filter.StatusCode != 0 {
+		addCondition("status_code = $%d", filter.StatusCode)
+	}
+	if filter.ErrorOccurred != nil {
+		addCondition("error_occurred = $%d", *filter.ErrorOccurred)
+	}
+	if filter.Pair != "" {
+		addCondition("pair_outcomes @> jsonb_build_array(jsonb_build_object('pair', $%d::text))", filter.Pair)
+	}
+	if filter.APIKeyID != "" {
+		addCondition("api_key_id = $%d", filter.APIKeyID)
+	}
+
+	query := "SELECT " + requestLogSelectColumns + " FROM request_logs"
+	sort := filter.Sort
+	if sort.Field == "" {
+		sort = DefaultRequestLogSort
+	}
+	page := pagination.Page{Limit: filter.Limit, Sort: sort, After: filter.After}
+	conditions, order, args := pageClauses(page, requestLogSortColumns, conditions, args)
+
+	query += " WHERE " + strings.Join(conditions, " AND ")
+
+	args = append(args, filter.Offset)
+	query += order + fmt.Sprintf(" OFFSET $%d", len(args))
+
+	rows, err := s.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query request logs: %w", err)
+	}
+	defer rows.Close()
+
+	return scanRequestLogs(rows)
+}
+
+// FrequentPairs returns up to limit of the pairs most often requested
+// since the given time, most frequent first, as they were requested
+func (s *PostgresStore) FrequentPairs(ctx context.Context, since time.Time, limit int) ([]string, error) {
+	if s.pool == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+
+	rows, err := s.pool.Query(ctx, `
+		SELECT btrim(pair) AS pair
+		FROM request_logs,
+		     unnest(string_to_array(pairs_requested, ',')) AS pair
+		WHERE deleted_at IS NULL AND timestamp >= $1 AND btrim(pair) <> ''
+		GROUP BY btrim(pair)
+		ORDER BY COUNT(*) DESC, pair
+		LIMIT $2
+	`, since, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query frequent pairs: %w", err)
+	}
+	defer rows.Close()
+
+	var pairs []string
+	for rows.Next() {
+		var pair string
+		if err := rows.Scan(&pair); err != nil {
+			return nil, fmt.Errorf("failed to scan frequent pair: %w", err)
+		}
+		pairs = append(pairs, pair)
+	}
+	return pairs, rows.Err()
+}
+
+// nullTimeUTC converts a nullable column to a UTC time, or nil when NULL,
+// so timestamps are returned in UTC whatever the session time zone
+func nullTimeUTC(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
 	}
+	utc := t.Time.UTC()
+	return &utc
 }
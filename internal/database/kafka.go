@@ -0,0 +1,79 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+
+	"github.com/chesskiss/btc-service/internal/clock"
+)
+
+// KafkaOptions locates the topic request log events are published to
+type KafkaOptions struct {
+	Brokers []string
+	Topic   string
+	// BatchSize is the most request logs published at once, the request
+	// log writer's batch size
+	BatchSize int
+	// Timeout bounds publishing a batch
+	Timeout time.Duration
+}
+
+// KafkaSink publishes request logs to a Kafka topic as JSON events, one
+// message per request keyed by its request ID, for data pipelines that
+// consume usage events
+type KafkaSink struct {
+	writer *kafka.Writer
+}
+
+// NewKafkaSink returns a sink publishing to the topic opts locates. It
+// connects to the brokers as it publishes.
+func NewKafkaSink(opts KafkaOptions) *KafkaSink {
+	return &KafkaSink{
+		writer: &kafka.Writer{
+			Addr:     kafka.TCP(opts.Brokers...),
+			Topic:    opts.Topic,
+			Balancer: &kafka.Hash{},
+			// A batch is published once every broker replica has it
+			RequiredAcks: kafka.RequireAll,
+			// LogRequests hands over whole batches, so don't wait for more
+			BatchSize:    opts.BatchSize,
+			BatchTimeout: 10 * time.Millisecond,
+			WriteTimeout: opts.Timeout,
+			ReadTimeout:  opts.Timeout,
+		},
+	}
+}
+
+// LogRequests publishes request log entries, waiting until the brokers
+// acknowledge them
+func (s *KafkaSink) LogRequests(ctx context.Context, reqLogs []RequestLog) error {
+	messages := make([]kafka.Message, 0, len(reqLogs))
+	for _, reqLog := range reqLogs {
+		if reqLog.Timestamp.IsZero() {
+			reqLog.Timestamp = clock.Now()
+		}
+		value, err := json.Marshal(reqLog)
+		if err != nil {
+			return fmt.Errorf("failed to encode request log: %w", err)
+		}
+		messages = append(messages, kafka.Message{
+			Key:   []byte(reqLog.RequestID),
+			Value: value,
+			Time:  reqLog.Timestamp,
+		})
+	}
+
+	if err := s.writer.WriteMessages(ctx, messages...); err != nil {
+		return fmt.Errorf("failed to publish request logs to kafka: %w", err)
+	}
+	return nil
+}
+
+// Close flushes pending messages and closes the connections to the brokers
+func (s *KafkaSink) Close() error {
+	return s.writer.Close()
+}
@@ -0,0 +1,43 @@
+package database
+
+import (
+	"context"
+	"fmt"
+)
+
+// lockPrefix keeps the service's lock names apart from those of other
+// applications sharing the database server
+const lockPrefix = "btc_service:"
+
+// RunExclusive runs fn while holding the advisory lock called name,
+// reporting false without running it when another replica holds the lock.
+// The lock is released when fn returns, or by the server if the process
+// dies holding it.
+func (s *PostgresStore) RunExclusive(ctx context.Context, name string, fn func(context.Context) error) (bool, error) {
+	if s.pool == nil {
+		return false, fmt.Errorf("database not initialized")
+	}
+
+	conn, err := s.pool.Acquire(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to take lock %s: %w", name, err)
+	}
+	defer conn.Release()
+
+	var locked bool
+	if err := conn.QueryRow(ctx, "SELECT pg_try_advisory_lock(hashtext($1))", lockPrefix+name).Scan(&locked); err != nil {
+		return false, fmt.Errorf("failed to take lock %s: %w", name, err)
+	}
+	if !locked {
+		return false, nil
+	}
+	defer func() {
+		// A session lock outlives the borrowed connection unless released,
+		// so a connection that can't release it is closed instead
+		if _, err := conn.Exec(context.Background(), "SELECT pg_advisory_unlock(hashtext($1))", lockPrefix+name); err != nil {
+			conn.Conn().Close(context.Background())
+		}
+	}()
+
+	return true, fn(ctx)
+}
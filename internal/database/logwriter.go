@@ -0,0 +1,306 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"github.com/chesskiss/btc-service/internal/clock"
+	"github.com/chesskiss/btc-service/internal/metrics"
+)
+
+// RequestLogWriter queues request log entries and inserts them in batches
+// from a single goroutine, so logging costs a request no more than a
+// channel send however slow the database is. Entries arriving while the
+// queue is full are dropped and counted. With a spool, batches that fail
+// to be written while the database is unreachable are kept on disk and
+// replayed once it is back.
+type RequestLogWriter struct {
+	sink          RequestLogSink
+	spool         *Spool
+	spoolPending  bool
+	entries       chan RequestLog
+	batchSize     int
+	flushInterval time.Duration
+	done          chan struct{}
+
+	sampler    atomic.Pointer[RequestLogSampler]
+	anonymizer atomic.Pointer[IPAnonymizer]
+
+	// mu keeps entries from being sent to once Close has closed them
+	mu     sync.RWMutex
+	closed bool
+}
+
+// StartRequestLogWriter starts a writer that writes the entries handed to
+// Enqueue to sink. It holds up to bufferSize entries and inserts up to
+// batchSize at a time, at least every flushInterval while entries are
+// queued. spool may be nil, to drop batches that fail.
+func StartRequestLogWriter(sink RequestLogSink, spool *Spool, bufferSize, batchSize int, flushInterval time.Duration) *RequestLogWriter {
+	w := &RequestLogWriter{
+		sink:          sink,
+		spool:         spool,
+		entries:       make(chan RequestLog, bufferSize),
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+		done:          make(chan struct{}),
+	}
+	if spool != nil {
+		// Entries left by a previous run are replayed on the first tick
+		size, err := spool.Size()
+		w.spoolPending = err != nil || size > 0
+	}
+	go w.run()
+	return w
+}
+
+// SetSampler sets which logs Enqueue keeps; nil keeps every one
+func (w *RequestLogWriter) SetSampler(s *RequestLogSampler) {
+	w.sampler.Store(s)
+}
+
+// SetIPAnonymizer sets how Enqueue rewrites client IPs; nil stores them as
+// they are
+func (w *RequestLogWriter) SetIPAnonymizer(a *IPAnonymizer) {
+	w.anonymizer.Store(a)
+}
+
+// Enqueue queues reqLog for insertion and reports whether it was queued; a
+// nil writer logs nothing. A zero Timestamp is set to now, rather than to
+// when the batch is written, and the client IP is anonymized before it is
+// queued. Logs the sampler skips are not queued.
+func (w *RequestLogWriter) Enqueue(reqLog RequestLog) bool {
+	if w == nil {
+		return false
+	}
+	if s := w.sampler.Load(); s != nil && !s.Keep(reqLog) {
+		return false
+	}
+	if reqLog.Timestamp.IsZero() {
+		reqLog.Timestamp = clock.Now()
+	}
+	if a := w.anonymizer.Load(); a != nil {
+		reqLog.UserIP = a.Anonymize(reqLog.UserIP, reqLog.Timestamp)
+	}
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		return false
+	}
+	select {
+	case w.entries <- reqLog:
+		return true
+	default:
+		metrics.RequestLogsDroppedTotal.WithLabelValues("queue_full").Inc()
+		return false
+	}
+}
+
+// Close stops accepting entries and writes the queued ones, waiting until
+// they are written or ctx is done
+func (w *RequestLogWriter) Close(ctx context.Context) error {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.entries)
+	}
+	w.mu.Unlock()
+
+	select {
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (w *RequestLogWriter) run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]RequestLog, 0, w.batchSize)
+	for {
+		select {
+		case reqLog, ok := <-w.entries:
+			if !ok {
+				w.write(batch)
+				return
+			}
+			batch = append(batch, reqLog)
+			if len(batch) >= w.batchSize {
+				w.write(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			w.write(batch)
+			batch = batch[:0]
+			if w.spoolPending {
+				w.replay()
+			}
+		}
+	}
+}
+
+// write inserts batch. If the database rejects a value or constraint of
+// some entry, the entries are written one by one so only the bad ones are
+// dropped. If the insert fails otherwise it is spooled, unless the
+// database rejected it, and otherwise dropped and counted.
+func (w *RequestLogWriter) write(batch []RequestLog) {
+	if len(batch) == 0 {
+		return
+	}
+	err := w.sink.LogRequests(context.Background(), batch)
+	if err == nil {
+		metrics.RequestLogsWrittenTotal.Add(float64(len(batch)))
+		return
+	}
+	metrics.RequestLogWriteErrorsTotal.Inc()
+
+	sqlState, detail, rejected := databaseRejection(err)
+	if rejected && rowRejected(sqlState) {
+		written, rest, restErr := w.writeEach(context.Background(), batch)
+		metrics.RequestLogsWrittenTotal.Add(float64(written))
+		if len(rest) == 0 {
+			return
+		}
+		batch, err = rest, restErr
+		sqlState, detail, rejected = databaseRejection(err)
+	}
+
+	attrs := []any{"entries", len(batch), "error", err}
+	// Say which constraint or value the database rejected
+	if rejected {
+		attrs = append(attrs, "sqlstate", sqlState, "detail", detail)
+	}
+
+	// Retrying a batch the database rejected would fail the same way
+	if w.spool != nil && !rejected {
+		spoolErr := w.spool.Append(batch)
+		if spoolErr == nil {
+			w.spoolPending = true
+			slog.Warn("failed to write request logs, spooled them to disk", attrs...)
+			metrics.RequestLogsSpooledTotal.Add(float64(len(batch)))
+			return
+		}
+		slog.Warn("failed to write request logs", append(attrs, "spool_error", spoolErr)...)
+		reason := "spool_error"
+		if errors.Is(spoolErr, ErrSpoolFull) {
+			reason = "spool_full"
+		}
+		metrics.RequestLogsDroppedTotal.WithLabelValues(reason).Add(float64(len(batch)))
+		return
+	}
+	slog.Warn("failed to write request logs", attrs...)
+	metrics.RequestLogsDroppedTotal.WithLabelValues("write_error").Add(float64(len(batch)))
+}
+
+// replay writes the spooled entries, leaving them spooled while the
+// database is still unreachable
+func (w *RequestLogWriter) replay() {
+	replayed, err := w.spool.Replay(context.Background(), w.batchSize, w.writeSpooled)
+	if err != nil {
+		slog.Debug("request log spool not replayed yet",
+			"replayed", replayed,
+			"error", err,
+		)
+		return
+	}
+	w.spoolPending = false
+	if replayed > 0 {
+		slog.Info("replayed spooled request logs",
+			"entries", replayed,
+		)
+	}
+}
+
+// writeSpooled inserts a batch replayed from the spool. An entry may have
+// been written before its batch was spooled, as when the connection was
+// lost before the insert was acknowledged; the store skips those. Entries
+// the database rejects are dropped, so they cannot block the spool.
+func (w *RequestLogWriter) writeSpooled(ctx context.Context, batch []RequestLog) error {
+	err := w.sink.LogRequests(ctx, batch)
+	if err == nil {
+		metrics.RequestLogsWrittenTotal.Add(float64(len(batch)))
+		metrics.RequestLogsReplayedTotal.Add(float64(len(batch)))
+		return nil
+	}
+	metrics.RequestLogWriteErrorsTotal.Inc()
+	sqlState, detail, rejected := databaseRejection(err)
+	if rejected && rowRejected(sqlState) {
+		written, rest, restErr := w.writeEach(ctx, batch)
+		metrics.RequestLogsWrittenTotal.Add(float64(written))
+		metrics.RequestLogsReplayedTotal.Add(float64(written))
+		if len(rest) == 0 {
+			return nil
+		}
+		batch, err = rest, restErr
+		sqlState, detail, rejected = databaseRejection(err)
+	}
+	if !rejected {
+		return err
+	}
+	slog.Warn("failed to write spooled request logs",
+		"entries", len(batch),
+		"error", err,
+		"sqlstate", sqlState,
+		"detail", detail,
+	)
+	metrics.RequestLogsDroppedTotal.WithLabelValues("write_error").Add(float64(len(batch)))
+	return nil
+}
+
+// writeEach inserts batch one entry at a time, dropping and counting the
+// entries the database rejects for a value or constraint. It stops at the
+// first entry that fails otherwise, returning it and those after it as
+// rest, with the error.
+func (w *RequestLogWriter) writeEach(ctx context.Context, batch []RequestLog) (written int, rest []RequestLog, err error) {
+	for i := range batch {
+		err := w.sink.LogRequests(ctx, batch[i:i+1])
+		if err == nil {
+			written++
+			continue
+		}
+		sqlState, detail, rejected := databaseRejection(err)
+		if !rejected || !rowRejected(sqlState) {
+			return written, batch[i:], err
+		}
+		slog.Warn("dropped a request log the database rejected",
+			"request_id", batch[i].RequestID,
+			"error", err,
+			"sqlstate", sqlState,
+			"detail", detail,
+		)
+		metrics.RequestLogsDroppedTotal.WithLabelValues("write_error").Inc()
+	}
+	return written, nil, nil
+}
+
+// rowRejected reports whether sqlState is a data exception (class 22),
+// such as a value too long for its column, or an integrity constraint
+// violation (class 23), which fail a batch for the sake of single entries
+func rowRejected(sqlState string) bool {
+	return strings.HasPrefix(sqlState, "22") || strings.HasPrefix(sqlState, "23")
+}
+
+// databaseRejection reports whether err is PostgreSQL or MySQL refusing a
+// write, rather than being unreachable, with its SQLSTATE and detail
+func databaseRejection(err error) (sqlState, detail string, ok bool) {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code, pgErr.Detail, true
+	}
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return string(mysqlErr.SQLState[:]), mysqlErr.Message, true
+	}
+	return "", "", false
+}
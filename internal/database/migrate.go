@@ -0,0 +1,268 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+//go:embed migrations/*.sql migrations/mysql/*.sql
+var migrationFiles embed.FS
+
+// migrationLockID is an arbitrary key for the advisory lock replicas take
+// while migrating, so only one applies the migrations when several start
+// at once
+const migrationLockID = 7_340_001
+
+// Migration is one schema change, from a file in migrations named
+// NNNN_description.sql
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// Migrations returns the migrations built into the binary, oldest first
+func Migrations() ([]Migration, error) {
+	return loadMigrations("migrations")
+}
+
+// MySQLMigrations returns the migrations built into the binary for
+// MySQLStore, oldest first. They are versioned apart from the PostgreSQL
+// ones, starting from the schema those had built.
+func MySQLMigrations() ([]Migration, error) {
+	return loadMigrations("migrations/mysql")
+}
+
+func loadMigrations(dir string) ([]Migration, error) {
+	paths, err := fs.Glob(migrationFiles, dir+"/*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := make([]Migration, 0, len(paths))
+	for _, p := range paths {
+		name := strings.TrimSuffix(path.Base(p), ".sql")
+		prefix, _, _ := strings.Cut(name, "_")
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s has no version prefix", p)
+		}
+		data, err := migrationFiles.ReadFile(p)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %w", p, err)
+		}
+		migrations = append(migrations, Migration{Version: version, Name: name, SQL: string(data)})
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	for i := 1; i < len(migrations); i++ {
+		if migrations[i].Version == migrations[i-1].Version {
+			return nil, fmt.Errorf("migrations %s and %s share a version", migrations[i-1].Name, migrations[i].Name)
+		}
+	}
+	return migrations, nil
+}
+
+// Migrate applies the built-in migrations db has not applied yet, oldest
+// first, each in its own transaction, recording them in schema_migrations,
+// and returns how many it applied. Migrations up to baseline are recorded
+// as applied without running them, for databases whose schema was created
+// by hand before migrations ran on startup.
+func Migrate(ctx context.Context, pool *pgxpool.Pool, baseline int) (int, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return 0, err
+	}
+
+	// The advisory lock belongs to a session, so everything runs on one
+	// connection
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, "SELECT pg_advisory_lock($1)", migrationLockID); err != nil {
+		return 0, fmt.Errorf("failed to take migration lock: %w", err)
+	}
+	defer conn.Exec(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLockID)
+
+	if _, err := conn.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INT PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		)`); err != nil {
+		return 0, fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+
+	applied := make(map[int]bool)
+	rows, err := conn.Query(ctx, "SELECT version FROM schema_migrations")
+	if err != nil {
+		return 0, fmt.Errorf("failed to read applied migrations: %w", err)
+	}
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to read applied migrations: %w", err)
+		}
+		applied[version] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to read applied migrations: %w", err)
+	}
+
+	count := 0
+	for _, m := range migrations {
+		if applied[m.Version] {
+			continue
+		}
+		if err := applyMigration(ctx, conn, m, m.Version <= baseline); err != nil {
+			return count, err
+		}
+		if m.Version <= baseline {
+			slog.Info("migration marked as applied", "migration", m.Name)
+			continue
+		}
+		slog.Info("migration applied", "migration", m.Name)
+		count++
+	}
+	return count, nil
+}
+
+// applyMigration runs m, unless skip is set, and records it as applied,
+// both in one transaction
+func applyMigration(ctx context.Context, conn *pgxpool.Conn, m Migration, skip bool) error {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin migration %s: %w", m.Name, err)
+	}
+	defer tx.Rollback(ctx)
+
+	if !skip {
+		if _, err := tx.Exec(ctx, m.SQL); err != nil {
+			return fmt.Errorf("failed to apply migration %s: %w", m.Name, err)
+		}
+	}
+	if _, err := tx.Exec(ctx, "INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", m.Version, m.Name); err != nil {
+		return fmt.Errorf("failed to record migration %s: %w", m.Name, err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit migration %s: %w", m.Name, err)
+	}
+	return nil
+}
+
+// MigrateMySQL is Migrate for MySQLStore. MySQL commits each schema change
+// as it runs, so a migration that fails partway stays partly applied
+// without being recorded; migrations are written to be rerun.
+func MigrateMySQL(ctx context.Context, db *sql.DB, baseline int) (int, error) {
+	migrations, err := MySQLMigrations()
+	if err != nil {
+		return 0, err
+	}
+
+	// The named lock belongs to a session, so everything runs on one
+	// connection
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer conn.Close()
+
+	var locked sql.NullInt64
+	if err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", mysqlMigrationLock, mysqlMigrationLockTimeout).Scan(&locked); err != nil {
+		return 0, fmt.Errorf("failed to take migration lock: %w", err)
+	}
+	if locked.Int64 != 1 {
+		return 0, fmt.Errorf("failed to take migration lock: timed out")
+	}
+	defer conn.ExecContext(context.Background(), "SELECT RELEASE_LOCK(?)", mysqlMigrationLock)
+
+	if _, err := conn.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INT PRIMARY KEY,
+			name VARCHAR(255) NOT NULL,
+			applied_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6)
+		) ENGINE = InnoDB`); err != nil {
+		return 0, fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+
+	applied := make(map[int]bool)
+	rows, err := conn.QueryContext(ctx, "SELECT version FROM schema_migrations")
+	if err != nil {
+		return 0, fmt.Errorf("failed to read applied migrations: %w", err)
+	}
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to read applied migrations: %w", err)
+		}
+		applied[version] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to read applied migrations: %w", err)
+	}
+
+	count := 0
+	for _, m := range migrations {
+		if applied[m.Version] {
+			continue
+		}
+		if m.Version > baseline {
+			// The driver runs one statement at a time
+			for _, statement := range splitStatements(m.SQL) {
+				if _, err := conn.ExecContext(ctx, statement); err != nil {
+					return count, fmt.Errorf("failed to apply migration %s: %w", m.Name, err)
+				}
+			}
+		}
+		if _, err := conn.ExecContext(ctx, "INSERT INTO schema_migrations (version, name) VALUES (?, ?)", m.Version, m.Name); err != nil {
+			return count, fmt.Errorf("failed to record migration %s: %w", m.Name, err)
+		}
+		if m.Version <= baseline {
+			slog.Info("migration marked as applied", "migration", m.Name)
+			continue
+		}
+		slog.Info("migration applied", "migration", m.Name)
+		count++
+	}
+	return count, nil
+}
+
+// The named lock replicas take while migrating MySQL, and how many seconds
+// one waits for another's migrations
+const (
+	mysqlMigrationLock        = "btc-service:migrate"
+	mysqlMigrationLockTimeout = 600
+)
+
+// splitStatements splits a migration into its statements, which end with
+// a semicolon. Comments stay with the statement they precede, so must not
+// hold semicolons themselves.
+func splitStatements(script string) []string {
+	var statements []string
+	for _, statement := range strings.Split(script, ";") {
+		for _, line := range strings.Split(statement, "\n") {
+			line = strings.TrimSpace(line)
+			if line != "" && !strings.HasPrefix(line, "--") {
+				statements = append(statements, strings.TrimSpace(statement))
+				break
+			}
+		}
+	}
+	return statements
+}
@@ -0,0 +1,11 @@
+-- Enough context on each row to investigate most incidents without
+-- cross-referencing other systems
+ALTER TABLE request_logs
+    ADD COLUMN IF NOT EXISTS trace_id VARCHAR(32),
+    ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(64),
+    ADD COLUMN IF NOT EXISTS user_agent TEXT,
+    ADD COLUMN IF NOT EXISTS response_bytes INT,
+    ADD COLUMN IF NOT EXISTS upstream_latency_ms INT;
+
+CREATE INDEX IF NOT EXISTS idx_trace_id ON request_logs(trace_id);
+CREATE INDEX IF NOT EXISTS idx_tenant_id ON request_logs(tenant_id);
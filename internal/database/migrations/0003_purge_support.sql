@@ -0,0 +1,17 @@
+-- Soft-deleted rows are hidden from queries until they are purged
+ALTER TABLE request_logs
+    ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+
+-- Audit trail of data-deletion requests. Subjects are stored hashed so the
+-- audit log itself does not retain the deleted identifier.
+CREATE TABLE IF NOT EXISTS purge_audit (
+    id SERIAL PRIMARY KEY,
+    requested_at TIMESTAMP DEFAULT NOW(),
+    subject_type VARCHAR(20) NOT NULL,
+    subject_hash VARCHAR(64) NOT NULL,
+    mode VARCHAR(10) NOT NULL,
+    rows_affected INT NOT NULL,
+    reason TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_purge_audit_subject ON purge_audit(subject_hash);
@@ -0,0 +1,15 @@
+-- Price alert subscriptions. last_price is the price seen on the previous
+-- evaluation, used to detect when the threshold is crossed.
+CREATE TABLE IF NOT EXISTS alert_subscriptions (
+    id SERIAL PRIMARY KEY,
+    created_at TIMESTAMP DEFAULT NOW(),
+    pair VARCHAR(20) NOT NULL,
+    threshold DOUBLE PRECISION NOT NULL,
+    direction VARCHAR(5) NOT NULL,
+    callback_url TEXT NOT NULL,
+    secret VARCHAR(64) NOT NULL,
+    last_price DOUBLE PRECISION,
+    last_triggered_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_alert_subscriptions_pair ON alert_subscriptions(pair);
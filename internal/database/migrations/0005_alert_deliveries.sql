@@ -0,0 +1,17 @@
+-- Queue of alert webhook calls. Failed calls are retried with backoff
+-- until they succeed or run out of attempts and are dead-lettered; the
+-- rows double as the delivery history of each subscription.
+CREATE TABLE IF NOT EXISTS alert_deliveries (
+    id SERIAL PRIMARY KEY,
+    subscription_id INT NOT NULL REFERENCES alert_subscriptions(id) ON DELETE CASCADE,
+    created_at TIMESTAMP DEFAULT NOW(),
+    payload TEXT NOT NULL,
+    status VARCHAR(12) NOT NULL DEFAULT 'pending',
+    attempts INT NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP DEFAULT NOW(),
+    last_error TEXT,
+    delivered_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_alert_deliveries_due ON alert_deliveries(status, next_attempt_at);
+CREATE INDEX IF NOT EXISTS idx_alert_deliveries_subscription ON alert_deliveries(subscription_id);
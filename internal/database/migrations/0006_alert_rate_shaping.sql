@@ -0,0 +1,10 @@
+-- Per-subscription webhook rate shaping. Zero means no limit / no batching.
+ALTER TABLE alert_subscriptions
+    ADD COLUMN IF NOT EXISTS max_deliveries_per_minute INT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS batch_window_seconds INT NOT NULL DEFAULT 0;
+
+-- When each delivery was last attempted, to enforce the delivery rate
+ALTER TABLE alert_deliveries
+    ADD COLUMN IF NOT EXISTS last_attempt_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_alert_deliveries_last_attempt ON alert_deliveries(subscription_id, last_attempt_at);
@@ -0,0 +1,4 @@
+-- Which requested pairs were served from cache. cache_hit now means every
+-- returned price was, rather than a guess from the response time.
+ALTER TABLE request_logs
+    ADD COLUMN IF NOT EXISTS cached_pairs TEXT;
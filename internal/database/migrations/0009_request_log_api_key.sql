@@ -0,0 +1,6 @@
+-- The API key a request authenticated with, so request_logs can be joined
+-- to a customer as trace_id joins them to a trace
+ALTER TABLE request_logs
+    ADD COLUMN IF NOT EXISTS api_key_id VARCHAR(64);
+
+CREATE INDEX IF NOT EXISTS idx_request_logs_api_key_id ON request_logs(api_key_id);
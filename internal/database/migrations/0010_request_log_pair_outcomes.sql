@@ -0,0 +1,4 @@
+-- What happened to each requested pair: whether it was priced, from cache
+-- or not, or why it failed. error_message keeps only the last error.
+ALTER TABLE request_logs
+    ADD COLUMN IF NOT EXISTS pair_outcomes JSONB;
@@ -0,0 +1,12 @@
+-- Requests each API key made per UTC day, and how many of them were rate
+-- limited, for billing and usage reports. Replicas add their counts to a
+-- day's row as they flush them.
+CREATE TABLE IF NOT EXISTS api_key_usage (
+    api_key_id VARCHAR(64) NOT NULL,
+    day DATE NOT NULL,
+    requests BIGINT NOT NULL DEFAULT 0,
+    rate_limited BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (api_key_id, day)
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_key_usage_day ON api_key_usage(day);
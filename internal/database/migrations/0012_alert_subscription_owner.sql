@@ -0,0 +1,6 @@
+-- The API key or tenant that created each subscription, which alone may
+-- list, inspect or delete it. Subscriptions made before owners were
+-- recorded belong to unauthenticated callers.
+ALTER TABLE alert_subscriptions ADD COLUMN IF NOT EXISTS owner VARCHAR(80) NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS idx_alert_subscriptions_owner ON alert_subscriptions(owner);
@@ -0,0 +1,3 @@
+-- Callers may supply their own request ID in X-Request-ID, up to 128
+-- characters, which is longer than the UUIDs generated for them
+ALTER TABLE request_logs ALTER COLUMN request_id TYPE VARCHAR(128);
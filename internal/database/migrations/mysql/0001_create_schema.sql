@@ -0,0 +1,89 @@
+-- The schema the PostgreSQL migrations build, in one step. Tables are
+-- InnoDB, for transactions and foreign keys, and timestamps are UTC with
+-- microseconds, as timestamptz are.
+CREATE TABLE IF NOT EXISTS request_logs (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    request_id VARCHAR(36) UNIQUE,
+    timestamp DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6),
+
+    -- Request
+    method VARCHAR(10),
+    endpoint VARCHAR(100),
+    pairs_requested TEXT,
+    user_ip VARCHAR(45),
+
+    -- Response
+    status_code INT,
+    response_time_ms INT,
+
+    -- Performance
+    cache_hit BOOLEAN,
+    kraken_calls INT,
+
+    -- Errors
+    error_occurred BOOLEAN,
+    error_message TEXT,
+
+    -- Context
+    trace_id VARCHAR(32),
+    tenant_id VARCHAR(64),
+    user_agent TEXT,
+    response_bytes INT,
+    upstream_latency_ms INT,
+    cached_pairs TEXT,
+    api_key_id VARCHAR(64),
+    pair_outcomes JSON,
+
+    deleted_at DATETIME(6),
+
+    INDEX idx_timestamp (timestamp),
+    INDEX idx_status (status_code),
+    INDEX idx_trace_id (trace_id),
+    INDEX idx_tenant_id (tenant_id),
+    INDEX idx_request_logs_api_key_id (api_key_id)
+) ENGINE = InnoDB;
+
+CREATE TABLE IF NOT EXISTS purge_audit (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    requested_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6),
+    subject_type VARCHAR(20) NOT NULL,
+    subject_hash VARCHAR(64) NOT NULL,
+    mode VARCHAR(10) NOT NULL,
+    rows_affected INT NOT NULL,
+    reason TEXT,
+
+    INDEX idx_purge_audit_subject (subject_hash)
+) ENGINE = InnoDB;
+
+CREATE TABLE IF NOT EXISTS alert_subscriptions (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    created_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6),
+    pair VARCHAR(20) NOT NULL,
+    threshold DOUBLE NOT NULL,
+    direction VARCHAR(5) NOT NULL,
+    callback_url TEXT NOT NULL,
+    secret VARCHAR(64) NOT NULL,
+    max_deliveries_per_minute INT NOT NULL DEFAULT 0,
+    batch_window_seconds INT NOT NULL DEFAULT 0,
+    last_price DOUBLE,
+    last_triggered_at DATETIME(6),
+
+    INDEX idx_alert_subscriptions_pair (pair)
+) ENGINE = InnoDB;
+
+CREATE TABLE IF NOT EXISTS alert_deliveries (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    subscription_id BIGINT NOT NULL,
+    created_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6),
+    payload TEXT NOT NULL,
+    status VARCHAR(12) NOT NULL DEFAULT 'pending',
+    attempts INT NOT NULL DEFAULT 0,
+    next_attempt_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6),
+    last_error TEXT,
+    delivered_at DATETIME(6),
+    last_attempt_at DATETIME(6),
+
+    INDEX idx_alert_deliveries_due (status, next_attempt_at),
+    INDEX idx_alert_deliveries_last_attempt (subscription_id, last_attempt_at),
+    FOREIGN KEY (subscription_id) REFERENCES alert_subscriptions(id) ON DELETE CASCADE
+) ENGINE = InnoDB;
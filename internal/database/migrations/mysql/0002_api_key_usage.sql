@@ -0,0 +1,11 @@
+-- Requests each API key made per UTC day, and how many of them were rate
+-- limited, for billing and usage reports
+CREATE TABLE IF NOT EXISTS api_key_usage (
+    api_key_id VARCHAR(64) NOT NULL,
+    day DATE NOT NULL,
+    requests BIGINT NOT NULL DEFAULT 0,
+    rate_limited BIGINT NOT NULL DEFAULT 0,
+
+    PRIMARY KEY (api_key_id, day),
+    INDEX idx_api_key_usage_day (day)
+) ENGINE = InnoDB;
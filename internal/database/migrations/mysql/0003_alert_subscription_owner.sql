@@ -0,0 +1,5 @@
+-- The API key or tenant that created each subscription, which alone may
+-- list, inspect or delete it
+ALTER TABLE alert_subscriptions
+    ADD COLUMN owner VARCHAR(80) NOT NULL DEFAULT '',
+    ADD INDEX idx_alert_subscriptions_owner (owner);
@@ -0,0 +1,4 @@
+-- When a delivery worker's claim on a due delivery lapses. Until then the
+-- delivery is being attempted and no other replica picks it up; a claim
+-- left by a crashed replica lapses and the delivery is retried.
+ALTER TABLE alert_deliveries ADD COLUMN claimed_until DATETIME(6);
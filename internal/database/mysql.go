@@ -0,0 +1,691 @@
+package database
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+
+	"github.com/chesskiss/btc-service/internal/clock"
+	"github.com/chesskiss/btc-service/internal/metrics"
+	"github.com/chesskiss/btc-service/internal/pagination"
+)
+
+// MySQLStore is the Store kept in MySQL or MariaDB, for deployments whose
+// operational database is MySQL. It needs MySQL 8.0 or MariaDB 10.6, for
+// SKIP LOCKED. Without a connection every call fails.
+type MySQLStore struct {
+	db *sql.DB
+}
+
+var _ Store = (*MySQLStore)(nil)
+
+// NewMySQLStore returns a store using db, which may be nil
+func NewMySQLStore(db *sql.DB) *MySQLStore {
+	return &MySQLStore{db: db}
+}
+
+// InitMySQL opens and checks a connection pool to MySQL, for
+// NewMySQLStore. tls takes the same libpq modes as InitDB.
+func InitMySQL(ctx context.Context, host, port, user, password, dbname string, tls TLSOptions) (*sql.DB, error) {
+	db, err := OpenMySQL(host, port, user, password, dbname, tls)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.PingContext(ctx); err != nil {
+		log.Printf("Warning: Failed to connect to MySQL: %v", err)
+		log.Println("Continuing without request logging...")
+		db.Close()
+		return nil, err
+	}
+
+	log.Println("MySQL connected successfully")
+	return db, nil
+}
+
+// OpenMySQL opens a connection pool to MySQL without checking that the
+// server is reachable, as OpenDB does for PostgreSQL
+func OpenMySQL(host, port, user, password, dbname string, tls TLSOptions) (*sql.DB, error) {
+	tlsConfig, err := mysqlTLSConfig(tls, host)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := mysql.NewConfig()
+	cfg.Net = "tcp"
+	cfg.Addr = net.JoinHostPort(host, port)
+	cfg.User = user
+	cfg.Passwd = password
+	cfg.DBName = dbname
+	cfg.TLS = tlsConfig
+	cfg.AllowFallbackToPlaintext = tls.SSLMode == "allow" || tls.SSLMode == "prefer"
+	// Timestamps are stored and read in UTC, as timestamptz are
+	cfg.ParseTime = true
+	cfg.Loc = time.UTC
+	cfg.Params = map[string]string{"time_zone": "'+00:00'"}
+	// Count the rows an UPDATE matched, as PostgreSQL does, not only those
+	// it changed
+	cfg.ClientFoundRows = true
+
+	connector, err := mysql.NewConnector(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	return sql.OpenDB(connector), nil
+}
+
+// mysqlTLSConfig translates the libpq sslmode in opts to the TLS config
+// the MySQL driver connects with, nil for none. As with libpq, require
+// verifies the server's CA only when a root certificate is given.
+func mysqlTLSConfig(opts TLSOptions, host string) (*tls.Config, error) {
+	mode := opts.SSLMode
+	if mode == "require" && opts.RootCert != "" {
+		mode = "verify-ca"
+	}
+
+	config := &tls.Config{ServerName: host}
+	switch mode {
+	case "", "disable":
+		return nil, nil
+	case "allow", "prefer", "require":
+		config.InsecureSkipVerify = true
+	case "verify-ca", "verify-full":
+	default:
+		return nil, fmt.Errorf("unsupported sslmode %q", opts.SSLMode)
+	}
+
+	if opts.RootCert != "" {
+		pem, err := os.ReadFile(opts.RootCert)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read root certificate: %w", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", opts.RootCert)
+		}
+	}
+	if opts.Cert != "" {
+		cert, err := tls.LoadX509KeyPair(opts.Cert, opts.Key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	if mode == "verify-ca" {
+		// Check the chain but not the host name, which Go only skips along
+		// with the rest of the verification
+		roots := config.RootCAs
+		config.InsecureSkipVerify = true
+		config.VerifyConnection = func(state tls.ConnectionState) error {
+			if len(state.PeerCertificates) == 0 {
+				return errors.New("server sent no certificate")
+			}
+			intermediates := x509.NewCertPool()
+			for _, cert := range state.PeerCertificates[1:] {
+				intermediates.AddCert(cert)
+			}
+			_, err := state.PeerCertificates[0].Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates})
+			return err
+		}
+	}
+	return config, nil
+}
+
+// MySQLPoolStats reports db's connections for the DB pool metrics
+func MySQLPoolStats(db *sql.DB) metrics.DBPoolStats {
+	stats := db.Stats()
+	return metrics.DBPoolStats{
+		Open:         stats.OpenConnections,
+		InUse:        stats.InUse,
+		Idle:         stats.Idle,
+		Max:          stats.MaxOpenConnections,
+		WaitCount:    stats.WaitCount,
+		WaitDuration: stats.WaitDuration,
+	}
+}
+
+// Ping checks the connection, for health checks
+func (s *MySQLStore) Ping(ctx context.Context) error {
+	if s.db == nil {
+		return fmt.Errorf("database not initialized")
+	}
+	return s.db.PingContext(ctx)
+}
+
+// mysqlPlaceholder matches the numbered placeholders pageClauses writes
+var mysqlPlaceholder = regexp.MustCompile(`\$\d+`)
+
+// mysqlQuery rewrites a query written with PostgreSQL's numbered
+// placeholders to MySQL's, which are bound in order. Each argument must
+// be used once, in the order it is given.
+func mysqlQuery(query string) string {
+	return mysqlPlaceholder.ReplaceAllString(query, "?")
+}
+
+// mysqlPage returns page with a timestamp cursor rewritten to the form
+// MySQL compares DATETIME columns with
+func mysqlPage(page pagination.Page, timeFields ...string) pagination.Page {
+	if page.After == nil || !slices.Contains(timeFields, page.Sort.Field) {
+		return page
+	}
+	t, err := time.Parse(time.RFC3339Nano, page.After.Value)
+	if err != nil {
+		return page
+	}
+	after := *page.After
+	after.Value = t.UTC().Format("2006-01-02 15:04:05.999999")
+	page.After = &after
+	return page
+}
+
+// mysqlInsertBatchRows bounds the rows of one INSERT, keeping it within
+// MySQL's 65535 placeholders
+const mysqlInsertBatchRows = 1000
+
+// LogRequest inserts a request log entry into the database. An entry whose
+// request ID is already stored, as when a write is retried, is skipped.
+func (s *MySQLStore) LogRequest(ctx context.Context, reqLog RequestLog) error {
+	if s.db == nil {
+		return fmt.Errorf("database not initialized")
+	}
+
+	if err := s.LogRequests(ctx, []RequestLog{reqLog}); err != nil {
+		log.Printf("Failed to log request to database: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// LogRequests inserts request log entries with multi-row INSERTs, skipping
+// those whose request ID is already stored. If one is rejected none are
+// stored.
+func (s *MySQLStore) LogRequests(ctx context.Context, reqLogs []RequestLog) error {
+	if s.db == nil {
+		return fmt.Errorf("database not initialized")
+	}
+	if len(reqLogs) == 0 {
+		return nil
+	}
+
+	if len(reqLogs) <= mysqlInsertBatchRows {
+		return insertMySQLRequestLogs(ctx, s.db, reqLogs)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin inserting request logs: %w", err)
+	}
+	defer tx.Rollback()
+
+	for start := 0; start < len(reqLogs); start += mysqlInsertBatchRows {
+		end := min(start+mysqlInsertBatchRows, len(reqLogs))
+		if err := insertMySQLRequestLogs(ctx, tx, reqLogs[start:end]); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit request logs: %w", err)
+	}
+	return nil
+}
+
+// mysqlExecer is a *sql.DB or a *sql.Tx
+type mysqlExecer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+func insertMySQLRequestLogs(ctx context.Context, db mysqlExecer, reqLogs []RequestLog) error {
+	row := "(" + strings.TrimSuffix(strings.Repeat("?, ", len(requestLogColumns)), ", ") + ")"
+	rows := make([]string, len(reqLogs))
+	args := make([]any, 0, len(reqLogs)*len(requestLogColumns))
+	for i, reqLog := range reqLogs {
+		values, err := mysqlRequestLogValues(reqLog)
+		if err != nil {
+			return err
+		}
+		rows[i] = row
+		args = append(args, values...)
+	}
+
+	// The no-op update skips duplicates without hiding other errors, as
+	// INSERT IGNORE would
+	query := "INSERT INTO request_logs (" + strings.Join(requestLogColumns, ", ") + ") VALUES " +
+		strings.Join(rows, ", ") + " ON DUPLICATE KEY UPDATE request_id = request_id"
+	if _, err := db.ExecContext(ctx, query, args...); err != nil {
+		return fmt.Errorf("failed to insert request logs: %w", err)
+	}
+	return nil
+}
+
+// mysqlRequestLogValues is requestLogValues with pair_outcomes encoded, as
+// database/sql has no JSON type
+func mysqlRequestLogValues(reqLog RequestLog) ([]any, error) {
+	values := requestLogValues(reqLog)
+	var outcomes any
+	if reqLog.PairOutcomes != nil {
+		data, err := json.Marshal(reqLog.PairOutcomes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode pair outcomes: %w", err)
+		}
+		outcomes = string(data)
+	}
+	values[slices.Index(requestLogColumns, "pair_outcomes")] = outcomes
+	return values, nil
+}
+
+// scanMySQLRequestLogs reads rows of requestLogSelectColumns
+func scanMySQLRequestLogs(rows *sql.Rows) ([]RequestLog, error) {
+	logs := []RequestLog{}
+	for rows.Next() {
+		var reqLog RequestLog
+		var outcomes []byte
+		if err := rows.Scan(
+			&reqLog.ID,
+			&reqLog.RequestID,
+			&reqLog.Timestamp,
+			&reqLog.Method,
+			&reqLog.Endpoint,
+			&reqLog.PairsRequested,
+			&reqLog.UserIP,
+			&reqLog.StatusCode,
+			&reqLog.ResponseTimeMs,
+			&reqLog.CacheHit,
+			&reqLog.KrakenCalls,
+			&reqLog.ErrorOccurred,
+			&reqLog.ErrorMessage,
+			&reqLog.TraceID,
+			&reqLog.TenantID,
+			&reqLog.UserAgent,
+			&reqLog.ResponseBytes,
+			&reqLog.UpstreamLatencyMs,
+			&reqLog.CachedPairs,
+			&reqLog.APIKeyID,
+			&outcomes,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan request log: %w", err)
+		}
+		if err := json.Unmarshal(outcomes, &reqLog.PairOutcomes); err != nil {
+			return nil, fmt.Errorf("failed to decode pair outcomes: %w", err)
+		}
+		reqLog.Timestamp = reqLog.Timestamp.UTC()
+		logs = append(logs, reqLog)
+	}
+	return logs, rows.Err()
+}
+
+// QueryRequests returns request log entries matching the filter, newest first
+func (s *MySQLStore) QueryRequests(ctx context.Context, filter RequestLogFilter) ([]RequestLog, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+
+	// Soft-deleted rows are hidden until they are purged for good
+	conditions := []string{"deleted_at IS NULL"}
+	var args []interface{}
+	addCondition := func(clause string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(clause, len(args)))
+	}
+
+	if !filter.From.IsZero() {
+		addCondition("timestamp >= $%d", filter.From)
+	}
+	if !filter.To.IsZero() {
+		addCondition("timestamp < $%d", filter.To)
+	}
+	if filter.StatusCode != 0 {
+		addCondition("status_code = $%d", filter.StatusCode)
+	}
+	if filter.ErrorOccurred != nil {
+		addCondition("error_occurred = $%d", *filter.ErrorOccurred)
+	}
+	if filter.Pair != "" {
+		addCondition("JSON_CONTAINS(pair_outcomes, JSON_OBJECT('pair', $%d))", filter.Pair)
+	}
+	if filter.APIKeyID != "" {
+		addCondition("api_key_id = $%d", filter.APIKeyID)
+	}
+
+	query := "SELECT " + requestLogSelectColumns + " FROM request_logs"
+	sort := filter.Sort
+	if sort.Field == "" {
+		sort = DefaultRequestLogSort
+	}
+	page := mysqlPage(pagination.Page{Limit: filter.Limit, Sort: sort, After: filter.After}, "timestamp")
+	conditions, order, args := pageClauses(page, requestLogSortColumns, conditions, args)
+
+	query += " WHERE " + strings.Join(conditions, " AND ")
+
+	args = append(args, filter.Offset)
+	query += order + fmt.Sprintf(" OFFSET $%d", len(args))
+
+	rows, err := s.db.QueryContext(ctx, mysqlQuery(query), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query request logs: %w", err)
+	}
+	defer rows.Close()
+
+	return scanMySQLRequestLogs(rows)
+}
+
+// FrequentPairs returns up to limit of the pairs most often requested
+// since the given time, most frequent first, as they were requested. MySQL
+// cannot split pairs_requested portably, so requests are counted by their
+// pair list and the lists are split here.
+func (s *MySQLStore) FrequentPairs(ctx context.Context, since time.Time, limit int) ([]string, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT pairs_requested, COUNT(*)
+		FROM request_logs
+		WHERE deleted_at IS NULL AND timestamp >= ? AND pairs_requested IS NOT NULL
+		GROUP BY pairs_requested
+	`, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query frequent pairs: %w", err)
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int64)
+	for rows.Next() {
+		var pairs string
+		var count int64
+		if err := rows.Scan(&pairs, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan frequent pair: %w", err)
+		}
+		for _, pair := range strings.Split(pairs, ",") {
+			if pair = strings.TrimSpace(pair); pair != "" {
+				counts[pair] += count
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query frequent pairs: %w", err)
+	}
+
+	pairs := make([]string, 0, len(counts))
+	for pair := range counts {
+		pairs = append(pairs, pair)
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if counts[pairs[i]] != counts[pairs[j]] {
+			return counts[pairs[i]] > counts[pairs[j]]
+		}
+		return pairs[i] < pairs[j]
+	})
+	if len(pairs) > limit {
+		pairs = pairs[:limit]
+	}
+	if len(pairs) == 0 {
+		return nil, nil
+	}
+	return pairs, nil
+}
+
+// PurgeRequests soft-deletes or permanently removes every request log
+// belonging to the subject and records the purge in purge_audit, all in
+// one transaction. A hard purge of an API key removes its api_key_usage
+// counts too.
+func (s *MySQLStore) PurgeRequests(ctx context.Context, req PurgeRequest) (PurgeResult, error) {
+	if s.db == nil {
+		return PurgeResult{}, fmt.Errorf("database not initialized")
+	}
+
+	var match string
+	subjectHash := HashSubject(req.Subject)
+	switch req.SubjectType {
+	case SubjectTenant:
+		match = "tenant_id = ?"
+	case SubjectIP:
+		// Expanded to the IP's stored forms below
+		match = "user_ip IN (%s)"
+	case SubjectIPHash:
+		match = "SHA2(user_ip, 256) = LOWER(?)"
+		subjectHash = req.Subject
+	case SubjectAPIKey:
+		match = "api_key_id = ?"
+	default:
+		return PurgeResult{}, fmt.Errorf("unsupported subject type %q", req.SubjectType)
+	}
+
+	var statement string
+	switch req.Mode {
+	case PurgeModeSoft:
+		statement = "UPDATE request_logs SET deleted_at = NOW(6) WHERE deleted_at IS NULL AND " + match
+	case PurgeModeHard:
+		statement = "DELETE FROM request_logs WHERE " + match
+	default:
+		return PurgeResult{}, fmt.Errorf("unsupported purge mode %q", req.Mode)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return PurgeResult{}, fmt.Errorf("failed to begin purge: %w", err)
+	}
+	defer tx.Rollback()
+
+	args := []any{req.Subject}
+	if req.SubjectType == SubjectIP {
+		// A hashed IP takes another form every rotation period since the
+		// oldest log
+		var oldest sql.NullTime
+		if err := tx.QueryRowContext(ctx, "SELECT MIN(timestamp) FROM request_logs").Scan(&oldest); err != nil {
+			return PurgeResult{}, fmt.Errorf("failed to find the oldest request log: %w", err)
+		}
+		ips := req.storedIPs(oldest.Time, clock.Now())
+		args = make([]any, len(ips))
+		for i, ip := range ips {
+			args[i] = ip
+		}
+		statement = fmt.Sprintf(statement, strings.TrimSuffix(strings.Repeat("?, ", len(ips)), ", "))
+	}
+
+	res, err := tx.ExecContext(ctx, statement, args...)
+	if err != nil {
+		return PurgeResult{}, fmt.Errorf("failed to purge request logs: %w", err)
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return PurgeResult{}, fmt.Errorf("failed to purge request logs: %w", err)
+	}
+
+	result := PurgeResult{Mode: req.Mode, RowsAffected: rows}
+	if req.SubjectType == SubjectAPIKey && req.Mode == PurgeModeHard {
+		res, err := tx.ExecContext(ctx, "DELETE FROM api_key_usage WHERE api_key_id = ?", req.Subject)
+		if err != nil {
+			return PurgeResult{}, fmt.Errorf("failed to purge API key usage: %w", err)
+		}
+		if result.UsageRowsAffected, err = res.RowsAffected(); err != nil {
+			return PurgeResult{}, fmt.Errorf("failed to purge API key usage: %w", err)
+		}
+	}
+
+	res, err = tx.ExecContext(ctx, `
+		INSERT INTO purge_audit (subject_type, subject_hash, mode, rows_affected, reason)
+		VALUES (?, ?, ?, ?, ?)
+	`, req.SubjectType, subjectHash, req.Mode, rows, req.Reason)
+	if err != nil {
+		return PurgeResult{}, fmt.Errorf("failed to record purge audit: %w", err)
+	}
+	if result.AuditID, err = res.LastInsertId(); err != nil {
+		return PurgeResult{}, fmt.Errorf("failed to record purge audit: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return PurgeResult{}, fmt.Errorf("failed to commit purge: %w", err)
+	}
+
+	return result, nil
+}
+
+// DeleteRequestLogsBefore permanently removes request logs, soft-deleted
+// or not, written before cutoff and returns how many it removed, batchSize
+// rows per statement or all at once when zero
+func (s *MySQLStore) DeleteRequestLogsBefore(ctx context.Context, cutoff time.Time, batchSize int) (int64, error) {
+	if s.db == nil {
+		return 0, fmt.Errorf("database not initialized")
+	}
+	return s.deleteRequestLogsBefore(ctx, cutoff, batchSize, false)
+}
+
+// deleteRequestLogsBefore is DeleteRequestLogsBefore, limited to
+// soft-deleted request logs if purgedOnly is set
+func (s *MySQLStore) deleteRequestLogsBefore(ctx context.Context, cutoff time.Time, batchSize int, purgedOnly bool) (int64, error) {
+	condition := "timestamp < ?"
+	if purgedOnly {
+		condition += " AND deleted_at IS NOT NULL"
+	}
+
+	if batchSize <= 0 {
+		res, err := s.db.ExecContext(ctx, "DELETE FROM request_logs WHERE "+condition, cutoff)
+		if err != nil {
+			return 0, fmt.Errorf("failed to delete request logs: %w", err)
+		}
+		deleted, err := res.RowsAffected()
+		if err != nil {
+			return 0, fmt.Errorf("failed to delete request logs: %w", err)
+		}
+		metrics.RequestLogsExpiredTotal.Add(float64(deleted))
+		return deleted, nil
+	}
+
+	var total int64
+	for {
+		res, err := s.db.ExecContext(ctx, `
+			DELETE FROM request_logs
+			WHERE `+condition+`
+			ORDER BY timestamp
+			LIMIT ?
+		`, cutoff, batchSize)
+		if err != nil {
+			return total, fmt.Errorf("failed to delete request logs: %w", err)
+		}
+		deleted, err := res.RowsAffected()
+		if err != nil {
+			return total, fmt.Errorf("failed to delete request logs: %w", err)
+		}
+		metrics.RequestLogsExpiredTotal.Add(float64(deleted))
+		total += deleted
+		if deleted < int64(batchSize) {
+			return total, nil
+		}
+	}
+}
+
+// ArchiveRequestLogsBefore hands request logs written before cutoff to
+// archive, oldest first and batchSize at a time (defaultArchiveBatchSize
+// when zero), deleting each batch once it is archived, and returns how many
+// it deleted. A batch is deleted in the transaction that reads it, so one
+// archive fails to take is kept for the next run. Soft-deleted logs were
+// purged on request, so they are deleted without being archived.
+func (s *MySQLStore) ArchiveRequestLogsBefore(ctx context.Context, cutoff time.Time, batchSize int, archive ArchiveFunc) (int64, error) {
+	if s.db == nil {
+		return 0, fmt.Errorf("database not initialized")
+	}
+	if batchSize <= 0 {
+		batchSize = defaultArchiveBatchSize
+	}
+
+	total, err := s.deleteRequestLogsBefore(ctx, cutoff, batchSize, true)
+	if err != nil {
+		return total, err
+	}
+	for {
+		archived, err := s.archiveRequestLogBatch(ctx, cutoff, batchSize, archive)
+		total += archived
+		if err != nil || archived < int64(batchSize) {
+			return total, err
+		}
+	}
+}
+
+func (s *MySQLStore) archiveRequestLogBatch(ctx context.Context, cutoff time.Time, batchSize int, archive ArchiveFunc) (int64, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin archiving request logs: %w", err)
+	}
+	defer tx.Rollback()
+
+	// MySQL has no DELETE ... RETURNING, so the batch is locked as it is
+	// read and deleted by ID
+	rows, err := tx.QueryContext(ctx, `
+		SELECT `+requestLogSelectColumns+`
+		FROM request_logs
+		WHERE timestamp < ? AND deleted_at IS NULL
+		ORDER BY timestamp, id
+		LIMIT ?
+		FOR UPDATE SKIP LOCKED
+	`, cutoff, batchSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read expired request logs: %w", err)
+	}
+	reqLogs, err := scanMySQLRequestLogs(rows)
+	rows.Close()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read expired request logs: %w", err)
+	}
+	if len(reqLogs) == 0 {
+		return 0, nil
+	}
+
+	ids := make([]any, len(reqLogs))
+	for i, reqLog := range reqLogs {
+		ids[i] = reqLog.ID
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")
+	if _, err := tx.ExecContext(ctx, "DELETE FROM request_logs WHERE id IN ("+placeholders+")", ids...); err != nil {
+		return 0, fmt.Errorf("failed to delete request logs: %w", err)
+	}
+
+	if err := archive(ctx, reqLogs); err != nil {
+		return 0, fmt.Errorf("failed to archive request logs: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit archived request logs: %w", err)
+	}
+	metrics.RequestLogsExpiredTotal.Add(float64(len(reqLogs)))
+	return int64(len(reqLogs)), nil
+}
+
+// RecordAPIKeyUsage adds usage to the stored counts in one statement
+func (s *MySQLStore) RecordAPIKeyUsage(ctx context.Context, usage []APIKeyUsage) error {
+	if s.db == nil {
+		return fmt.Errorf("database not initialized")
+	}
+	if len(usage) == 0 {
+		return nil
+	}
+
+	rows := make([]string, len(usage))
+	args := make([]any, 0, len(usage)*4)
+	for i, u := range usage {
+		rows[i] = "(?, ?, ?, ?)"
+		args = append(args, u.APIKeyID, usageDay(u.Day).Format(time.DateOnly), u.Requests, u.RateLimited)
+	}
+
+	// VALUES() rather than a row alias, which MariaDB lacks
+	query := "INSERT INTO api_key_usage (api_key_id, day, requests, rate_limited) VALUES " + strings.Join(rows, ", ") +
+		" ON DUPLICATE KEY UPDATE requests = requests + VALUES(requests), rate_limited = rate_limited + VALUES(rate_limited)"
+	if _, err := s.db.ExecContext(ctx, query, args...); err != nil {
+		return fmt.Errorf("failed to record API key usage: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,410 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/chesskiss/btc-service/internal/pagination"
+)
+
+// CreateAlertSubscription stores a new subscription and returns it with its
+// ID and creation time
+func (s *MySQLStore) CreateAlertSubscription(ctx context.Context, sub AlertSubscription) (AlertSubscription, error) {
+	if s.db == nil {
+		return sub, fmt.Errorf("database not initialized")
+	}
+
+	res, err := s.db.ExecContext(ctx, `
+		INSERT INTO alert_subscriptions (
+			pair, threshold, direction, callback_url, secret,
+			max_deliveries_per_minute, batch_window_seconds, owner
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, sub.Pair, sub.Threshold, sub.Direction, sub.CallbackURL, sub.Secret,
+		sub.MaxDeliveriesPerMinute, sub.BatchWindowSeconds, sub.Owner)
+	if err != nil {
+		return sub, fmt.Errorf("failed to create alert subscription: %w", err)
+	}
+	if sub.ID, err = res.LastInsertId(); err != nil {
+		return sub, fmt.Errorf("failed to create alert subscription: %w", err)
+	}
+	err = s.db.QueryRowContext(ctx, "SELECT created_at FROM alert_subscriptions WHERE id = ?", sub.ID).Scan(&sub.CreatedAt)
+	if err != nil {
+		return sub, fmt.Errorf("failed to create alert subscription: %w", err)
+	}
+	sub.CreatedAt = sub.CreatedAt.UTC()
+
+	return sub, nil
+}
+
+// ListAlertSubscriptions returns every subscription, oldest first
+func (s *MySQLStore) ListAlertSubscriptions(ctx context.Context) ([]AlertSubscription, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+
+	rows, err := s.db.QueryContext(ctx, alertSubscriptionSelect+" ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query alert subscriptions: %w", err)
+	}
+	defer rows.Close()
+
+	return scanMySQLAlertSubscriptions(rows)
+}
+
+// QueryAlertSubscriptions returns one page of owner's subscriptions
+func (s *MySQLStore) QueryAlertSubscriptions(ctx context.Context, owner string, page pagination.Page) ([]AlertSubscription, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+
+	conditions, order, args := pageClauses(mysqlPage(page, "created_at"), alertSubscriptionSortColumns, []string{"owner = $1"}, []interface{}{owner})
+	query := alertSubscriptionSelect + " WHERE " + strings.Join(conditions, " AND ")
+
+	rows, err := s.db.QueryContext(ctx, mysqlQuery(query+order), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query alert subscriptions: %w", err)
+	}
+	defer rows.Close()
+
+	return scanMySQLAlertSubscriptions(rows)
+}
+
+func scanMySQLAlertSubscriptions(rows *sql.Rows) ([]AlertSubscription, error) {
+	subs := []AlertSubscription{}
+	for rows.Next() {
+		var sub AlertSubscription
+		var lastPrice sql.NullFloat64
+		var lastTriggeredAt sql.NullTime
+		if err := rows.Scan(
+			&sub.ID,
+			&sub.CreatedAt,
+			&sub.Pair,
+			&sub.Threshold,
+			&sub.Direction,
+			&sub.CallbackURL,
+			&sub.MaxDeliveriesPerMinute,
+			&sub.BatchWindowSeconds,
+			&sub.Secret,
+			&lastPrice,
+			&lastTriggeredAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan alert subscription: %w", err)
+		}
+		if lastPrice.Valid {
+			sub.LastPrice = &lastPrice.Float64
+		}
+		sub.CreatedAt = sub.CreatedAt.UTC()
+		sub.LastTriggeredAt = nullTimeUTC(lastTriggeredAt)
+		subs = append(subs, sub)
+	}
+
+	return subs, rows.Err()
+}
+
+// DeleteAlertSubscription removes one of owner's subscriptions, reporting
+// whether it existed
+func (s *MySQLStore) DeleteAlertSubscription(ctx context.Context, owner string, id int64) (bool, error) {
+	if s.db == nil {
+		return false, fmt.Errorf("database not initialized")
+	}
+
+	res, err := s.db.ExecContext(ctx, "DELETE FROM alert_subscriptions WHERE id = ? AND owner = ?", id, owner)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete alert subscription: %w", err)
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to delete alert subscription: %w", err)
+	}
+
+	return rows > 0, nil
+}
+
+// RecordAlertEvaluation stores the price a subscription was evaluated
+// against and, if it fired, when
+func (s *MySQLStore) RecordAlertEvaluation(ctx context.Context, id int64, price float64, triggered bool) error {
+	if s.db == nil {
+		return fmt.Errorf("database not initialized")
+	}
+
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE alert_subscriptions
+		SET last_price = ?,
+		    last_triggered_at = CASE WHEN ? THEN NOW(6) ELSE last_triggered_at END
+		WHERE id = ?
+	`, price, triggered, id)
+	if err != nil {
+		return fmt.Errorf("failed to record alert evaluation: %w", err)
+	}
+
+	return nil
+}
+
+// EnqueueAlertDelivery queues a webhook payload for delivery after delay
+func (s *MySQLStore) EnqueueAlertDelivery(ctx context.Context, subscriptionID int64, payload []byte, delay time.Duration) (int64, error) {
+	if s.db == nil {
+		return 0, fmt.Errorf("database not initialized")
+	}
+
+	res, err := s.db.ExecContext(ctx, `
+		INSERT INTO alert_deliveries (subscription_id, payload, next_attempt_at)
+		VALUES (?, ?, NOW(6) + INTERVAL ? MICROSECOND)
+	`, subscriptionID, string(payload), delay.Microseconds())
+	if err != nil {
+		return 0, fmt.Errorf("failed to enqueue alert delivery: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("failed to enqueue alert delivery: %w", err)
+	}
+
+	return id, nil
+}
+
+// ClaimAlertDeliveries claims up to limit pending deliveries whose next
+// attempt is due and that no one else has claimed, oldest first. Other
+// replicas skip them until they are marked or deferred, or the lease
+// lapses, so each is attempted by one worker at a time.
+func (s *MySQLStore) ClaimAlertDeliveries(ctx context.Context, limit int, lease time.Duration) ([]AlertDelivery, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim alert deliveries: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id FROM alert_deliveries
+		WHERE status = ? AND next_attempt_at <= NOW(6)
+		  AND (claimed_until IS NULL OR claimed_until <= NOW(6))
+		ORDER BY next_attempt_at, id
+		LIMIT ?
+		FOR UPDATE SKIP LOCKED
+	`, DeliveryPending, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim alert deliveries: %w", err)
+	}
+	var ids []interface{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to claim alert deliveries: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to claim alert deliveries: %w", err)
+	}
+	if len(ids) == 0 {
+		return []AlertDelivery{}, nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")
+	_, err = tx.ExecContext(ctx, `
+		UPDATE alert_deliveries
+		SET claimed_until = NOW(6) + INTERVAL ? MICROSECOND
+		WHERE id IN (`+placeholders+`)
+	`, append([]interface{}{lease.Microseconds()}, ids...)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim alert deliveries: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to claim alert deliveries: %w", err)
+	}
+
+	return s.queryAlertDeliveries(ctx, alertDeliverySelect+`
+		WHERE d.id IN (`+placeholders+`)
+		ORDER BY d.next_attempt_at, d.id
+	`, ids...)
+}
+
+// ListAlertDeliveries returns the delivery history of one of owner's
+// subscriptions, newest first
+func (s *MySQLStore) ListAlertDeliveries(ctx context.Context, owner string, subscriptionID int64, limit int) ([]AlertDelivery, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+
+	return s.queryAlertDeliveries(ctx, alertDeliverySelect+`
+		WHERE d.subscription_id = ? AND s.owner = ?
+		ORDER BY d.id DESC
+		LIMIT ?
+	`, subscriptionID, owner, limit)
+}
+
+func (s *MySQLStore) queryAlertDeliveries(ctx context.Context, query string, args ...interface{}) ([]AlertDelivery, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query alert deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	deliveries := []AlertDelivery{}
+	for rows.Next() {
+		var delivery AlertDelivery
+		var payload string
+		var nextAttemptAt, deliveredAt sql.NullTime
+		if err := rows.Scan(
+			&delivery.ID,
+			&delivery.SubscriptionID,
+			&delivery.CreatedAt,
+			&payload,
+			&delivery.Status,
+			&delivery.Attempts,
+			&nextAttemptAt,
+			&delivery.LastError,
+			&deliveredAt,
+			&delivery.CallbackURL,
+			&delivery.Secret,
+			&delivery.MaxDeliveriesPerMinute,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan alert delivery: %w", err)
+		}
+		delivery.Payload = json.RawMessage(payload)
+		delivery.CreatedAt = delivery.CreatedAt.UTC()
+		delivery.NextAttemptAt = nullTimeUTC(nextAttemptAt)
+		delivery.DeliveredAt = nullTimeUTC(deliveredAt)
+		deliveries = append(deliveries, delivery)
+	}
+
+	return deliveries, rows.Err()
+}
+
+// BatchingAlertDelivery returns the subscription's delivery that is still
+// held back by its batch window, if any
+func (s *MySQLStore) BatchingAlertDelivery(ctx context.Context, subscriptionID int64) (AlertDelivery, bool, error) {
+	if s.db == nil {
+		return AlertDelivery{}, false, fmt.Errorf("database not initialized")
+	}
+
+	deliveries, err := s.queryAlertDeliveries(ctx, alertDeliverySelect+`
+		WHERE d.subscription_id = ? AND d.status = ? AND d.attempts = 0
+		  AND d.next_attempt_at > NOW(6)
+		ORDER BY d.id DESC
+		LIMIT 1
+	`, subscriptionID, DeliveryPending)
+	if err != nil || len(deliveries) == 0 {
+		return AlertDelivery{}, false, err
+	}
+
+	return deliveries[0], true, nil
+}
+
+// ReplaceAlertDeliveryPayload swaps the payload of a delivery still held
+// back by its batch window, reporting false if it has since become due
+func (s *MySQLStore) ReplaceAlertDeliveryPayload(ctx context.Context, id int64, payload []byte) (bool, error) {
+	if s.db == nil {
+		return false, fmt.Errorf("database not initialized")
+	}
+
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE alert_deliveries
+		SET payload = ?
+		WHERE id = ? AND status = ? AND attempts = 0 AND next_attempt_at > NOW(6)
+	`, string(payload), id, DeliveryPending)
+	if err != nil {
+		return false, fmt.Errorf("failed to replace alert delivery payload: %w", err)
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to replace alert delivery payload: %w", err)
+	}
+
+	return rows > 0, nil
+}
+
+// CountAlertDeliveryAttempts returns how many of the subscription's
+// deliveries were attempted within the last window
+func (s *MySQLStore) CountAlertDeliveryAttempts(ctx context.Context, subscriptionID int64, window time.Duration) (int, error) {
+	if s.db == nil {
+		return 0, fmt.Errorf("database not initialized")
+	}
+
+	var count int
+	err := s.db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM alert_deliveries
+		WHERE subscription_id = ? AND last_attempt_at > NOW(6) - INTERVAL ? MICROSECOND
+	`, subscriptionID, window.Microseconds()).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count alert delivery attempts: %w", err)
+	}
+
+	return count, nil
+}
+
+// DeferAlertDelivery postpones a pending delivery without counting an
+// attempt
+func (s *MySQLStore) DeferAlertDelivery(ctx context.Context, id int64, after time.Duration) error {
+	if s.db == nil {
+		return fmt.Errorf("database not initialized")
+	}
+
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE alert_deliveries
+		SET next_attempt_at = NOW(6) + INTERVAL ? MICROSECOND, claimed_until = NULL
+		WHERE id = ?
+	`, after.Microseconds(), id)
+	if err != nil {
+		return fmt.Errorf("failed to defer alert delivery: %w", err)
+	}
+
+	return nil
+}
+
+// MarkAlertDelivered records a successful delivery attempt
+func (s *MySQLStore) MarkAlertDelivered(ctx context.Context, id int64) error {
+	if s.db == nil {
+		return fmt.Errorf("database not initialized")
+	}
+
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE alert_deliveries
+		SET status = ?, attempts = attempts + 1, delivered_at = NOW(6),
+		    last_attempt_at = NOW(6), next_attempt_at = NULL, last_error = NULL,
+		    claimed_until = NULL
+		WHERE id = ?
+	`, DeliveryDelivered, id)
+	if err != nil {
+		return fmt.Errorf("failed to mark alert delivered: %w", err)
+	}
+
+	return nil
+}
+
+// MarkAlertDeliveryFailed records a failed attempt, scheduling a retry
+// after retryAfter or, if retryAfter is zero, dead-lettering the delivery
+func (s *MySQLStore) MarkAlertDeliveryFailed(ctx context.Context, id int64, lastError string, retryAfter time.Duration) error {
+	if s.db == nil {
+		return fmt.Errorf("database not initialized")
+	}
+
+	// The retry time is computed by the database so it shares a clock with
+	// the NOW(6) that ClaimAlertDeliveries compares against
+	retry := retryAfter > 0
+	status := DeliveryPending
+	if !retry {
+		status = DeliveryDeadLetter
+	}
+
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE alert_deliveries
+		SET status = ?, attempts = attempts + 1, last_error = ?,
+		    last_attempt_at = NOW(6), claimed_until = NULL,
+		    next_attempt_at = CASE WHEN ? THEN NOW(6) + INTERVAL ? MICROSECOND END
+		WHERE id = ?
+	`, status, lastError, retry, retryAfter.Microseconds(), id)
+	if err != nil {
+		return fmt.Errorf("failed to record alert delivery failure: %w", err)
+	}
+
+	return nil
+}
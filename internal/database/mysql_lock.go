@@ -0,0 +1,41 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+)
+
+// RunExclusive runs fn while holding the GET_LOCK lock called name,
+// reporting false without running it when another replica holds the lock.
+// The lock is released when fn returns, or by the server if the process
+// dies holding it.
+func (s *MySQLStore) RunExclusive(ctx context.Context, name string, fn func(context.Context) error) (bool, error) {
+	if s.db == nil {
+		return false, fmt.Errorf("database not initialized")
+	}
+
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to take lock %s: %w", name, err)
+	}
+	defer conn.Close()
+
+	var locked sql.NullInt64
+	if err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, 0)", lockPrefix+name).Scan(&locked); err != nil {
+		return false, fmt.Errorf("failed to take lock %s: %w", name, err)
+	}
+	if locked.Int64 != 1 {
+		return false, nil
+	}
+	defer func() {
+		// A named lock outlives the borrowed connection unless released,
+		// so a connection that can't release it is discarded instead
+		if _, err := conn.ExecContext(context.Background(), "DO RELEASE_LOCK(?)", lockPrefix+name); err != nil {
+			conn.Raw(func(any) error { return driver.ErrBadConn })
+		}
+	}()
+
+	return true, fn(ctx)
+}
@@ -0,0 +1,31 @@
+package database
+
+import (
+	"fmt"
+
+	"github.com/chesskiss/btc-service/internal/pagination"
+)
+
+// pageClauses appends the keyset condition for page to conditions and
+// returns the ORDER BY ... LIMIT clause. columns maps sortable API fields
+// to SQL columns; ties are broken by id.
+func pageClauses(page pagination.Page, columns map[string]string, conditions []string, args []interface{}) ([]string, string, []interface{}) {
+	column, ok := columns[page.Sort.Field]
+	if !ok {
+		column = "id"
+	}
+
+	direction, comparison := "ASC", ">"
+	if page.Sort.Desc {
+		direction, comparison = "DESC", "<"
+	}
+
+	if page.After != nil {
+		args = append(args, page.After.Value, page.After.ID)
+		conditions = append(conditions, fmt.Sprintf("(%s, id) %s ($%d, $%d)", column, comparison, len(args)-1, len(args)))
+	}
+
+	args = append(args, page.Limit)
+	order := fmt.Sprintf(" ORDER BY %s %s, id %s LIMIT $%d", column, direction, direction, len(args))
+	return conditions, order, args
+}
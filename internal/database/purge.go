@@ -0,0 +1,191 @@
+package database
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/chesskiss/btc-service/internal/clock"
+)
+
+// Purge modes: soft-deleted rows are hidden from queries, hard-purged rows
+// are removed from the table
+const (
+	PurgeModeSoft = "soft"
+	PurgeModeHard = "hard"
+)
+
+// Purge subject types
+const (
+	SubjectTenant = "tenant_id"
+	SubjectIP     = "ip"
+	SubjectIPHash = "ip_hash"
+	SubjectAPIKey = "api_key_id"
+)
+
+// PurgeRequest identifies whose data to delete. An IP subject is the
+// client's address, matched in whatever form Anonymizer stored it.
+type PurgeRequest struct {
+	SubjectType string
+	Subject     string
+	Mode        string
+	Reason      string
+	// Anonymizer is the one request logs are written with, nil if IPs are
+	// stored as they are
+	Anonymizer *IPAnonymizer
+}
+
+// PurgeResult describes a completed purge
+type PurgeResult struct {
+	AuditID      int64  `json:"audit_id"`
+	Mode         string `json:"mode"`
+	RowsAffected int64  `json:"rows_affected"`
+	// UsageRowsAffected counts the api_key_usage rows a hard purge of an
+	// API key removed
+	UsageRowsAffected int64 `json:"usage_rows_affected,omitempty"`
+	// NotPurged names the places besides the primary store that may still
+	// hold the subject's request logs, because they can't be purged or
+	// their purge failed
+	NotPurged []string `json:"not_purged,omitempty"`
+}
+
+// RequestLogPurger is a place besides the primary store that keeps request
+// logs, such as the spool or ClickHouse, which a purge removes the
+// subject's entries from too. It removes them in either mode, as none of
+// them can hide an entry.
+type RequestLogPurger interface {
+	PurgeRequestLogs(ctx context.Context, req PurgeRequest) error
+}
+
+// PurgeTarget names a RequestLogPurger in purge results
+type PurgeTarget struct {
+	Name   string
+	Purger RequestLogPurger
+}
+
+// Matches reports whether reqLog belongs to the subject of req
+func (req PurgeRequest) Matches(reqLog RequestLog) bool {
+	switch req.SubjectType {
+	case SubjectTenant:
+		return reqLog.TenantID == req.Subject
+	case SubjectIP:
+		return reqLog.UserIP != "" && slices.Contains(req.storedIPs(reqLog.Timestamp, reqLog.Timestamp), reqLog.UserIP)
+	case SubjectIPHash:
+		return HashSubject(reqLog.UserIP) == strings.ToLower(req.Subject)
+	case SubjectAPIKey:
+		return reqLog.APIKeyID == req.Subject
+	}
+	return false
+}
+
+// storedIPs is StoredForms of the request's anonymizer, along with the
+// subject itself for logs written before IPs were anonymized
+func (req PurgeRequest) storedIPs(since, until time.Time) []string {
+	forms := []string{req.Subject}
+	if a := req.Anonymizer; a != nil {
+		for _, form := range a.StoredForms(req.Subject, since, until) {
+			if form != req.Subject {
+				forms = append(forms, form)
+			}
+		}
+	}
+	return forms
+}
+
+// HashSubject returns the hex SHA-256 of an identifier, the form stored in
+// the purge audit and accepted as an IP hash
+func HashSubject(subject string) string {
+	sum := sha256.Sum256([]byte(subject))
+	return hex.EncodeToString(sum[:])
+}
+
+// PurgeRequests soft-deletes or permanently removes every request log
+// belonging to the subject and records the purge in purge_audit, all in
+// one transaction. A hard purge of an API key removes its api_key_usage
+// counts too.
+func (s *PostgresStore) PurgeRequests(ctx context.Context, req PurgeRequest) (PurgeResult, error) {
+	if s.pool == nil {
+		return PurgeResult{}, fmt.Errorf("database not initialized")
+	}
+
+	var match string
+	subjectHash := HashSubject(req.Subject)
+	switch req.SubjectType {
+	case SubjectTenant:
+		match = "tenant_id = $1"
+	case SubjectIP:
+		match = "user_ip = ANY($1)"
+	case SubjectIPHash:
+		match = "encode(sha256(user_ip::bytea), 'hex') = lower($1)"
+		subjectHash = req.Subject
+	case SubjectAPIKey:
+		match = "api_key_id = $1"
+	default:
+		return PurgeResult{}, fmt.Errorf("unsupported subject type %q", req.SubjectType)
+	}
+
+	var statement string
+	switch req.Mode {
+	case PurgeModeSoft:
+		statement = "UPDATE request_logs SET deleted_at = NOW() WHERE deleted_at IS NULL AND " + match
+	case PurgeModeHard:
+		statement = "DELETE FROM request_logs WHERE " + match
+	default:
+		return PurgeResult{}, fmt.Errorf("unsupported purge mode %q", req.Mode)
+	}
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return PurgeResult{}, fmt.Errorf("failed to begin purge: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	subject := any(req.Subject)
+	if req.SubjectType == SubjectIP {
+		// A hashed IP takes another form every rotation period since the
+		// oldest log
+		var oldest *time.Time
+		if err := tx.QueryRow(ctx, "SELECT MIN(timestamp) FROM request_logs").Scan(&oldest); err != nil {
+			return PurgeResult{}, fmt.Errorf("failed to find the oldest request log: %w", err)
+		}
+		var since time.Time
+		if oldest != nil {
+			since = *oldest
+		}
+		subject = req.storedIPs(since, clock.Now())
+	}
+
+	tag, err := tx.Exec(ctx, statement, subject)
+	if err != nil {
+		return PurgeResult{}, fmt.Errorf("failed to purge request logs: %w", err)
+	}
+	rows := tag.RowsAffected()
+
+	result := PurgeResult{Mode: req.Mode, RowsAffected: rows}
+	if req.SubjectType == SubjectAPIKey && req.Mode == PurgeModeHard {
+		tag, err := tx.Exec(ctx, "DELETE FROM api_key_usage WHERE api_key_id = $1", req.Subject)
+		if err != nil {
+			return PurgeResult{}, fmt.Errorf("failed to purge API key usage: %w", err)
+		}
+		result.UsageRowsAffected = tag.RowsAffected()
+	}
+
+	err = tx.QueryRow(ctx, `
+		INSERT INTO purge_audit (subject_type, subject_hash, mode, rows_affected, reason)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id
+	`, req.SubjectType, subjectHash, req.Mode, rows, req.Reason).Scan(&result.AuditID)
+	if err != nil {
+		return PurgeResult{}, fmt.Errorf("failed to record purge audit: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return PurgeResult{}, fmt.Errorf("failed to commit purge: %w", err)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,194 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/chesskiss/btc-service/internal/clock"
+	"github.com/chesskiss/btc-service/internal/metrics"
+	"github.com/chesskiss/btc-service/internal/subsystems"
+)
+
+// defaultArchiveBatchSize is how many request logs go to each archive
+// object when no batch size is configured
+const defaultArchiveBatchSize = 10000
+
+// ArchiveFunc keeps a copy of expiring request logs, given oldest first,
+// before they are deleted
+type ArchiveFunc func(ctx context.Context, reqLogs []RequestLog) error
+
+// StartRequestLogJanitor deletes request logs older than retention from
+// store every interval until ctx is cancelled, batchSize rows per
+// statement. With archive set, each batch is archived before it is
+// deleted.
+func StartRequestLogJanitor(ctx context.Context, store Store, retention, interval time.Duration, batchSize int, archive ArchiveFunc) {
+	go func() {
+		ticker := clock.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C():
+				if subsystems.Paused(ctx, subsystems.RequestLogJanitor) {
+					continue
+				}
+				cutoff := clock.Now().Add(-retention)
+				var deleted int64
+				var err error
+				if archive != nil {
+					deleted, err = store.ArchiveRequestLogsBefore(ctx, cutoff, batchSize, archive)
+				} else {
+					deleted, err = store.DeleteRequestLogsBefore(ctx, cutoff, batchSize)
+				}
+				if err != nil {
+					slog.Warn("failed to delete expired request logs",
+						"deleted", deleted,
+						"error", err,
+					)
+					continue
+				}
+				if deleted > 0 {
+					slog.Info("expired request logs deleted",
+						"deleted", deleted,
+					)
+				}
+			}
+		}
+	}()
+
+	slog.Info("request log janitor started",
+		"retention", retention.String(),
+		"interval", interval.String(),
+	)
+}
+
+// DeleteRequestLogsBefore permanently removes request logs, soft-deleted
+// or not, written before cutoff and returns how many it removed. Rows are
+// deleted batchSize at a time, each batch in its own statement, so a large
+// backlog does not hold locks on the table for long; a batchSize of zero
+// deletes them all at once.
+func (s *PostgresStore) DeleteRequestLogsBefore(ctx context.Context, cutoff time.Time, batchSize int) (int64, error) {
+	if s.pool == nil {
+		return 0, fmt.Errorf("database not initialized")
+	}
+	return s.deleteRequestLogsBefore(ctx, cutoff, batchSize, false)
+}
+
+// deleteRequestLogsBefore is DeleteRequestLogsBefore, limited to
+// soft-deleted request logs if purgedOnly is set
+func (s *PostgresStore) deleteRequestLogsBefore(ctx context.Context, cutoff time.Time, batchSize int, purgedOnly bool) (int64, error) {
+	condition := "timestamp < $1"
+	if purgedOnly {
+		condition += " AND deleted_at IS NOT NULL"
+	}
+
+	if batchSize <= 0 {
+		tag, err := s.pool.Exec(ctx, "DELETE FROM request_logs WHERE "+condition, cutoff)
+		if err != nil {
+			return 0, fmt.Errorf("failed to delete request logs: %w", err)
+		}
+		deleted := tag.RowsAffected()
+		metrics.RequestLogsExpiredTotal.Add(float64(deleted))
+		return deleted, nil
+	}
+
+	var total int64
+	for {
+		tag, err := s.pool.Exec(ctx, `
+			DELETE FROM request_logs
+			WHERE id IN (
+				SELECT id FROM request_logs
+				WHERE `+condition+`
+				ORDER BY timestamp
+				LIMIT $2
+			)
+		`, cutoff, batchSize)
+		if err != nil {
+			return total, fmt.Errorf("failed to delete request logs: %w", err)
+		}
+		deleted := tag.RowsAffected()
+		metrics.RequestLogsExpiredTotal.Add(float64(deleted))
+		total += deleted
+		if deleted < int64(batchSize) {
+			return total, nil
+		}
+	}
+}
+
+// ArchiveRequestLogsBefore hands request logs written before cutoff to
+// archive, oldest first and batchSize at a time (defaultArchiveBatchSize
+// when zero), deleting each batch once it is archived, and returns how many
+// it deleted. A batch is deleted in the transaction that reads it, so one
+// archive fails to take is kept for the next run. Soft-deleted logs were
+// purged on request, so they are deleted without being archived.
+func (s *PostgresStore) ArchiveRequestLogsBefore(ctx context.Context, cutoff time.Time, batchSize int, archive ArchiveFunc) (int64, error) {
+	if s.pool == nil {
+		return 0, fmt.Errorf("database not initialized")
+	}
+	if batchSize <= 0 {
+		batchSize = defaultArchiveBatchSize
+	}
+
+	total, err := s.deleteRequestLogsBefore(ctx, cutoff, batchSize, true)
+	if err != nil {
+		return total, err
+	}
+	for {
+		archived, err := s.archiveRequestLogBatch(ctx, cutoff, batchSize, archive)
+		total += archived
+		if err != nil || archived < int64(batchSize) {
+			return total, err
+		}
+	}
+}
+
+func (s *PostgresStore) archiveRequestLogBatch(ctx context.Context, cutoff time.Time, batchSize int, archive ArchiveFunc) (int64, error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin archiving request logs: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `
+		DELETE FROM request_logs
+		WHERE id IN (
+			SELECT id FROM request_logs
+			WHERE timestamp < $1 AND deleted_at IS NULL
+			ORDER BY timestamp
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+requestLogSelectColumns, cutoff, batchSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete request logs: %w", err)
+	}
+	reqLogs, err := scanRequestLogs(rows)
+	rows.Close()
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete request logs: %w", err)
+	}
+	if len(reqLogs) == 0 {
+		return 0, nil
+	}
+
+	// RETURNING doesn't keep the subquery's order
+	sort.Slice(reqLogs, func(i, j int) bool {
+		if !reqLogs[i].Timestamp.Equal(reqLogs[j].Timestamp) {
+			return reqLogs[i].Timestamp.Before(reqLogs[j].Timestamp)
+		}
+		return reqLogs[i].ID < reqLogs[j].ID
+	})
+	if err := archive(ctx, reqLogs); err != nil {
+		return 0, fmt.Errorf("failed to archive request logs: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("failed to commit archived request logs: %w", err)
+	}
+	metrics.RequestLogsExpiredTotal.Add(float64(len(reqLogs)))
+	return int64(len(reqLogs)), nil
+}
@@ -0,0 +1,38 @@
+package database
+
+import (
+	"math/rand/v2"
+	"net/http"
+
+	"github.com/chesskiss/btc-service/internal/metrics"
+)
+
+// RequestLogSampler decides which request logs are kept, keeping a
+// fraction of successful requests and a separate fraction of failed ones,
+// so volume can be cut at high traffic without losing sight of errors
+type RequestLogSampler struct {
+	successRate float64
+	errorRate   float64
+}
+
+// NewRequestLogSampler returns a sampler keeping successRate of successful
+// requests and errorRate of failed ones, each between 0 and 1
+func NewRequestLogSampler(successRate, errorRate float64) *RequestLogSampler {
+	return &RequestLogSampler{successRate: successRate, errorRate: errorRate}
+}
+
+// Keep reports whether reqLog is sampled, counting the decision. A request
+// failed if any pair failed or it was answered with an error status.
+func (s *RequestLogSampler) Keep(reqLog RequestLog) bool {
+	outcome, rate := "success", s.successRate
+	if reqLog.ErrorOccurred || reqLog.StatusCode >= http.StatusBadRequest {
+		outcome, rate = "error", s.errorRate
+	}
+	keep := rate >= 1 || rand.Float64() < rate
+	decision := "skipped"
+	if keep {
+		decision = "kept"
+	}
+	metrics.RequestLogSamplingTotal.WithLabelValues(outcome, decision).Inc()
+	return keep
+}
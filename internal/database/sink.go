@@ -0,0 +1,35 @@
+package database
+
+import (
+	"context"
+	"errors"
+)
+
+// Request log sinks, chosen by REQUEST_LOG_SINK
+const (
+	SinkPostgres   = "postgres"
+	SinkMySQL      = "mysql"
+	SinkClickHouse = "clickhouse"
+	SinkKafka      = "kafka"
+)
+
+// RequestLogSink is where a RequestLogWriter writes its batches
+type RequestLogSink interface {
+	LogRequests(ctx context.Context, reqLogs []RequestLog) error
+}
+
+// MultiSink writes each batch to every one of its sinks
+type MultiSink []RequestLogSink
+
+// LogRequests writes reqLogs to every sink, even when one fails, and
+// returns their errors joined. A batch retried after one sink failed is
+// written to the others again, so each receives it at least once.
+func (m MultiSink) LogRequests(ctx context.Context, reqLogs []RequestLog) error {
+	var errs []error
+	for _, sink := range m {
+		if err := sink.LogRequests(ctx, reqLogs); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,192 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// ErrSpoolFull is returned by Spool.Append when the entries would take
+// the spool past its size limit
+var ErrSpoolFull = errors.New("request log spool is full")
+
+// Spool is an append-only file of request log entries that could not be
+// written, one JSON object per line, kept until they can be replayed. The
+// writer goroutine appends and replays; a purge may rewrite it meanwhile.
+type Spool struct {
+	path     string
+	maxBytes int64
+
+	mu sync.Mutex
+}
+
+// NewSpool returns a spool kept at path, holding up to maxBytes. Entries
+// already at path, as left by a previous run, are replayed with the rest.
+func NewSpool(path string, maxBytes int64) (*Spool, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open request log spool: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return nil, fmt.Errorf("failed to open request log spool: %w", err)
+	}
+	return &Spool{path: path, maxBytes: maxBytes}, nil
+}
+
+// Size returns how many bytes of entries the spool holds
+func (s *Spool) Size() (int64, error) {
+	info, err := os.Stat(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// Append adds reqLogs to the end of the spool, and syncs it so they
+// survive the process
+func (s *Spool) Append(reqLogs []RequestLog) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.append(reqLogs)
+}
+
+func (s *Spool) append(reqLogs []RequestLog) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, reqLog := range reqLogs {
+		if err := enc.Encode(reqLog); err != nil {
+			return fmt.Errorf("failed to encode request log: %w", err)
+		}
+	}
+
+	size, err := s.Size()
+	if err != nil {
+		return fmt.Errorf("failed to read request log spool: %w", err)
+	}
+	if size+int64(buf.Len()) > s.maxBytes {
+		return ErrSpoolFull
+	}
+
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open request log spool: %w", err)
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to append to request log spool: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to sync request log spool: %w", err)
+	}
+	return f.Close()
+}
+
+// Replay hands the spooled entries to write, batchSize at a time and
+// oldest first, and removes those it accepts. It stops at the first batch
+// write fails and returns how many entries were replayed before it; the
+// rest stay spooled for the next attempt.
+func (s *Spool) Replay(ctx context.Context, batchSize int, write func(context.Context, []RequestLog) error) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	reqLogs, err := s.read()
+	if err != nil {
+		return 0, err
+	}
+
+	replayed := 0
+	for replayed < len(reqLogs) {
+		end := min(replayed+batchSize, len(reqLogs))
+		if err := write(ctx, reqLogs[replayed:end]); err != nil {
+			if rewriteErr := s.rewrite(reqLogs[replayed:]); rewriteErr != nil {
+				return replayed, rewriteErr
+			}
+			return replayed, err
+		}
+		replayed = end
+	}
+	if err := os.Truncate(s.path, 0); err != nil {
+		return replayed, fmt.Errorf("failed to empty request log spool: %w", err)
+	}
+	return replayed, nil
+}
+
+// PurgeRequestLogs removes the spooled entries belonging to the subject of
+// req, so they aren't written to the sinks once purged from them
+func (s *Spool) PurgeRequestLogs(ctx context.Context, req PurgeRequest) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	reqLogs, err := s.read()
+	if err != nil {
+		return err
+	}
+	kept := make([]RequestLog, 0, len(reqLogs))
+	for _, reqLog := range reqLogs {
+		if !req.Matches(reqLog) {
+			kept = append(kept, reqLog)
+		}
+	}
+	if len(kept) == len(reqLogs) {
+		return nil
+	}
+	if len(kept) == 0 {
+		if err := os.Truncate(s.path, 0); err != nil {
+			return fmt.Errorf("failed to empty request log spool: %w", err)
+		}
+		return nil
+	}
+	return s.rewrite(kept)
+}
+
+// read decodes the spooled entries, oldest first
+func (s *Spool) read() ([]RequestLog, error) {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read request log spool: %w", err)
+	}
+
+	var reqLogs []RequestLog
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(data)+1)
+	for scanner.Scan() {
+		var reqLog RequestLog
+		// A line cut short by a crash mid-append cannot be recovered
+		if err := json.Unmarshal(scanner.Bytes(), &reqLog); err != nil {
+			continue
+		}
+		reqLogs = append(reqLogs, reqLog)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read request log spool: %w", err)
+	}
+	return reqLogs, nil
+}
+
+// rewrite replaces the spool's contents with reqLogs
+func (s *Spool) rewrite(reqLogs []RequestLog) error {
+	tmp := s.path + ".tmp"
+	if err := os.Remove(tmp); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to rewrite request log spool: %w", err)
+	}
+	next := &Spool{path: tmp, maxBytes: s.maxBytes}
+	if err := next.append(reqLogs); err != nil {
+		return fmt.Errorf("failed to rewrite request log spool: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("failed to rewrite request log spool: %w", err)
+	}
+	return nil
+}
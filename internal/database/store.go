@@ -0,0 +1,62 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/chesskiss/btc-service/internal/pagination"
+)
+
+// Databases a Store is kept in, chosen by DB_DRIVER
+const (
+	DriverPostgres = "postgres"
+	DriverMySQL    = "mysql"
+)
+
+// Store persists request logs, price alerts and API key usage. Handlers and background
+// workers are given one when they are constructed, so tests can substitute
+// their own.
+type Store interface {
+	LogRequest(ctx context.Context, reqLog RequestLog) error
+	LogRequests(ctx context.Context, reqLogs []RequestLog) error
+	QueryRequests(ctx context.Context, filter RequestLogFilter) ([]RequestLog, error)
+	FrequentPairs(ctx context.Context, since time.Time, limit int) ([]string, error)
+	PurgeRequests(ctx context.Context, req PurgeRequest) (PurgeResult, error)
+	DeleteRequestLogsBefore(ctx context.Context, cutoff time.Time, batchSize int) (int64, error)
+	ArchiveRequestLogsBefore(ctx context.Context, cutoff time.Time, batchSize int, archive ArchiveFunc) (int64, error)
+
+	CreateAlertSubscription(ctx context.Context, sub AlertSubscription) (AlertSubscription, error)
+	ListAlertSubscriptions(ctx context.Context) ([]AlertSubscription, error)
+	QueryAlertSubscriptions(ctx context.Context, owner string, page pagination.Page) ([]AlertSubscription, error)
+	DeleteAlertSubscription(ctx context.Context, owner string, id int64) (bool, error)
+	RecordAlertEvaluation(ctx context.Context, id int64, price float64, triggered bool) error
+
+	EnqueueAlertDelivery(ctx context.Context, subscriptionID int64, payload []byte, delay time.Duration) (int64, error)
+	ClaimAlertDeliveries(ctx context.Context, limit int, lease time.Duration) ([]AlertDelivery, error)
+	ListAlertDeliveries(ctx context.Context, owner string, subscriptionID int64, limit int) ([]AlertDelivery, error)
+	BatchingAlertDelivery(ctx context.Context, subscriptionID int64) (AlertDelivery, bool, error)
+	ReplaceAlertDeliveryPayload(ctx context.Context, id int64, payload []byte) (bool, error)
+	CountAlertDeliveryAttempts(ctx context.Context, subscriptionID int64, window time.Duration) (int, error)
+	DeferAlertDelivery(ctx context.Context, id int64, after time.Duration) error
+	MarkAlertDelivered(ctx context.Context, id int64) error
+	MarkAlertDeliveryFailed(ctx context.Context, id int64, lastError string, retryAfter time.Duration) error
+
+	RecordAPIKeyUsage(ctx context.Context, usage []APIKeyUsage) error
+
+	RunExclusive(ctx context.Context, name string, fn func(context.Context) error) (bool, error)
+}
+
+// PostgresStore is the Store kept in PostgreSQL. Without a connection,
+// as when the database was unreachable on startup, every call fails.
+type PostgresStore struct {
+	pool *pgxpool.Pool
+}
+
+var _ Store = (*PostgresStore)(nil)
+
+// NewPostgresStore returns a store using pool, which may be nil
+func NewPostgresStore(pool *pgxpool.Pool) *PostgresStore {
+	return &PostgresStore{pool: pool}
+}
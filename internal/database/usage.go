@@ -0,0 +1,58 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// APIKeyUsage counts the requests one API key made on one UTC day, and
+// how many of them were rate limited
+type APIKeyUsage struct {
+	APIKeyID    string    `json:"api_key_id"`
+	Day         time.Time `json:"day"`
+	Requests    int64     `json:"requests"`
+	RateLimited int64     `json:"rate_limited"`
+}
+
+// upsertAPIKeyUsageSQL adds counts to each key's row for the day, creating
+// it on the key's first request that day
+const upsertAPIKeyUsageSQL = `
+	INSERT INTO api_key_usage (api_key_id, day, requests, rate_limited)
+	SELECT * FROM unnest($1::text[], $2::date[], $3::bigint[], $4::bigint[])
+	ON CONFLICT (api_key_id, day) DO UPDATE SET
+		requests = api_key_usage.requests + EXCLUDED.requests,
+		rate_limited = api_key_usage.rate_limited + EXCLUDED.rate_limited`
+
+// RecordAPIKeyUsage adds usage to the stored counts in one statement.
+// Each key and day may appear only once.
+func (s *PostgresStore) RecordAPIKeyUsage(ctx context.Context, usage []APIKeyUsage) error {
+	if s.pool == nil {
+		return fmt.Errorf("database not initialized")
+	}
+	if len(usage) == 0 {
+		return nil
+	}
+
+	ids := make([]string, len(usage))
+	days := make([]time.Time, len(usage))
+	requests := make([]int64, len(usage))
+	rateLimited := make([]int64, len(usage))
+	for i, u := range usage {
+		ids[i] = u.APIKeyID
+		days[i] = usageDay(u.Day)
+		requests[i] = u.Requests
+		rateLimited[i] = u.RateLimited
+	}
+
+	if _, err := s.pool.Exec(ctx, upsertAPIKeyUsageSQL, ids, days, requests, rateLimited); err != nil {
+		return fmt.Errorf("failed to record API key usage: %w", err)
+	}
+	return nil
+}
+
+// usageDay is the UTC day t falls on, as midnight
+func usageDay(t time.Time) time.Time {
+	year, month, day := t.UTC().Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
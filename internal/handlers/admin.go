@@ -0,0 +1,236 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/chesskiss/btc-service/internal/database"
+	"github.com/chesskiss/btc-service/internal/pagination"
+	"github.com/chesskiss/btc-service/internal/problem"
+	"github.com/chesskiss/btc-service/internal/projection"
+	"github.com/chesskiss/btc-service/services"
+)
+
+// RequestLogsResponse is the body returned by RequestLogsHandler
+type RequestLogsResponse struct {
+	Requests []database.RequestLog `json:"requests"`
+	Count    int                   `json:"count"`
+	Limit    int                   `json:"limit"`
+	Offset   int                   `json:"offset"`
+	// NextCursor fetches the following page; absent on the last one
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// RequestLogsHandler lists the requests logged in store with optional
+// filters and pagination
+func RequestLogsHandler(store database.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter, err := parseRequestLogFilter(r)
+		if err != nil {
+			problem.Write(w, r, problem.New(http.StatusBadRequest, problem.CodeInvalidParameter, err.Error()))
+			return
+		}
+		fields, err := projection.Parse(r, database.RequestLog{})
+		if err != nil {
+			problem.Write(w, r, problem.New(http.StatusBadRequest, problem.CodeInvalidParameter, err.Error()))
+			return
+		}
+
+		logs, err := store.QueryRequests(r.Context(), filter)
+		if err != nil {
+			problem.Write(w, r, problem.New(http.StatusServiceUnavailable, problem.CodeStorageUnavailable, "request logs unavailable"))
+			return
+		}
+
+		page := pagination.Page{Limit: filter.Limit, Sort: filter.Sort, After: filter.After}
+		next := page.NextCursor(len(logs), func() (string, int64) {
+			last := logs[len(logs)-1]
+			return database.RequestLogSortValue(last, filter.Sort), last.ID
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(fields.Apply(RequestLogsResponse{
+			Requests:   logs,
+			Count:      len(logs),
+			Limit:      filter.Limit,
+			Offset:     filter.Offset,
+			NextCursor: next,
+		}, "requests"))
+	}
+}
+
+func parseRequestLogFilter(r *http.Request) (database.RequestLogFilter, error) {
+	query := r.URL.Query()
+	page, err := pagination.Parse(r, database.RequestLogSorts, database.DefaultRequestLogSort)
+	if err != nil {
+		return database.RequestLogFilter{}, err
+	}
+	filter := database.RequestLogFilter{
+		APIKeyID: query.Get("api_key_id"),
+		Limit:    page.Limit,
+		Sort:     page.Sort,
+		After:    page.After,
+	}
+
+	if v := query.Get("pair"); v != "" {
+		pair, ok := services.NormalizePair(v)
+		if !ok {
+			return filter, fmt.Errorf("invalid pair %q", v)
+		}
+		filter.Pair = pair
+	}
+
+	if v := query.Get("from"); v != "" {
+		from, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return filter, fmt.Errorf("invalid from: must be RFC3339")
+		}
+		filter.From = from
+	}
+
+	if v := query.Get("to"); v != "" {
+		to, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return filter, fmt.Errorf("invalid to: must be RFC3339")
+		}
+		filter.To = to
+	}
+
+	if v := query.Get("status"); v != "" {
+		status, err := strconv.Atoi(v)
+		if err != nil || status < 100 || status > 599 {
+			return filter, fmt.Errorf("invalid status: must be an HTTP status code")
+		}
+		filter.StatusCode = status
+	}
+
+	if v := query.Get("error"); v != "" {
+		errorOccurred, err := strconv.ParseBool(v)
+		if err != nil {
+			return filter, fmt.Errorf("invalid error: must be true or false")
+		}
+		filter.ErrorOccurred = &errorOccurred
+	}
+
+	if v := query.Get("offset"); v != "" {
+		offset, err := strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			return filter, fmt.Errorf("invalid offset: must be non-negative")
+		}
+		if filter.After != nil {
+			return filter, fmt.Errorf("invalid offset: cannot be combined with cursor")
+		}
+		filter.Offset = offset
+	}
+
+	return filter, nil
+}
+
+// PurgeRequestBody selects whose stored data to delete; exactly one of
+// TenantID, IP, IPHash or APIKeyID must be set
+type PurgeRequestBody struct {
+	TenantID string `json:"tenant_id,omitempty"`
+	IP       string `json:"ip,omitempty"`
+	IPHash   string `json:"ip_hash,omitempty"`
+	APIKeyID string `json:"api_key_id,omitempty"`
+	Mode     string `json:"mode,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// PurgeHandler deletes the stored request logs of a tenant, client IP or
+// API key for data-deletion requests, recording an audit entry for each
+// purge. The subject's entries are removed from targets before the store,
+// so none are replayed into it afterwards. The result lists the targets
+// whose purge failed along with unpurgeable, the places request logs are
+// sent that can't be purged. IPs are matched in the forms anonymizer, which
+// may be nil, stored them.
+func PurgeHandler(store database.Store, anonymizer *database.IPAnonymizer, targets []database.PurgeTarget, unpurgeable []string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body PurgeRequestBody
+		if !decodeJSONBody(w, r, &body) {
+			return
+		}
+
+		req, err := body.toPurgeRequest()
+		if err != nil {
+			problem.Write(w, r, problem.New(http.StatusBadRequest, problem.CodeInvalidRequestBody, err.Error()))
+			return
+		}
+		req.Anonymizer = anonymizer
+
+		var notPurged []string
+		for _, target := range targets {
+			if err := target.Purger.PurgeRequestLogs(r.Context(), req); err != nil {
+				slog.Error("purge failed",
+					"target", target.Name,
+					"subject_type", req.SubjectType,
+					"error", err,
+				)
+				notPurged = append(notPurged, target.Name)
+			}
+		}
+
+		result, err := store.PurgeRequests(r.Context(), req)
+		if err != nil {
+			slog.Error("purge failed",
+				"subject_type", req.SubjectType,
+				"error", err,
+			)
+			problem.Write(w, r, problem.New(http.StatusServiceUnavailable, problem.CodeStorageUnavailable, "purge failed"))
+			return
+		}
+		result.NotPurged = append(notPurged, unpurgeable...)
+
+		slog.Info("stored data purged",
+			"subject_type", req.SubjectType,
+			"mode", result.Mode,
+			"rows_affected", result.RowsAffected,
+			"usage_rows_affected", result.UsageRowsAffected,
+			"not_purged", result.NotPurged,
+			"audit_id", result.AuditID,
+		)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}
+}
+
+func (b PurgeRequestBody) toPurgeRequest() (database.PurgeRequest, error) {
+	req := database.PurgeRequest{
+		Mode:   b.Mode,
+		Reason: b.Reason,
+	}
+	if req.Mode == "" {
+		req.Mode = database.PurgeModeHard
+	}
+	if req.Mode != database.PurgeModeHard && req.Mode != database.PurgeModeSoft {
+		return req, fmt.Errorf("invalid mode: must be soft or hard")
+	}
+
+	subjects := 0
+	if b.TenantID != "" {
+		req.SubjectType, req.Subject = database.SubjectTenant, b.TenantID
+		subjects++
+	}
+	if b.IP != "" {
+		req.SubjectType, req.Subject = database.SubjectIP, b.IP
+		subjects++
+	}
+	if b.IPHash != "" {
+		req.SubjectType, req.Subject = database.SubjectIPHash, b.IPHash
+		subjects++
+	}
+	if b.APIKeyID != "" {
+		req.SubjectType, req.Subject = database.SubjectAPIKey, b.APIKeyID
+		subjects++
+	}
+	if subjects != 1 {
+		return req, fmt.Errorf("exactly one of tenant_id, ip, ip_hash or api_key_id is required")
+	}
+
+	return req, nil
+}
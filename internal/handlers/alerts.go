@@ -0,0 +1,285 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/chesskiss/btc-service/internal/alerts"
+	"github.com/chesskiss/btc-service/internal/database"
+	"github.com/chesskiss/btc-service/internal/middleware"
+	"github.com/chesskiss/btc-service/internal/pagination"
+	"github.com/chesskiss/btc-service/internal/problem"
+	"github.com/chesskiss/btc-service/internal/projection"
+	"github.com/chesskiss/btc-service/services"
+)
+
+// maxBatchWindowSeconds bounds how long a webhook may be held back for
+// batching
+const maxBatchWindowSeconds = 3600
+
+// AlertSubscriptionRequest is the body accepted by CreateAlertHandler
+type AlertSubscriptionRequest struct {
+	Pair        string  `json:"pair"`
+	Threshold   float64 `json:"threshold"`
+	Direction   string  `json:"direction"`
+	CallbackURL string  `json:"callback_url"`
+	// Optional rate shaping for receivers that cannot take every crossing
+	MaxDeliveriesPerMinute int `json:"max_deliveries_per_minute,omitempty"`
+	BatchWindowSeconds     int `json:"batch_window_seconds,omitempty"`
+}
+
+// AlertSubscriptionsResponse is the body returned by ListAlertsHandler
+type AlertSubscriptionsResponse struct {
+	Subscriptions []database.AlertSubscription `json:"subscriptions"`
+	Count         int                          `json:"count"`
+	// NextCursor fetches the following page; absent on the last one
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// AlertDeliveriesResponse is the body returned by AlertDeliveriesHandler
+type AlertDeliveriesResponse struct {
+	Deliveries []database.AlertDelivery `json:"deliveries"`
+	Count      int                      `json:"count"`
+}
+
+// CreateAlertHandler registers a webhook, kept in store, to be called when
+// a pair's price crosses a threshold. The response includes the secret used to sign the
+// webhook payloads; it is not shown again. Callback URLs that callbacks
+// forbids are rejected.
+func CreateAlertHandler(store database.Store, callbacks alerts.CallbackPolicy) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		owner, ok := alertOwner(w, r)
+		if !ok {
+			return
+		}
+
+		var body AlertSubscriptionRequest
+		if !decodeJSONBody(w, r, &body) {
+			return
+		}
+
+		sub, err := body.toSubscription()
+		if err != nil {
+			problem.Write(w, r, problem.New(http.StatusBadRequest, problem.CodeInvalidRequestBody, err.Error()))
+			return
+		}
+		if err := callbacks.CheckURL(r.Context(), sub.CallbackURL); err != nil {
+			problem.Write(w, r, problem.New(http.StatusBadRequest, problem.CodeInvalidRequestBody, "invalid callback_url: "+err.Error()))
+			return
+		}
+		sub.Owner = owner
+
+		sub.Secret, err = alerts.NewSecret()
+		if err != nil {
+			problem.Write(w, r, problem.New(http.StatusInternalServerError, problem.CodeInternal, "failed to create subscription"))
+			return
+		}
+
+		sub, err = store.CreateAlertSubscription(r.Context(), sub)
+		if err != nil {
+			slog.Error("failed to create alert subscription",
+				"pair", sub.Pair,
+				"error", err,
+			)
+			problem.Write(w, r, problem.New(http.StatusServiceUnavailable, problem.CodeStorageUnavailable, "alert subscriptions unavailable"))
+			return
+		}
+
+		slog.Info("alert subscription created",
+			"subscription_id", sub.ID,
+			"pair", sub.Pair,
+			"direction", sub.Direction,
+			"threshold", sub.Threshold,
+		)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(sub)
+	}
+}
+
+// ListAlertsHandler lists a page of the caller's alert subscriptions
+// without their secrets
+func ListAlertsHandler(store database.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		owner, ok := alertOwner(w, r)
+		if !ok {
+			return
+		}
+
+		page, err := pagination.Parse(r, database.AlertSubscriptionSorts, database.DefaultAlertSubscriptionSort)
+		if err != nil {
+			problem.Write(w, r, problem.New(http.StatusBadRequest, problem.CodeInvalidParameter, err.Error()))
+			return
+		}
+		fields, err := projection.Parse(r, database.AlertSubscription{})
+		if err != nil {
+			problem.Write(w, r, problem.New(http.StatusBadRequest, problem.CodeInvalidParameter, err.Error()))
+			return
+		}
+
+		subs, err := store.QueryAlertSubscriptions(r.Context(), owner, page)
+		if err != nil {
+			problem.Write(w, r, problem.New(http.StatusServiceUnavailable, problem.CodeStorageUnavailable, "alert subscriptions unavailable"))
+			return
+		}
+
+		for i := range subs {
+			subs[i].Secret = ""
+		}
+
+		next := page.NextCursor(len(subs), func() (string, int64) {
+			last := subs[len(subs)-1]
+			return database.AlertSubscriptionSortValue(last, page.Sort), last.ID
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(fields.Apply(AlertSubscriptionsResponse{
+			Subscriptions: subs,
+			Count:         len(subs),
+			NextCursor:    next,
+		}, "subscriptions"))
+	}
+}
+
+// DeleteAlertHandler removes one of the caller's alert subscriptions and
+// its delivery history
+func DeleteAlertHandler(store database.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		owner, ok := alertOwner(w, r)
+		if !ok {
+			return
+		}
+		id, ok := alertIDFromPath(w, r)
+		if !ok {
+			return
+		}
+
+		deleted, err := store.DeleteAlertSubscription(r.Context(), owner, id)
+		if err != nil {
+			problem.Write(w, r, problem.New(http.StatusServiceUnavailable, problem.CodeStorageUnavailable, "alert subscriptions unavailable"))
+			return
+		}
+		if !deleted {
+			problem.Write(w, r, problem.New(http.StatusNotFound, problem.CodeNotFound, fmt.Sprintf("alert subscription %d not found", id)))
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// AlertDeliveriesHandler lists the webhook deliveries of one of the
+// caller's subscriptions, newest first, with their status, attempt count
+// and last error
+func AlertDeliveriesHandler(store database.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		owner, ok := alertOwner(w, r)
+		if !ok {
+			return
+		}
+		id, ok := alertIDFromPath(w, r)
+		if !ok {
+			return
+		}
+
+		limit := pagination.DefaultLimit
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > pagination.MaxLimit {
+				problem.Write(w, r, problem.New(http.StatusBadRequest, problem.CodeInvalidParameter, fmt.Sprintf("invalid limit: must be between 1 and %d", pagination.MaxLimit)))
+				return
+			}
+			limit = n
+		}
+
+		fields, err := projection.Parse(r, database.AlertDelivery{})
+		if err != nil {
+			problem.Write(w, r, problem.New(http.StatusBadRequest, problem.CodeInvalidParameter, err.Error()))
+			return
+		}
+
+		deliveries, err := store.ListAlertDeliveries(r.Context(), owner, id, limit)
+		if err != nil {
+			problem.Write(w, r, problem.New(http.StatusServiceUnavailable, problem.CodeStorageUnavailable, "alert deliveries unavailable"))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(fields.Apply(AlertDeliveriesResponse{
+			Deliveries: deliveries,
+			Count:      len(deliveries),
+		}, "deliveries"))
+	}
+}
+
+// alertOwner identifies who a request acts for: the API key it
+// authenticated with, else the tenant a trusted proxy forwarded. Requests
+// with neither are refused with 401, as they would otherwise all share
+// one set of subscriptions.
+func alertOwner(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if apiKeyID := middleware.GetAPIKeyID(r.Context()); apiKeyID != "" {
+		return "key:" + apiKeyID, true
+	}
+	if tenantID := middleware.GetTenantID(r.Context()); tenantID != "" {
+		return "tenant:" + tenantID, true
+	}
+	w.Header().Set("WWW-Authenticate", `APIKey header="`+middleware.APIKeyHeader+`"`)
+	problem.Write(w, r, problem.New(http.StatusUnauthorized, problem.CodeUnauthorized, "alert subscriptions require an API key in the "+middleware.APIKeyHeader+" header"))
+	return "", false
+}
+
+// alertIDFromPath parses the {id} route variable, writing a 400 problem if
+// it is not a valid ID
+func alertIDFromPath(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil || id < 1 {
+		problem.Write(w, r, problem.New(http.StatusBadRequest, problem.CodeInvalidParameter, "invalid id: must be a positive integer"))
+		return 0, false
+	}
+	return id, true
+}
+
+func (b AlertSubscriptionRequest) toSubscription() (database.AlertSubscription, error) {
+	sub := database.AlertSubscription{
+		Pair:                   strings.ToUpper(strings.TrimSpace(b.Pair)),
+		Threshold:              b.Threshold,
+		Direction:              b.Direction,
+		CallbackURL:            b.CallbackURL,
+		MaxDeliveriesPerMinute: b.MaxDeliveriesPerMinute,
+		BatchWindowSeconds:     b.BatchWindowSeconds,
+	}
+
+	pair, ok := services.NormalizePair(sub.Pair)
+	if !ok {
+		return sub, fmt.Errorf("invalid pair: must be of the form BTC/<currency>")
+	}
+	sub.Pair = pair
+	if sub.Threshold <= 0 {
+		return sub, fmt.Errorf("invalid threshold: must be positive")
+	}
+	if sub.Direction != database.AlertAbove && sub.Direction != database.AlertBelow {
+		return sub, fmt.Errorf("invalid direction: must be above or below")
+	}
+
+	if sub.MaxDeliveriesPerMinute < 0 {
+		return sub, fmt.Errorf("invalid max_deliveries_per_minute: must not be negative")
+	}
+	if sub.BatchWindowSeconds < 0 || sub.BatchWindowSeconds > maxBatchWindowSeconds {
+		return sub, fmt.Errorf("invalid batch_window_seconds: must be between 0 and %d", maxBatchWindowSeconds)
+	}
+
+	u, err := url.Parse(sub.CallbackURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return sub, fmt.Errorf("invalid callback_url: must be an absolute http or https URL")
+	}
+
+	return sub, nil
+}
@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/chesskiss/btc-service/internal/problem"
+)
+
+// decodeJSONBody decodes a JSON request body into dst, rejecting unknown
+// fields. On failure it writes a 413 if the body hit the size limit or a
+// 400 otherwise, and returns false.
+func decodeJSONBody(w http.ResponseWriter, r *http.Request, dst interface{}) bool {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(dst); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			problem.Write(w, r, problem.New(http.StatusRequestEntityTooLarge, problem.CodeRequestTooLarge,
+				fmt.Sprintf("request body exceeds %d bytes", maxBytesErr.Limit)))
+			return false
+		}
+		problem.Write(w, r, problem.New(http.StatusBadRequest, problem.CodeInvalidRequestBody, "invalid request body"))
+		return false
+	}
+	return true
+}
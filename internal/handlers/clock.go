@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/chesskiss/btc-service/internal/clock"
+	"github.com/chesskiss/btc-service/internal/problem"
+)
+
+// ClockResponse is the body returned by AdvanceClockHandler
+type ClockResponse struct {
+	Now time.Time `json:"now"`
+}
+
+// AdvanceClockHandler moves a simulated clock forward by the duration in
+// the by parameter (for example 90s), firing any scheduler ticks that fall
+// due, and returns the new time. It is only routed in simulation builds.
+func AdvanceClockHandler(sim *clock.Virtual) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		by, err := time.ParseDuration(r.URL.Query().Get("by"))
+		if err != nil || by <= 0 {
+			problem.Write(w, r, problem.New(http.StatusBadRequest, problem.CodeInvalidParameter,
+				"invalid by: must be a positive duration such as 90s"))
+			return
+		}
+
+		now := sim.Advance(by)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ClockResponse{Now: now.UTC()})
+	}
+}
@@ -2,54 +2,167 @@ package handlers
 
 import (
 	"context"
-	"database/sql"
 	"encoding/json"
 	"net/http"
+	"time"
 
-	"github.com/redis/go-redis/v9"
+	"github.com/chesskiss/btc-service/clients"
+	"github.com/chesskiss/btc-service/internal/cache"
+	"github.com/chesskiss/btc-service/internal/clock"
+	"github.com/chesskiss/btc-service/internal/health"
 )
 
-// HealthHandler returns basic health status
-func HealthHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
-		"status": "healthy",
-	})
+// readinessProbeTimeout bounds each round of dependency probes
+const readinessProbeTimeout = 2 * time.Second
+
+// Component and overall states reported by the health check
+const (
+	StatusOK       = "ok"
+	StatusDegraded = "degraded"
+	StatusDown     = "down"
+	StatusUnknown  = "unknown"
+)
+
+// ComponentHealth is the state of one dependency
+type ComponentHealth struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	// LastSuccessSecondsAgo is how long ago the upstream last answered
+	LastSuccessSecondsAgo *int64 `json:"last_success_seconds_ago,omitempty"`
 }
 
-// ReadinessHandler checks database and cache connectivity
-func ReadinessHandler(db *sql.DB, redisClient *redis.Client) http.HandlerFunc {
+// Pinger checks a database connection, as *pgxpool.Pool and
+// *database.MySQLStore do
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// HealthResponse is the body returned by the health check
+type HealthResponse struct {
+	Status     string                     `json:"status"`
+	Components map[string]ComponentHealth `json:"components"`
+}
+
+// HealthHandler reports the state of each component: the price cache,
+// named after its backend, is degraded when unreachable or not configured
+// (prices are still served uncached), the database, named after its
+// driver, is down when unreachable, and Kraken is degraded when its last
+// call failed. The overall status is ok only if every component is. It
+// always answers 200 so liveness probes don't restart the service over a
+// dependency outage; use /ready to gate traffic.
+func HealthHandler(dbName string, db Pinger, priceCache cache.Cache) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
+		ctx, cancel := context.WithTimeout(r.Context(), readinessProbeTimeout)
+		defer cancel()
 
-		ctx := context.Background()
-
-		// Check database connection
-		if db != nil {
-			if err := db.Ping(); err != nil {
-				w.WriteHeader(http.StatusServiceUnavailable)
-				json.NewEncoder(w).Encode(map[string]string{
-					"status": "not ready",
-					"error":  "database unavailable",
-				})
-				return
-			}
+		components := map[string]ComponentHealth{
+			dbName:   databaseHealth(ctx, db),
+			"kraken": krakenHealth(),
+		}
+		if priceCache == nil {
+			components[cache.BackendRedis] = ComponentHealth{Status: StatusDegraded, Error: "not configured"}
+		} else {
+			components[priceCache.Backend()] = cacheHealth(ctx, priceCache)
 		}
 
-		// Check Redis connection
-		if redisClient != nil {
-			if err := redisClient.Ping(ctx).Err(); err != nil {
-				w.WriteHeader(http.StatusServiceUnavailable)
-				json.NewEncoder(w).Encode(map[string]string{
-					"status": "not ready",
-					"error":  "cache unavailable",
-				})
-				return
+		status := StatusOK
+		for _, component := range components {
+			if component.Status != StatusOK {
+				status = StatusDegraded
 			}
 		}
 
-		json.NewEncoder(w).Encode(map[string]string{
-			"status": "ready",
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(HealthResponse{
+			Status:     status,
+			Components: components,
 		})
 	}
 }
+
+func cacheHealth(ctx context.Context, priceCache cache.Cache) ComponentHealth {
+	if err := priceCache.Ping(ctx); err != nil {
+		return ComponentHealth{Status: StatusDegraded, Error: err.Error()}
+	}
+	return ComponentHealth{Status: StatusOK}
+}
+
+func databaseHealth(ctx context.Context, db Pinger) ComponentHealth {
+	if db == nil {
+		return ComponentHealth{Status: StatusDown, Error: "not connected"}
+	}
+	if err := db.Ping(ctx); err != nil {
+		return ComponentHealth{Status: StatusDown, Error: err.Error()}
+	}
+	return ComponentHealth{Status: StatusOK}
+}
+
+func krakenHealth() ComponentHealth {
+	lastSuccess, lastFailure := clients.KrakenStatus()
+	if lastSuccess.IsZero() && lastFailure.IsZero() {
+		return ComponentHealth{Status: StatusUnknown}
+	}
+
+	component := ComponentHealth{Status: StatusOK}
+	if !lastSuccess.IsZero() {
+		ago := int64(clock.Since(lastSuccess).Seconds())
+		component.LastSuccessSecondsAgo = &ago
+	}
+	if lastFailure.After(lastSuccess) {
+		component.Status = StatusDegraded
+		component.Error = "last call failed"
+	}
+	return component
+}
+
+// ReadinessResponse is the body returned by the readiness check
+type ReadinessResponse struct {
+	Status       string                    `json:"status"`
+	Score        float64                   `json:"score"`
+	Dependencies []health.DependencyStatus `json:"dependencies"`
+}
+
+// ReadinessHandler reports whether every dependency is healthy, as smoothed
+// by the monitor's hysteresis
+func ReadinessHandler(monitor *health.Monitor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		ctx, cancel := context.WithTimeout(r.Context(), readinessProbeTimeout)
+		defer cancel()
+
+		report := monitor.Check(ctx)
+
+		status := "ready"
+		if !report.Healthy {
+			status = "not ready"
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+
+		json.NewEncoder(w).Encode(ReadinessResponse{
+			Status:       status,
+			Score:        report.Score,
+			Dependencies: report.Dependencies,
+		})
+	}
+}
+
+// DatabaseProbe checks database connectivity
+func DatabaseProbe(db Pinger) health.Probe {
+	return health.Probe{
+		Name: "database",
+		Check: func(ctx context.Context) error {
+			return db.Ping(ctx)
+		},
+	}
+}
+
+// CacheProbe checks that the price cache is reachable
+func CacheProbe(priceCache cache.Cache) health.Probe {
+	return health.Probe{
+		Name: "cache",
+		Check: func(ctx context.Context) error {
+			return priceCache.Ping(ctx)
+		},
+	}
+}
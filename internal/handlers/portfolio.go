@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/chesskiss/btc-service/internal/problem"
+	"github.com/chesskiss/btc-service/services"
+)
+
+// maxPortfolioHoldings bounds how many holdings one valuation may include
+const maxPortfolioHoldings = 100
+
+// PortfolioValueRequest is the body accepted by PortfolioValueHandler
+type PortfolioValueRequest struct {
+	Currency string             `json:"currency"`
+	Holdings []services.Holding `json:"holdings"`
+}
+
+// PortfolioValueResponse is the body returned by PortfolioValueHandler
+type PortfolioValueResponse struct {
+	Currency string                  `json:"currency"`
+	Total    decimal.Decimal         `json:"total"`
+	Holdings []services.HoldingValue `json:"holdings"`
+	// PricedAt is when the oldest price used was fetched; absent when no
+	// price was needed
+	PricedAt *time.Time `json:"priced_at,omitempty"`
+}
+
+// PortfolioValueHandler values a list of BTC and fiat holdings in the
+// requested currency using the latest prices. Amounts, rates and values
+// are decimal strings.
+func PortfolioValueHandler(w http.ResponseWriter, r *http.Request) {
+	var body PortfolioValueRequest
+	if !decodeJSONBody(w, r, &body) {
+		return
+	}
+
+	if err := body.normalize(); err != nil {
+		problem.Write(w, r, problem.New(http.StatusBadRequest, problem.CodeInvalidRequestBody, err.Error()))
+		return
+	}
+
+	valuation := services.ValuePortfolio(r.Context(), body.Currency, body.Holdings)
+	if len(valuation.InvalidPairs) > 0 {
+		problem.Write(w, r, problem.New(http.StatusBadRequest, problem.CodeInvalidPair, "one or more assets are not supported").
+			WithFailedPairs(valuation.InvalidPairs))
+		return
+	}
+	if len(valuation.FailedPairs) > 0 {
+		problem.Write(w, r, problem.New(http.StatusServiceUnavailable, problem.CodeUpstreamUnavailable, "prices could not be fetched from the exchange").
+			WithFailedPairs(valuation.FailedPairs))
+		return
+	}
+
+	resp := PortfolioValueResponse{
+		Currency: valuation.Currency,
+		Total:    valuation.Total,
+		Holdings: valuation.Holdings,
+	}
+	if !valuation.PricedAt.IsZero() {
+		resp.PricedAt = &valuation.PricedAt
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// normalize upper-cases the currency and assets and validates the request
+func (b *PortfolioValueRequest) normalize() error {
+	b.Currency = strings.ToUpper(strings.TrimSpace(b.Currency))
+	if !validAsset(b.Currency) {
+		return fmt.Errorf("invalid currency: must be BTC or a currency code")
+	}
+	if len(b.Holdings) == 0 || len(b.Holdings) > maxPortfolioHoldings {
+		return fmt.Errorf("invalid holdings: must list between 1 and %d holdings", maxPortfolioHoldings)
+	}
+
+	for i := range b.Holdings {
+		holding := &b.Holdings[i]
+		holding.Asset = strings.ToUpper(strings.TrimSpace(holding.Asset))
+		if !validAsset(holding.Asset) {
+			return fmt.Errorf("invalid holdings[%d].asset: must be BTC or a currency code", i)
+		}
+		if holding.Amount.IsNegative() {
+			return fmt.Errorf("invalid holdings[%d].amount: must not be negative", i)
+		}
+	}
+
+	return nil
+}
+
+// validAsset accepts short alphabetic codes such as BTC or USD
+func validAsset(asset string) bool {
+	if len(asset) < 2 || len(asset) > 10 {
+		return false
+	}
+	for _, c := range asset {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/chesskiss/btc-service/internal/problem"
+	"github.com/chesskiss/btc-service/internal/subsystems"
+)
+
+// SubsystemsResponse is the body returned by the subsystem admin endpoints
+type SubsystemsResponse struct {
+	Subsystems []subsystems.State `json:"subsystems"`
+}
+
+// SubsystemsHandler lists the background subsystems and whether each is
+// paused
+func SubsystemsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(SubsystemsResponse{Subsystems: subsystems.States(r.Context())})
+}
+
+// PauseSubsystemHandler pauses the {name} subsystem on every replica
+func PauseSubsystemHandler(w http.ResponseWriter, r *http.Request) {
+	setSubsystemPaused(w, r, true)
+}
+
+// ResumeSubsystemHandler resumes the {name} subsystem on every replica
+func ResumeSubsystemHandler(w http.ResponseWriter, r *http.Request) {
+	setSubsystemPaused(w, r, false)
+}
+
+func setSubsystemPaused(w http.ResponseWriter, r *http.Request, pause bool) {
+	name := mux.Vars(r)["name"]
+	if err := subsystems.SetPaused(r.Context(), name, pause); err != nil {
+		if errors.Is(err, subsystems.ErrUnknownSubsystem) {
+			problem.Write(w, r, problem.New(http.StatusNotFound, problem.CodeNotFound, fmt.Sprintf("subsystem %q not found", name)))
+			return
+		}
+		slog.Error("failed to change subsystem state",
+			"subsystem", name,
+			"error", err,
+		)
+		problem.Write(w, r, problem.New(http.StatusServiceUnavailable, problem.CodeStorageUnavailable, "subsystem state could not be persisted"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(SubsystemsResponse{Subsystems: subsystems.States(r.Context())})
+}
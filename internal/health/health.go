@@ -0,0 +1,111 @@
+package health
+
+import (
+	"context"
+	"sync"
+
+	"github.com/chesskiss/btc-service/internal/metrics"
+)
+
+// Probe checks one dependency
+type Probe struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+// DependencyStatus is the smoothed state of one dependency
+type DependencyStatus struct {
+	Name                string `json:"name"`
+	Healthy             bool   `json:"healthy"`
+	ConsecutiveFailures int    `json:"consecutive_failures,omitempty"`
+	Error               string `json:"error,omitempty"`
+}
+
+// Report is the outcome of one round of probes
+type Report struct {
+	Healthy bool
+	// Score is the fraction of dependencies currently considered healthy
+	Score        float64
+	Dependencies []DependencyStatus
+}
+
+type probeState struct {
+	healthy   bool
+	failures  int
+	successes int
+	lastError string
+}
+
+// Monitor aggregates dependency probes with hysteresis: a dependency is
+// only marked unhealthy after failureThreshold consecutive failures, and
+// only recovers after recoveryThreshold consecutive successes, so a single
+// transient failure does not flip readiness
+type Monitor struct {
+	mu                sync.Mutex
+	probes            []Probe
+	states            []probeState
+	failureThreshold  int
+	recoveryThreshold int
+}
+
+// NewMonitor creates a monitor whose dependencies start out healthy
+func NewMonitor(failureThreshold, recoveryThreshold int, probes ...Probe) *Monitor {
+	states := make([]probeState, len(probes))
+	for i := range states {
+		states[i].healthy = true
+	}
+
+	return &Monitor{
+		probes:            probes,
+		states:            states,
+		failureThreshold:  failureThreshold,
+		recoveryThreshold: recoveryThreshold,
+	}
+}
+
+// Check runs every probe once and returns the smoothed report
+func (m *Monitor) Check(ctx context.Context) Report {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	report := Report{Healthy: true, Score: 1}
+	healthy := 0
+	for i, probe := range m.probes {
+		state := &m.states[i]
+		if err := probe.Check(ctx); err != nil {
+			state.failures++
+			state.successes = 0
+			state.lastError = err.Error()
+			if state.failures >= m.failureThreshold {
+				state.healthy = false
+			}
+		} else {
+			state.successes++
+			state.failures = 0
+			state.lastError = ""
+			if state.successes >= m.recoveryThreshold {
+				state.healthy = true
+			}
+		}
+
+		if state.healthy {
+			healthy++
+			metrics.DependencyHealthy.WithLabelValues(probe.Name).Set(1)
+		} else {
+			report.Healthy = false
+			metrics.DependencyHealthy.WithLabelValues(probe.Name).Set(0)
+		}
+
+		report.Dependencies = append(report.Dependencies, DependencyStatus{
+			Name:                probe.Name,
+			Healthy:             state.healthy,
+			ConsecutiveFailures: state.failures,
+			Error:               state.lastError,
+		})
+	}
+
+	if len(m.probes) > 0 {
+		report.Score = float64(healthy) / float64(len(m.probes))
+	}
+	return report
+}
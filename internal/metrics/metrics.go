@@ -1,10 +1,35 @@
 package metrics
 
 import (
+	"sync/atomic"
+	"time"
+
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
+// Lookup counts behind CacheHitRatio, and the function behind CacheKeys
+var (
+	cacheHits   atomic.Uint64
+	cacheMisses atomic.Uint64
+	cacheSize   atomic.Pointer[func() float64]
+)
+
+// DBPoolStats is a snapshot of the database connection pool. WaitCount
+// and WaitDuration count the connections requested while none was idle,
+// and the time spent waiting for them, since startup.
+type DBPoolStats struct {
+	Open         int
+	InUse        int
+	Idle         int
+	Max          int
+	WaitCount    int64
+	WaitDuration time.Duration
+}
+
+// dbPoolStats is the function behind the DB pool metrics
+var dbPoolStats atomic.Pointer[func() DBPoolStats]
+
 var (
 	// HTTP request metrics
 	HTTPRequestsTotal = promauto.NewCounterVec(
@@ -24,6 +49,20 @@ var (
 		[]string{"method", "endpoint"},
 	)
 
+	HTTPRequestsInFlight = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "http_requests_in_flight",
+			Help: "Number of API requests being served",
+		},
+	)
+
+	HTTPRequestsShedTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "http_requests_shed_total",
+			Help: "Total number of API requests rejected because too many were in flight",
+		},
+	)
+
 	// Cache metrics
 	CacheHitsTotal = promauto.NewCounter(
 		prometheus.CounterOpts{
@@ -32,6 +71,13 @@ var (
 		},
 	)
 
+	CacheStaleHitsTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "cache_stale_hits_total",
+			Help: "Total number of stale cache hits served while the price was refreshed",
+		},
+	)
+
 	CacheMissesTotal = promauto.NewCounter(
 		prometheus.CounterOpts{
 			Name: "cache_misses_total",
@@ -39,6 +85,114 @@ var (
 		},
 	)
 
+	CachePairHitsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "cache_pair_hits_total",
+			Help: "Total number of cache hits by pair",
+		},
+		[]string{"pair"},
+	)
+
+	CacheHitRatio = promauto.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "cache_hit_ratio",
+			Help: "Fraction of price lookups served from cache since startup",
+		},
+		func() float64 {
+			hits, misses := cacheHits.Load(), cacheMisses.Load()
+			if hits+misses == 0 {
+				return 0
+			}
+			return float64(hits) / float64(hits+misses)
+		},
+	)
+
+	CacheKeys = promauto.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "cache_keys",
+			Help: "Number of keys held by the cache backend",
+		},
+		func() float64 {
+			if size := cacheSize.Load(); size != nil {
+				return (*size)()
+			}
+			return 0
+		},
+	)
+
+	// Database pool metrics
+	DBPoolOpenConnections = promauto.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "db_pool_open_connections",
+			Help: "Number of open database connections, in use or idle",
+		},
+		func() float64 { return float64(loadDBPoolStats().Open) },
+	)
+
+	DBPoolInUseConnections = promauto.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "db_pool_in_use_connections",
+			Help: "Number of database connections in use",
+		},
+		func() float64 { return float64(loadDBPoolStats().InUse) },
+	)
+
+	DBPoolIdleConnections = promauto.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "db_pool_idle_connections",
+			Help: "Number of idle database connections",
+		},
+		func() float64 { return float64(loadDBPoolStats().Idle) },
+	)
+
+	DBPoolMaxConnections = promauto.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "db_pool_max_connections",
+			Help: "Maximum number of database connections the pool opens",
+		},
+		func() float64 { return float64(loadDBPoolStats().Max) },
+	)
+
+	DBPoolWaitTotal = promauto.NewCounterFunc(
+		prometheus.CounterOpts{
+			Name: "db_pool_wait_total",
+			Help: "Total number of database connections waited for because none was idle",
+		},
+		func() float64 { return float64(loadDBPoolStats().WaitCount) },
+	)
+
+	DBPoolWaitSecondsTotal = promauto.NewCounterFunc(
+		prometheus.CounterOpts{
+			Name: "db_pool_wait_seconds_total",
+			Help: "Total time spent waiting for a database connection in seconds",
+		},
+		func() float64 { return loadDBPoolStats().WaitDuration.Seconds() },
+	)
+
+	CacheOperationDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "cache_operation_duration_seconds",
+			Help:    "Cache read and write latency in seconds",
+			Buckets: []float64{.0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1, .25},
+		},
+		[]string{"operation", "backend"},
+	)
+
+	CacheFallbackActive = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "cache_fallback_active",
+			Help: "Whether Redis is unreachable and prices are cached in process memory instead (1) or not (0)",
+		},
+	)
+
+	FetchLockWaitsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "fetch_lock_waits_total",
+			Help: "Total number of cache misses that waited for another replica's fetch, by whether the price arrived (filled) or the wait ran out (timeout)",
+		},
+		[]string{"result"},
+	)
+
 	// Kraken API metrics
 	KrakenAPICallsTotal = promauto.NewCounter(
 		prometheus.CounterOpts{
@@ -53,4 +207,190 @@ var (
 			Help: "Total number of Kraken API errors",
 		},
 	)
+
+	KrakenAPIRetriesTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "kraken_api_retries_total",
+			Help: "Total number of Kraken API calls retried after a transient failure",
+		},
+	)
+
+	LastKnownPricesServedTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "last_known_prices_served_total",
+			Help: "Total number of last known prices served, flagged stale, because Kraken was unavailable",
+		},
+	)
+
+	CircuitBreakerState = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "circuit_breaker_state",
+			Help: "State of each upstream provider's circuit breaker: closed (0), half-open (1) or open (2)",
+		},
+		[]string{"provider"},
+	)
+
+	KrakenMaintenanceActive = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "kraken_maintenance_active",
+			Help: "Whether an announced Kraken maintenance window is in effect (1) or not (0)",
+		},
+	)
+
+	KrakenMaintenanceSkippedTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "kraken_maintenance_skipped_fetches_total",
+			Help: "Total number of Kraken fetches skipped because of maintenance",
+		},
+	)
+
+	// Alert metrics
+	AlertWebhooksTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "alert_webhooks_total",
+			Help: "Total number of alert webhook attempts by result",
+		},
+		[]string{"result"},
+	)
+
+	// Request log writer metrics
+	RequestLogsWrittenTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "request_logs_written_total",
+			Help: "Total number of request log entries written to the database",
+		},
+	)
+
+	RequestLogsArchivedTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "request_logs_archived_total",
+			Help: "Total number of expired request log entries archived to object storage",
+		},
+	)
+
+	AuthFailuresTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "auth_failures_total",
+			Help: "Total number of API requests rejected for a missing or invalid API key",
+		},
+		[]string{"reason"},
+	)
+
+	RateLimitDecisionsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rate_limit_decisions_total",
+			Help: "Total number of API requests checked against their caller's rate limit",
+		},
+		[]string{"subject", "decision"},
+	)
+
+	RequestLogSamplingTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "request_log_sampling_total",
+			Help: "Total number of request log sampling decisions by request outcome and decision",
+		},
+		[]string{"outcome", "decision"},
+	)
+
+	RequestLogWriteErrorsTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "request_log_write_errors_total",
+			Help: "Total number of failed request log batch writes",
+		},
+	)
+
+	RequestLogsSpooledTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "request_logs_spooled_total",
+			Help: "Total number of request log entries spooled to disk after failing to be written",
+		},
+	)
+
+	RequestLogsReplayedTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "request_logs_replayed_total",
+			Help: "Total number of spooled request log entries written to the database",
+		},
+	)
+
+	RequestLogsDroppedTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "request_logs_dropped_total",
+			Help: "Total number of request log entries dropped by reason",
+		},
+		[]string{"reason"},
+	)
+
+	RequestLogsExpiredTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "request_logs_expired_total",
+			Help: "Total number of request logs deleted after the retention period",
+		},
+	)
+
+	// Background subsystems paused by an operator
+	SubsystemPaused = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "subsystem_paused",
+			Help: "Whether a background subsystem is paused (1) or running (0)",
+		},
+		[]string{"subsystem"},
+	)
+
+	// Build information, always 1
+	BuildInfo = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "build_info",
+			Help: "Build information of the running binary",
+		},
+		[]string{"version", "commit", "go_version"},
+	)
+
+	// Dependency health, after hysteresis
+	DependencyHealthy = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dependency_healthy",
+			Help: "Whether a dependency is considered healthy (1) or not (0)",
+		},
+		[]string{"dependency"},
+	)
 )
+
+// RecordCacheHit counts a price served from cache
+func RecordCacheHit(pair string) {
+	CacheHitsTotal.Inc()
+	CachePairHitsTotal.WithLabelValues(pair).Inc()
+	cacheHits.Add(1)
+}
+
+// RecordCacheMiss counts a price that had to be fetched
+func RecordCacheMiss() {
+	CacheMissesTotal.Inc()
+	cacheMisses.Add(1)
+}
+
+// SetCacheSize sets how CacheKeys is measured on each scrape; nil reports 0
+func SetCacheSize(size func() float64) {
+	if size == nil {
+		cacheSize.Store(nil)
+		return
+	}
+	cacheSize.Store(&size)
+}
+
+// SetDBPoolStats sets how the DB pool metrics are measured on each scrape;
+// nil reports zeros
+func SetDBPoolStats(stats func() DBPoolStats) {
+	if stats == nil {
+		dbPoolStats.Store(nil)
+		return
+	}
+	dbPoolStats.Store(&stats)
+}
+
+func loadDBPoolStats() DBPoolStats {
+	if stats := dbPoolStats.Load(); stats != nil {
+		return (*stats)()
+	}
+	return DBPoolStats{}
+}
@@ -0,0 +1,169 @@
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/chesskiss/btc-service/internal/metrics"
+)
+
+// APIKeyHeader carries the API key a caller authenticates with
+const APIKeyHeader = "X-API-Key"
+
+// APIKey is what a key store knows of an API key: the ID it is logged
+// under, and whether it may call the admin endpoints
+type APIKey struct {
+	ID    string
+	Admin bool
+}
+
+// KeyStore looks up an API key. ok is false for unknown keys; err is
+// reserved for a store that cannot answer, such as an unreachable
+// database.
+type KeyStore interface {
+	LookupAPIKey(ctx context.Context, key string) (apiKey APIKey, ok bool, err error)
+}
+
+// StaticKeyStore holds a fixed set of API keys, such as those configured
+// in AUTH_API_KEYS and ADMIN_API_KEYS. Keys are kept only as SHA-256
+// hashes.
+type StaticKeyStore struct {
+	keys map[[sha256.Size]byte]APIKey
+}
+
+// NewStaticKeyStore returns a store of entries and of adminEntries, which
+// are given the admin scope. Each is either "id:key" or a bare key whose
+// ID is derived from its hash, so logs and request_logs never hold the key
+// itself.
+func NewStaticKeyStore(entries, adminEntries []string) (*StaticKeyStore, error) {
+	s := &StaticKeyStore{keys: make(map[[sha256.Size]byte]APIKey, len(entries)+len(adminEntries))}
+	add := func(entry string, admin bool) error {
+		id, key, err := ParseAPIKey(entry)
+		if err != nil {
+			return err
+		}
+		hash := sha256.Sum256([]byte(key))
+		if _, ok := s.keys[hash]; ok {
+			return fmt.Errorf("API key %s is configured twice", id)
+		}
+		s.keys[hash] = APIKey{ID: id, Admin: admin}
+		return nil
+	}
+	for _, entry := range entries {
+		if err := add(entry, false); err != nil {
+			return nil, err
+		}
+	}
+	for _, entry := range adminEntries {
+		if err := add(entry, true); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// LookupAPIKey returns the ID and scope key was configured with
+func (s *StaticKeyStore) LookupAPIKey(ctx context.Context, key string) (APIKey, bool, error) {
+	apiKey, ok := s.keys[sha256.Sum256([]byte(key))]
+	return apiKey, ok, nil
+}
+
+// maxAPIKeyIDLength is the width of request_logs.api_key_id
+const maxAPIKeyIDLength = 64
+
+// ParseAPIKey splits an "id:key" entry. A bare key gets the ID "key-"
+// followed by the first 12 hex digits of its SHA-256 hash.
+func ParseAPIKey(entry string) (id, key string, err error) {
+	id, key, named := strings.Cut(entry, ":")
+	if !named {
+		hash := sha256.Sum256([]byte(entry))
+		return "key-" + hex.EncodeToString(hash[:6]), entry, nil
+	}
+	if id == "" || key == "" {
+		return "", "", fmt.Errorf("API key entries must be a key or id:key with both parts set")
+	}
+	if !validID(id, maxAPIKeyIDLength) {
+		return "", "", fmt.Errorf("API key ID %q must be at most %d printable ASCII characters without spaces", id, maxAPIKeyIDLength)
+	}
+	return id, key, nil
+}
+
+// RequireAPIKey rejects requests under /api/ without a known key in the
+// X-API-Key header with 401, or with 503 when the store cannot be asked.
+// Authenticated requests carry the key's ID in their context, for request
+// logs. Health, metrics and the API specification stay open.
+func RequireAPIKey(store KeyStore, reject RejectFunc) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !strings.HasPrefix(r.URL.Path, "/api/") {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			key := r.Header.Get(APIKeyHeader)
+			if key == "" {
+				metrics.AuthFailuresTotal.WithLabelValues("missing").Inc()
+				w.Header().Set("WWW-Authenticate", `APIKey header="`+APIKeyHeader+`"`)
+				reject(w, r, http.StatusUnauthorized, "missing "+APIKeyHeader+" header")
+				return
+			}
+
+			apiKey, ok, err := store.LookupAPIKey(r.Context(), key)
+			if err != nil {
+				metrics.AuthFailuresTotal.WithLabelValues("store_error").Inc()
+				reject(w, r, http.StatusServiceUnavailable, "API keys cannot be checked")
+				return
+			}
+			if !ok {
+				metrics.AuthFailuresTotal.WithLabelValues("invalid").Inc()
+				w.Header().Set("WWW-Authenticate", `APIKey header="`+APIKeyHeader+`"`)
+				reject(w, r, http.StatusUnauthorized, "invalid API key")
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(WithAPIKeyID(r.Context(), apiKey.ID)))
+		})
+	}
+}
+
+// RequireAdmin guards the admin endpoints, whether or not AUTH_ENABLED is
+// set: requests without a known key in X-API-Key get 401, and those whose
+// key lacks the admin scope get 403. With no admin keys configured every
+// request is refused.
+func RequireAdmin(store KeyStore, reject RejectFunc) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(APIKeyHeader)
+			if key == "" {
+				metrics.AuthFailuresTotal.WithLabelValues("missing").Inc()
+				w.Header().Set("WWW-Authenticate", `APIKey header="`+APIKeyHeader+`"`)
+				reject(w, r, http.StatusUnauthorized, "admin endpoints require an admin key in the "+APIKeyHeader+" header")
+				return
+			}
+
+			apiKey, ok, err := store.LookupAPIKey(r.Context(), key)
+			if err != nil {
+				metrics.AuthFailuresTotal.WithLabelValues("store_error").Inc()
+				reject(w, r, http.StatusServiceUnavailable, "API keys cannot be checked")
+				return
+			}
+			if !ok {
+				metrics.AuthFailuresTotal.WithLabelValues("invalid").Inc()
+				w.Header().Set("WWW-Authenticate", `APIKey header="`+APIKeyHeader+`"`)
+				reject(w, r, http.StatusUnauthorized, "invalid API key")
+				return
+			}
+			if !apiKey.Admin {
+				metrics.AuthFailuresTotal.WithLabelValues("forbidden").Inc()
+				reject(w, r, http.StatusForbidden, "API key "+apiKey.ID+" may not call admin endpoints")
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(WithAPIKeyID(r.Context(), apiKey.ID)))
+		})
+	}
+}
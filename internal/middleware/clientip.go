@@ -0,0 +1,105 @@
+package middleware
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+)
+
+var trustedProxies atomic.Pointer[[]netip.Prefix]
+
+// ParseTrustedProxies parses proxy addresses and CIDR ranges, such as
+// 10.0.0.0/8 for a load balancer's subnet
+func ParseTrustedProxies(entries []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(entries))
+	for _, entry := range entries {
+		if addr, err := netip.ParseAddr(entry); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: must be an IP address or CIDR range", entry)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// SetTrustedProxies sets the proxies whose X-Forwarded-For and X-Real-IP
+// headers ClientIP believes, and whose X-Tenant-ID LoggingMiddleware
+// accepts; nil trusts none
+func SetTrustedProxies(prefixes []netip.Prefix) {
+	if len(prefixes) == 0 {
+		trustedProxies.Store(nil)
+		return
+	}
+	trustedProxies.Store(&prefixes)
+}
+
+// ClientIP returns the address of the client that sent r. Forwarding
+// headers are only believed when the connection comes from a trusted
+// proxy, as anyone else can set them: X-Forwarded-For is then read from
+// the right, past the trusted proxies that appended to it, and the first
+// address they didn't append is the client's. Without it X-Real-IP is
+// used, and otherwise the connection's address.
+func ClientIP(r *http.Request) string {
+	remote := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(remote); err == nil {
+		remote = host
+	}
+	if !isTrustedProxy(remote) {
+		return remote
+	}
+
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		if !isTrustedProxy(hop) || i == 0 {
+			return hop
+		}
+	}
+
+	if realIP := strings.TrimSpace(r.Header.Get("X-Real-IP")); realIP != "" {
+		return realIP
+	}
+	return remote
+}
+
+// fromTrustedProxy reports whether r's connection comes from one of the
+// trusted proxies
+func fromTrustedProxy(r *http.Request) bool {
+	remote := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(remote); err == nil {
+		remote = host
+	}
+	return isTrustedProxy(remote)
+}
+
+// isTrustedProxy reports whether ip is one of the trusted proxies
+func isTrustedProxy(ip string) bool {
+	prefixes := trustedProxies.Load()
+	if prefixes == nil {
+		return false
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range *prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,184 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Content codings Compress negotiates, in order of preference
+const (
+	EncodingGzip    = "gzip"
+	EncodingDeflate = "deflate"
+)
+
+var (
+	gzipWriters = sync.Pool{New: func() any { return gzip.NewWriter(io.Discard) }}
+	zlibWriters = sync.Pool{New: func() any { return zlib.NewWriter(io.Discard) }}
+)
+
+// Compress gzip- or deflate-encodes responses of at least minSize bytes
+// for clients that accept either, as large JSON bodies shrink several
+// times over. Smaller responses, which would barely shrink, and responses
+// a handler has already encoded are sent as they are.
+func Compress(minSize int) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept-Encoding")
+			encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+			if encoding == "" || r.Method == http.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			cw := &compressWriter{ResponseWriter: w, encoding: encoding, minSize: minSize}
+			defer cw.Close()
+			next.ServeHTTP(cw, r)
+		})
+	}
+}
+
+// negotiateEncoding picks gzip or deflate from an Accept-Encoding header
+// by quality, preferring gzip on a tie, or returns "" for neither. "*"
+// stands for whichever of them the header doesn't name.
+func negotiateEncoding(acceptEncoding string) string {
+	qualities := map[string]float64{}
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding != EncodingGzip && coding != EncodingDeflate && coding != "*" {
+			continue
+		}
+
+		q := 1.0
+		if name, value, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.TrimSpace(name) == "q" {
+			parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		qualities[coding] = q
+	}
+
+	best, bestQ := "", 0.0
+	for _, coding := range []string{EncodingGzip, EncodingDeflate} {
+		q, ok := qualities[coding]
+		if !ok {
+			q = qualities["*"]
+		}
+		if q > bestQ {
+			best, bestQ = coding, q
+		}
+	}
+	return best
+}
+
+// compressWriter holds back the status and the first minSize bytes of a
+// response until it knows whether the body is large enough to compress
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	minSize  int
+
+	status  int
+	buf     []byte
+	started bool
+	encoder io.WriteCloser
+}
+
+func (cw *compressWriter) WriteHeader(code int) {
+	if cw.started || cw.status != 0 {
+		return
+	}
+	cw.status = code
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	if !cw.started {
+		if len(cw.buf)+len(p) < cw.minSize {
+			cw.buf = append(cw.buf, p...)
+			return len(p), nil
+		}
+		cw.start(true)
+		if len(cw.buf) > 0 {
+			buffered := cw.buf
+			cw.buf = nil
+			if _, err := cw.write(buffered); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return cw.write(p)
+}
+
+func (cw *compressWriter) write(p []byte) (int, error) {
+	if cw.encoder != nil {
+		return cw.encoder.Write(p)
+	}
+	return cw.ResponseWriter.Write(p)
+}
+
+// start sends the status and headers, switching to an encoder if compress
+// is set and the response may be compressed
+func (cw *compressWriter) start(compress bool) {
+	cw.started = true
+	header := cw.Header()
+	if compress && header.Get("Content-Encoding") == "" && bodyAllowed(cw.status) {
+		header.Set("Content-Encoding", cw.encoding)
+		header.Del("Content-Length")
+		switch cw.encoding {
+		case EncodingGzip:
+			gz := gzipWriters.Get().(*gzip.Writer)
+			gz.Reset(cw.ResponseWriter)
+			cw.encoder = gz
+		case EncodingDeflate:
+			zw := zlibWriters.Get().(*zlib.Writer)
+			zw.Reset(cw.ResponseWriter)
+			cw.encoder = zw
+		}
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+}
+
+// Close sends a response too small to compress as it is, or finishes the
+// compressed stream
+func (cw *compressWriter) Close() error {
+	if !cw.started {
+		if cw.status == 0 {
+			// Nothing was written; net/http sends an empty 200
+			return nil
+		}
+		cw.start(false)
+		if len(cw.buf) > 0 {
+			_, err := cw.ResponseWriter.Write(cw.buf)
+			return err
+		}
+		return nil
+	}
+	if cw.encoder == nil {
+		return nil
+	}
+
+	err := cw.encoder.Close()
+	switch encoder := cw.encoder.(type) {
+	case *gzip.Writer:
+		gzipWriters.Put(encoder)
+	case *zlib.Writer:
+		zlibWriters.Put(encoder)
+	}
+	cw.encoder = nil
+	return err
+}
+
+// bodyAllowed reports whether a response with status may have a body
+func bodyAllowed(status int) bool {
+	return status >= 200 && status != http.StatusNoContent && status != http.StatusNotModified
+}
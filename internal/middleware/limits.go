@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// SizeLimits bounds the size of incoming requests. Zero disables a limit.
+type SizeLimits struct {
+	MaxHeaderBytes int
+	MaxBodyBytes   int64
+	MaxURLLength   int
+}
+
+// RejectFunc writes the response for a request that exceeds a limit
+type RejectFunc func(w http.ResponseWriter, r *http.Request, status int, detail string)
+
+// LimitRequestSize rejects requests whose URL (414) or headers (431) are
+// too long, or whose declared body is too large (413). Bodies without a
+// Content-Length are capped with http.MaxBytesReader, so handlers reading
+// past the limit get an *http.MaxBytesError.
+func LimitRequestSize(limits SizeLimits, reject RejectFunc) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if limits.MaxURLLength > 0 && len(r.URL.RequestURI()) > limits.MaxURLLength {
+				reject(w, r, http.StatusRequestURITooLong,
+					fmt.Sprintf("request URI exceeds %d bytes", limits.MaxURLLength))
+				return
+			}
+
+			if limits.MaxHeaderBytes > 0 && headerSize(r.Header) > limits.MaxHeaderBytes {
+				reject(w, r, http.StatusRequestHeaderFieldsTooLarge,
+					fmt.Sprintf("request headers exceed %d bytes", limits.MaxHeaderBytes))
+				return
+			}
+
+			if limits.MaxBodyBytes > 0 {
+				if r.ContentLength > limits.MaxBodyBytes {
+					reject(w, r, http.StatusRequestEntityTooLarge,
+						fmt.Sprintf("request body exceeds %d bytes", limits.MaxBodyBytes))
+					return
+				}
+				r.Body = http.MaxBytesReader(w, r.Body, limits.MaxBodyBytes)
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// headerSize approximates the wire size of the headers as "Name: value\r\n"
+// lines
+func headerSize(header http.Header) int {
+	size := 0
+	for name, values := range header {
+		for _, value := range values {
+			size += len(name) + len(value) + 4
+		}
+	}
+	return size
+}
@@ -7,12 +7,43 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type contextKey string
 
 const RequestIDKey contextKey = "request_id"
 
+// APIKeyIDKey holds the ID of the API key a request authenticated with
+const APIKeyIDKey contextKey = "api_key_id"
+
+// TenantIDKey holds the tenant the gateway forwarded in TenantIDHeader
+const TenantIDKey contextKey = "tenant_id"
+
+// RequestIDHeader carries the request ID in both directions: a caller may
+// supply one, and every response echoes the ID that was used
+const RequestIDHeader = "X-Request-ID"
+
+// TenantIDHeader names the tenant a request was made for, as forwarded by
+// the gateway
+const TenantIDHeader = "X-Tenant-ID"
+
+// maxRequestIDLength bounds caller-supplied IDs, which end up in logs and
+// request_logs
+const maxRequestIDLength = 128
+
+// maxTenantIDLength is the width of request_logs.tenant_id
+const maxTenantIDLength = 64
+
+// inboundTrace reads the caller's W3C traceparent, tracestate and baggage
+// headers. It is used whether or not tracing is enabled, so request logs
+// carry the gateway's trace ID either way.
+var inboundTrace = propagation.NewCompositeTextMapPropagator(
+	propagation.TraceContext{},
+	propagation.Baggage{},
+)
+
 type responseWriter struct {
 	http.ResponseWriter
 	statusCode int
@@ -28,21 +59,38 @@ func LoggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		startTime := time.Now()
 
-		// Generate request ID
-		requestID := uuid.New().String()
+		// Use the caller's request ID if it is usable, otherwise generate one
+		requestID := r.Header.Get(RequestIDHeader)
+		if !validID(requestID, maxRequestIDLength) {
+			requestID = uuid.New().String()
+		}
 		ctx := context.WithValue(r.Context(), RequestIDKey, requestID)
+		// Only the gateway may say which tenant a request is for, as
+		// anyone else could claim to be any tenant. A tenant ID that would
+		// break log lines or not fit request_logs is ignored, as if none
+		// had been forwarded.
+		if tenantID := r.Header.Get(TenantIDHeader); fromTrustedProxy(r) && validID(tenantID, maxTenantIDLength) {
+			ctx = context.WithValue(ctx, TenantIDKey, tenantID)
+		}
+		// Continue the caller's trace: spans started for this request
+		// become children of the span in its traceparent
+		ctx = inboundTrace.Extract(ctx, propagation.HeaderCarrier(r.Header))
 		r = r.WithContext(ctx)
+		w.Header().Set(RequestIDHeader, requestID)
+		attrs := []any{"request_id", requestID}
+		if remote := trace.SpanContextFromContext(ctx); remote.IsValid() {
+			attrs = append(attrs, "trace_id", remote.TraceID().String())
+		}
 
 		// Wrap response writer to capture status code
 		rw := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
 
 		// Log request start
-		slog.Info("request started",
-			"request_id", requestID,
+		slog.Info("request started", append(attrs,
 			"method", r.Method,
 			"path", r.URL.Path,
 			"remote_addr", r.RemoteAddr,
-		)
+		)...)
 
 		// Call next handler
 		next.ServeHTTP(rw, r)
@@ -51,16 +99,30 @@ func LoggingMiddleware(next http.Handler) http.Handler {
 		duration := time.Since(startTime)
 
 		// Log request completion
-		slog.Info("request completed",
-			"request_id", requestID,
+		slog.Info("request completed", append(attrs,
 			"method", r.Method,
 			"path", r.URL.Path,
 			"status", rw.statusCode,
 			"duration_ms", duration.Milliseconds(),
-		)
+		)...)
 	})
 }
 
+// validID accepts non-empty IDs of up to maxLength bytes of printable
+// ASCII without spaces, so a supplied ID can't break log lines or header
+// values
+func validID(id string, maxLength int) bool {
+	if id == "" || len(id) > maxLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
 // GetRequestID retrieves the request ID from context
 func GetRequestID(ctx context.Context) string {
 	if requestID, ok := ctx.Value(RequestIDKey).(string); ok {
@@ -68,3 +130,27 @@ func GetRequestID(ctx context.Context) string {
 	}
 	return ""
 }
+
+// GetTenantID returns the tenant forwarded with the request, or "" if none
+// or an invalid one was
+func GetTenantID(ctx context.Context) string {
+	if tenantID, ok := ctx.Value(TenantIDKey).(string); ok {
+		return tenantID
+	}
+	return ""
+}
+
+// WithAPIKeyID returns ctx carrying the ID of the API key a request
+// authenticated with, for request logs
+func WithAPIKeyID(ctx context.Context, apiKeyID string) context.Context {
+	return context.WithValue(ctx, APIKeyIDKey, apiKeyID)
+}
+
+// GetAPIKeyID returns the ID of the API key the request authenticated
+// with, or "" for an unauthenticated request
+func GetAPIKeyID(ctx context.Context) string {
+	if apiKeyID, ok := ctx.Value(APIKeyIDKey).(string); ok {
+		return apiKeyID
+	}
+	return ""
+}
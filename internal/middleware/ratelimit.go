@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/chesskiss/btc-service/internal/metrics"
+	"github.com/chesskiss/btc-service/internal/ratelimit"
+)
+
+// UsageRecorder counts the requests made with each API key
+type UsageRecorder interface {
+	Record(apiKeyID string, limited bool)
+}
+
+// RateLimit takes a token for each request under /api/ from its caller's
+// bucket: the API key it authenticated with, or else its client IP. When
+// the bucket is empty the request is rejected with 429 and Retry-After.
+// Every response carries X-RateLimit-Limit and X-RateLimit-Remaining. If
+// the limiter fails, as when Redis is down, requests are let through.
+// usage, which may be nil, counts requests made with an API key.
+func RateLimit(limiter ratelimit.Limiter, usage UsageRecorder, reject RejectFunc) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !strings.HasPrefix(r.URL.Path, "/api/") {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			apiKeyID := GetAPIKeyID(r.Context())
+			subject, bucket := "api_key", "key:"+apiKeyID
+			if apiKeyID == "" {
+				subject, bucket = "ip", "ip:"+ClientIP(r)
+			}
+
+			decision, err := limiter.Allow(r.Context(), bucket)
+			if err != nil {
+				metrics.RateLimitDecisionsTotal.WithLabelValues(subject, "error").Inc()
+				if apiKeyID != "" && usage != nil {
+					usage.Record(apiKeyID, false)
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("X-RateLimit-Limit", strconv.Itoa(decision.Limit))
+			w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(decision.Remaining))
+			if apiKeyID != "" && usage != nil {
+				usage.Record(apiKeyID, !decision.Allowed)
+			}
+			if !decision.Allowed {
+				metrics.RateLimitDecisionsTotal.WithLabelValues(subject, "limited").Inc()
+				retryAfter := max(int(math.Ceil(decision.RetryAfter.Seconds())), 1)
+				w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+				reject(w, r, http.StatusTooManyRequests, "rate limit exceeded; retry after "+strconv.Itoa(retryAfter)+"s")
+				return
+			}
+			metrics.RateLimitDecisionsTotal.WithLabelValues(subject, "allowed").Inc()
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chesskiss/btc-service/internal/metrics"
+)
+
+// ShedLoad caps the requests under /api/ being served at once at
+// maxInFlight. Requests arriving while that many are in flight are
+// rejected at once with 503 and Retry-After, instead of queueing and
+// piling more calls onto Kraken and the database; health checks and
+// metrics are never shed.
+func ShedLoad(maxInFlight int, retryAfter time.Duration, reject RejectFunc) func(http.Handler) http.Handler {
+	slots := make(chan struct{}, maxInFlight)
+	retryAfterSeconds := strconv.Itoa(max(int(math.Ceil(retryAfter.Seconds())), 1))
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !strings.HasPrefix(r.URL.Path, "/api/") {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			select {
+			case slots <- struct{}{}:
+			default:
+				metrics.HTTPRequestsShedTotal.Inc()
+				w.Header().Set("Retry-After", retryAfterSeconds)
+				reject(w, r, http.StatusServiceUnavailable,
+					"server is at its limit of "+strconv.Itoa(maxInFlight)+" requests in flight; retry later")
+				return
+			}
+			metrics.HTTPRequestsInFlight.Inc()
+			defer func() {
+				metrics.HTTPRequestsInFlight.Dec()
+				<-slots
+			}()
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,433 @@
+package openapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/chesskiss/btc-service/handlers"
+	"github.com/chesskiss/btc-service/internal/database"
+	internalHandlers "github.com/chesskiss/btc-service/internal/handlers"
+	"github.com/chesskiss/btc-service/internal/middleware"
+	"github.com/chesskiss/btc-service/internal/problem"
+	"github.com/chesskiss/btc-service/internal/subsystems"
+	"github.com/chesskiss/btc-service/internal/version"
+	"github.com/chesskiss/btc-service/services"
+)
+
+// Document is an OpenAPI 3 document
+type Document struct {
+	OpenAPI    string               `json:"openapi"`
+	Info       Info                 `json:"info"`
+	Paths      map[string]*PathItem `json:"paths"`
+	Components Components           `json:"components"`
+	// Security lists the accepted schemes; an empty requirement makes
+	// authentication optional, as it is unless AUTH_ENABLED is set
+	Security []map[string][]string `json:"security,omitempty"`
+}
+
+type Info struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+type Components struct {
+	Schemas         map[string]*Schema         `json:"schemas"`
+	SecuritySchemes map[string]*SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+type SecurityScheme struct {
+	Type        string `json:"type"`
+	Name        string `json:"name"`
+	In          string `json:"in"`
+	Description string `json:"description,omitempty"`
+}
+
+type PathItem struct {
+	Get    *Operation `json:"get,omitempty"`
+	Post   *Operation `json:"post,omitempty"`
+	Delete *Operation `json:"delete,omitempty"`
+}
+
+type Operation struct {
+	OperationID string               `json:"operationId"`
+	Summary     string               `json:"summary"`
+	Tags        []string             `json:"tags,omitempty"`
+	Parameters  []Parameter          `json:"parameters,omitempty"`
+	RequestBody *RequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*Response `json:"responses"`
+	// Security overrides the document's requirements for this operation
+	Security []map[string][]string `json:"security,omitempty"`
+}
+
+type RequestBody struct {
+	Required bool                  `json:"required"`
+	Content  map[string]*MediaType `json:"content"`
+}
+
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required"`
+	Schema      *Schema `json:"schema"`
+}
+
+type Response struct {
+	Description string                `json:"description"`
+	Content     map[string]*MediaType `json:"content,omitempty"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+var (
+	specOnce sync.Once
+	specJSON []byte
+	specErr  error
+)
+
+// Spec builds the OpenAPI document for all public endpoints from the Go
+// types the handlers encode
+func Spec() *Document {
+	sr := newSchemaRegistry()
+
+	jsonResponse := func(description string, body interface{}) *Response {
+		return &Response{
+			Description: description,
+			Content: map[string]*MediaType{
+				"application/json": {Schema: sr.schemaOf(body)},
+			},
+		}
+	}
+	jsonBody := func(body interface{}) *RequestBody {
+		return &RequestBody{
+			Required: true,
+			Content: map[string]*MediaType{
+				"application/json": {Schema: sr.schemaOf(body)},
+			},
+		}
+	}
+	problemResponse := func(description string) *Response {
+		return &Response{
+			Description: description,
+			Content: map[string]*MediaType{
+				problem.ContentType: {Schema: sr.schemaOf(problem.Details{})},
+			},
+		}
+	}
+	queryParam := func(name, description string, schema *Schema) Parameter {
+		return Parameter{Name: name, In: "query", Description: description, Schema: schema}
+	}
+	stringSchema := &Schema{Type: "string"}
+	cursorParam := queryParam("cursor", "next_cursor from the previous page; only valid with the same sort", stringSchema)
+	fieldsParam := queryParam("fields", "Comma-separated attributes to return for each list entry, e.g. pair,amount; defaults to all", stringSchema)
+	integerSchema := &Schema{Type: "integer", Format: "int32"}
+	dateTimeSchema := &Schema{Type: "string", Format: "date-time"}
+	subsystemSchema := &Schema{Type: "string", Enum: subsystems.Names}
+	formatSchema := &Schema{Type: "string", Enum: []string{"json", "csv", "protobuf", "msgpack"}}
+	maxAgeParam := queryParam("max_age", "Oldest cached price, in seconds, to accept; older ones are fetched live. Rate limited (CACHE_BYPASS_PER_MINUTE)", integerSchema)
+	freshParam := queryParam("fresh", "true forces a live fetch, like max_age=0. Rate limited (CACHE_BYPASS_PER_MINUTE)", &Schema{Type: "boolean"})
+
+	doc := &Document{
+		OpenAPI: "3.0.3",
+		Info: Info{
+			Title:       "Bitcoin LTP Service",
+			Description: "Last traded prices for Bitcoin across currency pairs, sourced from Kraken.",
+			Version:     "1.0.0",
+		},
+		Paths: map[string]*PathItem{
+			"/api/v1/ltp": {
+				Get: &Operation{
+					OperationID: "getLTP",
+					Summary:     "Get the last traded price for one or more BTC pairs",
+					Tags:        []string{"prices"},
+					Parameters: []Parameter{
+						queryParam("pairs", "Comma-separated pairs, e.g. BTC/USD,BTC/EUR; aliases such as XBT/USD, btc-usd and BTCUSD are accepted. Defaults to BTC/USD, BTC/EUR and BTC/CHF.", stringSchema),
+						queryParam("format", "Response format; csv returns pair,price,timestamp rows, protobuf a btcservice.ltp.v1.LTPResponse message and msgpack the JSON body as MessagePack (also selected by Accept)", formatSchema),
+						queryParam("precision", "Decimal places to round prices to (0-8); defaults to the server's PRICE_PRECISION", integerSchema),
+						maxAgeParam,
+						freshParam,
+						fieldsParam,
+					},
+					Responses: map[string]*Response{
+						"200": jsonResponse("Prices for the requested pairs; pairs that failed are omitted", services.LTPResponse{}),
+						"400": problemResponse("Unsupported format, invalid precision, unknown field, or none of the requested pairs are supported (code invalid_pair)"),
+						"429": problemResponse("Over the caller's rate limit or too many requests bypassing the cache with max_age or fresh (code rate_limited; see Retry-After), or the exchange rate limited every price fetch (code upstream_rate_limited)"),
+						"503": problemResponse("No prices could be fetched from the exchange (code upstream_unavailable)"),
+					},
+				},
+			},
+			"/api/v2/ltp": {
+				Get: &Operation{
+					OperationID: "getLTPV2",
+					Summary:     "Get last traded prices as decimal strings with timestamp, source and age",
+					Tags:        []string{"prices"},
+					Parameters: []Parameter{
+						queryParam("pairs", "Comma-separated pairs, e.g. BTC/USD,BTC/EUR; aliases such as XBT/USD, btc-usd and BTCUSD are accepted. Defaults to BTC/USD, BTC/EUR and BTC/CHF.", stringSchema),
+						queryParam("format", "Response format; csv returns pair,price,timestamp rows, protobuf a btcservice.ltp.v1.LTPResponse message and msgpack the JSON body as MessagePack (also selected by Accept)", formatSchema),
+						queryParam("precision", "Decimal places to round prices to (0-8); defaults to the server's PRICE_PRECISION", integerSchema),
+						maxAgeParam,
+						freshParam,
+						fieldsParam,
+					},
+					Responses: map[string]*Response{
+						"200": jsonResponse("Prices for the requested pairs; pairs that failed are omitted", handlers.LTPV2Response{}),
+						"400": problemResponse("Unsupported format, invalid precision, unknown field, or none of the requested pairs are supported (code invalid_pair)"),
+						"429": problemResponse("Over the caller's rate limit or too many requests bypassing the cache with max_age or fresh (code rate_limited; see Retry-After), or the exchange rate limited every price fetch (code upstream_rate_limited)"),
+						"503": problemResponse("No prices could be fetched from the exchange (code upstream_unavailable)"),
+					},
+				},
+			},
+			"/api/v1/portfolio/value": {
+				Post: &Operation{
+					OperationID: "valuePortfolio",
+					Summary:     "Value BTC and fiat holdings in one currency at the latest prices",
+					Tags:        []string{"prices"},
+					RequestBody: jsonBody(internalHandlers.PortfolioValueRequest{}),
+					Responses: map[string]*Response{
+						"200": jsonResponse("Value of each holding and the total, as decimal strings", internalHandlers.PortfolioValueResponse{}),
+						"400": problemResponse("Invalid body, or an asset Kraken does not price"),
+						"503": problemResponse("Prices could not be fetched from the exchange"),
+					},
+				},
+			},
+			"/api/v1/alerts": {
+				Get: &Operation{
+					OperationID: "listAlerts",
+					Summary:     "List price alert subscriptions",
+					Tags:        []string{"alerts"},
+					Parameters: []Parameter{
+						queryParam("limit", "Page size, 1-500 (default 50)", integerSchema),
+						queryParam("sort", "Sort field, - prefix for descending: id (default), created_at, pair or threshold", stringSchema),
+						cursorParam,
+						fieldsParam,
+					},
+					Responses: map[string]*Response{
+						"200": jsonResponse("Alert subscriptions, without their secrets", internalHandlers.AlertSubscriptionsResponse{}),
+						"400": problemResponse("Invalid limit, sort, cursor or field"),
+						"503": problemResponse("Alert subscriptions are unavailable"),
+					},
+				},
+				Post: &Operation{
+					OperationID: "createAlert",
+					Summary:     "Subscribe a webhook to a pair crossing a price threshold",
+					Tags:        []string{"alerts"},
+					RequestBody: jsonBody(internalHandlers.AlertSubscriptionRequest{}),
+					Responses: map[string]*Response{
+						"201": jsonResponse("Subscription created; the secret signing its webhooks is only returned here", database.AlertSubscription{}),
+						"400": problemResponse("Invalid subscription"),
+						"503": problemResponse("Alert subscriptions are unavailable"),
+					},
+				},
+			},
+			"/api/v1/alerts/{id}": {
+				Delete: &Operation{
+					OperationID: "deleteAlert",
+					Summary:     "Delete a price alert subscription",
+					Tags:        []string{"alerts"},
+					Parameters: []Parameter{
+						{Name: "id", In: "path", Required: true, Schema: &Schema{Type: "integer", Format: "int64"}},
+					},
+					Responses: map[string]*Response{
+						"204": {Description: "Subscription and its delivery history deleted"},
+						"400": problemResponse("Invalid ID"),
+						"404": problemResponse("No such subscription"),
+						"503": problemResponse("Alert subscriptions are unavailable"),
+					},
+				},
+			},
+			"/api/v1/alerts/{id}/deliveries": {
+				Get: &Operation{
+					OperationID: "listAlertDeliveries",
+					Summary:     "List a subscription's webhook deliveries, newest first",
+					Tags:        []string{"alerts"},
+					Parameters: []Parameter{
+						{Name: "id", In: "path", Required: true, Schema: &Schema{Type: "integer", Format: "int64"}},
+						queryParam("limit", "Page size, 1-500 (default 50)", integerSchema),
+						fieldsParam,
+					},
+					Responses: map[string]*Response{
+						"200": jsonResponse("Deliveries with their status, attempts and last error", internalHandlers.AlertDeliveriesResponse{}),
+						"400": problemResponse("Invalid ID, limit or field"),
+						"503": problemResponse("Alert deliveries are unavailable"),
+					},
+				},
+			},
+			"/health": {
+				Get: &Operation{
+					OperationID: "getHealth",
+					Summary:     "Liveness probe with the state of Redis, PostgreSQL and Kraken",
+					Tags:        []string{"health"},
+					Responses: map[string]*Response{
+						"200": jsonResponse("Service is alive; status is degraded if any component is not ok", internalHandlers.HealthResponse{}),
+					},
+				},
+			},
+			"/version": {
+				Get: &Operation{
+					OperationID: "getVersion",
+					Summary:     "Build information: version, commit, build time, Go version and enabled features",
+					Tags:        []string{"health"},
+					Responses: map[string]*Response{
+						"200": jsonResponse("Build information", version.Info{}),
+					},
+				},
+			},
+			"/ready": {
+				Get: &Operation{
+					OperationID: "getReady",
+					Summary:     "Readiness probe checking the database and cache",
+					Tags:        []string{"health"},
+					Responses: map[string]*Response{
+						"200": jsonResponse("Service is ready", internalHandlers.ReadinessResponse{}),
+						"503": jsonResponse("A dependency has failed repeated probes", internalHandlers.ReadinessResponse{}),
+					},
+				},
+			},
+			"/api/v1/admin/requests": {
+				Get: &Operation{
+					OperationID: "listRequestLogs",
+					Summary:     "List logged requests, newest first",
+					Tags:        []string{"admin"},
+					Parameters: []Parameter{
+						queryParam("from", "Only requests at or after this time (RFC3339)", dateTimeSchema),
+						queryParam("to", "Only requests before this time (RFC3339)", dateTimeSchema),
+						queryParam("status", "HTTP status code", integerSchema),
+						queryParam("error", "Only requests with (true) or without (false) errors", &Schema{Type: "boolean"}),
+						queryParam("pair", "Only requests with an outcome for this pair; aliases such as btcusd are accepted", stringSchema),
+						queryParam("api_key_id", "Only requests authenticated with this API key", stringSchema),
+						queryParam("limit", "Page size, 1-500 (default 50)", integerSchema),
+						queryParam("offset", "Number of entries to skip; cannot be combined with cursor", integerSchema),
+						queryParam("sort", "Sort field, - prefix for descending: timestamp, id, status_code or response_time_ms (default -timestamp)", stringSchema),
+						cursorParam,
+						fieldsParam,
+					},
+					Responses: map[string]*Response{
+						"200": jsonResponse("Matching request logs", internalHandlers.RequestLogsResponse{}),
+						"400": problemResponse("Invalid filter"),
+						"503": problemResponse("Request logs are unavailable"),
+					},
+				},
+			},
+			"/api/v1/admin/purge": {
+				Post: &Operation{
+					OperationID: "purgeStoredData",
+					Summary:     "Soft-delete or permanently purge the request logs of a tenant, client IP or API key",
+					Tags:        []string{"admin"},
+					RequestBody: jsonBody(internalHandlers.PurgeRequestBody{}),
+					Responses: map[string]*Response{
+						"200": jsonResponse("Purge completed and audited", database.PurgeResult{}),
+						"400": problemResponse("Invalid purge request"),
+						"503": problemResponse("Purge failed"),
+					},
+				},
+			},
+			"/api/v1/admin/subsystems": {
+				Get: &Operation{
+					OperationID: "listSubsystems",
+					Summary:     "List background subsystems and whether each is paused",
+					Tags:        []string{"admin"},
+					Responses: map[string]*Response{
+						"200": jsonResponse("State of every subsystem", internalHandlers.SubsystemsResponse{}),
+					},
+				},
+			},
+			"/api/v1/admin/subsystems/{name}/pause": {
+				Post: &Operation{
+					OperationID: "pauseSubsystem",
+					Summary:     "Pause a background subsystem on every replica",
+					Tags:        []string{"admin"},
+					Parameters: []Parameter{
+						{Name: "name", In: "path", Required: true, Schema: subsystemSchema},
+					},
+					Responses: map[string]*Response{
+						"200": jsonResponse("State of every subsystem", internalHandlers.SubsystemsResponse{}),
+						"404": problemResponse("Unknown subsystem"),
+						"503": problemResponse("State could not be persisted to Redis"),
+					},
+				},
+			},
+			"/api/v1/admin/subsystems/{name}/resume": {
+				Post: &Operation{
+					OperationID: "resumeSubsystem",
+					Summary:     "Resume a paused background subsystem",
+					Tags:        []string{"admin"},
+					Parameters: []Parameter{
+						{Name: "name", In: "path", Required: true, Schema: subsystemSchema},
+					},
+					Responses: map[string]*Response{
+						"200": jsonResponse("State of every subsystem", internalHandlers.SubsystemsResponse{}),
+						"404": problemResponse("Unknown subsystem"),
+						"503": problemResponse("State could not be persisted to Redis"),
+					},
+				},
+			},
+		},
+	}
+
+	// With AUTH_ENABLED every /api/ operation may be refused for its key,
+	// with RATE_LIMIT_ENABLED for its caller's rate, and with
+	// SERVER_MAX_IN_FLIGHT for the server's load
+	unauthorized := problemResponse("Missing or unknown X-API-Key (code unauthorized), when authentication is enabled")
+	rateLimited := problemResponse("Over the caller's rate limit (code rate_limited; see Retry-After), when rate limiting is enabled")
+	overloaded := problemResponse("The server is at its limit of requests in flight (code overloaded; see Retry-After)")
+	// Admin operations always need an admin key, whatever AUTH_ENABLED says
+	adminUnauthorized := problemResponse("Missing or unknown X-API-Key (code unauthorized)")
+	forbidden := problemResponse("The X-API-Key is not an admin key (code forbidden)")
+	// Alert subscriptions need an owner: an API key, or a tenant forwarded
+	// by a trusted proxy
+	alertsUnauthorized := problemResponse("No X-API-Key, nor X-Tenant-ID from a trusted proxy (code unauthorized)")
+	for path, item := range doc.Paths {
+		if !strings.HasPrefix(path, "/api/") {
+			continue
+		}
+		for _, op := range []*Operation{item.Get, item.Post, item.Delete} {
+			if op == nil {
+				continue
+			}
+			op.Responses["401"] = unauthorized
+			if strings.HasPrefix(path, "/api/v1/admin/") {
+				op.Responses["401"] = adminUnauthorized
+				op.Responses["403"] = forbidden
+				op.Security = []map[string][]string{{"apiKey": {}}}
+			}
+			if strings.HasPrefix(path, "/api/v1/alerts") {
+				op.Responses["401"] = alertsUnauthorized
+			}
+			if _, ok := op.Responses["429"]; !ok {
+				op.Responses["429"] = rateLimited
+			}
+			if _, ok := op.Responses["503"]; !ok {
+				op.Responses["503"] = overloaded
+			}
+		}
+	}
+
+	doc.Components = Components{
+		Schemas: sr.components,
+		SecuritySchemes: map[string]*SecurityScheme{
+			"apiKey": {Type: "apiKey", Name: middleware.APIKeyHeader, In: "header", Description: "One of AUTH_API_KEYS; required when AUTH_ENABLED is set"},
+		},
+	}
+	doc.Security = []map[string][]string{{"apiKey": {}}, {}}
+	return doc
+}
+
+// Handler serves the OpenAPI document as JSON
+func Handler(w http.ResponseWriter, r *http.Request) {
+	specOnce.Do(func() {
+		specJSON, specErr = json.Marshal(Spec())
+	})
+
+	if specErr != nil {
+		problem.Write(w, r, problem.New(http.StatusInternalServerError, problem.CodeInternal, "failed to build OpenAPI spec"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(specJSON)
+}
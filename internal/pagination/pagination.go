@@ -0,0 +1,159 @@
+// Package pagination implements the limit, cursor and sort query
+// parameters shared by the list endpoints. Pages are keyset-based: a
+// cursor holds the sort value and ID of the last entry already returned.
+package pagination
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Page size bounds shared by every list endpoint
+const (
+	DefaultLimit = 50
+	MaxLimit     = 500
+)
+
+// Sort orders a list by one field, ties broken by ID in the same direction
+type Sort struct {
+	Field string
+	Desc  bool
+}
+
+// String renders the sort as it appears in the query, with a leading -
+// for descending order
+func (s Sort) String() string {
+	if s.Desc {
+		return "-" + s.Field
+	}
+	return s.Field
+}
+
+// Sorts maps each field a list can be sorted by to a check of the values
+// its cursors hold, so that a forged cursor is refused rather than failing
+// the query
+type Sorts map[string]func(value string) error
+
+// Int32Value accepts the values of an INT column
+func Int32Value(value string) error {
+	_, err := strconv.ParseInt(value, 10, 32)
+	return err
+}
+
+// Int64Value accepts the values of a BIGINT column
+func Int64Value(value string) error {
+	_, err := strconv.ParseInt(value, 10, 64)
+	return err
+}
+
+// FloatValue accepts the finite values of a DOUBLE PRECISION column
+func FloatValue(value string) error {
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return err
+	}
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return errors.New("not a finite number")
+	}
+	return nil
+}
+
+// TimeValue accepts timestamps in RFC 3339 format
+func TimeValue(value string) error {
+	_, err := time.Parse(time.RFC3339Nano, value)
+	return err
+}
+
+// TextValue accepts any value of a text column
+func TextValue(string) error {
+	return nil
+}
+
+// Cursor is the position after which the next page starts
+type Cursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    int64  `json:"id"`
+}
+
+// Page selects up to Limit entries in Sort order, after After when set
+type Page struct {
+	Limit int
+	Sort  Sort
+	After *Cursor
+}
+
+// Parse reads limit, sort and cursor from the query. sorts lists the
+// fields a client may sort by; defaultSort is used when none is given.
+func Parse(r *http.Request, sorts Sorts, defaultSort Sort) (Page, error) {
+	query := r.URL.Query()
+	page := Page{Limit: DefaultLimit, Sort: defaultSort}
+
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > MaxLimit {
+			return page, fmt.Errorf("invalid limit: must be between 1 and %d", MaxLimit)
+		}
+		page.Limit = limit
+	}
+
+	if v := query.Get("sort"); v != "" {
+		sort := Sort{Field: strings.TrimPrefix(v, "-"), Desc: strings.HasPrefix(v, "-")}
+		if _, ok := sorts[sort.Field]; !ok {
+			return page, fmt.Errorf("invalid sort %q: must be one of %s, optionally prefixed with -", v, strings.Join(slices.Sorted(maps.Keys(sorts)), ", "))
+		}
+		page.Sort = sort
+	}
+
+	if v := query.Get("cursor"); v != "" {
+		cursor, err := decodeCursor(v)
+		if err != nil {
+			return page, fmt.Errorf("invalid cursor")
+		}
+		if cursor.Sort != page.Sort.String() {
+			return page, fmt.Errorf("invalid cursor: it was issued for sort %q", cursor.Sort)
+		}
+		if check, ok := sorts[page.Sort.Field]; ok && check(cursor.Value) != nil {
+			return page, fmt.Errorf("invalid cursor")
+		}
+		page.After = &cursor
+	}
+
+	return page, nil
+}
+
+// NextCursor returns the cursor for the page following one that returned
+// n entries, or "" when that page was the last. last reports the sort value
+// and ID of the final entry.
+func (p Page) NextCursor(n int, last func() (string, int64)) string {
+	if n < p.Limit || n == 0 {
+		return ""
+	}
+	value, id := last()
+	data, err := json.Marshal(Cursor{Sort: p.Sort.String(), Value: value, ID: id})
+	if err != nil {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(encoded string) (Cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return Cursor{}, err
+	}
+	var cursor Cursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return Cursor{}, err
+	}
+	return cursor, nil
+}
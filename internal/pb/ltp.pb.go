@@ -0,0 +1,307 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.10
+// 	protoc        (unknown)
+// source: ltp.proto
+
+package pb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// LTPResponse mirrors the JSON body of /api/v1/ltp and /api/v2/ltp
+type LTPResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Ltp           []*PairPrice           `protobuf:"bytes,1,rep,name=ltp,proto3" json:"ltp,omitempty"`
+	Errors        []*PairError           `protobuf:"bytes,2,rep,name=errors,proto3" json:"errors,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LTPResponse) Reset() {
+	*x = LTPResponse{}
+	mi := &file_ltp_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LTPResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LTPResponse) ProtoMessage() {}
+
+func (x *LTPResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_ltp_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LTPResponse.ProtoReflect.Descriptor instead.
+func (*LTPResponse) Descriptor() ([]byte, []int) {
+	return file_ltp_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *LTPResponse) GetLtp() []*PairPrice {
+	if x != nil {
+		return x.Ltp
+	}
+	return nil
+}
+
+func (x *LTPResponse) GetErrors() []*PairError {
+	if x != nil {
+		return x.Errors
+	}
+	return nil
+}
+
+type PairPrice struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Pair  string                 `protobuf:"bytes,1,opt,name=pair,proto3" json:"pair,omitempty"`
+	// amount is the price as a float; price is the exact decimal string
+	// reported by the exchange
+	Amount     float64                `protobuf:"fixed64,2,opt,name=amount,proto3" json:"amount,omitempty"`
+	Price      string                 `protobuf:"bytes,3,opt,name=price,proto3" json:"price,omitempty"`
+	Timestamp  *timestamppb.Timestamp `protobuf:"bytes,4,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	Source     string                 `protobuf:"bytes,5,opt,name=source,proto3" json:"source,omitempty"`
+	AgeSeconds int64                  `protobuf:"varint,6,opt,name=age_seconds,json=ageSeconds,proto3" json:"age_seconds,omitempty"`
+	Cached     bool                   `protobuf:"varint,7,opt,name=cached,proto3" json:"cached,omitempty"`
+	// stale marks a last known price served because Kraken is unavailable
+	Stale         bool `protobuf:"varint,8,opt,name=stale,proto3" json:"stale,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PairPrice) Reset() {
+	*x = PairPrice{}
+	mi := &file_ltp_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PairPrice) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PairPrice) ProtoMessage() {}
+
+func (x *PairPrice) ProtoReflect() protoreflect.Message {
+	mi := &file_ltp_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PairPrice.ProtoReflect.Descriptor instead.
+func (*PairPrice) Descriptor() ([]byte, []int) {
+	return file_ltp_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *PairPrice) GetPair() string {
+	if x != nil {
+		return x.Pair
+	}
+	return ""
+}
+
+func (x *PairPrice) GetAmount() float64 {
+	if x != nil {
+		return x.Amount
+	}
+	return 0
+}
+
+func (x *PairPrice) GetPrice() string {
+	if x != nil {
+		return x.Price
+	}
+	return ""
+}
+
+func (x *PairPrice) GetTimestamp() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Timestamp
+	}
+	return nil
+}
+
+func (x *PairPrice) GetSource() string {
+	if x != nil {
+		return x.Source
+	}
+	return ""
+}
+
+func (x *PairPrice) GetAgeSeconds() int64 {
+	if x != nil {
+		return x.AgeSeconds
+	}
+	return 0
+}
+
+func (x *PairPrice) GetCached() bool {
+	if x != nil {
+		return x.Cached
+	}
+	return false
+}
+
+func (x *PairPrice) GetStale() bool {
+	if x != nil {
+		return x.Stale
+	}
+	return false
+}
+
+type PairError struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Pair          string                 `protobuf:"bytes,1,opt,name=pair,proto3" json:"pair,omitempty"`
+	Reason        string                 `protobuf:"bytes,2,opt,name=reason,proto3" json:"reason,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PairError) Reset() {
+	*x = PairError{}
+	mi := &file_ltp_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PairError) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PairError) ProtoMessage() {}
+
+func (x *PairError) ProtoReflect() protoreflect.Message {
+	mi := &file_ltp_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PairError.ProtoReflect.Descriptor instead.
+func (*PairError) Descriptor() ([]byte, []int) {
+	return file_ltp_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *PairError) GetPair() string {
+	if x != nil {
+		return x.Pair
+	}
+	return ""
+}
+
+func (x *PairError) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+var File_ltp_proto protoreflect.FileDescriptor
+
+const file_ltp_proto_rawDesc = "" +
+	"\n" +
+	"\tltp.proto\x12\x11btcservice.ltp.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"s\n" +
+	"\vLTPResponse\x12.\n" +
+	"\x03ltp\x18\x01 \x03(\v2\x1c.btcservice.ltp.v1.PairPriceR\x03ltp\x124\n" +
+	"\x06errors\x18\x02 \x03(\v2\x1c.btcservice.ltp.v1.PairErrorR\x06errors\"\xee\x01\n" +
+	"\tPairPrice\x12\x12\n" +
+	"\x04pair\x18\x01 \x01(\tR\x04pair\x12\x16\n" +
+	"\x06amount\x18\x02 \x01(\x01R\x06amount\x12\x14\n" +
+	"\x05price\x18\x03 \x01(\tR\x05price\x128\n" +
+	"\ttimestamp\x18\x04 \x01(\v2\x1a.google.protobuf.TimestampR\ttimestamp\x12\x16\n" +
+	"\x06source\x18\x05 \x01(\tR\x06source\x12\x1f\n" +
+	"\vage_seconds\x18\x06 \x01(\x03R\n" +
+	"ageSeconds\x12\x16\n" +
+	"\x06cached\x18\a \x01(\bR\x06cached\x12\x14\n" +
+	"\x05stale\x18\b \x01(\bR\x05stale\"7\n" +
+	"\tPairError\x12\x12\n" +
+	"\x04pair\x18\x01 \x01(\tR\x04pair\x12\x16\n" +
+	"\x06reason\x18\x02 \x01(\tR\x06reasonB.Z,github.com/chesskiss/btc-service/internal/pbb\x06proto3"
+
+var (
+	file_ltp_proto_rawDescOnce sync.Once
+	file_ltp_proto_rawDescData []byte
+)
+
+func file_ltp_proto_rawDescGZIP() []byte {
+	file_ltp_proto_rawDescOnce.Do(func() {
+		file_ltp_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_ltp_proto_rawDesc), len(file_ltp_proto_rawDesc)))
+	})
+	return file_ltp_proto_rawDescData
+}
+
+var file_ltp_proto_msgTypes = make([]protoimpl.MessageInfo, 3)
+var file_ltp_proto_goTypes = []any{
+	(*LTPResponse)(nil),           // 0: btcservice.ltp.v1.LTPResponse
+	(*PairPrice)(nil),             // 1: btcservice.ltp.v1.PairPrice
+	(*PairError)(nil),             // 2: btcservice.ltp.v1.PairError
+	(*timestamppb.Timestamp)(nil), // 3: google.protobuf.Timestamp
+}
+var file_ltp_proto_depIdxs = []int32{
+	1, // 0: btcservice.ltp.v1.LTPResponse.ltp:type_name -> btcservice.ltp.v1.PairPrice
+	2, // 1: btcservice.ltp.v1.LTPResponse.errors:type_name -> btcservice.ltp.v1.PairError
+	3, // 2: btcservice.ltp.v1.PairPrice.timestamp:type_name -> google.protobuf.Timestamp
+	3, // [3:3] is the sub-list for method output_type
+	3, // [3:3] is the sub-list for method input_type
+	3, // [3:3] is the sub-list for extension type_name
+	3, // [3:3] is the sub-list for extension extendee
+	0, // [0:3] is the sub-list for field type_name
+}
+
+func init() { file_ltp_proto_init() }
+func file_ltp_proto_init() {
+	if File_ltp_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_ltp_proto_rawDesc), len(file_ltp_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   3,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_ltp_proto_goTypes,
+		DependencyIndexes: file_ltp_proto_depIdxs,
+		MessageInfos:      file_ltp_proto_msgTypes,
+	}.Build()
+	File_ltp_proto = out.File
+	file_ltp_proto_goTypes = nil
+	file_ltp_proto_depIdxs = nil
+}
@@ -0,0 +1,104 @@
+package problem
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/chesskiss/btc-service/internal/middleware"
+)
+
+// ContentType is the media type of RFC 7807 problem details
+const ContentType = "application/problem+json"
+
+// Machine-readable error codes
+const (
+	CodeInvalidParameter    = "invalid_parameter"
+	CodeInvalidRequestBody  = "invalid_request_body"
+	CodeRequestTooLarge     = "request_too_large"
+	CodeURITooLong          = "uri_too_long"
+	CodeHeadersTooLarge     = "headers_too_large"
+	CodeInvalidPair         = "invalid_pair"
+	CodeNotFound            = "not_found"
+	CodeUnsupportedFormat   = "unsupported_format"
+	CodeUpstreamUnavailable = "upstream_unavailable"
+	CodeUpstreamRateLimited = "upstream_rate_limited"
+	CodeStorageUnavailable  = "storage_unavailable"
+	CodeRateLimited         = "rate_limited"
+	CodeUnauthorized        = "unauthorized"
+	CodeForbidden           = "forbidden"
+	CodeOverloaded          = "overloaded"
+	CodeInternal            = "internal_error"
+)
+
+// Details is an RFC 7807 problem details body, extended with a stable
+// error code, the pairs that failed and the request ID
+type Details struct {
+	Type        string   `json:"type"`
+	Title       string   `json:"title"`
+	Status      int      `json:"status"`
+	Detail      string   `json:"detail,omitempty"`
+	Instance    string   `json:"instance,omitempty"`
+	Code        string   `json:"code"`
+	RequestID   string   `json:"request_id,omitempty"`
+	FailedPairs []string `json:"failed_pairs,omitempty"`
+}
+
+// New builds problem details for the given status and error code
+func New(status int, code, detail string) Details {
+	return Details{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+		Code:   code,
+	}
+}
+
+// WithFailedPairs attaches the pairs that caused the problem
+func (d Details) WithFailedPairs(pairs []string) Details {
+	d.FailedPairs = pairs
+	return d
+}
+
+// ForRequest fills in the request path and ID
+func (d Details) ForRequest(r *http.Request) Details {
+	d.Instance = r.URL.Path
+	d.RequestID = middleware.GetRequestID(r.Context())
+	return d
+}
+
+// Reject writes the problem for a request refused by a size limit, for
+// lack of an API key or by a rate limit; it matches middleware.RejectFunc
+func Reject(w http.ResponseWriter, r *http.Request, status int, detail string) {
+	code := CodeRequestTooLarge
+	switch status {
+	case http.StatusRequestURITooLong:
+		code = CodeURITooLong
+	case http.StatusRequestHeaderFieldsTooLarge:
+		code = CodeHeadersTooLarge
+	case http.StatusUnauthorized:
+		code = CodeUnauthorized
+	case http.StatusForbidden:
+		code = CodeForbidden
+	case http.StatusTooManyRequests:
+		code = CodeRateLimited
+	case http.StatusServiceUnavailable:
+		code = CodeStorageUnavailable
+	}
+	Write(w, r, New(status, code, detail))
+}
+
+// Shed writes the problem for a request shed because the server is at
+// capacity; it matches middleware.RejectFunc
+func Shed(w http.ResponseWriter, r *http.Request, status int, detail string) {
+	Write(w, r, New(status, CodeOverloaded, detail))
+}
+
+// Write sends the problem as application/problem+json
+func Write(w http.ResponseWriter, r *http.Request, d Details) {
+	d = d.ForRequest(r)
+
+	w.Header().Set("Content-Type", ContentType)
+	w.WriteHeader(d.Status)
+	json.NewEncoder(w).Encode(d)
+}
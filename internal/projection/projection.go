@@ -0,0 +1,124 @@
+// Package projection implements the fields query parameter, which trims
+// the elements of a list response down to the attributes a client asks for
+package projection
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// Fields is a validated selection of JSON field names; nil selects every
+// field
+type Fields []string
+
+// Parse reads the comma-separated fields query parameter, checking each
+// name against the JSON fields of element, a value of the list's element
+// type
+func Parse(r *http.Request, element interface{}) (Fields, error) {
+	value := r.URL.Query().Get("fields")
+	if value == "" {
+		return nil, nil
+	}
+
+	known := map[string]bool{}
+	var names []string
+	t := reflect.TypeOf(element)
+	for i := 0; i < t.NumField(); i++ {
+		if name, _, ok := jsonField(t.Field(i)); ok {
+			known[name] = true
+			names = append(names, name)
+		}
+	}
+
+	var fields Fields
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		if !known[field] {
+			return nil, fmt.Errorf("unknown field %q: must be one of %s", field, strings.Join(names, ", "))
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// Apply returns v, a response struct, with every element of its listField
+// list reduced to the selected fields. Other fields of v are kept, and v is
+// returned unchanged when no fields were selected.
+func (f Fields) Apply(v interface{}, listField string) interface{} {
+	if f == nil {
+		return v
+	}
+
+	selected := map[string]bool{}
+	for _, field := range f {
+		selected[field] = true
+	}
+
+	rv := reflect.ValueOf(v)
+	out := map[string]interface{}{}
+	for i := 0; i < rv.NumField(); i++ {
+		name, omitEmpty, ok := jsonField(rv.Type().Field(i))
+		fv := rv.Field(i)
+		if !ok || (omitEmpty && fv.IsZero()) {
+			continue
+		}
+		if name != listField || fv.Kind() != reflect.Slice {
+			out[name] = fv.Interface()
+			continue
+		}
+		if fv.IsNil() {
+			out[name] = nil
+			continue
+		}
+
+		items := make([]map[string]interface{}, 0, fv.Len())
+		for j := 0; j < fv.Len(); j++ {
+			items = append(items, project(fv.Index(j), selected))
+		}
+		out[name] = items
+	}
+	return out
+}
+
+// project keeps the selected fields of one struct element
+func project(element reflect.Value, selected map[string]bool) map[string]interface{} {
+	item := map[string]interface{}{}
+	for k := 0; k < element.NumField(); k++ {
+		name, omitEmpty, ok := jsonField(element.Type().Field(k))
+		fv := element.Field(k)
+		if !ok || !selected[name] || (omitEmpty && fv.IsZero()) {
+			continue
+		}
+		item[name] = fv.Interface()
+	}
+	return item
+}
+
+// jsonField returns the JSON name of an exported struct field, whether it
+// is omitempty, and false if it is not serialized
+func jsonField(field reflect.StructField) (string, bool, bool) {
+	if !field.IsExported() {
+		return "", false, false
+	}
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return "", false, false
+	}
+	parts := strings.Split(tag, ",")
+	name := parts[0]
+	if name == "" {
+		name = field.Name
+	}
+	omitEmpty := false
+	for _, opt := range parts[1:] {
+		if opt == "omitempty" {
+			omitEmpty = true
+		}
+	}
+	return name, omitEmpty, true
+}
@@ -0,0 +1,87 @@
+// Package ratelimit limits how often each API client may call the service
+// with token buckets, kept in Redis so every replica draws from the same
+// bucket, and counts each API key's requests for billing.
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Decision is the outcome of taking a token from a bucket
+type Decision struct {
+	Allowed bool
+	// Limit is the bucket size, and Remaining the whole tokens left in it
+	Limit     int
+	Remaining int
+	// RetryAfter is how long until a token is available, when not allowed
+	RetryAfter time.Duration
+}
+
+// Limiter takes a token from the bucket named by key. Buckets hold up to
+// burst tokens and refill at perMinute tokens a minute.
+type Limiter interface {
+	Allow(ctx context.Context, key string) (Decision, error)
+}
+
+// maxLocalBuckets is how many buckets Local holds before dropping full
+// ones, which are no different from a new bucket
+const maxLocalBuckets = 10000
+
+// Local is a Limiter whose buckets are kept in process memory, for
+// deployments without Redis. Each replica limits clients separately.
+type Local struct {
+	limit rate.Limit
+	burst int
+
+	mu      sync.Mutex
+	buckets map[string]*rate.Limiter
+}
+
+// NewLocal returns a Limiter refilling perMinute tokens a minute, up to
+// burst
+func NewLocal(perMinute, burst int) *Local {
+	return &Local{
+		limit:   rate.Limit(float64(perMinute) / 60),
+		burst:   burst,
+		buckets: make(map[string]*rate.Limiter),
+	}
+}
+
+// Allow takes a token from key's bucket; it never fails
+func (l *Local) Allow(ctx context.Context, key string) (Decision, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	bucket, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= maxLocalBuckets {
+			l.prune()
+		}
+		bucket = rate.NewLimiter(l.limit, l.burst)
+		l.buckets[key] = bucket
+	}
+
+	now := time.Now()
+	reservation := bucket.ReserveN(now, 1)
+	if delay := reservation.DelayFrom(now); delay > 0 {
+		reservation.CancelAt(now)
+		return Decision{Limit: l.burst, RetryAfter: delay}, nil
+	}
+	remaining := int(math.Floor(bucket.TokensAt(now)))
+	return Decision{Allowed: true, Limit: l.burst, Remaining: max(remaining, 0)}, nil
+}
+
+// prune drops the buckets that have refilled completely
+func (l *Local) prune() {
+	now := time.Now()
+	for key, bucket := range l.buckets {
+		if bucket.TokensAt(now) >= float64(l.burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/chesskiss/btc-service/internal/cache"
+)
+
+// takeToken refills the bucket in KEYS[1], a hash of its tokens and the
+// time they were counted, by ARGV[1] tokens a millisecond up to ARGV[2],
+// then takes one if it can. It reads Redis' clock so replicas with skewed
+// clocks agree, and lets an idle bucket expire once it would be full. It
+// returns whether a token was taken, the whole tokens left, and the
+// milliseconds until one is available.
+var takeToken = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1]) or burst
+local ts = tonumber(state[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate)
+
+local allowed = 0
+local wait = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	wait = math.ceil((1 - tokens) / rate)
+end
+
+redis.call('HSET', KEYS[1], 'tokens', string.format('%.6f', tokens), 'ts', string.format('%d', now))
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst / rate))
+return {allowed, math.floor(tokens), wait}
+`)
+
+// Redis is a Limiter whose buckets are kept in Redis, so a client's
+// requests to every replica draw from one bucket
+type Redis struct {
+	client    redis.UniversalClient
+	namespace string
+	perMs     float64
+	burst     int
+}
+
+// NewRedis returns a Limiter refilling perMinute tokens a minute, up to
+// burst, with buckets stored under namespace
+func NewRedis(client redis.UniversalClient, namespace string, perMinute, burst int) *Redis {
+	return &Redis{
+		client:    client,
+		namespace: namespace,
+		perMs:     float64(perMinute) / float64(time.Minute/time.Millisecond),
+		burst:     burst,
+	}
+}
+
+// Allow takes a token from key's bucket, stored as ratelimit:<key>
+func (l *Redis) Allow(ctx context.Context, key string) (Decision, error) {
+	bucket := cache.Key(l.namespace, "ratelimit:"+key)
+	result, err := takeToken.Run(ctx, l.client, []string{bucket},
+		strconv.FormatFloat(l.perMs, 'g', -1, 64), l.burst).Int64Slice()
+	if err != nil {
+		return Decision{}, fmt.Errorf("failed to take a rate limit token: %w", err)
+	}
+	if len(result) != 3 {
+		return Decision{}, fmt.Errorf("unexpected rate limit script result %v", result)
+	}
+
+	decision := Decision{
+		Allowed:   result[0] == 1,
+		Limit:     l.burst,
+		Remaining: int(max(result[1], 0)),
+	}
+	if !decision.Allowed {
+		decision.RetryAfter = time.Duration(max(result[2], 1)) * time.Millisecond
+	}
+	return decision, nil
+}
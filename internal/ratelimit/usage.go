@@ -0,0 +1,124 @@
+package ratelimit
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/chesskiss/btc-service/internal/database"
+)
+
+// UsageStore persists API key usage counts
+type UsageStore interface {
+	RecordAPIKeyUsage(ctx context.Context, usage []database.APIKeyUsage) error
+}
+
+type usageKey struct {
+	apiKeyID string
+	day      time.Time
+}
+
+// Usage counts requests per API key and UTC day in memory and adds them to
+// the store every flush interval, so counting costs a request no database
+// round trip. Counts that fail to be written are kept for the next flush.
+type Usage struct {
+	store UsageStore
+
+	mu     sync.Mutex
+	counts map[usageKey]*database.APIKeyUsage
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// StartUsage starts flushing counts to store every interval until Close
+func StartUsage(store UsageStore, interval time.Duration) *Usage {
+	u := &Usage{
+		store:  store,
+		counts: make(map[usageKey]*database.APIKeyUsage),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go u.run(interval)
+	return u
+}
+
+// Record counts a request made with apiKeyID, and whether it was rate
+// limited
+func (u *Usage) Record(apiKeyID string, limited bool) {
+	now := time.Now().UTC()
+	key := usageKey{apiKeyID: apiKeyID, day: time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	count, ok := u.counts[key]
+	if !ok {
+		count = &database.APIKeyUsage{APIKeyID: apiKeyID, Day: key.day}
+		u.counts[key] = count
+	}
+	count.Requests++
+	if limited {
+		count.RateLimited++
+	}
+}
+
+// Flush adds the counts recorded since the last flush to the store
+func (u *Usage) Flush(ctx context.Context) error {
+	u.mu.Lock()
+	counts := u.counts
+	u.counts = make(map[usageKey]*database.APIKeyUsage, len(counts))
+	u.mu.Unlock()
+	if len(counts) == 0 {
+		return nil
+	}
+
+	usage := make([]database.APIKeyUsage, 0, len(counts))
+	for _, count := range counts {
+		usage = append(usage, *count)
+	}
+	err := u.store.RecordAPIKeyUsage(ctx, usage)
+	if err == nil {
+		return nil
+	}
+
+	// Fold the unwritten counts back in with those recorded meanwhile
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for key, count := range counts {
+		if current, ok := u.counts[key]; ok {
+			current.Requests += count.Requests
+			current.RateLimited += count.RateLimited
+			continue
+		}
+		u.counts[key] = count
+	}
+	return err
+}
+
+// Close stops the periodic flushes and writes the remaining counts
+func (u *Usage) Close(ctx context.Context) error {
+	close(u.stop)
+	<-u.done
+	return u.Flush(ctx)
+}
+
+func (u *Usage) run(interval time.Duration) {
+	defer close(u.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-u.stop:
+			return
+		case <-ticker.C:
+			if err := u.Flush(context.Background()); err != nil {
+				slog.Warn("failed to record API key usage, keeping it for the next flush",
+					"error", err,
+				)
+			}
+		}
+	}
+}
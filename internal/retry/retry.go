@@ -0,0 +1,30 @@
+// Package retry holds the exponential backoff shared by everything that
+// retries a failed call, so Kraken fetches and webhook deliveries back off
+// the same way.
+package retry
+
+import "time"
+
+// Policy controls how many times a failed call is attempted and how long
+// to wait between attempts
+type Policy struct {
+	// MaxAttempts counts the first call; one or less disables retries
+	MaxAttempts int
+	// BaseBackoff is the wait after the first failure, doubling with each
+	// further failure up to MaxBackoff
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+}
+
+// Backoff returns how long to wait after the given number of failed
+// attempts
+func (p Policy) Backoff(attempts int) time.Duration {
+	backoff := p.BaseBackoff
+	for i := 1; i < attempts; i++ {
+		backoff *= 2
+		if backoff >= p.MaxBackoff {
+			return p.MaxBackoff
+		}
+	}
+	return min(backoff, p.MaxBackoff)
+}
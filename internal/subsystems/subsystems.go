@@ -0,0 +1,147 @@
+// Package subsystems lets operators pause and resume the service's
+// background workers at runtime. Paused state is kept in Redis so it
+// applies to every replica and survives restarts.
+package subsystems
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/chesskiss/btc-service/internal/cache"
+	"github.com/chesskiss/btc-service/internal/metrics"
+)
+
+// Background subsystems that can be paused
+const (
+	AlertEvaluator     = "alert_evaluator"
+	AlertDelivery      = "alert_delivery"
+	MaintenanceMonitor = "maintenance_monitor"
+	CacheRefresher     = "cache_refresher"
+	RequestLogJanitor  = "request_log_janitor"
+)
+
+// Names lists every subsystem that can be paused
+var Names = []string{AlertEvaluator, AlertDelivery, MaintenanceMonitor, CacheRefresher, RequestLogJanitor}
+
+// ErrUnknownSubsystem is returned for names not in Names
+var ErrUnknownSubsystem = errors.New("unknown subsystem")
+
+// pausedKey is the Redis hash holding the paused subsystems, within the
+// namespace given to Init
+var pausedKey = "subsystems:paused"
+
+// State is whether one subsystem is paused
+type State struct {
+	Name   string `json:"name"`
+	Paused bool   `json:"paused"`
+}
+
+var (
+	mu          sync.RWMutex
+	paused      = map[string]bool{}
+	redisClient redis.UniversalClient
+)
+
+// Init persists pause state in the given Redis client, under namespace,
+// and loads any state already stored there
+func Init(ctx context.Context, client redis.UniversalClient, namespace string) {
+	mu.Lock()
+	redisClient = client
+	pausedKey = cache.Key(namespace, "subsystems:paused")
+	mu.Unlock()
+
+	for _, name := range Names {
+		if Paused(ctx, name) {
+			slog.Warn("subsystem is paused",
+				"subsystem", name,
+			)
+		}
+	}
+}
+
+// Paused reports whether the named subsystem is paused. It reads Redis so
+// a pause made on another replica takes effect here, falling back to the
+// last known state when Redis is unreachable.
+func Paused(ctx context.Context, name string) bool {
+	mu.RLock()
+	client, key := redisClient, pausedKey
+	mu.RUnlock()
+
+	if client != nil {
+		stored, err := client.HExists(ctx, key, name).Result()
+		if err == nil {
+			record(name, stored)
+			return stored
+		}
+	}
+
+	mu.RLock()
+	defer mu.RUnlock()
+	return paused[name]
+}
+
+// SetPaused pauses or resumes the named subsystem. Nothing changes if the
+// state cannot be persisted.
+func SetPaused(ctx context.Context, name string, pause bool) error {
+	if !known(name) {
+		return fmt.Errorf("%w: %s", ErrUnknownSubsystem, name)
+	}
+
+	mu.RLock()
+	client, key := redisClient, pausedKey
+	mu.RUnlock()
+
+	if client != nil {
+		var err error
+		if pause {
+			err = client.HSet(ctx, key, name, "1").Err()
+		} else {
+			err = client.HDel(ctx, key, name).Err()
+		}
+		if err != nil {
+			return fmt.Errorf("failed to persist subsystem state: %w", err)
+		}
+	}
+
+	record(name, pause)
+	slog.Info("subsystem state changed",
+		"subsystem", name,
+		"paused", pause,
+	)
+	return nil
+}
+
+// States returns the state of every subsystem
+func States(ctx context.Context) []State {
+	states := make([]State, 0, len(Names))
+	for _, name := range Names {
+		states = append(states, State{Name: name, Paused: Paused(ctx, name)})
+	}
+	return states
+}
+
+func record(name string, pause bool) {
+	mu.Lock()
+	paused[name] = pause
+	mu.Unlock()
+
+	value := 0.0
+	if pause {
+		value = 1
+	}
+	metrics.SubsystemPaused.WithLabelValues(name).Set(value)
+}
+
+func known(name string) bool {
+	for _, n := range Names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
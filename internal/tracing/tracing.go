@@ -3,23 +3,17 @@ package tracing
 import (
 	"context"
 	"log/slog"
-	"os"
 
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
 	"go.opentelemetry.io/otel/sdk/resource"
 	"go.opentelemetry.io/otel/sdk/trace"
 	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
 )
 
 // InitTracer initializes the OpenTelemetry tracer with OTLP exporter (Jaeger)
-func InitTracer(serviceName string) (*trace.TracerProvider, error) {
-	// Get Jaeger endpoint from environment or use default
-	jaegerEndpoint := os.Getenv("JAEGER_ENDPOINT")
-	if jaegerEndpoint == "" {
-		jaegerEndpoint = "jaeger:4318" // Default for docker-compose
-	}
-
+func InitTracer(serviceName, serviceVersion, jaegerEndpoint string) (*trace.TracerProvider, error) {
 	// Create OTLP HTTP exporter for Jaeger
 	exporter, err := otlptracehttp.New(
 		context.Background(),
@@ -30,12 +24,13 @@ func InitTracer(serviceName string) (*trace.TracerProvider, error) {
 		return nil, err
 	}
 
-	// Create resource with service name
+	// Create resource with service name and version
 	res, err := resource.Merge(
 		resource.Default(),
 		resource.NewWithAttributes(
 			semconv.SchemaURL,
 			semconv.ServiceName(serviceName),
+			semconv.ServiceVersion(serviceVersion),
 		),
 	)
 	if err != nil {
@@ -52,7 +47,13 @@ func InitTracer(serviceName string) (*trace.TracerProvider, error) {
 	// Set global tracer provider
 	otel.SetTracerProvider(tp)
 
-	slog.Info("OpenTelemetry tracer initialized", "service", serviceName, "jaeger_endpoint", jaegerEndpoint)
+	// Propagate traces to upstreams in W3C traceparent and baggage headers
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	slog.Info("OpenTelemetry tracer initialized", "service", serviceName, "version", serviceVersion, "jaeger_endpoint", jaegerEndpoint)
 
 	return tp, nil
 }
@@ -0,0 +1,66 @@
+// Package version reports how the running binary was built. Version,
+// Commit and BuildTime are set at link time, e.g.
+//
+//	go build -ldflags "-X github.com/chesskiss/btc-service/internal/version.Version=v1.4.0 \
+//	    -X github.com/chesskiss/btc-service/internal/version.Commit=$(git rev-parse HEAD) \
+//	    -X github.com/chesskiss/btc-service/internal/version.BuildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+package version
+
+import (
+	"runtime"
+	"runtime/debug"
+	"sort"
+)
+
+// Populated via -ldflags -X
+var (
+	Version   = "dev"
+	Commit    = "unknown"
+	BuildTime = "unknown"
+)
+
+// features lists the optional subsystems enabled at startup
+var features []string
+
+// Info describes the running build
+type Info struct {
+	Version   string   `json:"version"`
+	Commit    string   `json:"commit"`
+	BuildTime string   `json:"build_time"`
+	GoVersion string   `json:"go_version"`
+	Features  []string `json:"features"`
+}
+
+// SetFeatures records the optional subsystems enabled in this process
+func SetFeatures(enabled []string) {
+	features = append([]string(nil), enabled...)
+	sort.Strings(features)
+}
+
+// Get returns the build information, falling back to the VCS revision Go
+// embeds in the binary when Commit was not set via ldflags
+func Get() Info {
+	info := Info{
+		Version:   Version,
+		Commit:    Commit,
+		BuildTime: BuildTime,
+		GoVersion: runtime.Version(),
+		Features:  features,
+	}
+	if info.Features == nil {
+		info.Features = []string{}
+	}
+
+	if build, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range build.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && info.Commit == "unknown":
+				info.Commit = setting.Value
+			case setting.Key == "vcs.time" && info.BuildTime == "unknown":
+				info.BuildTime = setting.Value
+			}
+		}
+	}
+
+	return info
+}
@@ -2,22 +2,39 @@ package main
 
 import (
     "context"
+    "errors"
     "fmt"
     "log/slog"
     "net/http"
     "os"
+    "os/signal"
+    "syscall"
     "time"
 
     "github.com/gorilla/mux"
     "github.com/prometheus/client_golang/prometheus/promhttp"
+    "github.com/redis/go-redis/v9"
 
     "github.com/chesskiss/btc-service/clients"
     "github.com/chesskiss/btc-service/config"
     "github.com/chesskiss/btc-service/handlers"
+    "github.com/chesskiss/btc-service/internal/alerts"
+    "github.com/chesskiss/btc-service/internal/archive"
+    "github.com/chesskiss/btc-service/internal/cache"
+    "github.com/chesskiss/btc-service/internal/clock"
     "github.com/chesskiss/btc-service/internal/database"
     internalHandlers "github.com/chesskiss/btc-service/internal/handlers"
+    "github.com/chesskiss/btc-service/internal/health"
+    "github.com/chesskiss/btc-service/internal/metrics"
     "github.com/chesskiss/btc-service/internal/middleware"
+    "github.com/chesskiss/btc-service/internal/openapi"
+    "github.com/chesskiss/btc-service/internal/problem"
+    "github.com/chesskiss/btc-service/internal/ratelimit"
+    "github.com/chesskiss/btc-service/internal/retry"
+    "github.com/chesskiss/btc-service/internal/subsystems"
     "github.com/chesskiss/btc-service/internal/tracing"
+    "github.com/chesskiss/btc-service/internal/version"
+    "github.com/chesskiss/btc-service/services"
 )
 
 func main() {
@@ -25,63 +42,553 @@ func main() {
     logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
     slog.SetDefault(logger)
 
-    slog.Info("starting Bitcoin LTP service")
-
-    cfg := config.Load()
+    build := version.Get()
+    slog.Info("starting Bitcoin LTP service",
+        "version", build.Version,
+        "commit", build.Commit,
+    )
+    metrics.BuildInfo.WithLabelValues(build.Version, build.Commit, build.GoVersion).Set(1)
 
-    // Initialize OpenTelemetry tracing
-    tp, err := tracing.InitTracer("btc-service")
+    cfg, err := config.Load()
     if err != nil {
-        slog.Error("failed to initialize tracer", "error", err)
+        slog.Error("invalid configuration", "error", err)
         os.Exit(1)
     }
-    defer func() {
-        ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-        defer cancel()
-        if err := tracing.Shutdown(ctx, tp); err != nil {
-            slog.Error("failed to shutdown tracer", "error", err)
+    version.SetFeatures(cfg.EnabledFeatures())
+
+    // Initialize OpenTelemetry tracing
+    if cfg.Tracing.Enabled {
+        tp, err := tracing.InitTracer(cfg.Tracing.ServiceName, build.Version, cfg.Tracing.Endpoint)
+        if err != nil {
+            slog.Error("failed to initialize tracer", "error", err)
+            os.Exit(1)
         }
-    }()
+        defer func() {
+            ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+            defer cancel()
+            if err := tracing.Shutdown(ctx, tp); err != nil {
+                slog.Error("failed to shutdown tracer", "error", err)
+            }
+        }()
+    }
 
-    // Initialize Redis
-    redisClient := clients.InitRedis(cfg.RedisHost, cfg.RedisPort, cfg.RedisPassword)
+    // Initialize the price cache and the Kraken price service using it;
+    // without Redis, pause state stays local to this process
+    var priceCache cache.Cache
+    var redisClient redis.UniversalClient
+    // snapshotCache is the in-process cache saved across restarts: the
+    // memory backend, the L1, or else the Redis fallback
+    var snapshotCache *cache.Memory
+    if cfg.Cache.Backend == cache.BackendMemory {
+        slog.Info("caching prices in process memory",
+            "max_entries", cfg.Cache.MemoryMaxEntries,
+        )
+        snapshotCache = cache.NewMemory(cfg.Cache.MemoryMaxEntries)
+        priceCache = snapshotCache
+    } else {
+        redisClient = clients.NewRedisClient(clients.RedisOptions{
+            Addr:             fmt.Sprintf("%s:%s", cfg.Redis.Host, cfg.Redis.Port),
+            Username:         cfg.Redis.Username,
+            Password:         cfg.Redis.Password,
+            DB:               cfg.Redis.DB,
+            TLS:              cfg.Redis.TLSEnabled,
+            SentinelMaster:   cfg.Redis.SentinelMaster,
+            SentinelAddrs:    cfg.Redis.SentinelAddrs,
+            SentinelPassword: cfg.Redis.SentinelPassword,
+            ClusterAddrs:     cfg.Redis.ClusterAddrs,
+            PoolSize:         cfg.Redis.PoolSize,
+            MinIdleConns:     cfg.Redis.MinIdleConns,
+            ReadTimeout:      cfg.Redis.ReadTimeout,
+            WriteTimeout:     cfg.Redis.WriteTimeout,
+            MaxRetries:       cfg.Redis.MaxRetries,
+            MinRetryBackoff:  cfg.Redis.MinRetryBackoff,
+            MaxRetryBackoff:  cfg.Redis.MaxRetryBackoff,
+        })
+        priceCache = cache.NewRedis(redisClient)
+        // The fallback and the L1 share one bounded in-process cache, which
+        // is the one snapshots save
+        if cfg.Cache.FallbackEnabled || cfg.Cache.L1TTL > 0 {
+            snapshotCache = cache.NewMemory(cfg.Cache.MemoryMaxEntries)
+        }
+        if cfg.Cache.FallbackEnabled {
+            priceCache = cache.NewFallback(priceCache, snapshotCache, cfg.Cache.FallbackProbeInterval)
+        }
+        if cfg.Cache.L1TTL > 0 {
+            priceCache = cache.NewTiered(snapshotCache, priceCache, cfg.Cache.L1TTL)
+        }
+    }
+    if cfg.Cache.SnapshotPath != "" && snapshotCache != nil {
+        loaded, err := snapshotCache.LoadSnapshot(cfg.Cache.SnapshotPath)
+        if err != nil {
+            slog.Warn("failed to load cache snapshot",
+                "path", cfg.Cache.SnapshotPath,
+                "error", err,
+            )
+        } else {
+            slog.Info("cache snapshot loaded",
+                "path", cfg.Cache.SnapshotPath,
+                "entries", loaded,
+            )
+        }
+    }
+    if cfg.Cache.Namespace != "" {
+        priceCache = cache.NewNamespaced(priceCache, cfg.Cache.Namespace)
+    }
 
-    // Initialize PostgreSQL
-    db, err := database.InitDB(cfg.DBHost, cfg.DBPort, cfg.DBUser, cfg.DBPassword, cfg.DBName)
-    if err != nil {
-        slog.Warn("database initialization failed",
+    krakenClient := clients.NewHTTPClient(clients.HTTPClientOptions{
+        Timeout:               cfg.Providers.Kraken.Timeout,
+        DialTimeout:           cfg.Providers.Kraken.DialTimeout,
+        ResponseHeaderTimeout: cfg.Providers.Kraken.ResponseHeaderTimeout,
+        MaxIdleConnsPerHost:   cfg.Providers.Kraken.MaxIdleConns,
+        IdleConnTimeout:       cfg.Providers.Kraken.IdleConnTimeout,
+        MaxResponseBytes:      cfg.Providers.Kraken.MaxResponseBytes,
+    })
+    priceService := clients.NewPriceService(priceCache, clients.PriceServiceOptions{
+        KrakenBaseURL:        cfg.Providers.Kraken.BaseURL,
+        HTTPClient:           krakenClient,
+        CacheTTL:             cfg.Cache.TTL,
+        StaleWhileRevalidate: cfg.Cache.StaleWhileRevalidate,
+        LastKnownMaxAge:      cfg.Cache.LastKnownMaxAge,
+        FetchLockTTL:         cfg.Cache.FetchLockTTL,
+        FetchLockWait:        cfg.Cache.FetchLockWait,
+        RawTickerEnabled:     cfg.Cache.RawTickerEnabled,
+        RawTickerTTL:         cfg.Cache.RawTickerTTL,
+        Retry: clients.RetryPolicy{
+            Policy: retry.Policy{
+                MaxAttempts: cfg.Providers.Kraken.RetryMaxAttempts,
+                BaseBackoff: cfg.Providers.Kraken.RetryBackoff,
+                MaxBackoff:  cfg.Providers.Kraken.MaxRetryBackoff,
+            },
+            RetryableStatuses: cfg.Providers.Kraken.RetryableStatuses,
+        },
+        BreakerFailureThreshold: cfg.Providers.Kraken.BreakerFailureThreshold,
+        BreakerOpenDuration:     cfg.Providers.Kraken.BreakerOpenDuration,
+        MaxConcurrentFetches:    cfg.Providers.Kraken.MaxConcurrentFetches,
+    })
+    clients.SetDefaultService(priceService)
+    subsystems.Init(context.Background(), redisClient, cfg.Cache.Namespace)
+    handlers.SetPricePrecision(cfg.Prices.Precision, cfg.Prices.Rounding)
+    services.SetUpstreamBudget(cfg.Prices.UpstreamBudget)
+    handlers.SetCacheBypassLimit(cfg.Cache.BypassPerMinute, cfg.Cache.BypassBurst)
+
+    // Watch Kraken's maintenance calendar
+    if cfg.Providers.Kraken.MaintenanceFeedURL != "" {
+        clients.StartMaintenanceMonitor(context.Background(), krakenClient, cfg.Providers.Kraken.MaintenanceFeedURL, cfg.Providers.Kraken.MaintenanceCheckInterval)
+    }
+
+    // Initialize the database, PostgreSQL or MySQL as configured. Its pool
+    // keeps trying to connect if it is unreachable on startup, and the
+    // workers using it fail until it does.
+    dbTLS := database.TLSOptions{
+        SSLMode:  cfg.DB.SSLMode,
+        RootCert: cfg.DB.SSLRootCert,
+        Cert:     cfg.DB.SSLCert,
+        Key:      cfg.DB.SSLKey,
+    }
+    var store, readStore database.Store
+    var dbConn internalHandlers.Pinger
+    var migrate func(ctx context.Context) (int, error)
+    var connected bool
+    switch cfg.DB.Driver {
+    case database.DriverMySQL:
+        mysqlDB, err := database.OpenMySQL(cfg.DB.Host, cfg.DB.Port, cfg.DB.User, cfg.DB.Password, cfg.DB.Name, dbTLS)
+        if err != nil {
+            slog.Error("failed to open database",
+                "error", err,
+            )
+            os.Exit(1)
+        }
+        defer mysqlDB.Close()
+        metrics.SetDBPoolStats(func() metrics.DBPoolStats { return database.MySQLPoolStats(mysqlDB) })
+        mysqlStore := database.NewMySQLStore(mysqlDB)
+        dbConn = mysqlStore
+        migrate = func(ctx context.Context) (int, error) {
+            return database.MigrateMySQL(ctx, mysqlDB, cfg.DB.MigrationsBaseline)
+        }
+        store, readStore = mysqlStore, mysqlStore
+    default:
+        db, err := database.OpenDB(context.Background(), cfg.DB.Host, cfg.DB.Port, cfg.DB.User, cfg.DB.Password, cfg.DB.Name, dbTLS)
+        if err != nil {
+            slog.Error("failed to open database",
+                "error", err,
+            )
+            os.Exit(1)
+        }
+        defer db.Close()
+        metrics.SetDBPoolStats(func() metrics.DBPoolStats { return database.PoolStats(db) })
+        dbConn = db
+        migrate = func(ctx context.Context) (int, error) {
+            return database.Migrate(ctx, db, cfg.DB.MigrationsBaseline)
+        }
+        store = database.NewPostgresStore(db)
+
+        // Serve request log queries from a replica when there is one, so
+        // reporting doesn't contend with writes on the primary
+        readStore = store
+        if cfg.DB.ReadReplicaDSN != "" {
+            replica, err := database.InitReadReplica(context.Background(), cfg.DB.ReadReplicaDSN)
+            if err != nil {
+                slog.Warn("read replica initialization failed, querying the primary",
+                    "error", err,
+                )
+            } else {
+                defer replica.Close()
+                readStore = database.NewPostgresStore(replica)
+            }
+        }
+    }
+
+    // Bring the schema up to date before anything queries it. A database
+    // unreachable on startup is migrated in the background once it
+    // answers, and request logs are spooled until then.
+    var dbReady <-chan struct{}
+    if err := dbConn.Ping(context.Background()); err != nil {
+        slog.Warn("database unreachable on startup, connecting once it is up",
             "error", err,
         )
-        slog.Info("continuing without request logging")
+        var setup func(ctx context.Context) error
+        if cfg.DB.Migrate {
+            setup = func(ctx context.Context) error {
+                applied, err := migrate(ctx)
+                if err == nil {
+                    slog.Info("database schema up to date",
+                        "migrations_applied", applied,
+                    )
+                }
+                return err
+            }
+        }
+        dbReady = database.AwaitConnection(context.Background(), dbConn.Ping, setup, 5*time.Second)
+    } else {
+        connected = true
+        if cfg.DB.Migrate {
+            applied, err := migrate(context.Background())
+            if err != nil {
+                slog.Error("database migration failed",
+                    "error", err,
+                )
+                os.Exit(1)
+            }
+            slog.Info("database schema up to date",
+                "migrations_applied", applied,
+            )
+        }
+    }
+
+    // Client IPs are anonymized as request logs are queued, and purges
+    // match them in that form
+    var ipAnonymizer *database.IPAnonymizer
+    if cfg.DB.LogIPMode != database.IPModeFull {
+        ipAnonymizer = database.NewIPAnonymizer(cfg.DB.LogIPMode, cfg.DB.LogIPHMACSecret, cfg.DB.LogIPHMACRotation)
+    }
+    var logSpool *database.Spool
+    if cfg.DB.LogSpoolPath != "" {
+        logSpool, err = database.NewSpool(cfg.DB.LogSpoolPath, int64(cfg.DB.LogSpoolMaxBytes))
+        if err != nil {
+            slog.Error("failed to open request log spool",
+                "path", cfg.DB.LogSpoolPath,
+                "error", err,
+            )
+            os.Exit(1)
+        }
+    }
+
+    // Write request logs in batches from the background, to PostgreSQL,
+    // to ClickHouse for high-volume analytics and to Kafka for data
+    // pipelines, as configured
+    // Purges reach the spool and ClickHouse too; Kafka topics and archived
+    // objects can't be purged, so purge results list them
+    var logSinks database.MultiSink
+    var kafkaSink *database.KafkaSink
+    var purgeTargets []database.PurgeTarget
+    var unpurgeable []string
+    if logSpool != nil {
+        purgeTargets = append(purgeTargets, database.PurgeTarget{Name: "spool", Purger: logSpool})
+    }
+    for _, sink := range cfg.DB.LogSinks {
+        switch sink {
+        case database.SinkPostgres, database.SinkMySQL:
+            // Registered even while the database is unreachable, so the
+            // writer spools its batches until it is ready
+            if dbReady != nil {
+                logSinks = append(logSinks, database.ReadySink{Sink: store, Ready: dbReady})
+            } else {
+                logSinks = append(logSinks, store)
+            }
+        case database.SinkClickHouse:
+            clickHouseSink := database.NewClickHouseSink(database.ClickHouseOptions{
+                URL:      cfg.DB.ClickHouse.URL,
+                Database: cfg.DB.ClickHouse.Database,
+                Table:    cfg.DB.ClickHouse.Table,
+                User:     cfg.DB.ClickHouse.User,
+                Password: cfg.DB.ClickHouse.Password,
+                Timeout:  cfg.DB.ClickHouse.Timeout,
+            })
+            logSinks = append(logSinks, clickHouseSink)
+            purgeTargets = append(purgeTargets, database.PurgeTarget{Name: database.SinkClickHouse, Purger: clickHouseSink})
+        case database.SinkKafka:
+            kafkaSink = database.NewKafkaSink(database.KafkaOptions{
+                Brokers:   cfg.DB.Kafka.Brokers,
+                Topic:     cfg.DB.Kafka.Topic,
+                BatchSize: cfg.DB.LogBatchSize,
+                Timeout:   cfg.DB.Kafka.Timeout,
+            })
+            logSinks = append(logSinks, kafkaSink)
+            unpurgeable = append(unpurgeable, database.SinkKafka)
+        }
+    }
+    var logWriter *database.RequestLogWriter
+    switch len(logSinks) {
+    case 0:
+    case 1:
+        logWriter = database.StartRequestLogWriter(logSinks[0], logSpool, cfg.DB.LogBufferSize, cfg.DB.LogBatchSize, cfg.DB.LogFlushInterval)
+    default:
+        logWriter = database.StartRequestLogWriter(logSinks, logSpool, cfg.DB.LogBufferSize, cfg.DB.LogBatchSize, cfg.DB.LogFlushInterval)
+    }
+    if logWriter != nil {
+        // Sample and anonymize request logs as they are queued
+        if cfg.DB.LogSuccessSampleRate < 1 || cfg.DB.LogErrorSampleRate < 1 {
+            logWriter.SetSampler(database.NewRequestLogSampler(cfg.DB.LogSuccessSampleRate, cfg.DB.LogErrorSampleRate))
+        }
+        logWriter.SetIPAnonymizer(ipAnonymizer)
+    }
+
+    // Keep request_logs from growing without bound, archiving expiring
+    // logs to object storage first when a bucket is configured
+    if cfg.DB.LogRetention > 0 {
+        var archiveFunc database.ArchiveFunc
+        if cfg.DB.LogArchive.Bucket != "" {
+            archiver := archive.NewRequestLogArchiver(archive.NewS3Store(archive.S3Options{
+                Endpoint:        cfg.DB.LogArchive.Endpoint,
+                Region:          cfg.DB.LogArchive.Region,
+                Bucket:          cfg.DB.LogArchive.Bucket,
+                AccessKeyID:     cfg.DB.LogArchive.AccessKeyID,
+                SecretAccessKey: cfg.DB.LogArchive.SecretAccessKey,
+                PathStyle:       cfg.DB.LogArchive.PathStyle,
+                Timeout:         cfg.DB.LogArchive.Timeout,
+            }), cfg.DB.LogArchive.Prefix)
+            archiveFunc = archiver.Archive
+            unpurgeable = append(unpurgeable, "archive")
+        }
+        database.StartRequestLogJanitor(context.Background(), store, cfg.DB.LogRetention, cfg.DB.LogRetentionInterval, cfg.DB.LogRetentionBatchSize, archiveFunc)
+    }
+
+    // Evaluate price alerts and deliver their webhooks, both of which are
+    // queued in the database
+    if cfg.Alerts.Enabled {
+        alerts.StartEvaluator(context.Background(), store, cfg.Alerts.EvaluationInterval)
+        alerts.StartDeliveryWorker(context.Background(), store, cfg.Alerts.DeliveryInterval, cfg.Alerts.WebhookTimeout, retry.Policy{
+            MaxAttempts: cfg.Alerts.MaxAttempts,
+            BaseBackoff: cfg.Alerts.RetryBackoff,
+            MaxBackoff:  cfg.Alerts.MaxRetryBackoff,
+        }, alerts.CallbackPolicy{AllowPrivate: cfg.Alerts.AllowPrivateCallbacks})
+    }
+
+    // Prefetch popular prices before accepting traffic so the first
+    // requests after a deploy are cache hits
+    if cfg.Cache.WarmEnabled {
+        var frequent []string
+        if connected && cfg.Cache.WarmFrequentPairs > 0 {
+            frequent, err = readStore.FrequentPairs(context.Background(), clock.Now().Add(-cfg.Cache.WarmLookback), cfg.Cache.WarmFrequentPairs)
+            if err != nil {
+                slog.Warn("failed to load frequently requested pairs",
+                    "error", err,
+                )
+            }
+        }
+        warmCtx, cancel := context.WithTimeout(context.Background(), cfg.Cache.WarmTimeout)
+        services.WarmCache(warmCtx, frequent)
+        cancel()
+    }
+
+    // Keep popular prices cached by refreshing them before they expire
+    if cfg.Cache.RefreshEnabled {
+        priceService.StartCacheRefresher(context.Background(), cfg.Cache.RefreshInterval, cfg.Cache.RefreshLead, cfg.Cache.RefreshActiveWindow)
+    }
+
+    // Probe dependencies with hysteresis so one failed ping doesn't flip
+    // readiness
+    readiness := health.NewMonitor(cfg.Health.FailureThreshold, cfg.Health.RecoveryThreshold,
+        internalHandlers.DatabaseProbe(dbConn),
+        internalHandlers.CacheProbe(priceCache),
+    )
+
+    // Believe forwarding headers only from the proxies in front of us
+    trustedProxies, err := middleware.ParseTrustedProxies(cfg.Server.TrustedProxies)
+    if err != nil {
+        slog.Error("invalid trusted proxies", "error", err)
+        os.Exit(1)
+    }
+    middleware.SetTrustedProxies(trustedProxies)
+
+    // API keys. Admin keys are required on the admin endpoints whether or
+    // not AUTH_ENABLED is set
+    keys, err := middleware.NewStaticKeyStore(cfg.Auth.APIKeys, cfg.Auth.AdminAPIKeys)
+    if err != nil {
+        slog.Error("invalid API keys", "error", err)
+        os.Exit(1)
+    }
+    if len(cfg.Auth.AdminAPIKeys) == 0 {
+        slog.Warn("no ADMIN_API_KEYS configured, admin endpoints will refuse every request")
     }
-    defer database.Close()
 
     // Setup router
     r := mux.NewRouter()
+    admin := r.PathPrefix("/api/v1/admin").Subrouter()
+    admin.Use(middleware.RequireAdmin(keys, problem.Reject))
 
     // Health and readiness checks
-    r.HandleFunc("/health", internalHandlers.HealthHandler).Methods("GET")
-    r.HandleFunc("/ready", internalHandlers.ReadinessHandler(db, redisClient)).Methods("GET")
+    r.HandleFunc("/health", internalHandlers.HealthHandler(cfg.DB.Driver, dbConn, priceCache)).Methods("GET")
+    r.HandleFunc("/ready", internalHandlers.ReadinessHandler(readiness)).Methods("GET")
+    r.HandleFunc("/version", internalHandlers.VersionHandler).Methods("GET")
 
     // Prometheus metrics
     r.Handle("/metrics", promhttp.Handler()).Methods("GET")
 
+    // API specification
+    r.HandleFunc("/openapi.json", openapi.Handler).Methods("GET")
+    r.HandleFunc("/docs", openapi.DocsHandler).Methods("GET")
+    r.PathPrefix("/docs/assets/").Handler(openapi.AssetsHandler).Methods("GET")
+
     // API endpoints
-    r.HandleFunc("/api/v1/ltp", handlers.LTPHandler).Methods("GET")
+    r.HandleFunc("/api/v1/ltp", handlers.LTPHandler(logWriter)).Methods("GET")
+    r.HandleFunc("/api/v2/ltp", handlers.LTPV2Handler(logWriter)).Methods("GET")
+    r.HandleFunc("/api/v1/portfolio/value", internalHandlers.PortfolioValueHandler).Methods("POST")
+    r.HandleFunc("/api/v1/alerts", internalHandlers.CreateAlertHandler(store, alerts.CallbackPolicy{AllowPrivate: cfg.Alerts.AllowPrivateCallbacks})).Methods("POST")
+    r.HandleFunc("/api/v1/alerts", internalHandlers.ListAlertsHandler(store)).Methods("GET")
+    r.HandleFunc("/api/v1/alerts/{id}", internalHandlers.DeleteAlertHandler(store)).Methods("DELETE")
+    r.HandleFunc("/api/v1/alerts/{id}/deliveries", internalHandlers.AlertDeliveriesHandler(store)).Methods("GET")
 
-    // Apply logging middleware
-    handler := middleware.LoggingMiddleware(r)
+    // Admin endpoints
+    admin.HandleFunc("/requests", internalHandlers.RequestLogsHandler(readStore)).Methods("GET")
+    admin.HandleFunc("/purge", internalHandlers.PurgeHandler(store, ipAnonymizer, purgeTargets, unpurgeable)).Methods("POST")
+    admin.HandleFunc("/subsystems", internalHandlers.SubsystemsHandler).Methods("GET")
+    admin.HandleFunc("/subsystems/{name}/pause", internalHandlers.PauseSubsystemHandler).Methods("POST")
+    admin.HandleFunc("/subsystems/{name}/resume", internalHandlers.ResumeSubsystemHandler).Methods("POST")
+
+    // Simulation builds run on a virtual clock that tests advance explicitly
+    if sim, ok := clock.Simulated(); ok {
+        slog.Warn("running on a simulated clock", "now", sim.Now())
+        admin.HandleFunc("/clock/advance", internalHandlers.AdvanceClockHandler(sim)).Methods("POST")
+    }
+
+    // Shed requests beyond the in-flight cap, reject oversized requests,
+    // then requests without an API key or over their rate limit; compress
+    // the responses and log them all
+    limits := middleware.SizeLimits{
+        MaxHeaderBytes: cfg.Server.MaxHeaderBytes,
+        MaxBodyBytes:   cfg.Server.MaxBodyBytes,
+        MaxURLLength:   cfg.Server.MaxURLLength,
+    }
+    var api http.Handler = r
+    // Rate limit each API key, or client IP without one, across replicas
+    // through Redis, and count each key's requests in the database
+    var usage *ratelimit.Usage
+    if cfg.RateLimit.Enabled {
+        var limiter ratelimit.Limiter = ratelimit.NewLocal(cfg.RateLimit.PerMinute, cfg.RateLimit.Burst)
+        if redisClient != nil {
+            limiter = ratelimit.NewRedis(redisClient, cfg.Cache.Namespace, cfg.RateLimit.PerMinute, cfg.RateLimit.Burst)
+        }
+        var recorder middleware.UsageRecorder
+        if cfg.Auth.Enabled {
+            usage = ratelimit.StartUsage(store, cfg.RateLimit.UsageFlushInterval)
+            recorder = usage
+        }
+        api = middleware.RateLimit(limiter, recorder, problem.Reject)(api)
+        slog.Info("rate limiting enabled",
+            "per_minute", cfg.RateLimit.PerMinute,
+            "burst", cfg.RateLimit.Burst,
+            "shared", redisClient != nil,
+        )
+    }
+    if cfg.Auth.Enabled {
+        api = middleware.RequireAPIKey(keys, problem.Reject)(api)
+        slog.Info("API key authentication enabled", "keys", len(cfg.Auth.APIKeys))
+    }
+    handler := middleware.LimitRequestSize(limits, problem.Reject)(api)
+    if cfg.Server.MaxInFlight > 0 {
+        // Shed excess load before it costs a Redis or database call
+        handler = middleware.ShedLoad(cfg.Server.MaxInFlight, cfg.Server.ShedRetryAfter, problem.Shed)(handler)
+    }
+    if cfg.Server.CompressionEnabled {
+        handler = middleware.Compress(cfg.Server.CompressionMinBytes)(handler)
+    }
+    handler = middleware.LoggingMiddleware(handler)
 
     // Start server
-    addr := fmt.Sprintf(":%s", cfg.Port)
+    server := &http.Server{
+        Addr:         fmt.Sprintf(":%s", cfg.Server.Port),
+        Handler:      handler,
+        ReadTimeout:  cfg.Server.ReadTimeout,
+        WriteTimeout: cfg.Server.WriteTimeout,
+        IdleTimeout:  cfg.Server.IdleTimeout,
+        // Backstop for headers too large to reach the middleware
+        MaxHeaderBytes: cfg.Server.MaxHeaderBytes,
+    }
     slog.Info("server starting",
-        "address", addr,
+        "address", server.Addr,
+    )
+
+    go func() {
+        if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+            slog.Error("server failed",
+                "error", err,
+            )
+            os.Exit(1)
+        }
+    }()
+
+    // Finish in-flight requests on SIGTERM or SIGINT, then save the
+    // in-process cache so the next start isn't cold
+    stop := make(chan os.Signal, 1)
+    signal.Notify(stop, syscall.SIGTERM, syscall.SIGINT)
+    sig := <-stop
+    slog.Info("shutting down",
+        "signal", sig.String(),
     )
 
-    if err := http.ListenAndServe(addr, handler); err != nil {
-        slog.Error("server failed",
+    shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+    defer cancel()
+    if err := server.Shutdown(shutdownCtx); err != nil {
+        slog.Error("failed to shut down server gracefully",
             "error", err,
         )
-        os.Exit(1)
+    }
+
+    if logWriter != nil {
+        if err := logWriter.Close(shutdownCtx); err != nil {
+            slog.Error("failed to write queued request logs",
+                "error", err,
+            )
+        }
+    }
+    if usage != nil {
+        if err := usage.Close(shutdownCtx); err != nil {
+            slog.Error("failed to record API key usage",
+                "error", err,
+            )
+        }
+    }
+    if kafkaSink != nil {
+        if err := kafkaSink.Close(); err != nil {
+            slog.Error("failed to close kafka writer",
+                "error", err,
+            )
+        }
+    }
+
+    if cfg.Cache.SnapshotPath != "" && snapshotCache != nil {
+        saved, err := snapshotCache.SaveSnapshot(cfg.Cache.SnapshotPath)
+        if err != nil {
+            slog.Error("failed to save cache snapshot",
+                "path", cfg.Cache.SnapshotPath,
+                "error", err,
+            )
+        } else {
+            slog.Info("cache snapshot saved",
+                "path", cfg.Cache.SnapshotPath,
+                "entries", saved,
+            )
+        }
     }
 }
@@ -0,0 +1,47 @@
+package services
+
+import "strings"
+
+// pairSeparators split a base from a quote currency, as in BTC/USD,
+// btc-usd, BTC_USD or BTC:USD
+const pairSeparators = "/-_:"
+
+// baseAliases maps the names exchanges use for bitcoin to the canonical one
+var baseAliases = map[string]string{
+    "BTC": AssetBTC,
+    "XBT": AssetBTC,
+}
+
+// NormalizePair returns the canonical BTC/<currency> form of a pair written
+// in any common convention, such as XBT/USD, btc-usd or BTCUSD. It reports
+// false for pairs that are malformed or not quoted against bitcoin.
+func NormalizePair(pair string) (string, bool) {
+    pair = strings.ToUpper(strings.TrimSpace(pair))
+
+    var base, quote string
+    if i := strings.IndexAny(pair, pairSeparators); i >= 0 {
+        base, quote = pair[:i], pair[i+1:]
+    } else if len(pair) > 3 {
+        // Concatenated pairs like BTCUSD use a three-letter base
+        base, quote = pair[:3], pair[3:]
+    }
+
+    canonical, ok := baseAliases[base]
+    if !ok || !isCurrencyCode(quote) {
+        return "", false
+    }
+    return canonical + "/" + quote, true
+}
+
+// isCurrencyCode accepts the letter codes Kraken quotes bitcoin in
+func isCurrencyCode(code string) bool {
+    if len(code) < 3 {
+        return false
+    }
+    for _, c := range code {
+        if c < 'A' || c > 'Z' {
+            return false
+        }
+    }
+    return true
+}
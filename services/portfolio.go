@@ -0,0 +1,112 @@
+package services
+
+import (
+    "context"
+    "sort"
+    "strings"
+    "time"
+
+    "github.com/shopspring/decimal"
+)
+
+// AssetBTC is the only crypto asset the service prices; every other asset
+// is a fiat currency valued through its BTC pair
+const AssetBTC = "BTC"
+
+// Holding is an amount of one asset, either BTC or a fiat currency
+type Holding struct {
+    Asset  string          `json:"asset"`
+    Amount decimal.Decimal `json:"amount"`
+}
+
+// HoldingValue is a holding valued in the portfolio currency
+type HoldingValue struct {
+    Asset  string          `json:"asset"`
+    Amount decimal.Decimal `json:"amount"`
+    Rate   decimal.Decimal `json:"rate"` // value of one unit of the asset
+    Value  decimal.Decimal `json:"value"`
+}
+
+// PortfolioValuation is the value of a set of holdings in one currency.
+// When any needed pair could not be priced, Holdings is empty and the
+// pairs are listed in InvalidPairs or FailedPairs.
+type PortfolioValuation struct {
+    Currency string
+    Total    decimal.Decimal
+    Holdings []HoldingValue
+    // PricedAt is when the oldest price used was fetched
+    PricedAt     time.Time
+    InvalidPairs []string
+    FailedPairs  []string
+}
+
+// ValuePortfolio values holdings in currency, which may itself be BTC,
+// using the current BTC prices. Fiat amounts are converted through BTC, so
+// valuing EUR in USD uses BTC/USD divided by BTC/EUR.
+func ValuePortfolio(ctx context.Context, currency string, holdings []Holding) PortfolioValuation {
+    valuation := PortfolioValuation{Currency: currency, Total: decimal.Zero}
+
+    needed := map[string]bool{}
+    for _, holding := range holdings {
+        if holding.Asset == currency {
+            continue
+        }
+        for _, asset := range []string{currency, holding.Asset} {
+            if asset != AssetBTC {
+                needed[asset] = true
+            }
+        }
+    }
+
+    // perBTC holds how many units of each asset one BTC buys
+    perBTC := map[string]decimal.Decimal{AssetBTC: decimal.NewFromInt(1)}
+    if len(needed) > 0 {
+        pairs := make([]string, 0, len(needed))
+        for c := range needed {
+            pairs = append(pairs, AssetBTC+"/"+c)
+        }
+        sort.Strings(pairs)
+
+        result := GetPrices(ctx, strings.Join(pairs, ","))
+        if len(result.InvalidPairs) > 0 || len(result.FailedPairs) > 0 {
+            valuation.InvalidPairs = result.InvalidPairs
+            valuation.FailedPairs = result.FailedPairs
+            return valuation
+        }
+
+        for _, pq := range result.Quotes {
+            price, err := decimal.NewFromString(pq.Quote.Decimal)
+            if err != nil {
+                price = decimal.NewFromFloat(pq.Quote.Price)
+            }
+            if !price.IsPositive() {
+                valuation.FailedPairs = []string{pq.Pair}
+                return valuation
+            }
+            perBTC[strings.TrimPrefix(pq.Pair, AssetBTC+"/")] = price
+            if valuation.PricedAt.IsZero() || pq.Quote.Timestamp.Before(valuation.PricedAt) {
+                valuation.PricedAt = pq.Quote.Timestamp.UTC()
+            }
+        }
+    }
+
+    for _, holding := range holdings {
+        rate, value := decimal.NewFromInt(1), holding.Amount
+        if holding.Asset != currency {
+            // Multiply before dividing so the value isn't computed from a
+            // rounded rate
+            rate = perBTC[currency].Div(perBTC[holding.Asset])
+            value = holding.Amount.Mul(perBTC[currency]).Div(perBTC[holding.Asset])
+        }
+
+        valuation.Total = valuation.Total.Add(value)
+        valuation.Holdings = append(valuation.Holdings, HoldingValue{
+            Asset:  holding.Asset,
+            Amount: holding.Amount,
+            Rate:   rate,
+            Value:  value,
+        })
+    }
+
+    return valuation
+}
@@ -2,29 +2,87 @@ package services
 
 import (
     "context"
+    "errors"
     "fmt"
     "log"
+    "strings"
+    "time"
 
     "go.opentelemetry.io/otel"
     "go.opentelemetry.io/otel/attribute"
 
     "github.com/chesskiss/btc-service/clients"
+    "github.com/chesskiss/btc-service/internal/clock"
 )
 
 type PairPrice struct {
-    Pair   string  `json:"pair"`
-    Amount float64 `json:"amount"`
+    Pair       string    `json:"pair"`
+    Amount     float64   `json:"amount"`
+    Timestamp  time.Time `json:"timestamp"`       // when the price was fetched from Kraken
+    AgeSeconds int64     `json:"age_seconds"`     // age of the price when the response was built
+    Cached     bool      `json:"cached"`          // served from cache rather than a live fetch
+    Stale      bool      `json:"stale,omitempty"` // last known price, served because Kraken is unavailable
+}
+
+// Reasons a requested pair is missing from a response
+const (
+    ReasonInvalidPair             = "invalid_pair"
+    ReasonExchangeMaintenance     = "exchange_maintenance"
+    ReasonUpstreamUnavailable     = "upstream_unavailable"
+    ReasonUpstreamTimeout         = "upstream_timeout"
+    ReasonUpstreamRateLimited     = "upstream_rate_limited"
+    ReasonUpstreamInvalidResponse = "upstream_invalid_response"
+    ReasonDeadlineExceeded        = "deadline_exceeded"
+    ReasonBypassRateLimited       = "bypass_rate_limited"
+)
+
+// upstreamBudget bounds how long GetPrices waits on the exchange in total;
+// zero waits as long as the fetches take
+var upstreamBudget time.Duration
+
+// SetUpstreamBudget sets how long a request may spend fetching its pairs
+// from the exchange; pairs not priced by then are reported as
+// deadline_exceeded and the rest are returned
+func SetUpstreamBudget(budget time.Duration) {
+    upstreamBudget = budget
+}
+
+// PairError explains why a requested pair has no price
+type PairError struct {
+    Pair   string `json:"pair"`
+    Reason string `json:"reason"`
 }
 
 type LTPResponse struct {
-    LTP []PairPrice `json:"ltp"`
+    LTP    []PairPrice `json:"ltp"`
+    Errors []PairError `json:"errors,omitempty"`
+}
+
+// PairQuote is a pair's price with its exact decimal form and provenance
+type PairQuote struct {
+    Pair  string
+    Quote clients.Quote
 }
 
 type PriceResult struct {
-    Prices       []PairPrice
+    Prices []PairPrice
+    Quotes []PairQuote
+    // InvalidPairs are malformed or unsupported pairs, FailedPairs those
+    // that could not be fetched from upstream
+    InvalidPairs []string
+    FailedPairs  []string
+    // Errors lists every pair without a price and why
+    Errors []PairError
+    // CachedPairs are the priced pairs served from cache
+    CachedPairs  []string
     ErrorsCount  int
     KrakenCalls  int
     ErrorMessage string
+    // UpstreamLatency is the total time spent waiting on Kraken
+    UpstreamLatency time.Duration
+    // BudgetExceeded is set when the upstream budget ran out before every
+    // pair was priced
+    BudgetExceeded bool
 }
 
 func GetPrices(ctx context.Context, pairsParam string) PriceResult {
@@ -32,7 +90,7 @@ func GetPrices(ctx context.Context, pairsParam string) PriceResult {
     ctx, span := tracer.Start(ctx, "get_prices")
     defer span.End()
 
-    currencies := resolveCurrencies(pairsParam)
+    currencies, invalidPairs := resolveCurrencies(pairsParam)
 
     span.SetAttributes(
         attribute.StringSlice("currencies", currencies),
@@ -40,59 +98,146 @@ func GetPrices(ctx context.Context, pairsParam string) PriceResult {
     )
 
     var prices []PairPrice
+    var quotes []PairQuote
+    var failedPairs []string
+    var pairErrors []PairError
+    var cachedPairs []string
     var errorsCount int
     var lastError string
+    var upstreamLatency time.Duration
+
+    for _, pair := range invalidPairs {
+        errorsCount++
+        lastError = fmt.Sprintf("%s: invalid pair", pair)
+        pairErrors = append(pairErrors, PairError{Pair: pair, Reason: ReasonInvalidPair})
+    }
 
-    for _, currency := range currencies {
-        price, err := clients.GetBTCPrice(ctx, currency)
+    // Pairs share one budget, fetched concurrently, so a slow currency
+    // cannot hold back the others past it
+    fetchCtx := ctx
+    if upstreamBudget > 0 {
+        var cancel context.CancelFunc
+        fetchCtx, cancel = context.WithTimeout(ctx, upstreamBudget)
+        defer cancel()
+    }
+
+    quoteResults, quoteErrs := clients.GetBTCQuotes(fetchCtx, currencies)
+    budgetExceeded := false
+    for i, currency := range currencies {
+        pair := fmt.Sprintf("BTC/%s", currency)
+        quote, err := quoteResults[i], quoteErrs[i]
+        upstreamLatency += quote.FetchDuration
         if err != nil {
             log.Printf("Error fetching BTC/%s: %v\n", currency, err)
             errorsCount++
             lastError = fmt.Sprintf("BTC/%s: %v", currency, err)
+            reason := failureReason(err)
+            if ctx.Err() == nil && fetchCtx.Err() != nil && errors.Is(err, context.DeadlineExceeded) {
+                reason = ReasonDeadlineExceeded
+                budgetExceeded = true
+            }
+            if reason == ReasonInvalidPair {
+                invalidPairs = append(invalidPairs, pair)
+            } else {
+                failedPairs = append(failedPairs, pair)
+            }
+            pairErrors = append(pairErrors, PairError{Pair: pair, Reason: reason})
             continue
         }
 
         prices = append(prices, PairPrice{
-            Pair:   fmt.Sprintf("BTC/%s", currency),
-            Amount: price,
+            Pair:       pair,
+            Amount:     quote.Price,
+            Timestamp:  quote.Timestamp.UTC(),
+            AgeSeconds: int64(clock.Since(quote.Timestamp).Seconds()),
+            Cached:     quote.Cached,
+            Stale:      quote.Stale,
+        })
+        quotes = append(quotes, PairQuote{
+            Pair:  pair,
+            Quote: quote,
         })
+        if quote.Cached {
+            cachedPairs = append(cachedPairs, pair)
+        }
     }
 
     span.SetAttributes(
         attribute.Int("prices_fetched", len(prices)),
         attribute.Int("errors_count", errorsCount),
+        attribute.Int("cache_hits", len(cachedPairs)),
+        attribute.Bool("budget_exceeded", budgetExceeded),
     )
 
     return PriceResult{
-        Prices:       prices,
-        ErrorsCount:  errorsCount,
-        KrakenCalls:  len(currencies), // Each currency requires one Kraken API call
-        ErrorMessage: lastError,
+        Prices:          prices,
+        Quotes:          quotes,
+        InvalidPairs:    invalidPairs,
+        FailedPairs:     failedPairs,
+        Errors:          pairErrors,
+        CachedPairs:     cachedPairs,
+        ErrorsCount:     errorsCount,
+        KrakenCalls:     len(currencies), // Each currency requires one Kraken API call
+        ErrorMessage:    lastError,
+        UpstreamLatency: upstreamLatency,
+        BudgetExceeded:  budgetExceeded,
     }
 }
 
-func resolveCurrencies(pairsParam string) []string {
+// failureReason maps a price fetch error to its PairError reason
+func failureReason(err error) string {
+    switch {
+    case errors.Is(err, clients.ErrPairNotSupported):
+        return ReasonInvalidPair
+    case errors.Is(err, clients.ErrExchangeMaintenance):
+        return ReasonExchangeMaintenance
+    case errors.Is(err, clients.ErrUpstreamRateLimited):
+        return ReasonUpstreamRateLimited
+    case errors.Is(err, clients.ErrBypassLimited):
+        return ReasonBypassRateLimited
+    case errors.Is(err, clients.ErrUpstreamTimeout):
+        return ReasonUpstreamTimeout
+    case errors.Is(err, clients.ErrParse):
+        return ReasonUpstreamInvalidResponse
+    default:
+        return ReasonUpstreamUnavailable
+    }
+}
+
+// DefaultCurrencies are the quote currencies served when no pairs are
+// requested
+func DefaultCurrencies() []string {
+    return []string{"USD", "EUR", "CHF"}
+}
+
+// resolveCurrencies returns the quote currencies of the requested BTC
+// pairs, along with any pairs that are not BTC pairs in a known convention
+func resolveCurrencies(pairsParam string) ([]string, []string) {
     if pairsParam == "" {
-        return []string{"USD", "EUR", "CHF"}
+        return DefaultCurrencies(), nil
     }
 
     pairs := splitPairs(pairsParam)
     var currencies []string
+    var invalid []string
     for _, pair := range pairs {
         if currency := extractCurrency(pair); currency != "" {
             currencies = append(currencies, currency)
+        } else {
+            invalid = append(invalid, pair)
         }
     }
-    return currencies
+    return currencies, invalid
 }
 
+// extractCurrency returns the quote currency of a BTC pair in any of the
+// conventions NormalizePair accepts, or "" if it is not one
 func extractCurrency(pair string) string {
-    for i, char := range pair {
-        if char == '/' && i+1 < len(pair) {
-            return pair[i+1:]
-        }
+    canonical, ok := NormalizePair(pair)
+    if !ok {
+        return ""
     }
-    return ""
+    return strings.TrimPrefix(canonical, AssetBTC+"/")
 }
 
 func splitPairs(pairsParam string) []string {
@@ -0,0 +1,47 @@
+package services
+
+import (
+    "context"
+    "log/slog"
+
+    "github.com/chesskiss/btc-service/clients"
+)
+
+// WarmCache fetches the default pairs and the given ones, in any
+// convention NormalizePair accepts, so their prices are cached before
+// traffic arrives. It stops early when ctx is done and returns how many
+// pairs were cached.
+func WarmCache(ctx context.Context, pairs []string) int {
+    currencies := DefaultCurrencies()
+    seen := map[string]bool{}
+    for _, currency := range currencies {
+        seen[currency] = true
+    }
+    for _, pair := range pairs {
+        if currency := extractCurrency(pair); currency != "" && !seen[currency] {
+            seen[currency] = true
+            currencies = append(currencies, currency)
+        }
+    }
+
+    warmed := 0
+    for _, currency := range currencies {
+        if ctx.Err() != nil {
+            break
+        }
+        if _, err := clients.GetBTCQuote(ctx, currency); err != nil {
+            slog.Warn("failed to warm cached price",
+                "pair", AssetBTC+"/"+currency,
+                "error", err,
+            )
+            continue
+        }
+        warmed++
+    }
+
+    slog.Info("price cache warmed",
+        "warmed", warmed,
+        "pairs", len(currencies),
+    )
+    return warmed
+}
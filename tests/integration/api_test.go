@@ -15,7 +15,7 @@ import (
 
 func createTestServer() *httptest.Server {
 	r := mux.NewRouter()
-	r.HandleFunc("/api/v1/ltp", handlers.LTPHandler).Methods("GET")
+	r.HandleFunc("/api/v1/ltp", handlers.LTPHandler(nil)).Methods("GET")
 	return httptest.NewServer(r)
 }
 
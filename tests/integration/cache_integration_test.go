@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/chesskiss/btc-service/clients"
+	"github.com/chesskiss/btc-service/internal/cache"
 	"github.com/chesskiss/btc-service/services"
 	"github.com/redis/go-redis/v9"
 )
@@ -28,11 +29,19 @@ func setupIntegrationRedis(t *testing.T) *redis.Client {
 	return client
 }
 
+// useRedisCache makes the default price service cache in Redis until the
+// test ends
+func useRedisCache(t *testing.T, client *redis.Client) {
+	previous := clients.DefaultService()
+	clients.SetDefaultService(clients.NewPriceService(cache.NewRedis(client), clients.PriceServiceOptions{}))
+	t.Cleanup(func() { clients.SetDefaultService(previous) })
+}
+
 func TestCacheIntegrationFullFlow(t *testing.T) {
 	redisClient := setupIntegrationRedis(t)
 	defer redisClient.Close()
 
-	clients.InitRedis("localhost", "6379", "")
+	useRedisCache(t, redisClient)
 	server := createTestServer()
 	defer server.Close()
 
@@ -84,7 +93,7 @@ func TestCacheIntegrationMultiplePairs(t *testing.T) {
 	redisClient := setupIntegrationRedis(t)
 	defer redisClient.Close()
 
-	clients.InitRedis("localhost", "6379", "")
+	useRedisCache(t, redisClient)
 	server := createTestServer()
 	defer server.Close()
 
@@ -124,7 +133,7 @@ func TestCacheIntegrationExpiry(t *testing.T) {
 	redisClient := setupIntegrationRedis(t)
 	defer redisClient.Close()
 
-	clients.InitRedis("localhost", "6379", "")
+	useRedisCache(t, redisClient)
 
 	// Get price to cache it
 	_, err := clients.GetBTCPrice(context.Background(), "USD")
@@ -168,7 +177,7 @@ func TestCacheIntegrationConcurrentRequests(t *testing.T) {
 	redisClient := setupIntegrationRedis(t)
 	defer redisClient.Close()
 
-	clients.InitRedis("localhost", "6379", "")
+	useRedisCache(t, redisClient)
 	server := createTestServer()
 	defer server.Close()
 
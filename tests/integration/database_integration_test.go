@@ -1,6 +1,7 @@
 package integration
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
@@ -10,7 +11,7 @@ import (
 	"time"
 
 	"github.com/gorilla/mux"
-	_ "github.com/lib/pq"
+	_ "github.com/jackc/pgx/v5/stdlib"
 
 	"github.com/chesskiss/btc-service/handlers"
 	"github.com/chesskiss/btc-service/internal/database"
@@ -18,18 +19,18 @@ import (
 )
 
 // setupIntegrationDB creates a test database for integration tests
-func setupIntegrationDB(t *testing.T) *sql.DB {
+func setupIntegrationDB(t *testing.T) (*sql.DB, *database.RequestLogWriter) {
 	connStr := "host=localhost port=5432 user=postgres password=postgres dbname=btc_service_test sslmode=disable"
 
-	db, err := sql.Open("postgres", connStr)
+	db, err := sql.Open("pgx", connStr)
 	if err != nil {
 		t.Skipf("Skipping integration tests: PostgreSQL not available: %v", err)
-		return nil
+		return nil, nil
 	}
 
 	if err := db.Ping(); err != nil {
 		t.Skipf("Skipping integration tests: PostgreSQL not reachable: %v", err)
-		return nil
+		return nil, nil
 	}
 
 	// Create test database schema
@@ -37,8 +38,8 @@ func setupIntegrationDB(t *testing.T) *sql.DB {
 		DROP TABLE IF EXISTS request_logs;
 		CREATE TABLE request_logs (
 			id SERIAL PRIMARY KEY,
-			request_id VARCHAR(36) UNIQUE,
-			timestamp TIMESTAMP DEFAULT NOW(),
+			request_id VARCHAR(128) UNIQUE,
+			timestamp TIMESTAMPTZ DEFAULT NOW(),
 			method VARCHAR(10),
 			endpoint VARCHAR(100),
 			pairs_requested TEXT,
@@ -48,7 +49,16 @@ func setupIntegrationDB(t *testing.T) *sql.DB {
 			cache_hit BOOLEAN,
 			kraken_calls INT,
 			error_occurred BOOLEAN,
-			error_message TEXT
+			error_message TEXT,
+			trace_id VARCHAR(32),
+			tenant_id VARCHAR(64),
+			user_agent TEXT,
+			response_bytes INT,
+			upstream_latency_ms INT,
+			cached_pairs TEXT,
+			api_key_id VARCHAR(64),
+			pair_outcomes JSONB,
+			deleted_at TIMESTAMPTZ
 		);
 		CREATE INDEX idx_timestamp ON request_logs(timestamp);
 		CREATE INDEX idx_status ON request_logs(status_code);
@@ -59,7 +69,17 @@ func setupIntegrationDB(t *testing.T) *sql.DB {
 		t.Fatalf("Failed to create test schema: %v", err)
 	}
 
-	return db
+	// Handlers queue their logs for a writer, flushed often enough for
+	// waitForAsyncLog
+	pool, err := database.InitDB(context.Background(), "localhost", "5432", "postgres", "postgres", "btc_service_test", database.TLSOptions{})
+	if err != nil {
+		t.Skipf("Skipping integration tests: Cannot initialize database: %v", err)
+	}
+	t.Cleanup(pool.Close)
+	writer := database.StartRequestLogWriter(database.NewPostgresStore(pool), nil, 1000, 100, 10*time.Millisecond)
+	t.Cleanup(func() { writer.Close(context.Background()) })
+
+	return db, writer
 }
 
 // cleanupIntegrationDB removes all data from the test database
@@ -79,23 +99,15 @@ func waitForAsyncLog() {
 }
 
 func TestDatabaseIntegration_SuccessfulRequest(t *testing.T) {
-	db := setupIntegrationDB(t)
+	db, writer := setupIntegrationDB(t)
 	if db == nil {
 		return
 	}
 	defer cleanupIntegrationDB(t, db)
 
-	// Initialize database package
-	_, err := database.InitDB("localhost", "5432", "postgres", "postgres", "btc_service_test")
-	if err != nil {
-		t.Skipf("Skipping test: Cannot initialize database: %v", err)
-		return
-	}
-	defer database.Close()
-
 	// Create router with middleware
 	r := mux.NewRouter()
-	r.HandleFunc("/api/v1/ltp", handlers.LTPHandler).Methods("GET")
+	r.HandleFunc("/api/v1/ltp", handlers.LTPHandler(writer)).Methods("GET")
 	handler := middleware.LoggingMiddleware(r)
 
 	// Make request
@@ -115,7 +127,7 @@ func TestDatabaseIntegration_SuccessfulRequest(t *testing.T) {
 
 	// Verify database logging
 	var count int
-	err = db.QueryRow("SELECT COUNT(*) FROM request_logs").Scan(&count)
+	err := db.QueryRow("SELECT COUNT(*) FROM request_logs").Scan(&count)
 	if err != nil {
 		t.Fatalf("Failed to query database: %v", err)
 	}
@@ -164,21 +176,14 @@ func TestDatabaseIntegration_SuccessfulRequest(t *testing.T) {
 }
 
 func TestDatabaseIntegration_MultiplePairs(t *testing.T) {
-	db := setupIntegrationDB(t)
+	db, writer := setupIntegrationDB(t)
 	if db == nil {
 		return
 	}
 	defer cleanupIntegrationDB(t, db)
 
-	_, err := database.InitDB("localhost", "5432", "postgres", "postgres", "btc_service_test")
-	if err != nil {
-		t.Skipf("Skipping test: Cannot initialize database: %v", err)
-		return
-	}
-	defer database.Close()
-
 	r := mux.NewRouter()
-	r.HandleFunc("/api/v1/ltp", handlers.LTPHandler).Methods("GET")
+	r.HandleFunc("/api/v1/ltp", handlers.LTPHandler(writer)).Methods("GET")
 	handler := middleware.LoggingMiddleware(r)
 
 	// Request multiple pairs
@@ -191,7 +196,7 @@ func TestDatabaseIntegration_MultiplePairs(t *testing.T) {
 
 	// Verify pairs were logged
 	var pairsRequested string
-	err = db.QueryRow("SELECT pairs_requested FROM request_logs LIMIT 1").Scan(&pairsRequested)
+	err := db.QueryRow("SELECT pairs_requested FROM request_logs LIMIT 1").Scan(&pairsRequested)
 	if err != nil {
 		t.Fatalf("Failed to retrieve pairs_requested: %v", err)
 	}
@@ -213,21 +218,14 @@ func TestDatabaseIntegration_MultiplePairs(t *testing.T) {
 }
 
 func TestDatabaseIntegration_MultipleRequests(t *testing.T) {
-	db := setupIntegrationDB(t)
+	db, writer := setupIntegrationDB(t)
 	if db == nil {
 		return
 	}
 	defer cleanupIntegrationDB(t, db)
 
-	_, err := database.InitDB("localhost", "5432", "postgres", "postgres", "btc_service_test")
-	if err != nil {
-		t.Skipf("Skipping test: Cannot initialize database: %v", err)
-		return
-	}
-	defer database.Close()
-
 	r := mux.NewRouter()
-	r.HandleFunc("/api/v1/ltp", handlers.LTPHandler).Methods("GET")
+	r.HandleFunc("/api/v1/ltp", handlers.LTPHandler(writer)).Methods("GET")
 	handler := middleware.LoggingMiddleware(r)
 
 	// Make multiple requests
@@ -242,7 +240,7 @@ func TestDatabaseIntegration_MultipleRequests(t *testing.T) {
 
 	// Verify all requests were logged
 	var count int
-	err = db.QueryRow("SELECT COUNT(*) FROM request_logs").Scan(&count)
+	err := db.QueryRow("SELECT COUNT(*) FROM request_logs").Scan(&count)
 	if err != nil {
 		t.Fatalf("Failed to query database: %v", err)
 	}
@@ -264,21 +262,14 @@ func TestDatabaseIntegration_MultipleRequests(t *testing.T) {
 }
 
 func TestDatabaseIntegration_RequestIDPropagation(t *testing.T) {
-	db := setupIntegrationDB(t)
+	db, writer := setupIntegrationDB(t)
 	if db == nil {
 		return
 	}
 	defer cleanupIntegrationDB(t, db)
 
-	_, err := database.InitDB("localhost", "5432", "postgres", "postgres", "btc_service_test")
-	if err != nil {
-		t.Skipf("Skipping test: Cannot initialize database: %v", err)
-		return
-	}
-	defer database.Close()
-
 	r := mux.NewRouter()
-	r.HandleFunc("/api/v1/ltp", handlers.LTPHandler).Methods("GET")
+	r.HandleFunc("/api/v1/ltp", handlers.LTPHandler(writer)).Methods("GET")
 	handler := middleware.LoggingMiddleware(r)
 
 	req := httptest.NewRequest("GET", "/api/v1/ltp?pairs=BTC/USD", nil)
@@ -289,7 +280,7 @@ func TestDatabaseIntegration_RequestIDPropagation(t *testing.T) {
 
 	// Verify request_id is a valid UUID format
 	var requestID string
-	err = db.QueryRow("SELECT request_id FROM request_logs LIMIT 1").Scan(&requestID)
+	err := db.QueryRow("SELECT request_id FROM request_logs LIMIT 1").Scan(&requestID)
 	if err != nil {
 		t.Fatalf("Failed to retrieve request_id: %v", err)
 	}
@@ -306,21 +297,14 @@ func TestDatabaseIntegration_RequestIDPropagation(t *testing.T) {
 }
 
 func TestDatabaseIntegration_ResponseTimeTracking(t *testing.T) {
-	db := setupIntegrationDB(t)
+	db, writer := setupIntegrationDB(t)
 	if db == nil {
 		return
 	}
 	defer cleanupIntegrationDB(t, db)
 
-	_, err := database.InitDB("localhost", "5432", "postgres", "postgres", "btc_service_test")
-	if err != nil {
-		t.Skipf("Skipping test: Cannot initialize database: %v", err)
-		return
-	}
-	defer database.Close()
-
 	r := mux.NewRouter()
-	r.HandleFunc("/api/v1/ltp", handlers.LTPHandler).Methods("GET")
+	r.HandleFunc("/api/v1/ltp", handlers.LTPHandler(writer)).Methods("GET")
 	handler := middleware.LoggingMiddleware(r)
 
 	req := httptest.NewRequest("GET", "/api/v1/ltp?pairs=BTC/USD", nil)
@@ -331,7 +315,7 @@ func TestDatabaseIntegration_ResponseTimeTracking(t *testing.T) {
 
 	// Verify response time is tracked
 	var responseTimeMs int
-	err = db.QueryRow("SELECT response_time_ms FROM request_logs LIMIT 1").Scan(&responseTimeMs)
+	err := db.QueryRow("SELECT response_time_ms FROM request_logs LIMIT 1").Scan(&responseTimeMs)
 	if err != nil {
 		t.Fatalf("Failed to retrieve response_time_ms: %v", err)
 	}
@@ -346,11 +330,9 @@ func TestDatabaseIntegration_ResponseTimeTracking(t *testing.T) {
 }
 
 func TestDatabaseIntegration_WithoutDatabaseConnection(t *testing.T) {
-	// Close database connection to simulate failure
-	database.Close()
-
+	// No request log writer is running, as when the database is down
 	r := mux.NewRouter()
-	r.HandleFunc("/api/v1/ltp", handlers.LTPHandler).Methods("GET")
+	r.HandleFunc("/api/v1/ltp", handlers.LTPHandler(nil)).Methods("GET")
 	handler := middleware.LoggingMiddleware(r)
 
 	req := httptest.NewRequest("GET", "/api/v1/ltp?pairs=BTC/USD", nil)
@@ -372,26 +354,19 @@ func TestDatabaseIntegration_WithoutDatabaseConnection(t *testing.T) {
 }
 
 func TestDatabaseIntegration_IPAddressExtraction(t *testing.T) {
-	db := setupIntegrationDB(t)
+	db, writer := setupIntegrationDB(t)
 	if db == nil {
 		return
 	}
 	defer cleanupIntegrationDB(t, db)
 
-	_, err := database.InitDB("localhost", "5432", "postgres", "postgres", "btc_service_test")
-	if err != nil {
-		t.Skipf("Skipping test: Cannot initialize database: %v", err)
-		return
-	}
-	defer database.Close()
-
 	tests := []struct {
-		name           string
-		headerName     string
-		headerValue    string
-		expectedIP     string
-		useRemoteAddr  bool
-		remoteAddrVal  string
+		name          string
+		headerName    string
+		headerValue   string
+		expectedIP    string
+		useRemoteAddr bool
+		remoteAddrVal string
 	}{
 		{
 			name:        "X-Forwarded-For single IP",
@@ -420,7 +395,7 @@ func TestDatabaseIntegration_IPAddressExtraction(t *testing.T) {
 	}
 
 	r := mux.NewRouter()
-	r.HandleFunc("/api/v1/ltp", handlers.LTPHandler).Methods("GET")
+	r.HandleFunc("/api/v1/ltp", handlers.LTPHandler(writer)).Methods("GET")
 	handler := middleware.LoggingMiddleware(r)
 
 	for _, tt := range tests {
@@ -455,21 +430,14 @@ func TestDatabaseIntegration_IPAddressExtraction(t *testing.T) {
 }
 
 func TestDatabaseIntegration_EmptyPairsParameter(t *testing.T) {
-	db := setupIntegrationDB(t)
+	db, writer := setupIntegrationDB(t)
 	if db == nil {
 		return
 	}
 	defer cleanupIntegrationDB(t, db)
 
-	_, err := database.InitDB("localhost", "5432", "postgres", "postgres", "btc_service_test")
-	if err != nil {
-		t.Skipf("Skipping test: Cannot initialize database: %v", err)
-		return
-	}
-	defer database.Close()
-
 	r := mux.NewRouter()
-	r.HandleFunc("/api/v1/ltp", handlers.LTPHandler).Methods("GET")
+	r.HandleFunc("/api/v1/ltp", handlers.LTPHandler(writer)).Methods("GET")
 	handler := middleware.LoggingMiddleware(r)
 
 	// Request without pairs parameter
@@ -482,7 +450,7 @@ func TestDatabaseIntegration_EmptyPairsParameter(t *testing.T) {
 	// Verify pairs_requested is empty string (not null)
 	var pairsRequested string
 	var found bool
-	err = db.QueryRow("SELECT pairs_requested FROM request_logs LIMIT 1").Scan(&pairsRequested)
+	err := db.QueryRow("SELECT pairs_requested FROM request_logs LIMIT 1").Scan(&pairsRequested)
 	if err != nil {
 		t.Fatalf("Failed to retrieve pairs_requested: %v", err)
 	}
@@ -499,7 +467,7 @@ func TestDatabaseIntegration_EmptyPairsParameter(t *testing.T) {
 }
 
 func TestDatabaseIntegration_IndexesExist(t *testing.T) {
-	db := setupIntegrationDB(t)
+	db, _ := setupIntegrationDB(t)
 	if db == nil {
 		return
 	}
@@ -530,21 +498,14 @@ func TestDatabaseIntegration_IndexesExist(t *testing.T) {
 }
 
 func TestDatabaseIntegration_ErrorLogging(t *testing.T) {
-	db := setupIntegrationDB(t)
+	db, writer := setupIntegrationDB(t)
 	if db == nil {
 		return
 	}
 	defer cleanupIntegrationDB(t, db)
 
-	_, err := database.InitDB("localhost", "5432", "postgres", "postgres", "btc_service_test")
-	if err != nil {
-		t.Skipf("Skipping test: Cannot initialize database: %v", err)
-		return
-	}
-	defer database.Close()
-
 	r := mux.NewRouter()
-	r.HandleFunc("/api/v1/ltp", handlers.LTPHandler).Methods("GET")
+	r.HandleFunc("/api/v1/ltp", handlers.LTPHandler(writer)).Methods("GET")
 	handler := middleware.LoggingMiddleware(r)
 
 	// Request with invalid pair that will cause Kraken API error
@@ -559,7 +520,7 @@ func TestDatabaseIntegration_ErrorLogging(t *testing.T) {
 	var errorMessage string
 	var statusCode int
 	var krakenCalls int
-	err = db.QueryRow(`
+	err := db.QueryRow(`
 		SELECT error_occurred, error_message, status_code, kraken_calls
 		FROM request_logs
 		LIMIT 1
@@ -576,8 +537,8 @@ func TestDatabaseIntegration_ErrorLogging(t *testing.T) {
 		t.Errorf("Expected error_message to be non-empty")
 	}
 
-	if statusCode != 503 {
-		t.Errorf("Expected status code 503 (service unavailable), got %d", statusCode)
+	if statusCode != 400 {
+		t.Errorf("Expected status code 400 (invalid pair), got %d", statusCode)
 	}
 
 	if krakenCalls != 1 {
@@ -591,21 +552,14 @@ func TestDatabaseIntegration_ErrorLogging(t *testing.T) {
 }
 
 func TestDatabaseIntegration_KrakenCallsTracking(t *testing.T) {
-	db := setupIntegrationDB(t)
+	db, writer := setupIntegrationDB(t)
 	if db == nil {
 		return
 	}
 	defer cleanupIntegrationDB(t, db)
 
-	_, err := database.InitDB("localhost", "5432", "postgres", "postgres", "btc_service_test")
-	if err != nil {
-		t.Skipf("Skipping test: Cannot initialize database: %v", err)
-		return
-	}
-	defer database.Close()
-
 	r := mux.NewRouter()
-	r.HandleFunc("/api/v1/ltp", handlers.LTPHandler).Methods("GET")
+	r.HandleFunc("/api/v1/ltp", handlers.LTPHandler(writer)).Methods("GET")
 	handler := middleware.LoggingMiddleware(r)
 
 	testCases := []struct {
@@ -649,21 +603,14 @@ func TestDatabaseIntegration_KrakenCallsTracking(t *testing.T) {
 }
 
 func TestDatabaseIntegration_PartialFailure(t *testing.T) {
-	db := setupIntegrationDB(t)
+	db, writer := setupIntegrationDB(t)
 	if db == nil {
 		return
 	}
 	defer cleanupIntegrationDB(t, db)
 
-	_, err := database.InitDB("localhost", "5432", "postgres", "postgres", "btc_service_test")
-	if err != nil {
-		t.Skipf("Skipping test: Cannot initialize database: %v", err)
-		return
-	}
-	defer database.Close()
-
 	r := mux.NewRouter()
-	r.HandleFunc("/api/v1/ltp", handlers.LTPHandler).Methods("GET")
+	r.HandleFunc("/api/v1/ltp", handlers.LTPHandler(writer)).Methods("GET")
 	handler := middleware.LoggingMiddleware(r)
 
 	// Request with mix of valid and invalid pairs
@@ -676,7 +623,7 @@ func TestDatabaseIntegration_PartialFailure(t *testing.T) {
 	var errorOccurred bool
 	var statusCode int
 	var krakenCalls int
-	err = db.QueryRow(`
+	err := db.QueryRow(`
 		SELECT error_occurred, status_code, kraken_calls
 		FROM request_logs
 		LIMIT 1
@@ -701,21 +648,14 @@ func TestDatabaseIntegration_PartialFailure(t *testing.T) {
 }
 
 func TestDatabaseIntegration_QueryByTimestamp(t *testing.T) {
-	db := setupIntegrationDB(t)
+	db, writer := setupIntegrationDB(t)
 	if db == nil {
 		return
 	}
 	defer cleanupIntegrationDB(t, db)
 
-	_, err := database.InitDB("localhost", "5432", "postgres", "postgres", "btc_service_test")
-	if err != nil {
-		t.Skipf("Skipping test: Cannot initialize database: %v", err)
-		return
-	}
-	defer database.Close()
-
 	r := mux.NewRouter()
-	r.HandleFunc("/api/v1/ltp", handlers.LTPHandler).Methods("GET")
+	r.HandleFunc("/api/v1/ltp", handlers.LTPHandler(writer)).Methods("GET")
 	handler := middleware.LoggingMiddleware(r)
 
 	// Make multiple requests
@@ -761,3 +701,62 @@ func TestDatabaseIntegration_QueryByTimestamp(t *testing.T) {
 		t.Errorf("Expected 3 records, got %d", count)
 	}
 }
+
+func TestDatabaseIntegration_EnrichedFields(t *testing.T) {
+	db, writer := setupIntegrationDB(t)
+	if db == nil {
+		return
+	}
+	defer cleanupIntegrationDB(t, db)
+
+	r := mux.NewRouter()
+	r.HandleFunc("/api/v1/ltp", handlers.LTPHandler(writer)).Methods("GET")
+	// Stand in for authentication, which identifies the caller's API key
+	authenticated := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.ServeHTTP(w, req.WithContext(middleware.WithAPIKeyID(req.Context(), "key-7")))
+	})
+	handler := middleware.LoggingMiddleware(authenticated)
+
+	req := httptest.NewRequest("GET", "/api/v1/ltp?pairs=BTC/USD", nil)
+	req.Header.Set("User-Agent", "integration-test/1.0")
+	req.Header.Set("X-Tenant-ID", "tenant-42")
+	// Tenant IDs are only accepted from the gateway, here httptest's
+	// default client address
+	proxies, err := middleware.ParseTrustedProxies([]string{"192.0.2.1"})
+	if err != nil {
+		t.Fatalf("ParseTrustedProxies failed: %v", err)
+	}
+	middleware.SetTrustedProxies(proxies)
+	t.Cleanup(func() { middleware.SetTrustedProxies(nil) })
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, req)
+	waitForAsyncLog()
+
+	var userAgent, tenantID, apiKeyID string
+	var responseBytes, upstreamLatencyMs int
+	err = db.QueryRow(`
+		SELECT user_agent, tenant_id, response_bytes, upstream_latency_ms, api_key_id
+		FROM request_logs
+		LIMIT 1
+	`).Scan(&userAgent, &tenantID, &responseBytes, &upstreamLatencyMs, &apiKeyID)
+	if err != nil {
+		t.Fatalf("Failed to query enriched fields: %v", err)
+	}
+
+	if userAgent != "integration-test/1.0" {
+		t.Errorf("Expected user agent integration-test/1.0, got %s", userAgent)
+	}
+	if tenantID != "tenant-42" {
+		t.Errorf("Expected tenant tenant-42, got %s", tenantID)
+	}
+	if apiKeyID != "key-7" {
+		t.Errorf("Expected API key key-7, got %s", apiKeyID)
+	}
+	if responseBytes != w.Body.Len() {
+		t.Errorf("Expected response_bytes %d, got %d", w.Body.Len(), responseBytes)
+	}
+	if upstreamLatencyMs < 0 {
+		t.Errorf("Expected non-negative upstream latency, got %d", upstreamLatencyMs)
+	}
+}
@@ -0,0 +1,397 @@
+package unit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chesskiss/btc-service/internal/database"
+	internalHandlers "github.com/chesskiss/btc-service/internal/handlers"
+	"github.com/chesskiss/btc-service/internal/pagination"
+	"github.com/chesskiss/btc-service/internal/problem"
+)
+
+func TestRequestLogsHandlerInvalidParams(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+	}{
+		{name: "Invalid from", query: "from=yesterday"},
+		{name: "Invalid to", query: "to=2024-13-01"},
+		{name: "Invalid status", query: "status=abc"},
+		{name: "Out of range status", query: "status=42"},
+		{name: "Invalid error flag", query: "error=maybe"},
+		{name: "Zero limit", query: "limit=0"},
+		{name: "Limit too large", query: "limit=10000"},
+		{name: "Negative offset", query: "offset=-1"},
+		{name: "Unknown field", query: "fields=request_id,password"},
+		{name: "Invalid sort", query: "sort=user_ip"},
+		{name: "Invalid cursor", query: "cursor=abc"},
+		{name: "Pattern as pair", query: "pair=%25"},
+		{name: "Cursor value of the wrong type", query: "sort=status_code&cursor=eyJzIjoic3RhdHVzX2NvZGUiLCJ2IjoiYWJjIiwiaWQiOjF9"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/v1/admin/requests?"+tt.query, nil)
+			w := httptest.NewRecorder()
+
+			internalHandlers.RequestLogsHandler(&fakeStore{})(w, req)
+
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("got status %d, want %d", w.Code, http.StatusBadRequest)
+			}
+
+			var body problem.Details
+			if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+				t.Fatalf("JSON decode failed: %v", err)
+			}
+			if body.Code != problem.CodeInvalidParameter {
+				t.Errorf("got code %q, want %q", body.Code, problem.CodeInvalidParameter)
+			}
+			if body.Detail == "" {
+				t.Error("expected error detail in response")
+			}
+		})
+	}
+}
+
+func TestRequestLogsHandlerWithoutDatabase(t *testing.T) {
+	req := httptest.NewRequest("GET", "/api/v1/admin/requests?status=200&error=false", nil)
+	w := httptest.NewRecorder()
+
+	internalHandlers.RequestLogsHandler(&fakeStore{err: errStoreUnavailable})(w, req)
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+}
+
+func TestRequestLogsHandlerListsStoredRequests(t *testing.T) {
+	store := &fakeStore{requestLogs: []database.RequestLog{
+		{ID: 3, RequestID: "req-3", StatusCode: 503},
+		{ID: 2, RequestID: "req-2", StatusCode: 200},
+		{ID: 1, RequestID: "req-1", StatusCode: 200},
+	}}
+
+	req := httptest.NewRequest("GET", "/api/v1/admin/requests?status=200&limit=1&fields=request_id", nil)
+	w := httptest.NewRecorder()
+
+	internalHandlers.RequestLogsHandler(store)(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusOK)
+	}
+	var body struct {
+		Requests   []map[string]any `json:"requests"`
+		NextCursor string           `json:"next_cursor"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("JSON decode failed: %v", err)
+	}
+	if len(body.Requests) != 1 || body.Requests[0]["request_id"] != "req-2" {
+		t.Errorf("got requests %v, want only req-2", body.Requests)
+	}
+	if body.NextCursor == "" {
+		t.Error("expected a cursor to the next page")
+	}
+}
+
+func TestQueryRequests_Filters(t *testing.T) {
+	db := setupTestDB(t)
+	if db == nil {
+		return
+	}
+	defer cleanupTestDB(t, db)
+
+	store := newTestStore(t)
+
+	logs := []database.RequestLog{
+		{RequestID: "query-1", Method: "GET", Endpoint: "/api/v1/ltp", PairsRequested: "BTC/USD", StatusCode: 200,
+			PairOutcomes: []database.PairOutcome{{Pair: "BTC/USD", Success: true}}},
+		{RequestID: "query-2", Method: "GET", Endpoint: "/api/v1/ltp", PairsRequested: "BTC/EUR", StatusCode: 200,
+			PairOutcomes: []database.PairOutcome{{Pair: "BTC/EUR", Success: true}}},
+		{RequestID: "query-3", Method: "GET", Endpoint: "/api/v1/ltp", PairsRequested: "btcusd,BTC/CHF", StatusCode: 503, ErrorOccurred: true, ErrorMessage: "BTC/CHF: timeout",
+			PairOutcomes: []database.PairOutcome{{Pair: "BTC/USD", Success: true}, {Pair: "BTC/CHF", Reason: "timeout"}}},
+	}
+	for _, reqLog := range logs {
+		if err := store.LogRequest(context.Background(), reqLog); err != nil {
+			t.Fatalf("Failed to log request: %v", err)
+		}
+	}
+
+	errorsOnly := true
+	tests := []struct {
+		name   string
+		filter database.RequestLogFilter
+		want   int
+	}{
+		{name: "No filter", filter: database.RequestLogFilter{Limit: 10}, want: 3},
+		{name: "By status", filter: database.RequestLogFilter{StatusCode: 503, Limit: 10}, want: 1},
+		{name: "By error", filter: database.RequestLogFilter{ErrorOccurred: &errorsOnly, Limit: 10}, want: 1},
+		{name: "By pair", filter: database.RequestLogFilter{Pair: "BTC/USD", Limit: 10}, want: 2},
+		{name: "By pair, not a pattern", filter: database.RequestLogFilter{Pair: "BTC/%", Limit: 10}, want: 0},
+		{name: "Paginated", filter: database.RequestLogFilter{Limit: 2, Offset: 2}, want: 1},
+		{name: "Sorted", filter: database.RequestLogFilter{Sort: pagination.Sort{Field: "status_code", Desc: true}, Limit: 1}, want: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := store.QueryRequests(context.Background(), tt.filter)
+			if err != nil {
+				t.Fatalf("QueryRequests failed: %v", err)
+			}
+			if len(got) != tt.want {
+				t.Errorf("got %d records, want %d", len(got), tt.want)
+			}
+		})
+	}
+}
+
+func TestQueryRequests_CursorPages(t *testing.T) {
+	db := setupTestDB(t)
+	if db == nil {
+		return
+	}
+	defer cleanupTestDB(t, db)
+
+	store := newTestStore(t)
+
+	for i := 0; i < 5; i++ {
+		if err := store.LogRequest(context.Background(), database.RequestLog{RequestID: fmt.Sprintf("page-%d", i), StatusCode: 200}); err != nil {
+			t.Fatalf("Failed to log request: %v", err)
+		}
+	}
+
+	seen := map[string]bool{}
+	page := pagination.Page{Limit: 2, Sort: database.DefaultRequestLogSort}
+	for pages := 0; pages < 5; pages++ {
+		logs, err := store.QueryRequests(context.Background(), database.RequestLogFilter{Limit: page.Limit, Sort: page.Sort, After: page.After})
+		if err != nil {
+			t.Fatalf("QueryRequests failed: %v", err)
+		}
+		for _, reqLog := range logs {
+			if seen[reqLog.RequestID] {
+				t.Fatalf("request %s returned on two pages", reqLog.RequestID)
+			}
+			seen[reqLog.RequestID] = true
+		}
+		if len(logs) < page.Limit {
+			break
+		}
+		last := logs[len(logs)-1]
+		page.After = &pagination.Cursor{Sort: page.Sort.String(), Value: database.RequestLogSortValue(last, page.Sort), ID: last.ID}
+	}
+
+	if len(seen) != 5 {
+		t.Errorf("paged through %d requests, want 5", len(seen))
+	}
+}
+
+func TestPurgeHandlerInvalidBody(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{
+		{name: "Malformed JSON", body: `{"tenant_id":`},
+		{name: "Unknown field", body: `{"email":"a@example.com"}`},
+		{name: "No subject", body: `{"mode":"hard"}`},
+		{name: "Multiple subjects", body: `{"tenant_id":"t1","ip":"10.0.0.1"}`},
+		{name: "API key and IP", body: `{"api_key_id":"partner","ip":"10.0.0.1"}`},
+		{name: "Invalid mode", body: `{"ip":"10.0.0.1","mode":"shred"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/api/v1/admin/purge", strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+
+			internalHandlers.PurgeHandler(&fakeStore{}, nil, nil, nil)(w, req)
+
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("got status %d, want %d", w.Code, http.StatusBadRequest)
+			}
+		})
+	}
+}
+
+// purgerFunc adapts a function to database.RequestLogPurger
+type purgerFunc func(ctx context.Context, req database.PurgeRequest) error
+
+func (f purgerFunc) PurgeRequestLogs(ctx context.Context, req database.PurgeRequest) error {
+	return f(ctx, req)
+}
+
+func TestPurgeHandlerPurgesEveryTarget(t *testing.T) {
+	spool, err := database.NewSpool(filepath.Join(t.TempDir(), "request_logs.spool"), 1<<20)
+	if err != nil {
+		t.Fatalf("NewSpool failed: %v", err)
+	}
+	if err := spool.Append([]database.RequestLog{
+		{RequestID: "spooled-1", APIKeyID: "partner"},
+		{RequestID: "spooled-2", APIKeyID: "other"},
+		{RequestID: "spooled-3", APIKeyID: "partner"},
+	}); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+
+	var purged database.PurgeRequest
+	targets := []database.PurgeTarget{
+		{Name: "spool", Purger: spool},
+		{Name: "clickhouse", Purger: purgerFunc(func(ctx context.Context, req database.PurgeRequest) error {
+			purged = req
+			return errStoreUnavailable
+		})},
+	}
+	req := httptest.NewRequest("POST", "/api/v1/admin/purge", strings.NewReader(`{"api_key_id":"partner","reason":"erasure request"}`))
+	w := httptest.NewRecorder()
+
+	internalHandlers.PurgeHandler(&fakeStore{}, nil, targets, []string{"kafka"})(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	var result database.PurgeResult
+	if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+		t.Fatalf("failed to decode result: %v", err)
+	}
+	if strings.Join(result.NotPurged, ",") != "clickhouse,kafka" {
+		t.Errorf("got not_purged %v, want the failed target and kafka", result.NotPurged)
+	}
+	if purged.SubjectType != database.SubjectAPIKey || purged.Subject != "partner" || purged.Mode != database.PurgeModeHard {
+		t.Errorf("unexpected purge request %+v", purged)
+	}
+
+	var remaining []string
+	if _, err := spool.Replay(context.Background(), 10, func(ctx context.Context, batch []database.RequestLog) error {
+		for _, reqLog := range batch {
+			remaining = append(remaining, reqLog.RequestID)
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("Replay failed: %v", err)
+	}
+	if strings.Join(remaining, ",") != "spooled-2" {
+		t.Errorf("got %v left spooled, want spooled-2", remaining)
+	}
+}
+
+func TestPurgeRequestMatches(t *testing.T) {
+	reqLog := database.RequestLog{UserIP: "10.0.0.1", TenantID: "tenant-a", APIKeyID: "partner"}
+	tests := []struct {
+		name string
+		req  database.PurgeRequest
+		want bool
+	}{
+		{name: "Tenant", req: database.PurgeRequest{SubjectType: database.SubjectTenant, Subject: "tenant-a"}, want: true},
+		{name: "Other tenant", req: database.PurgeRequest{SubjectType: database.SubjectTenant, Subject: "tenant-b"}},
+		{name: "IP", req: database.PurgeRequest{SubjectType: database.SubjectIP, Subject: "10.0.0.1"}, want: true},
+		{name: "IP hash", req: database.PurgeRequest{SubjectType: database.SubjectIPHash, Subject: strings.ToUpper(database.HashSubject("10.0.0.1"))}, want: true},
+		{name: "API key", req: database.PurgeRequest{SubjectType: database.SubjectAPIKey, Subject: "partner"}, want: true},
+		{name: "Unknown subject type", req: database.PurgeRequest{SubjectType: "email", Subject: "partner"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.req.Matches(reqLog); got != tt.want {
+				t.Errorf("Matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPurgeRequestMatchesAnonymizedIP(t *testing.T) {
+	anonymizer := database.NewIPAnonymizer(database.IPModeHMAC, "0123456789abcdef", 24*time.Hour)
+	loggedAt := time.Now().Add(-48 * time.Hour)
+	reqLog := database.RequestLog{Timestamp: loggedAt, UserIP: anonymizer.Anonymize("203.0.113.77", loggedAt)}
+
+	if !(database.PurgeRequest{SubjectType: database.SubjectIP, Subject: "203.0.113.77", Anonymizer: anonymizer}).Matches(reqLog) {
+		t.Error("Expected the client's address to match its hashed form")
+	}
+	if (database.PurgeRequest{SubjectType: database.SubjectIP, Subject: "203.0.113.78", Anonymizer: anonymizer}).Matches(reqLog) {
+		t.Error("Expected another address not to match")
+	}
+}
+
+func TestPurgeRequests(t *testing.T) {
+	db := setupTestDB(t)
+	if db == nil {
+		return
+	}
+	defer cleanupTestDB(t, db)
+
+	if _, err := db.Exec(`
+		DROP TABLE IF EXISTS purge_audit;
+		CREATE TABLE purge_audit (
+			id SERIAL PRIMARY KEY,
+			requested_at TIMESTAMPTZ DEFAULT NOW(),
+			subject_type VARCHAR(20) NOT NULL,
+			subject_hash VARCHAR(64) NOT NULL,
+			mode VARCHAR(10) NOT NULL,
+			rows_affected INT NOT NULL,
+			reason TEXT
+		);
+	`); err != nil {
+		t.Fatalf("Failed to create purge_audit: %v", err)
+	}
+
+	store := newTestStore(t)
+
+	for i, ip := range []string{"10.0.0.1", "10.0.0.1", "10.0.0.2"} {
+		if err := store.LogRequest(context.Background(), database.RequestLog{
+			RequestID:  fmt.Sprintf("purge-%d", i),
+			UserIP:     ip,
+			TenantID:   "tenant-a",
+			StatusCode: 200,
+		}); err != nil {
+			t.Fatalf("Failed to log request: %v", err)
+		}
+	}
+
+	soft, err := store.PurgeRequests(context.Background(), database.PurgeRequest{
+		SubjectType: database.SubjectIPHash,
+		Subject:     database.HashSubject("10.0.0.1"),
+		Mode:        database.PurgeModeSoft,
+	})
+	if err != nil {
+		t.Fatalf("soft purge failed: %v", err)
+	}
+	if soft.RowsAffected != 2 {
+		t.Errorf("soft purge affected %d rows, want 2", soft.RowsAffected)
+	}
+
+	visible, err := store.QueryRequests(context.Background(), database.RequestLogFilter{Limit: 10})
+	if err != nil {
+		t.Fatalf("QueryRequests failed: %v", err)
+	}
+	if len(visible) != 1 {
+		t.Errorf("got %d visible rows after soft purge, want 1", len(visible))
+	}
+
+	hard, err := store.PurgeRequests(context.Background(), database.PurgeRequest{
+		SubjectType: database.SubjectTenant,
+		Subject:     "tenant-a",
+		Mode:        database.PurgeModeHard,
+		Reason:      "erasure request",
+	})
+	if err != nil {
+		t.Fatalf("hard purge failed: %v", err)
+	}
+	if hard.RowsAffected != 3 {
+		t.Errorf("hard purge affected %d rows, want 3", hard.RowsAffected)
+	}
+
+	var audits int
+	if err := db.QueryRow("SELECT COUNT(*) FROM purge_audit WHERE subject_hash <> 'tenant-a'").Scan(&audits); err != nil {
+		t.Fatalf("Failed to count audits: %v", err)
+	}
+	if audits != 2 {
+		t.Errorf("got %d audit records, want 2", audits)
+	}
+}
@@ -0,0 +1,524 @@
+package unit
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/chesskiss/btc-service/internal/alerts"
+	"github.com/chesskiss/btc-service/internal/database"
+	internalHandlers "github.com/chesskiss/btc-service/internal/handlers"
+	"github.com/chesskiss/btc-service/internal/middleware"
+	"github.com/chesskiss/btc-service/internal/retry"
+)
+
+func TestAlertCrossed(t *testing.T) {
+	tests := []struct {
+		name      string
+		direction string
+		previous  float64
+		current   float64
+		want      bool
+	}{
+		{name: "Rises past threshold", direction: database.AlertAbove, previous: 69000, current: 70500, want: true},
+		{name: "Rises to threshold", direction: database.AlertAbove, previous: 69000, current: 70000, want: true},
+		{name: "Stays above", direction: database.AlertAbove, previous: 70500, current: 71000, want: false},
+		{name: "Stays below for above", direction: database.AlertAbove, previous: 68000, current: 69000, want: false},
+		{name: "Falls past threshold", direction: database.AlertBelow, previous: 71000, current: 69000, want: true},
+		{name: "Rises past threshold for below", direction: database.AlertBelow, previous: 69000, current: 71000, want: false},
+		{name: "Unknown direction", direction: "sideways", previous: 69000, current: 71000, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := alerts.Crossed(tt.direction, 70000, tt.previous, tt.current); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAlertDeliverSignsPayload(t *testing.T) {
+	payload, _ := json.Marshal(alerts.Event{SubscriptionID: 7, Pair: "BTC/USD", Direction: database.AlertAbove, Threshold: 70000, Price: 70100})
+
+	var received alerts.Event
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if got, want := r.Header.Get(alerts.SignatureHeader), alerts.Sign("s3cret", body); got != want {
+			t.Errorf("got signature %q, want %q", got, want)
+		}
+		json.Unmarshal(body, &received)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	if err := alerts.Deliver(context.Background(), server.Client(), server.URL, "s3cret", payload); err != nil {
+		t.Fatalf("Deliver failed: %v", err)
+	}
+	if received.SubscriptionID != 7 || received.Price != 70100 {
+		t.Errorf("got event %+v, want subscription 7 at 70100", received)
+	}
+}
+
+func TestAlertDeliverRejectedByConsumer(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	if err := alerts.Deliver(context.Background(), server.Client(), server.URL, "s3cret", []byte(`{}`)); err == nil {
+		t.Error("expected error for non-2xx webhook response")
+	}
+}
+
+func TestCreateAlertHandlerInvalidBody(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{
+		{name: "Malformed JSON", body: `{"pair":`},
+		{name: "Unknown field", body: `{"pair":"BTC/USD","threshold":70000,"direction":"above","callback_url":"https://example.com/hook","email":"a@example.com"}`},
+		{name: "Non-BTC pair", body: `{"pair":"ETH/USD","threshold":70000,"direction":"above","callback_url":"https://example.com/hook"}`},
+		{name: "Zero threshold", body: `{"pair":"BTC/USD","threshold":0,"direction":"above","callback_url":"https://example.com/hook"}`},
+		{name: "Invalid direction", body: `{"pair":"BTC/USD","threshold":70000,"direction":"sideways","callback_url":"https://example.com/hook"}`},
+		{name: "Relative callback", body: `{"pair":"BTC/USD","threshold":70000,"direction":"above","callback_url":"/hook"}`},
+		{name: "Non-HTTP callback", body: `{"pair":"BTC/USD","threshold":70000,"direction":"above","callback_url":"ftp://example.com/hook"}`},
+		{name: "Negative rate", body: `{"pair":"BTC/USD","threshold":70000,"direction":"above","callback_url":"https://example.com/hook","max_deliveries_per_minute":-1}`},
+		{name: "Batch window too long", body: `{"pair":"BTC/USD","threshold":70000,"direction":"above","callback_url":"https://example.com/hook","batch_window_seconds":86400}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/api/v1/alerts", strings.NewReader(tt.body))
+			req = req.WithContext(middleware.WithAPIKeyID(req.Context(), "acme"))
+			w := httptest.NewRecorder()
+
+			internalHandlers.CreateAlertHandler(&fakeStore{}, alerts.CallbackPolicy{})(w, req)
+
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("got status %d, want %d", w.Code, http.StatusBadRequest)
+			}
+		})
+	}
+}
+
+func TestCreateAlertHandlerForbiddenCallback(t *testing.T) {
+	tests := []struct {
+		name        string
+		callbackURL string
+	}{
+		{name: "Loopback", callbackURL: "http://127.0.0.1:8080/hook"},
+		{name: "Localhost", callbackURL: "http://localhost/hook"},
+		{name: "Private", callbackURL: "http://10.1.2.3/hook"},
+		{name: "Cloud metadata", callbackURL: "http://169.254.169.254/latest/meta-data/"},
+		{name: "Carrier-grade NAT", callbackURL: "http://100.64.0.1/hook"},
+		{name: "Unspecified", callbackURL: "http://0.0.0.0/hook"},
+		{name: "IPv6 loopback", callbackURL: "http://[::1]/hook"},
+		{name: "IPv6 unique local", callbackURL: "https://[fd00::1]/hook"},
+		{name: "IPv4-mapped loopback", callbackURL: "http://[::ffff:127.0.0.1]/hook"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &fakeStore{}
+			body := `{"pair":"BTC/USD","threshold":70000,"direction":"above","callback_url":"` + tt.callbackURL + `"}`
+			req := httptest.NewRequest("POST", "/api/v1/alerts", strings.NewReader(body))
+			req = req.WithContext(middleware.WithAPIKeyID(req.Context(), "acme"))
+			w := httptest.NewRecorder()
+
+			internalHandlers.CreateAlertHandler(store, alerts.CallbackPolicy{})(w, req)
+
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("got status %d, want %d", w.Code, http.StatusBadRequest)
+			}
+			if len(store.subscriptions) != 0 {
+				t.Errorf("got %d stored subscriptions, want none", len(store.subscriptions))
+			}
+		})
+	}
+}
+
+func TestForbiddenAddr(t *testing.T) {
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{addr: "93.184.216.34", want: false},
+		{addr: "2606:4700:4700::1111", want: false},
+		{addr: "127.0.0.1", want: true},
+		{addr: "192.168.1.10", want: true},
+		{addr: "172.16.0.1", want: true},
+		{addr: "169.254.169.254", want: true},
+		{addr: "198.18.0.1", want: true},
+		{addr: "255.255.255.255", want: true},
+		{addr: "224.0.0.1", want: true},
+		{addr: "fe80::1", want: true},
+		{addr: "::ffff:10.0.0.1", want: true},
+		{addr: "2001:db8::1", want: true},
+	}
+
+	for _, tt := range tests {
+		if got := alerts.ForbiddenAddr(netip.MustParseAddr(tt.addr)); got != tt.want {
+			t.Errorf("ForbiddenAddr(%s) = %v, want %v", tt.addr, got, tt.want)
+		}
+	}
+}
+
+func TestCallbackPolicyClientRefusesPrivateAddresses(t *testing.T) {
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer hook.Close()
+
+	// Checked again when connecting, so a host that has since resolved to
+	// a private address, or a redirect to one, is refused too
+	err := alerts.Deliver(context.Background(), alerts.CallbackPolicy{}.Client(time.Second), hook.URL, "s3cret", []byte(`{}`))
+	if !errors.Is(err, alerts.ErrForbiddenCallback) {
+		t.Errorf("got error %v, want %v", err, alerts.ErrForbiddenCallback)
+	}
+
+	err = alerts.Deliver(context.Background(), alerts.CallbackPolicy{AllowPrivate: true}.Client(time.Second), hook.URL, "s3cret", []byte(`{}`))
+	if err != nil {
+		t.Errorf("got error %v with private callbacks allowed, want none", err)
+	}
+}
+
+func TestAlertSubscriptionsScopedToOwner(t *testing.T) {
+	store := &fakeStore{}
+	callbacks := alerts.CallbackPolicy{AllowPrivate: true}
+	r := mux.NewRouter()
+	r.HandleFunc("/api/v1/alerts", internalHandlers.CreateAlertHandler(store, callbacks)).Methods("POST")
+	r.HandleFunc("/api/v1/alerts", internalHandlers.ListAlertsHandler(store)).Methods("GET")
+	r.HandleFunc("/api/v1/alerts/{id}", internalHandlers.DeleteAlertHandler(store)).Methods("DELETE")
+	as := func(apiKeyID, method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if apiKeyID != "" {
+			req = req.WithContext(middleware.WithAPIKeyID(req.Context(), apiKeyID))
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	count := func(apiKeyID string) int {
+		var resp internalHandlers.AlertSubscriptionsResponse
+		json.NewDecoder(as(apiKeyID, "GET", "/api/v1/alerts", "").Body).Decode(&resp)
+		return resp.Count
+	}
+
+	w := as("acme", "POST", "/api/v1/alerts", `{"pair":"BTC/USD","threshold":70000,"direction":"above","callback_url":"http://127.0.0.1/hook"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("got status %d creating a subscription, want %d", w.Code, http.StatusCreated)
+	}
+
+	if got := count("acme"); got != 1 {
+		t.Errorf("got %d subscriptions for their owner, want 1", got)
+	}
+	if got := count("globex"); got != 0 {
+		t.Errorf("got %d subscriptions for another key, want 0", got)
+	}
+	if w := as("", "GET", "/api/v1/alerts", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("got status %d listing without a key, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	if w := as("globex", "DELETE", "/api/v1/alerts/1", ""); w.Code != http.StatusNotFound {
+		t.Errorf("got status %d deleting another key's subscription, want %d", w.Code, http.StatusNotFound)
+	}
+	if w := as("acme", "DELETE", "/api/v1/alerts/1", ""); w.Code != http.StatusNoContent {
+		t.Errorf("got status %d deleting an own subscription, want %d", w.Code, http.StatusNoContent)
+	}
+}
+
+func TestAlertSubscriptionsTenantFromTrustedProxy(t *testing.T) {
+	proxies, err := middleware.ParseTrustedProxies([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatalf("ParseTrustedProxies failed: %v", err)
+	}
+	middleware.SetTrustedProxies(proxies)
+	t.Cleanup(func() { middleware.SetTrustedProxies(nil) })
+
+	handler := middleware.LoggingMiddleware(internalHandlers.ListAlertsHandler(&fakeStore{}))
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		wantStatus int
+	}{
+		{name: "Trusted proxy", remoteAddr: "10.0.0.5:4321", wantStatus: http.StatusOK},
+		{name: "Untrusted client", remoteAddr: "203.0.113.7:4321", wantStatus: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/v1/alerts", nil)
+			req.RemoteAddr = tt.remoteAddr
+			req.Header.Set(middleware.TenantIDHeader, "tenant-42")
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("got status %d, want %d", w.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestDeleteAlertHandlerInvalidID(t *testing.T) {
+	r := mux.NewRouter()
+	r.HandleFunc("/api/v1/alerts/{id}", internalHandlers.DeleteAlertHandler(&fakeStore{})).Methods("DELETE")
+
+	req := httptest.NewRequest("DELETE", "/api/v1/alerts/abc", nil)
+	req = req.WithContext(middleware.WithAPIKeyID(req.Context(), "acme"))
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestAlertEvaluateFiresOnCrossing(t *testing.T) {
+	db := setupTestDB(t)
+	if db == nil {
+		return
+	}
+	defer cleanupTestDB(t, db)
+
+	setupAlertTables(t, db)
+
+	store := newTestStore(t)
+
+	// The fake's default service has no cache, so each evaluation sees the
+	// new price
+	prices := map[string]string{"HKD": "540000.0"}
+	setupFakeKraken(t, prices)
+
+	calls := 0
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		// Fail the first attempt so the delivery has to be retried
+		if calls == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer hook.Close()
+
+	sub, err := store.CreateAlertSubscription(context.Background(), database.AlertSubscription{
+		Pair:        "BTC/HKD",
+		Threshold:   550000,
+		Direction:   database.AlertAbove,
+		CallbackURL: hook.URL,
+		Secret:      "s3cret",
+	})
+	if err != nil {
+		t.Fatalf("CreateAlertSubscription failed: %v", err)
+	}
+
+	for _, price := range []string{"540000.0", "551000.0", "552000.0"} {
+		prices["HKD"] = price
+		time.Sleep(5 * time.Millisecond)
+		if err := alerts.Evaluate(context.Background(), store); err != nil {
+			t.Fatalf("Evaluate failed: %v", err)
+		}
+	}
+
+	// Retry immediately so the second pass picks up the failed delivery
+	policy := retry.Policy{MaxAttempts: 3, BaseBackoff: time.Nanosecond, MaxBackoff: time.Nanosecond}
+	for i := 0; i < 2; i++ {
+		if err := alerts.ProcessDeliveries(context.Background(), store, hook.Client(), policy); err != nil {
+			t.Fatalf("ProcessDeliveries failed: %v", err)
+		}
+	}
+
+	if calls != 2 {
+		t.Errorf("got %d webhook calls, want 2", calls)
+	}
+
+	deliveries, err := store.ListAlertDeliveries(context.Background(), "", sub.ID, 10)
+	if err != nil {
+		t.Fatalf("ListAlertDeliveries failed: %v", err)
+	}
+	if len(deliveries) != 1 {
+		t.Fatalf("got %d deliveries, want 1", len(deliveries))
+	}
+	if deliveries[0].Status != database.DeliveryDelivered || deliveries[0].Attempts != 2 {
+		t.Errorf("got status %q after %d attempts, want delivered after 2", deliveries[0].Status, deliveries[0].Attempts)
+	}
+
+	subs, err := store.ListAlertSubscriptions(context.Background())
+	if err != nil {
+		t.Fatalf("ListAlertSubscriptions failed: %v", err)
+	}
+	if len(subs) != 1 || subs[0].LastTriggeredAt == nil || subs[0].LastPrice == nil || *subs[0].LastPrice != 552000 {
+		t.Errorf("got subscription %+v, want triggered with last price 552000", subs)
+	}
+}
+
+func TestAlertDeliveryClaimedOnce(t *testing.T) {
+	store := newMigratedTestStore(t)
+	ctx := context.Background()
+
+	sub, err := store.CreateAlertSubscription(ctx, database.AlertSubscription{
+		Pair:        "BTC/USD",
+		Threshold:   50000,
+		Direction:   database.AlertAbove,
+		CallbackURL: "https://example.com/hook",
+		Secret:      "s3cret",
+	})
+	if err != nil {
+		t.Fatalf("CreateAlertSubscription failed: %v", err)
+	}
+	id, err := store.EnqueueAlertDelivery(ctx, sub.ID, []byte(`{"price":51000}`), 0)
+	if err != nil {
+		t.Fatalf("EnqueueAlertDelivery failed: %v", err)
+	}
+
+	claimed, err := store.ClaimAlertDeliveries(ctx, 10, time.Minute)
+	if err != nil || len(claimed) != 1 || claimed[0].ID != id {
+		t.Fatalf("got %+v (%v), want the queued delivery claimed", claimed, err)
+	}
+	if again, err := store.ClaimAlertDeliveries(ctx, 10, time.Minute); err != nil || len(again) != 0 {
+		t.Fatalf("got %+v (%v), want nothing while the claim lasts", again, err)
+	}
+
+	// Recording the attempt releases the claim for the retry
+	if err := store.MarkAlertDeliveryFailed(ctx, id, "timeout", time.Nanosecond); err != nil {
+		t.Fatalf("MarkAlertDeliveryFailed failed: %v", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if retry, err := store.ClaimAlertDeliveries(ctx, 10, time.Minute); err != nil || len(retry) != 1 {
+		t.Errorf("got %+v (%v), want the retry claimed", retry, err)
+	}
+}
+
+func TestRunExclusive(t *testing.T) {
+	store := newMigratedTestStore(t)
+	ctx := context.Background()
+
+	ran, err := store.RunExclusive(ctx, "test_lock", func(ctx context.Context) error {
+		nested, err := store.RunExclusive(ctx, "test_lock", func(ctx context.Context) error {
+			t.Error("ran while another session held the lock")
+			return nil
+		})
+		if err != nil || nested {
+			t.Errorf("got %v, %v taking a held lock, want false", nested, err)
+		}
+		return nil
+	})
+	if err != nil || !ran {
+		t.Fatalf("got %v, %v, want the function run", ran, err)
+	}
+
+	if ran, err := store.RunExclusive(ctx, "test_lock", func(ctx context.Context) error { return nil }); err != nil || !ran {
+		t.Errorf("got %v, %v, want the lock released after the first run", ran, err)
+	}
+}
+
+// setupAlertTables recreates the alert tables in the test database
+func setupAlertTables(t *testing.T, db *sql.DB) {
+	if _, err := db.Exec(`
+		DROP TABLE IF EXISTS alert_deliveries;
+		DROP TABLE IF EXISTS alert_subscriptions;
+		CREATE TABLE alert_subscriptions (
+			id SERIAL PRIMARY KEY,
+			created_at TIMESTAMPTZ DEFAULT NOW(),
+			pair VARCHAR(20) NOT NULL,
+			threshold DOUBLE PRECISION NOT NULL,
+			direction VARCHAR(5) NOT NULL,
+			callback_url TEXT NOT NULL,
+			secret VARCHAR(64) NOT NULL,
+			last_price DOUBLE PRECISION,
+			last_triggered_at TIMESTAMPTZ,
+			max_deliveries_per_minute INT NOT NULL DEFAULT 0,
+			batch_window_seconds INT NOT NULL DEFAULT 0,
+			owner VARCHAR(80) NOT NULL DEFAULT ''
+		);
+		CREATE TABLE alert_deliveries (
+			id SERIAL PRIMARY KEY,
+			subscription_id INT NOT NULL REFERENCES alert_subscriptions(id) ON DELETE CASCADE,
+			created_at TIMESTAMPTZ DEFAULT NOW(),
+			payload TEXT NOT NULL,
+			status VARCHAR(12) NOT NULL DEFAULT 'pending',
+			attempts INT NOT NULL DEFAULT 0,
+			next_attempt_at TIMESTAMPTZ DEFAULT NOW(),
+			last_error TEXT,
+			delivered_at TIMESTAMPTZ,
+			last_attempt_at TIMESTAMPTZ,
+			claimed_until TIMESTAMPTZ
+		);
+	`); err != nil {
+		t.Fatalf("Failed to create alert tables: %v", err)
+	}
+}
+
+func TestAlertBatchWindowCoalesces(t *testing.T) {
+	db := setupTestDB(t)
+	if db == nil {
+		return
+	}
+	defer cleanupTestDB(t, db)
+
+	setupAlertTables(t, db)
+
+	store := newTestStore(t)
+
+	prices := map[string]string{}
+	setupFakeKraken(t, prices)
+
+	sub, err := store.CreateAlertSubscription(context.Background(), database.AlertSubscription{
+		Pair:               "BTC/SEK",
+		Threshold:          700000,
+		Direction:          database.AlertAbove,
+		CallbackURL:        "https://example.com/hook",
+		Secret:             "s3cret",
+		BatchWindowSeconds: 60,
+	})
+	if err != nil {
+		t.Fatalf("CreateAlertSubscription failed: %v", err)
+	}
+
+	// Two crossings inside the batch window
+	for _, price := range []string{"690000.0", "701000.0", "695000.0", "702000.0"} {
+		prices["SEK"] = price
+		time.Sleep(5 * time.Millisecond)
+		if err := alerts.Evaluate(context.Background(), store); err != nil {
+			t.Fatalf("Evaluate failed: %v", err)
+		}
+	}
+
+	deliveries, err := store.ListAlertDeliveries(context.Background(), "", sub.ID, 10)
+	if err != nil {
+		t.Fatalf("ListAlertDeliveries failed: %v", err)
+	}
+	if len(deliveries) != 1 {
+		t.Fatalf("got %d deliveries, want 1 coalesced delivery", len(deliveries))
+	}
+
+	var event alerts.Event
+	if err := json.Unmarshal(deliveries[0].Payload, &event); err != nil {
+		t.Fatalf("payload decode failed: %v", err)
+	}
+	if event.Price != 702000 || event.Coalesced != 1 {
+		t.Errorf("got price %v coalescing %d crossings, want 702000 coalescing 1", event.Price, event.Coalesced)
+	}
+
+	// Held back for the window, so nothing is due yet
+	due, err := store.ClaimAlertDeliveries(context.Background(), 10, time.Minute)
+	if err != nil {
+		t.Fatalf("ClaimAlertDeliveries failed: %v", err)
+	}
+	if len(due) != 0 {
+		t.Errorf("got %d due deliveries, want 0 during the batch window", len(due))
+	}
+}
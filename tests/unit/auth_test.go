@@ -0,0 +1,150 @@
+package unit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/chesskiss/btc-service/internal/middleware"
+	"github.com/chesskiss/btc-service/internal/problem"
+)
+
+// failingKeyStore is a key store that cannot be reached
+type failingKeyStore struct{}
+
+func (failingKeyStore) LookupAPIKey(ctx context.Context, key string) (middleware.APIKey, bool, error) {
+	return middleware.APIKey{}, false, errors.New("connection refused")
+}
+
+func TestRequireAPIKey(t *testing.T) {
+	keys, err := middleware.NewStaticKeyStore([]string{"acme:secret-a", "secret-b"}, nil)
+	if err != nil {
+		t.Fatalf("NewStaticKeyStore: %v", err)
+	}
+	var seen string
+	handler := middleware.RequireAPIKey(keys, problem.Reject)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = middleware.GetAPIKeyID(r.Context())
+	}))
+
+	bareID, _, _ := middleware.ParseAPIKey("secret-b")
+	tests := []struct {
+		name     string
+		path     string
+		key      string
+		want     int
+		wantID   string
+		wantCode string
+	}{
+		{name: "Named key", path: "/api/v1/ltp", key: "secret-a", want: http.StatusOK, wantID: "acme"},
+		{name: "Bare key", path: "/api/v1/ltp", key: "secret-b", want: http.StatusOK, wantID: bareID},
+		{name: "Missing key", path: "/api/v1/ltp", want: http.StatusUnauthorized, wantCode: problem.CodeUnauthorized},
+		{name: "Unknown key", path: "/api/v1/alerts", key: "secret-c", want: http.StatusUnauthorized, wantCode: problem.CodeUnauthorized},
+		{name: "Open endpoint", path: "/health", want: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seen = ""
+			req := httptest.NewRequest("GET", tt.path, nil)
+			if tt.key != "" {
+				req.Header.Set(middleware.APIKeyHeader, tt.key)
+			}
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tt.want {
+				t.Fatalf("got status %d, want %d", w.Code, tt.want)
+			}
+			if seen != tt.wantID {
+				t.Errorf("got API key ID %q, want %q", seen, tt.wantID)
+			}
+			if tt.wantCode != "" {
+				if !strings.Contains(w.Body.String(), `"code":"`+tt.wantCode+`"`) {
+					t.Errorf("expected code %s in body %s", tt.wantCode, w.Body.String())
+				}
+				if w.Header().Get("WWW-Authenticate") == "" {
+					t.Error("expected a WWW-Authenticate header")
+				}
+			}
+		})
+	}
+}
+
+func TestRequireAPIKeyStoreUnavailable(t *testing.T) {
+	handler := middleware.RequireAPIKey(failingKeyStore{}, problem.Reject)(http.NotFoundHandler())
+	req := httptest.NewRequest("GET", "/api/v1/ltp", nil)
+	req.Header.Set(middleware.APIKeyHeader, "secret-a")
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), problem.CodeStorageUnavailable) {
+		t.Errorf("got %d %s, want 503 storage_unavailable", w.Code, w.Body.String())
+	}
+}
+
+func TestParseAPIKey(t *testing.T) {
+	id, key, err := middleware.ParseAPIKey("secret-b")
+	if err != nil || key != "secret-b" || !strings.HasPrefix(id, "key-") || strings.Contains(id, "secret") {
+		t.Errorf("got %q, %q, %v; want a derived ID that hides the key", id, key, err)
+	}
+	if _, _, err := middleware.ParseAPIKey("acme:"); err == nil {
+		t.Error("Expected an error for an entry without a key")
+	}
+}
+
+func TestRequireAdmin(t *testing.T) {
+	keys, err := middleware.NewStaticKeyStore([]string{"acme:secret-a"}, []string{"ops:secret-admin"})
+	if err != nil {
+		t.Fatalf("NewStaticKeyStore: %v", err)
+	}
+	var seen string
+	handler := middleware.RequireAdmin(keys, problem.Reject)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = middleware.GetAPIKeyID(r.Context())
+	}))
+
+	tests := []struct {
+		name     string
+		key      string
+		want     int
+		wantCode string
+	}{
+		{name: "Admin key", key: "secret-admin", want: http.StatusOK},
+		{name: "Regular key", key: "secret-a", want: http.StatusForbidden, wantCode: problem.CodeForbidden},
+		{name: "Missing key", want: http.StatusUnauthorized, wantCode: problem.CodeUnauthorized},
+		{name: "Unknown key", key: "secret-c", want: http.StatusUnauthorized, wantCode: problem.CodeUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seen = ""
+			req := httptest.NewRequest("POST", "/api/v1/admin/purge", nil)
+			if tt.key != "" {
+				req.Header.Set(middleware.APIKeyHeader, tt.key)
+			}
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tt.want {
+				t.Fatalf("got status %d, want %d", w.Code, tt.want)
+			}
+			if tt.want == http.StatusOK && seen != "ops" {
+				t.Errorf("got API key ID %q, want ops", seen)
+			}
+			if tt.wantCode != "" && !strings.Contains(w.Body.String(), `"code":"`+tt.wantCode+`"`) {
+				t.Errorf("expected code %s in body %s", tt.wantCode, w.Body.String())
+			}
+		})
+	}
+
+	// Admin keys are API keys too
+	apiKey, ok, _ := keys.LookupAPIKey(context.Background(), "secret-admin")
+	if !ok || !apiKey.Admin || apiKey.ID != "ops" {
+		t.Errorf("got %+v, %v for the admin key, want ops with the admin scope", apiKey, ok)
+	}
+}
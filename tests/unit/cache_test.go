@@ -3,10 +3,12 @@ package unit
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"testing"
 	"time"
 
 	"github.com/chesskiss/btc-service/clients"
+	"github.com/chesskiss/btc-service/internal/cache"
 	"github.com/redis/go-redis/v9"
 )
 
@@ -80,7 +82,7 @@ func TestGetBTCPriceWithRedis(t *testing.T) {
 	redisClient := setupTestRedis(t)
 	defer redisClient.Close()
 
-	clients.InitRedis("localhost", "6379", "")
+	usePriceService(t, cache.NewRedis(redisClient), clients.PriceServiceOptions{})
 
 	// First call should fetch from Kraken and cache
 	price1, err := clients.GetBTCPrice(context.Background(), "USD")
@@ -107,7 +109,7 @@ func TestCacheExpiration(t *testing.T) {
 	redisClient := setupTestRedis(t)
 	defer redisClient.Close()
 
-	clients.InitRedis("localhost", "6379", "")
+	usePriceService(t, cache.NewRedis(redisClient), clients.PriceServiceOptions{})
 
 	// Get initial price
 	_, err := clients.GetBTCPrice(context.Background(), "EUR")
@@ -141,7 +143,7 @@ func TestCacheKeyFormat(t *testing.T) {
 	redisClient := setupTestRedis(t)
 	defer redisClient.Close()
 
-	clients.InitRedis("localhost", "6379", "")
+	usePriceService(t, cache.NewRedis(redisClient), clients.PriceServiceOptions{})
 
 	// Test different currencies
 	currencies := []string{"USD", "EUR", "CHF"}
@@ -165,9 +167,61 @@ func TestCacheKeyFormat(t *testing.T) {
 	}
 }
 
-func TestInitRedisWithInvalidHost(t *testing.T) {
-	t.Skip("Skipping test that pollutes global Redis client state")
-	// This test is problematic because it sets the global redisClient to an invalid host,
-	// which affects subsequent tests. In a real scenario, the service would initialize
-	// Redis once at startup, not repeatedly with different configurations.
+func TestPriceServiceWithInvalidRedisHost(t *testing.T) {
+	server := setupFakeKraken(t, map[string]string{"USD": "50000.0"})
+	redisClient := clients.NewRedisClient(clients.RedisOptions{Addr: "127.0.0.1:1"})
+	defer redisClient.Close()
+
+	// The service is local to this test, so the unreachable Redis can't
+	// leak into others; prices are still served straight from Kraken
+	service := clients.NewPriceService(cache.NewRedis(redisClient), clients.PriceServiceOptions{KrakenBaseURL: server.URL})
+
+	price, err := service.GetBTCPrice(context.Background(), "USD")
+	if err != nil {
+		t.Fatalf("expected success with Redis unreachable, got error: %v", err)
+	}
+	if price != 50000.0 {
+		t.Errorf("got price %f, want 50000.0", price)
+	}
+}
+
+func TestRawTickerNotCachedWhenDisabled(t *testing.T) {
+	service := clients.NewPriceService(cache.NewMemory(100), clients.PriceServiceOptions{})
+
+	if _, err := service.GetRawTicker(context.Background(), "USD"); !errors.Is(err, clients.ErrTickerNotCached) {
+		t.Errorf("got error %v, want ErrTickerNotCached", err)
+	}
+}
+
+func TestRawTickerCachedAlongsidePrice(t *testing.T) {
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	server := setupFakeKraken(t, map[string]string{"GBP": "51000.7"})
+	usePriceService(t, cache.NewRedis(redisClient), clients.PriceServiceOptions{
+		KrakenBaseURL:    server.URL,
+		RawTickerEnabled: true,
+		RawTickerTTL:     time.Minute,
+	})
+
+	if _, err := clients.GetBTCQuote(context.Background(), "GBP"); err != nil {
+		t.Fatalf("failed to get quote: %v", err)
+	}
+
+	raw, err := clients.GetRawTicker(context.Background(), "GBP")
+	if err != nil {
+		t.Fatalf("GetRawTicker failed: %v", err)
+	}
+	ticker, err := raw.Ticker()
+	if err != nil {
+		t.Fatalf("failed to decode ticker: %v", err)
+	}
+	if raw.Pair != "BTC/GBP" || len(ticker.Close) == 0 || ticker.Close[0] != "51000.7" {
+		t.Errorf("got %s with close %v, want BTC/GBP closing at 51000.7", raw.Pair, ticker.Close)
+	}
+
+	ttl, err := redisClient.TTL(context.Background(), "ticker:BTC/GBP").Result()
+	if err != nil || ttl <= 0 || ttl > time.Minute {
+		t.Errorf("got raw ticker TTL %v (err %v), want at most 1m", ttl, err)
+	}
 }
@@ -0,0 +1,91 @@
+package unit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/chesskiss/btc-service/internal/clock"
+	"github.com/chesskiss/btc-service/internal/handlers"
+)
+
+func TestVirtualClockTicks(t *testing.T) {
+	start := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	sim := clock.NewVirtual(start)
+	ticker := sim.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	sim.Advance(59 * time.Second)
+	select {
+	case <-ticker.C():
+		t.Fatal("ticked before the interval elapsed")
+	default:
+	}
+
+	sim.Advance(time.Second)
+	select {
+	case tick := <-ticker.C():
+		if want := start.Add(time.Minute); !tick.Equal(want) {
+			t.Errorf("got tick at %v, want %v", tick, want)
+		}
+	default:
+		t.Fatal("expected a tick once the interval elapsed")
+	}
+
+	if got, want := sim.Now(), start.Add(time.Minute); !got.Equal(want) {
+		t.Errorf("got now %v, want %v", got, want)
+	}
+}
+
+func TestVirtualClockStoppedTicker(t *testing.T) {
+	sim := clock.NewVirtual(time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC))
+	ticker := sim.NewTicker(time.Second)
+	ticker.Stop()
+
+	sim.Advance(time.Minute)
+	select {
+	case <-ticker.C():
+		t.Error("stopped ticker fired")
+	default:
+	}
+}
+
+func TestAdvanceClockHandler(t *testing.T) {
+	start := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	handler := handlers.AdvanceClockHandler(clock.NewVirtual(start))
+
+	tests := []struct {
+		name string
+		by   string
+		want int
+	}{
+		{name: "Advance", by: "90s", want: http.StatusOK},
+		{name: "Missing", by: "", want: http.StatusBadRequest},
+		{name: "Negative", by: "-1m", want: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/api/v1/admin/clock/advance?by="+tt.by, nil)
+			w := httptest.NewRecorder()
+
+			handler(w, req)
+
+			if w.Code != tt.want {
+				t.Fatalf("got status %d, want %d", w.Code, tt.want)
+			}
+			if tt.want != http.StatusOK {
+				return
+			}
+			var resp handlers.ClockResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("JSON decode failed: %v", err)
+			}
+			if want := start.Add(90 * time.Second); !resp.Now.Equal(want) {
+				t.Errorf("got now %v, want %v", resp.Now, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package unit
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/chesskiss/btc-service/internal/middleware"
+)
+
+func TestCompress(t *testing.T) {
+	large := strings.Repeat(`{"pair":"BTC/USD","amount":"52000.1"},`, 100)
+	handler := middleware.Compress(1024)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/small":
+			w.Write([]byte(`{"ok":true}`))
+		case "/encoded":
+			w.Header().Set("Content-Encoding", "br")
+			w.Write([]byte(large))
+		case "/empty":
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			// Written in pieces, so the threshold is crossed mid-response
+			for i := 0; i < len(large); i += 100 {
+				w.Write([]byte(large[i:min(i+100, len(large))]))
+			}
+		}
+	}))
+
+	tests := []struct {
+		name           string
+		path           string
+		acceptEncoding string
+		wantEncoding   string
+		wantStatus     int
+	}{
+		{name: "Gzip", path: "/large", acceptEncoding: "gzip, deflate", wantEncoding: "gzip", wantStatus: http.StatusCreated},
+		{name: "Deflate preferred", path: "/large", acceptEncoding: "gzip;q=0.5, deflate", wantEncoding: "deflate", wantStatus: http.StatusCreated},
+		{name: "Wildcard", path: "/large", acceptEncoding: "br, *", wantEncoding: "gzip", wantStatus: http.StatusCreated},
+		{name: "Gzip refused", path: "/large", acceptEncoding: "gzip;q=0, *;q=0.1", wantEncoding: "deflate", wantStatus: http.StatusCreated},
+		{name: "Not accepted", path: "/large", acceptEncoding: "br", wantStatus: http.StatusCreated},
+		{name: "Small response", path: "/small", acceptEncoding: "gzip", wantStatus: http.StatusOK},
+		{name: "Already encoded", path: "/encoded", acceptEncoding: "gzip", wantEncoding: "br", wantStatus: http.StatusOK},
+		{name: "No content", path: "/empty", acceptEncoding: "gzip", wantStatus: http.StatusNoContent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.path, nil)
+			req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("got status %d, want %d", w.Code, tt.wantStatus)
+			}
+			if got := w.Header().Get("Content-Encoding"); got != tt.wantEncoding {
+				t.Fatalf("got Content-Encoding %q, want %q", got, tt.wantEncoding)
+			}
+			if w.Header().Get("Vary") != "Accept-Encoding" {
+				t.Errorf("got Vary %q, want Accept-Encoding", w.Header().Get("Vary"))
+			}
+
+			var body io.Reader = w.Body
+			switch tt.wantEncoding {
+			case "gzip":
+				gz, err := gzip.NewReader(w.Body)
+				if err != nil {
+					t.Fatalf("gzip.NewReader: %v", err)
+				}
+				body = gz
+			case "deflate":
+				zr, err := zlib.NewReader(w.Body)
+				if err != nil {
+					t.Fatalf("zlib.NewReader: %v", err)
+				}
+				body = zr
+			}
+			decoded, err := io.ReadAll(body)
+			if err != nil {
+				t.Fatalf("failed to read body: %v", err)
+			}
+			if tt.path == "/large" && string(decoded) != large {
+				t.Errorf("got %d bytes after decoding, want the %d written", len(decoded), len(large))
+			}
+			if tt.path == "/small" && string(decoded) != `{"ok":true}` {
+				t.Errorf("got body %q, want it unchanged", decoded)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package unit
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chesskiss/btc-service/config"
+)
+
+func TestLoadDefaults(t *testing.T) {
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("expected defaults to be valid, got error: %v", err)
+	}
+
+	if cfg.Server.Port != "8080" {
+		t.Errorf("got port %s, want 8080", cfg.Server.Port)
+	}
+	if cfg.Cache.TTL != 60*time.Second {
+		t.Errorf("got cache TTL %v, want 60s", cfg.Cache.TTL)
+	}
+	if !cfg.Tracing.Enabled {
+		t.Error("expected tracing to be enabled by default")
+	}
+	if cfg.Auth.Enabled {
+		t.Error("expected auth to be disabled by default")
+	}
+}
+
+func TestLoadTypedValues(t *testing.T) {
+	t.Setenv("SERVER_READ_TIMEOUT", "5s")
+	t.Setenv("CACHE_TTL", "2m")
+	t.Setenv("TRACING_ENABLED", "false")
+	t.Setenv("AUTH_ENABLED", "true")
+	t.Setenv("AUTH_API_KEYS", "key-a, key-b,,")
+
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	if cfg.Server.ReadTimeout != 5*time.Second {
+		t.Errorf("got read timeout %v, want 5s", cfg.Server.ReadTimeout)
+	}
+	if cfg.Cache.TTL != 2*time.Minute {
+		t.Errorf("got cache TTL %v, want 2m", cfg.Cache.TTL)
+	}
+	if cfg.Tracing.Enabled {
+		t.Error("expected tracing to be disabled")
+	}
+	if len(cfg.Auth.APIKeys) != 2 || cfg.Auth.APIKeys[0] != "key-a" || cfg.Auth.APIKeys[1] != "key-b" {
+		t.Errorf("got API keys %v, want [key-a key-b]", cfg.Auth.APIKeys)
+	}
+	if features := strings.Join(cfg.EnabledFeatures(), ","); features != "auth,alerts,maintenance_monitor" {
+		t.Errorf("got features %s, want auth,alerts,maintenance_monitor", features)
+	}
+}
+
+func TestLoadMySQLDriver(t *testing.T) {
+	t.Setenv("DB_DRIVER", "mysql")
+
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	if cfg.DB.Port != "3306" {
+		t.Errorf("got database port %s, want 3306", cfg.DB.Port)
+	}
+	if len(cfg.DB.LogSinks) != 1 || cfg.DB.LogSinks[0] != "mysql" {
+		t.Errorf("got request log sinks %v, want [mysql]", cfg.DB.LogSinks)
+	}
+
+	t.Setenv("DB_READ_REPLICA_DSN", "reader:secret@tcp(replica:3306)/btc_service")
+	if _, err := config.Load(); err == nil {
+		t.Error("expected a read replica to be rejected with the mysql driver")
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		key   string
+		value string
+	}{
+		{name: "Invalid duration", key: "CACHE_TTL", value: "soon"},
+		{name: "Non-positive TTL", key: "CACHE_TTL", value: "0s"},
+		{name: "Unknown cache backend", key: "CACHE_BACKEND", value: "memcached"},
+		{name: "Negative L1 TTL", key: "CACHE_L1_TTL", value: "-1s"},
+		{name: "Sentinel master without sentinels", key: "REDIS_SENTINEL_MASTER", value: "mymaster"},
+		{name: "Negative Redis DB", key: "REDIS_DB", value: "-1"},
+		{name: "Unknown database driver", key: "DB_DRIVER", value: "sqlite"},
+		{name: "Unknown sslmode", key: "DB_SSLMODE", value: "always"},
+		{name: "Client certificate without key", key: "DB_SSLCERT", value: "/etc/btc-service/client.crt"},
+		{name: "Negative migrations baseline", key: "DB_MIGRATIONS_BASELINE", value: "-1"},
+		{name: "Empty request log buffer", key: "REQUEST_LOG_BUFFER_SIZE", value: "0"},
+		{name: "Request log sample rate above 1", key: "REQUEST_LOG_SUCCESS_SAMPLE_RATE", value: "1.5"},
+		{name: "Invalid request log sample rate", key: "REQUEST_LOG_ERROR_SAMPLE_RATE", value: "half"},
+		{name: "Unknown request log IP mode", key: "REQUEST_LOG_IP_MODE", value: "mask"},
+		{name: "Request log IP HMAC without secret", key: "REQUEST_LOG_IP_MODE", value: "hmac"},
+		{name: "Unknown request log sink", key: "REQUEST_LOG_SINK", value: "elasticsearch"},
+		{name: "Request log sink listed twice", key: "REQUEST_LOG_SINK", value: "postgres,postgres"},
+		{name: "Kafka sink without brokers", key: "REQUEST_LOG_SINK", value: "postgres,kafka"},
+		{name: "MySQL sink with the postgres driver", key: "REQUEST_LOG_SINK", value: "mysql"},
+		{name: "Negative request log retention", key: "REQUEST_LOG_RETENTION", value: "-1h"},
+		{name: "Zero request log retention interval", key: "REQUEST_LOG_RETENTION_INTERVAL", value: "0s"},
+		{name: "Request log archive without retention", key: "REQUEST_LOG_ARCHIVE_BUCKET", value: "logs"},
+		{name: "Negative Redis pool size", key: "REDIS_POOL_SIZE", value: "-5"},
+		{name: "Invalid Redis max retries", key: "REDIS_MAX_RETRIES", value: "-2"},
+		{name: "Invalid boolean", key: "TRACING_ENABLED", value: "sometimes"},
+		{name: "Invalid port", key: "PORT", value: "http"},
+		{name: "Invalid Kraken URL", key: "KRAKEN_BASE_URL", value: "api.kraken.com"},
+		{name: "No concurrent Kraken fetches", key: "KRAKEN_MAX_CONCURRENT_FETCHES", value: "0"},
+		{name: "Non-positive Kraken response limit", key: "KRAKEN_MAX_RESPONSE_BYTES", value: "0"},
+		{name: "Auth without keys", key: "AUTH_ENABLED", value: "true"},
+		{name: "API key without ID", key: "AUTH_API_KEYS", value: ":secret"},
+		{name: "Duplicate API key", key: "AUTH_API_KEYS", value: "a:secret,b:secret"},
+		{name: "Admin key without ID", key: "ADMIN_API_KEYS", value: ":secret"},
+		{name: "API key ID too long", key: "AUTH_API_KEYS", value: strings.Repeat("k", 65) + ":secret"},
+		{name: "Non-positive rate limit", key: "RATE_LIMIT_PER_MINUTE", value: "0"},
+		{name: "Negative max in flight", key: "SERVER_MAX_IN_FLIGHT", value: "-1"},
+		{name: "Negative compression threshold", key: "SERVER_COMPRESSION_MIN_BYTES", value: "-1"},
+		{name: "Invalid trusted proxy", key: "SERVER_TRUSTED_PROXIES", value: "10.0.0.0/8,load-balancer"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(tt.key, tt.value)
+
+			if _, err := config.Load(); err == nil {
+				t.Errorf("expected error for %s=%q", tt.key, tt.value)
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package unit
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/chesskiss/btc-service/clients"
+	"github.com/chesskiss/btc-service/internal/cache"
+)
+
+// setupFakeKraken starts a Kraken Ticker stand-in that serves the given
+// last-trade prices keyed by currency, and makes the default price service
+// call it without a cache; unknown currencies get a Kraken error
+func setupFakeKraken(t *testing.T, prices map[string]string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		currency := strings.TrimPrefix(r.URL.Query().Get("pair"), "XBT")
+		w.Header().Set("Content-Type", "application/json")
+
+		price, ok := prices[currency]
+		if !ok {
+			fmt.Fprint(w, `{"error":["EQuery:Unknown asset pair"],"result":{}}`)
+			return
+		}
+		fmt.Fprintf(w, `{"error":[],"result":{"XXBTZ%s":{"c":["%s","0.001"]}}}`, currency, price)
+	}))
+
+	t.Cleanup(server.Close)
+	usePriceService(t, nil, clients.PriceServiceOptions{KrakenBaseURL: server.URL})
+
+	return server
+}
+
+// usePriceService makes a price service with the given cache and options
+// the default one until the test ends
+func usePriceService(t *testing.T, priceCache cache.Cache, opts clients.PriceServiceOptions) *clients.PriceService {
+	previous := clients.DefaultService()
+	service := clients.NewPriceService(priceCache, opts)
+	clients.SetDefaultService(service)
+	t.Cleanup(func() { clients.SetDefaultService(previous) })
+	return service
+}
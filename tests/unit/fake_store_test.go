@@ -0,0 +1,96 @@
+package unit
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"github.com/chesskiss/btc-service/internal/database"
+	"github.com/chesskiss/btc-service/internal/pagination"
+)
+
+// errStoreUnavailable is what fakeStore returns when it is down
+var errStoreUnavailable = errors.New("database not initialized")
+
+// fakeStore is a database.Store for handler tests that don't need
+// PostgreSQL: it serves requestLogs and subscriptions, or fails with err
+// when set. Like PostgreSQL, it rejects a whole batch of request logs if
+// one has the ID rejectRequestID. Methods it doesn't implement panic
+// through the nil embedded Store.
+type fakeStore struct {
+	database.Store
+	requestLogs     []database.RequestLog
+	subscriptions   []database.AlertSubscription
+	rejectRequestID string
+	err             error
+}
+
+func (s *fakeStore) LogRequests(ctx context.Context, reqLogs []database.RequestLog) error {
+	if s.err != nil {
+		return s.err
+	}
+	for _, reqLog := range reqLogs {
+		if s.rejectRequestID != "" && reqLog.RequestID == s.rejectRequestID {
+			return &pgconn.PgError{Code: "22001", Message: "value too long for type character varying(128)"}
+		}
+	}
+	s.requestLogs = append(s.requestLogs, reqLogs...)
+	return nil
+}
+
+func (s *fakeStore) QueryRequests(ctx context.Context, filter database.RequestLogFilter) ([]database.RequestLog, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	var logs []database.RequestLog
+	for _, reqLog := range s.requestLogs {
+		if filter.StatusCode != 0 && reqLog.StatusCode != filter.StatusCode {
+			continue
+		}
+		logs = append(logs, reqLog)
+	}
+	return logs[:min(len(logs), filter.Limit)], nil
+}
+
+func (s *fakeStore) PurgeRequests(ctx context.Context, req database.PurgeRequest) (database.PurgeResult, error) {
+	if s.err != nil {
+		return database.PurgeResult{}, s.err
+	}
+	return database.PurgeResult{Mode: req.Mode}, nil
+}
+
+func (s *fakeStore) CreateAlertSubscription(ctx context.Context, sub database.AlertSubscription) (database.AlertSubscription, error) {
+	if s.err != nil {
+		return sub, s.err
+	}
+	sub.ID = int64(len(s.subscriptions) + 1)
+	s.subscriptions = append(s.subscriptions, sub)
+	return sub, nil
+}
+
+func (s *fakeStore) QueryAlertSubscriptions(ctx context.Context, owner string, page pagination.Page) ([]database.AlertSubscription, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	subs := []database.AlertSubscription{}
+	for _, sub := range s.subscriptions {
+		if sub.Owner == owner {
+			subs = append(subs, sub)
+		}
+	}
+	return subs[:min(len(subs), page.Limit)], nil
+}
+
+func (s *fakeStore) DeleteAlertSubscription(ctx context.Context, owner string, id int64) (bool, error) {
+	if s.err != nil {
+		return false, s.err
+	}
+	for i, sub := range s.subscriptions {
+		if sub.ID == id && sub.Owner == owner {
+			s.subscriptions = append(s.subscriptions[:i], s.subscriptions[i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
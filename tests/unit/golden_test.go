@@ -0,0 +1,127 @@
+package unit
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"github.com/chesskiss/btc-service/clients"
+	"github.com/chesskiss/btc-service/handlers"
+	internalHandlers "github.com/chesskiss/btc-service/internal/handlers"
+	"github.com/chesskiss/btc-service/internal/health"
+	"github.com/chesskiss/btc-service/internal/middleware"
+	"github.com/chesskiss/btc-service/internal/version"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden response schemas in testdata/golden")
+
+// TestResponseSchemasGolden pins the JSON shape of every public endpoint.
+// A failure here means a response changed shape for API consumers; if the
+// change is intended, rerun with -update and review the golden diff.
+func TestResponseSchemasGolden(t *testing.T) {
+	setupFakeKraken(t, map[string]string{"USD": "65000.1", "EUR": "60000.2"})
+	// Health reports Kraken's last call, so make sure it succeeded
+	if _, err := clients.GetBTCQuote(context.Background(), "USD"); err != nil {
+		t.Fatalf("failed to get quote: %v", err)
+	}
+
+	version.SetFeatures([]string{"tracing"})
+	cacheProbe := health.Probe{Name: "cache", Check: func(ctx context.Context) error { return nil }}
+
+	r := mux.NewRouter()
+	r.HandleFunc("/health", internalHandlers.HealthHandler("postgres", nil, nil)).Methods("GET")
+	r.HandleFunc("/version", internalHandlers.VersionHandler).Methods("GET")
+	r.HandleFunc("/ready", internalHandlers.ReadinessHandler(health.NewMonitor(3, 2, cacheProbe))).Methods("GET")
+	r.HandleFunc("/api/v1/ltp", handlers.LTPHandler(nil)).Methods("GET")
+	r.HandleFunc("/api/v2/ltp", handlers.LTPV2Handler(nil)).Methods("GET")
+	handler := middleware.LoggingMiddleware(r)
+
+	tests := []struct {
+		golden string
+		url    string
+		status int
+	}{
+		{golden: "health", url: "/health", status: http.StatusOK},
+		{golden: "ready", url: "/ready", status: http.StatusOK},
+		{golden: "version", url: "/version", status: http.StatusOK},
+		{golden: "ltp_v1", url: "/api/v1/ltp?pairs=BTC/USD,BTC/EUR", status: http.StatusOK},
+		{golden: "ltp_v1_partial", url: "/api/v1/ltp?pairs=BTC/USD,BTC/XYZ", status: http.StatusOK},
+		{golden: "ltp_v1_invalid_pair", url: "/api/v1/ltp?pairs=BTC/XYZ", status: http.StatusBadRequest},
+		{golden: "ltp_v2", url: "/api/v2/ltp?pairs=BTC/USD,BTC/EUR", status: http.StatusOK},
+		{golden: "ltp_v2_partial", url: "/api/v2/ltp?pairs=BTC/USD,BTC/XYZ", status: http.StatusOK},
+		{golden: "ltp_v2_invalid_pair", url: "/api/v2/ltp?pairs=BTC/XYZ", status: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.golden, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.url, nil)
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tt.status {
+				t.Fatalf("got status %d, want %d", w.Code, tt.status)
+			}
+
+			var body interface{}
+			if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+				t.Fatalf("JSON decode failed: %v", err)
+			}
+			got, err := json.MarshalIndent(jsonShape(body), "", "  ")
+			if err != nil {
+				t.Fatalf("JSON encode failed: %v", err)
+			}
+			got = append(got, '\n')
+
+			path := filepath.Join("testdata", "golden", tt.golden+".json")
+			if *updateGolden {
+				if err := os.WriteFile(path, got, 0o644); err != nil {
+					t.Fatalf("failed to write golden file: %v", err)
+				}
+				return
+			}
+
+			want, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("failed to read golden file (run with -update to create it): %v", err)
+			}
+			if string(got) != string(want) {
+				t.Errorf("response schema for %s changed\ngot:\n%s\nwant:\n%s", tt.url, got, want)
+			}
+		})
+	}
+}
+
+// jsonShape replaces every value in a decoded JSON document with its type
+// name, so golden files pin field names and types but not live prices or
+// timestamps. Arrays are represented by the shape of their first element.
+func jsonShape(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		shape := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			shape[key] = jsonShape(value)
+		}
+		return shape
+	case []interface{}:
+		if len(v) == 0 {
+			return []interface{}{}
+		}
+		return []interface{}{jsonShape(v[0])}
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	default:
+		return "null"
+	}
+}
@@ -1,18 +1,31 @@
 package unit
 
 import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/chesskiss/btc-service/clients"
 	"github.com/chesskiss/btc-service/handlers"
+	"github.com/chesskiss/btc-service/internal/cache"
+	"github.com/chesskiss/btc-service/internal/database"
 	"github.com/chesskiss/btc-service/internal/middleware"
+	"github.com/chesskiss/btc-service/internal/pb"
+	"github.com/chesskiss/btc-service/services"
 	"github.com/gorilla/mux"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
 )
 
 func TestLTPHandler(t *testing.T) {
 	r := mux.NewRouter()
-	r.HandleFunc("/api/v1/ltp", handlers.LTPHandler).Methods("GET")
+	r.HandleFunc("/api/v1/ltp", handlers.LTPHandler(nil)).Methods("GET")
 	handler := middleware.LoggingMiddleware(r)
 
 	req := httptest.NewRequest("GET", "/api/v1/ltp", nil)
@@ -31,7 +44,7 @@ func TestLTPHandler(t *testing.T) {
 
 func TestLTPHandlerWithPairs(t *testing.T) {
 	r := mux.NewRouter()
-	r.HandleFunc("/api/v1/ltp", handlers.LTPHandler).Methods("GET")
+	r.HandleFunc("/api/v1/ltp", handlers.LTPHandler(nil)).Methods("GET")
 	handler := middleware.LoggingMiddleware(r)
 
 	req := httptest.NewRequest("GET", "/api/v1/ltp?pairs=BTC/USD", nil)
@@ -43,3 +56,261 @@ func TestLTPHandlerWithPairs(t *testing.T) {
 		t.Errorf("got status %d, want %d", w.Code, http.StatusOK)
 	}
 }
+
+func TestLTPHandlerCSV(t *testing.T) {
+	setupFakeKraken(t, map[string]string{"GBP": "41000.50000"})
+
+	r := mux.NewRouter()
+	r.HandleFunc("/api/v1/ltp", handlers.LTPHandler(nil)).Methods("GET")
+
+	tests := []struct {
+		name   string
+		url    string
+		accept string
+	}{
+		{name: "Format parameter", url: "/api/v1/ltp?pairs=BTC/GBP&format=csv"},
+		{name: "Accept header", url: "/api/v1/ltp?pairs=BTC/GBP", accept: "text/csv"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.url, nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			w := httptest.NewRecorder()
+
+			r.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("got status %d, want %d", w.Code, http.StatusOK)
+			}
+			if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/csv") {
+				t.Errorf("got Content-Type %q, want text/csv", ct)
+			}
+
+			records, err := csv.NewReader(w.Body).ReadAll()
+			if err != nil {
+				t.Fatalf("CSV parse failed: %v", err)
+			}
+			if len(records) != 2 {
+				t.Fatalf("got %d rows, want header plus 1", len(records))
+			}
+			if strings.Join(records[0], ",") != "pair,price,timestamp,stale" {
+				t.Errorf("got header %v", records[0])
+			}
+			if records[1][0] != "BTC/GBP" || records[1][1] != "41000.50000" {
+				t.Errorf("got row %v, want BTC/GBP 41000.50000", records[1])
+			}
+			if _, err := time.Parse(time.RFC3339, records[1][2]); err != nil {
+				t.Errorf("expected RFC3339 timestamp, got %q", records[1][2])
+			}
+			if records[1][3] != "false" {
+				t.Errorf("got stale %q, want false", records[1][3])
+			}
+		})
+	}
+}
+
+func TestLTPHandlerUnsupportedFormat(t *testing.T) {
+	r := mux.NewRouter()
+	r.HandleFunc("/api/v1/ltp", handlers.LTPHandler(nil)).Methods("GET")
+
+	req := httptest.NewRequest("GET", "/api/v1/ltp?format=xml", nil)
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestLTPHandlerFreshnessMetadata(t *testing.T) {
+	setupFakeKraken(t, map[string]string{"AUD": "98000.1"})
+
+	r := mux.NewRouter()
+	r.HandleFunc("/api/v1/ltp", handlers.LTPHandler(nil)).Methods("GET")
+
+	req := httptest.NewRequest("GET", "/api/v1/ltp?pairs=BTC/AUD", nil)
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	var resp services.LTPResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("JSON decode failed: %v", err)
+	}
+	if len(resp.LTP) != 1 {
+		t.Fatalf("got %d prices, want 1", len(resp.LTP))
+	}
+
+	price := resp.LTP[0]
+	if price.Amount != 98000.1 {
+		t.Errorf("got amount %f, want 98000.1", price.Amount)
+	}
+	if price.Timestamp.IsZero() || time.Since(price.Timestamp) > time.Minute {
+		t.Errorf("expected a recent timestamp, got %v", price.Timestamp)
+	}
+	if price.AgeSeconds < 0 {
+		t.Errorf("expected non-negative age, got %d", price.AgeSeconds)
+	}
+}
+
+func TestLTPHandlerPartialFailureErrors(t *testing.T) {
+	setupFakeKraken(t, map[string]string{"SGD": "131000.5"})
+
+	r := mux.NewRouter()
+	r.HandleFunc("/api/v1/ltp", handlers.LTPHandler(nil)).Methods("GET")
+
+	req := httptest.NewRequest("GET", "/api/v1/ltp?pairs=BTC/SGD,BTC/XYZ,ETH/USD", nil)
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusOK)
+	}
+
+	var resp services.LTPResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("JSON decode failed: %v", err)
+	}
+	if len(resp.LTP) != 1 || resp.LTP[0].Pair != "BTC/SGD" {
+		t.Errorf("got prices %+v, want only BTC/SGD", resp.LTP)
+	}
+
+	want := map[string]string{
+		"BTC/XYZ": services.ReasonInvalidPair,
+		"ETH/USD": services.ReasonInvalidPair,
+	}
+	if len(resp.Errors) != len(want) {
+		t.Fatalf("got %d errors, want %d", len(resp.Errors), len(want))
+	}
+	for _, pairErr := range resp.Errors {
+		if want[pairErr.Pair] != pairErr.Reason {
+			t.Errorf("got reason %q for %s, want %q", pairErr.Reason, pairErr.Pair, want[pairErr.Pair])
+		}
+	}
+}
+
+func TestLTPHandlerLogsPairOutcomes(t *testing.T) {
+	setupFakeKraken(t, map[string]string{"SGD": "131000.5"})
+	store := &fakeStore{}
+	writer := database.StartRequestLogWriter(store, nil, 10, 10, time.Hour)
+
+	r := mux.NewRouter()
+	r.HandleFunc("/api/v1/ltp", handlers.LTPHandler(writer)).Methods("GET")
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/v1/ltp?pairs=BTC/SGD,BTC/XYZ", nil))
+
+	if err := writer.Close(context.Background()); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if len(store.requestLogs) != 1 {
+		t.Fatalf("got %d request logs, want 1", len(store.requestLogs))
+	}
+	want := []database.PairOutcome{
+		{Pair: "BTC/SGD", Success: true},
+		{Pair: "BTC/XYZ", Reason: services.ReasonInvalidPair},
+	}
+	if got := store.requestLogs[0].PairOutcomes; !reflect.DeepEqual(got, want) {
+		t.Errorf("got pair outcomes %+v, want %+v", got, want)
+	}
+}
+
+func TestLTPHandlerProtobuf(t *testing.T) {
+	setupFakeKraken(t, map[string]string{"JPY": "15000000.25"})
+
+	r := mux.NewRouter()
+	r.HandleFunc("/api/v2/ltp", handlers.LTPV2Handler(nil)).Methods("GET")
+
+	req := httptest.NewRequest("GET", "/api/v2/ltp?pairs=BTC/JPY", nil)
+	req.Header.Set("Accept", "application/x-protobuf")
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	if ct := w.Header().Get("Content-Type"); ct != "application/x-protobuf" {
+		t.Errorf("got Content-Type %q, want application/x-protobuf", ct)
+	}
+
+	var resp pb.LTPResponse
+	if err := proto.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("protobuf decode failed: %v", err)
+	}
+	if len(resp.Ltp) != 1 {
+		t.Fatalf("got %d prices, want 1", len(resp.Ltp))
+	}
+	got := resp.Ltp[0]
+	if got.Pair != "BTC/JPY" || got.Price != "15000000.25" || got.Amount != 15000000.25 {
+		t.Errorf("got %+v, want BTC/JPY at 15000000.25", got)
+	}
+	if got.Timestamp == nil || got.Timestamp.AsTime().IsZero() {
+		t.Error("expected timestamp")
+	}
+}
+
+func TestLTPHandlerMsgpack(t *testing.T) {
+	setupFakeKraken(t, map[string]string{"JPY": "15000000.25"})
+
+	r := mux.NewRouter()
+	r.HandleFunc("/api/v1/ltp", handlers.LTPHandler(nil)).Methods("GET")
+
+	req := httptest.NewRequest("GET", "/api/v1/ltp?pairs=BTC/JPY&format=msgpack", nil)
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	if ct := w.Header().Get("Content-Type"); ct != "application/msgpack" {
+		t.Errorf("got Content-Type %q, want application/msgpack", ct)
+	}
+
+	var resp map[string][]map[string]interface{}
+	if err := msgpack.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("msgpack decode failed: %v", err)
+	}
+	if len(resp["ltp"]) != 1 || resp["ltp"][0]["pair"] != "BTC/JPY" || resp["ltp"][0]["amount"] != 15000000.25 {
+		t.Errorf("got %v, want BTC/JPY at 15000000.25 under JSON field names", resp)
+	}
+}
+
+func TestLTPHandlerFormatsFlagStalePrices(t *testing.T) {
+	server := setupFakeKraken(t, map[string]string{"DKK": "450000.25"})
+	priceCache := cache.NewMemory(100)
+	opts := clients.PriceServiceOptions{KrakenBaseURL: server.URL, LastKnownMaxAge: time.Hour}
+	if _, err := clients.NewPriceService(priceCache, opts).GetBTCQuote(context.Background(), "DKK"); err != nil {
+		t.Fatalf("initial fetch failed: %v", err)
+	}
+
+	// Expire the regular entry and take Kraken down
+	priceCache.Set(context.Background(), "price:BTC/DKK", nil, 0)
+	opts.KrakenBaseURL = "http://127.0.0.1:1"
+	usePriceService(t, priceCache, opts)
+
+	r := mux.NewRouter()
+	r.HandleFunc("/api/v2/ltp", handlers.LTPV2Handler(nil)).Methods("GET")
+	get := func(format string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", "/api/v2/ltp?pairs=BTC/DKK&format="+format, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("got status %d for %s, want %d", w.Code, format, http.StatusOK)
+		}
+		return w
+	}
+
+	records, err := csv.NewReader(get("csv").Body).ReadAll()
+	if err != nil {
+		t.Fatalf("CSV parse failed: %v", err)
+	}
+	if len(records) != 2 || records[0][3] != "stale" || records[1][3] != "true" {
+		t.Errorf("got rows %v, want BTC/DKK flagged stale", records)
+	}
+
+	var resp pb.LTPResponse
+	if err := proto.Unmarshal(get("protobuf").Body.Bytes(), &resp); err != nil {
+		t.Fatalf("protobuf decode failed: %v", err)
+	}
+	if len(resp.Ltp) != 1 || !resp.Ltp[0].Stale {
+		t.Errorf("got %+v, want BTC/DKK flagged stale", resp.Ltp)
+	}
+}
@@ -0,0 +1,257 @@
+package unit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"github.com/chesskiss/btc-service/clients"
+	"github.com/chesskiss/btc-service/handlers"
+	"github.com/chesskiss/btc-service/internal/cache"
+	"github.com/chesskiss/btc-service/internal/middleware"
+)
+
+func TestLTPV2HandlerDecimalStrings(t *testing.T) {
+	setupFakeKraken(t, map[string]string{"JPY": "7512345.12345678"})
+
+	r := mux.NewRouter()
+	r.HandleFunc("/api/v2/ltp", handlers.LTPV2Handler(nil)).Methods("GET")
+	handler := middleware.LoggingMiddleware(r)
+
+	req := httptest.NewRequest("GET", "/api/v2/ltp?pairs=BTC/JPY", nil)
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusOK)
+	}
+
+	var resp handlers.LTPV2Response
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("JSON decode failed: %v", err)
+	}
+
+	if len(resp.LTP) != 1 {
+		t.Fatalf("got %d prices, want 1", len(resp.LTP))
+	}
+
+	got := resp.LTP[0]
+	if got.Pair != "BTC/JPY" {
+		t.Errorf("got pair %s, want BTC/JPY", got.Pair)
+	}
+	if got.Price != "7512345.12345678" {
+		t.Errorf("got price %q, want exact decimal 7512345.12345678", got.Price)
+	}
+	if got.Source != "kraken" {
+		t.Errorf("got source %q, want kraken", got.Source)
+	}
+	if got.Timestamp.IsZero() {
+		t.Error("expected non-zero timestamp")
+	}
+	if got.AgeSeconds < 0 {
+		t.Errorf("expected non-negative age, got %d", got.AgeSeconds)
+	}
+}
+
+func TestLTPV2HandlerAllFailed(t *testing.T) {
+	setupFakeKraken(t, map[string]string{})
+
+	r := mux.NewRouter()
+	r.HandleFunc("/api/v2/ltp", handlers.LTPV2Handler(nil)).Methods("GET")
+
+	req := httptest.NewRequest("GET", "/api/v2/ltp?pairs=BTC/XYZ", nil)
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestLTPV2HandlerPrecision(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		rounding string
+		want     string
+	}{
+		{name: "Exchange precision", query: "", rounding: handlers.RoundHalfEven, want: "65000.125"},
+		{name: "Half even", query: "&precision=2", rounding: handlers.RoundHalfEven, want: "65000.12"},
+		{name: "Half up", query: "&precision=2", rounding: handlers.RoundHalfUp, want: "65000.13"},
+		{name: "Down", query: "&precision=0", rounding: handlers.RoundDown, want: "65000"},
+		{name: "Padded", query: "&precision=5", rounding: handlers.RoundHalfEven, want: "65000.12500"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupFakeKraken(t, map[string]string{"USD": "65000.125"})
+			handlers.SetPricePrecision(-1, tt.rounding)
+			defer handlers.SetPricePrecision(-1, handlers.RoundHalfEven)
+
+			r := mux.NewRouter()
+			r.HandleFunc("/api/v2/ltp", handlers.LTPV2Handler(nil)).Methods("GET")
+
+			req := httptest.NewRequest("GET", "/api/v2/ltp?pairs=BTC/USD"+tt.query, nil)
+			w := httptest.NewRecorder()
+
+			r.ServeHTTP(w, req)
+
+			var resp handlers.LTPV2Response
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("JSON decode failed: %v", err)
+			}
+			if len(resp.LTP) != 1 || resp.LTP[0].Price != tt.want {
+				t.Errorf("got %+v, want price %s", resp.LTP, tt.want)
+			}
+		})
+	}
+}
+
+func TestLTPV2HandlerInvalidPrecision(t *testing.T) {
+	for _, precision := range []string{"-1", "9", "two"} {
+		t.Run(precision, func(t *testing.T) {
+			r := mux.NewRouter()
+			r.HandleFunc("/api/v2/ltp", handlers.LTPV2Handler(nil)).Methods("GET")
+
+			req := httptest.NewRequest("GET", "/api/v2/ltp?precision="+precision, nil)
+			w := httptest.NewRecorder()
+
+			r.ServeHTTP(w, req)
+
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("got status %d, want %d", w.Code, http.StatusBadRequest)
+			}
+		})
+	}
+}
+
+func TestLTPV2HandlerFields(t *testing.T) {
+	setupFakeKraken(t, map[string]string{"USD": "65000.1"})
+
+	r := mux.NewRouter()
+	r.HandleFunc("/api/v2/ltp", handlers.LTPV2Handler(nil)).Methods("GET")
+
+	req := httptest.NewRequest("GET", "/api/v2/ltp?pairs=BTC/USD,BTC/XYZ&fields=pair,price", nil)
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusOK)
+	}
+
+	var resp map[string][]map[string]interface{}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("JSON decode failed: %v", err)
+	}
+	if len(resp["ltp"]) != 1 || len(resp["ltp"][0]) != 2 || resp["ltp"][0]["price"] != "65000.1" {
+		t.Errorf("got ltp %v, want only pair and price", resp["ltp"])
+	}
+	if len(resp["errors"]) != 1 {
+		t.Errorf("got errors %v, want the invalid pair kept", resp["errors"])
+	}
+}
+
+func TestLTPV2HandlerUnknownField(t *testing.T) {
+	r := mux.NewRouter()
+	r.HandleFunc("/api/v2/ltp", handlers.LTPV2Handler(nil)).Methods("GET")
+
+	req := httptest.NewRequest("GET", "/api/v2/ltp?fields=pair,amount", nil)
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestLTPV2HandlerFreshBypassesCache(t *testing.T) {
+	server := setupFakeKraken(t, map[string]string{"HKD": "510000.5"})
+	usePriceService(t, cache.NewMemory(100), clients.PriceServiceOptions{KrakenBaseURL: server.URL})
+	handlers.SetCacheBypassLimit(0, 1)
+	t.Cleanup(func() { handlers.SetCacheBypassLimit(60, 10) })
+
+	r := mux.NewRouter()
+	r.HandleFunc("/api/v2/ltp", handlers.LTPV2Handler(nil)).Methods("GET")
+	handler := middleware.LoggingMiddleware(r)
+
+	get := func(url string) (*httptest.ResponseRecorder, handlers.LTPV2Response) {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+		var resp handlers.LTPV2Response
+		json.NewDecoder(w.Body).Decode(&resp)
+		return w, resp
+	}
+
+	get("/api/v2/ltp?pairs=BTC/HKD")
+	if _, resp := get("/api/v2/ltp?pairs=BTC/HKD"); len(resp.LTP) != 1 || !resp.LTP[0].Cached {
+		t.Fatalf("got %+v, want a cached price", resp.LTP)
+	}
+
+	if _, resp := get("/api/v2/ltp?pairs=BTC/HKD&fresh=true"); len(resp.LTP) != 1 || resp.LTP[0].Cached {
+		t.Errorf("got %+v, want a live price", resp.LTP)
+	}
+
+	// The burst of one is spent
+	w, _ := get("/api/v2/ltp?pairs=BTC/HKD&max_age=0")
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("expected a Retry-After header")
+	}
+
+	if w, _ := get("/api/v2/ltp?pairs=BTC/HKD&max_age=soon"); w.Code != http.StatusBadRequest {
+		t.Errorf("got status %d for an invalid max_age, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestLTPV2HandlerBypassChargedOnlyWhenStale(t *testing.T) {
+	server := setupFakeKraken(t, map[string]string{"HKD": "510000.5", "SGD": "88000.1"})
+	usePriceService(t, cache.NewMemory(100), clients.PriceServiceOptions{KrakenBaseURL: server.URL})
+	handlers.SetCacheBypassLimit(0, 1)
+	t.Cleanup(func() { handlers.SetCacheBypassLimit(60, 10) })
+
+	r := mux.NewRouter()
+	r.HandleFunc("/api/v2/ltp", handlers.LTPV2Handler(nil)).Methods("GET")
+	handler := middleware.LoggingMiddleware(r)
+
+	get := func(url, remoteAddr string) (*httptest.ResponseRecorder, handlers.LTPV2Response) {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", url, nil)
+		req.RemoteAddr = remoteAddr
+		handler.ServeHTTP(w, req)
+		var resp handlers.LTPV2Response
+		json.NewDecoder(w.Body).Decode(&resp)
+		return w, resp
+	}
+
+	get("/api/v2/ltp?pairs=BTC/HKD,BTC/SGD", "192.0.2.1:1234")
+
+	// The cached prices are younger than an hour, so nothing is spent
+	for range 3 {
+		w, resp := get("/api/v2/ltp?pairs=BTC/HKD,BTC/SGD&max_age=3600", "192.0.2.1:1234")
+		if w.Code != http.StatusOK || len(resp.LTP) != 2 || !resp.LTP[0].Cached {
+			t.Fatalf("got status %d and %+v, want cached prices", w.Code, resp.LTP)
+		}
+	}
+
+	// Refreshing both pairs spends the one token
+	if w, resp := get("/api/v2/ltp?pairs=BTC/HKD,BTC/SGD&fresh=true", "192.0.2.1:1234"); w.Code != http.StatusOK || len(resp.LTP) != 2 {
+		t.Fatalf("got status %d and %+v, want two live prices", w.Code, resp.LTP)
+	}
+	if w, _ := get("/api/v2/ltp?pairs=BTC/HKD&fresh=true", "192.0.2.1:1234"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+
+	// Another client IP has its own bucket
+	if w, resp := get("/api/v2/ltp?pairs=BTC/HKD&fresh=true", "198.51.100.7:1234"); w.Code != http.StatusOK || len(resp.LTP) != 1 || resp.LTP[0].Cached {
+		t.Errorf("got status %d and %+v, want a live price", w.Code, resp.LTP)
+	}
+}
@@ -0,0 +1,131 @@
+package unit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/chesskiss/btc-service/clients"
+	"github.com/chesskiss/btc-service/internal/handlers"
+	"github.com/chesskiss/btc-service/internal/health"
+)
+
+func TestHealthMonitorHysteresis(t *testing.T) {
+	var failing bool
+	probe := health.Probe{
+		Name: "cache",
+		Check: func(ctx context.Context) error {
+			if failing {
+				return errors.New("ping failed")
+			}
+			return nil
+		},
+	}
+	monitor := health.NewMonitor(3, 2, probe)
+
+	steps := []struct {
+		fail bool
+		want bool
+	}{
+		{fail: false, want: true},
+		{fail: true, want: true},
+		{fail: true, want: true},
+		{fail: true, want: false},
+		{fail: false, want: false},
+		{fail: true, want: false},
+		{fail: false, want: false},
+		{fail: false, want: true},
+	}
+
+	for i, step := range steps {
+		failing = step.fail
+		report := monitor.Check(context.Background())
+		if report.Healthy != step.want {
+			t.Fatalf("step %d: got healthy %v, want %v", i, report.Healthy, step.want)
+		}
+	}
+}
+
+func TestHealthMonitorScore(t *testing.T) {
+	ok := health.Probe{Name: "database", Check: func(ctx context.Context) error { return nil }}
+	down := health.Probe{Name: "cache", Check: func(ctx context.Context) error { return errors.New("down") }}
+	monitor := health.NewMonitor(1, 1, ok, down)
+
+	report := monitor.Check(context.Background())
+	if report.Healthy {
+		t.Error("expected report to be unhealthy")
+	}
+	if report.Score != 0.5 {
+		t.Errorf("got score %v, want 0.5", report.Score)
+	}
+	if dep := report.Dependencies[1]; dep.Healthy || dep.Error != "down" || dep.ConsecutiveFailures != 1 {
+		t.Errorf("unexpected cache status: %+v", dep)
+	}
+}
+
+func TestReadinessHandlerIgnoresTransientFailure(t *testing.T) {
+	calls := 0
+	probe := health.Probe{
+		Name: "cache",
+		Check: func(ctx context.Context) error {
+			calls++
+			if calls == 1 {
+				return errors.New("timeout")
+			}
+			return nil
+		},
+	}
+	handler := handlers.ReadinessHandler(health.NewMonitor(3, 2, probe))
+
+	req := httptest.NewRequest("GET", "/ready", nil)
+	w := httptest.NewRecorder()
+	handler(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusOK)
+	}
+
+	var body handlers.ReadinessResponse
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("JSON decode failed: %v", err)
+	}
+	if body.Status != "ready" || body.Score != 1 {
+		t.Errorf("got %+v, want ready with score 1", body)
+	}
+}
+
+func TestHealthHandlerComponents(t *testing.T) {
+	setupFakeKraken(t, map[string]string{"NZD": "110000.1"})
+	if _, err := clients.GetBTCQuote(context.Background(), "NZD"); err != nil {
+		t.Fatalf("failed to get quote: %v", err)
+	}
+
+	req := httptest.NewRequest("GET", "/health", nil)
+	w := httptest.NewRecorder()
+	handlers.HealthHandler("postgres", nil, nil)(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusOK)
+	}
+
+	var body handlers.HealthResponse
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("JSON decode failed: %v", err)
+	}
+	if body.Status != handlers.StatusDegraded {
+		t.Errorf("got status %q, want %q", body.Status, handlers.StatusDegraded)
+	}
+	if got := body.Components["postgres"].Status; got != handlers.StatusDown {
+		t.Errorf("got postgres %q, want %q", got, handlers.StatusDown)
+	}
+	if got := body.Components["redis"].Status; got != handlers.StatusDegraded {
+		t.Errorf("got redis %q, want %q", got, handlers.StatusDegraded)
+	}
+	kraken := body.Components["kraken"]
+	if kraken.Status != handlers.StatusOK || kraken.LastSuccessSecondsAgo == nil {
+		t.Errorf("got kraken %+v, want ok with a last success", kraken)
+	}
+}
@@ -0,0 +1,112 @@
+package unit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chesskiss/btc-service/internal/alerts"
+	"github.com/chesskiss/btc-service/internal/handlers"
+	"github.com/chesskiss/btc-service/internal/middleware"
+	"github.com/chesskiss/btc-service/internal/problem"
+)
+
+func TestLimitRequestSize(t *testing.T) {
+	limits := middleware.SizeLimits{MaxHeaderBytes: 256, MaxBodyBytes: 64, MaxURLLength: 64}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := middleware.LimitRequestSize(limits, problem.Reject)(ok)
+
+	tests := []struct {
+		name     string
+		url      string
+		header   string
+		body     string
+		want     int
+		wantCode string
+	}{
+		{name: "Within limits", url: "/api/v1/ltp?pairs=BTC/USD", body: `{}`, want: http.StatusOK},
+		{name: "URL too long", url: "/api/v1/ltp?pairs=" + strings.Repeat("BTC/USD,", 10), want: http.StatusRequestURITooLong, wantCode: problem.CodeURITooLong},
+		{name: "Headers too large", url: "/api/v1/ltp", header: strings.Repeat("x", 300), want: http.StatusRequestHeaderFieldsTooLarge, wantCode: problem.CodeHeadersTooLarge},
+		{name: "Body too large", url: "/api/v1/alerts", body: strings.Repeat("x", 65), want: http.StatusRequestEntityTooLarge, wantCode: problem.CodeRequestTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", tt.url, strings.NewReader(tt.body))
+			if tt.header != "" {
+				req.Header.Set("X-Padding", tt.header)
+			}
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tt.want {
+				t.Errorf("got status %d, want %d", w.Code, tt.want)
+			}
+			if tt.wantCode != "" && !strings.Contains(w.Body.String(), `"code":"`+tt.wantCode+`"`) {
+				t.Errorf("expected code %s in body %s", tt.wantCode, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestLimitRequestSizeStreamedBody(t *testing.T) {
+	limits := middleware.SizeLimits{MaxBodyBytes: 64}
+	handler := middleware.LimitRequestSize(limits, problem.Reject)(handlers.CreateAlertHandler(&fakeStore{}, alerts.CallbackPolicy{}))
+
+	// Without a Content-Length the limit is only hit while decoding
+	body := `{"pair":"BTC/USD","callback_url":"https://example.com/` + strings.Repeat("x", 100) + `"}`
+	req := httptest.NewRequest("POST", "/api/v1/alerts", strings.NewReader(body))
+	req.ContentLength = -1
+	req = req.WithContext(middleware.WithAPIKeyID(req.Context(), "acme"))
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusRequestEntityTooLarge)
+	}
+}
+
+func TestShedLoad(t *testing.T) {
+	entered, release := make(chan struct{}), make(chan struct{})
+	handler := middleware.ShedLoad(1, 1500*time.Millisecond, problem.Shed)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v1/slow" {
+			entered <- struct{}{}
+			<-release
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	send := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	done := make(chan int)
+	go func() { done <- send("/api/v1/slow").Code }()
+	<-entered
+
+	w := send("/api/v1/ltp")
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "2" {
+		t.Errorf("got status %d with Retry-After %q at capacity, want 503 with 2", w.Code, w.Header().Get("Retry-After"))
+	}
+	if !strings.Contains(w.Body.String(), `"code":"`+problem.CodeOverloaded+`"`) {
+		t.Errorf("expected code %s in body %s", problem.CodeOverloaded, w.Body.String())
+	}
+	if w := send("/health"); w.Code != http.StatusOK {
+		t.Errorf("got status %d for /health at capacity, want 200", w.Code)
+	}
+
+	close(release)
+	if code := <-done; code != http.StatusOK {
+		t.Errorf("got status %d for the request in flight, want 200", code)
+	}
+	if w := send("/api/v1/ltp"); w.Code != http.StatusOK {
+		t.Errorf("got status %d once the slot was freed, want 200", w.Code)
+	}
+}
@@ -0,0 +1,106 @@
+package unit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/chesskiss/btc-service/clients"
+	"github.com/chesskiss/btc-service/handlers"
+)
+
+func setMaintenance(t *testing.T, windows []clients.MaintenanceWindow) {
+	clients.SetMaintenanceWindows(windows)
+	t.Cleanup(func() {
+		clients.SetMaintenanceWindows(nil)
+	})
+}
+
+func TestActiveMaintenance(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name   string
+		window clients.MaintenanceWindow
+		active bool
+	}{
+		{
+			name:   "Scheduled now",
+			window: clients.MaintenanceWindow{Status: "scheduled", Start: now.Add(-time.Minute), End: now.Add(time.Hour)},
+			active: true,
+		},
+		{
+			name:   "Scheduled later",
+			window: clients.MaintenanceWindow{Status: "scheduled", Start: now.Add(time.Hour), End: now.Add(2 * time.Hour)},
+			active: false,
+		},
+		{
+			name:   "Completed early",
+			window: clients.MaintenanceWindow{Status: "completed", Start: now.Add(-time.Minute), End: now.Add(time.Hour)},
+			active: false,
+		},
+		{
+			name:   "Overrunning",
+			window: clients.MaintenanceWindow{Status: "in_progress", Start: now.Add(-2 * time.Hour), End: now.Add(-time.Hour)},
+			active: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setMaintenance(t, []clients.MaintenanceWindow{tt.window})
+
+			if _, active := clients.ActiveMaintenance(); active != tt.active {
+				t.Errorf("got active %v, want %v", active, tt.active)
+			}
+		})
+	}
+}
+
+func TestGetBTCPriceSkipsFetchDuringMaintenance(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"error":[],"result":{"XXBTZSEK":{"c":["500000.0","1"]}}}`))
+	}))
+	defer server.Close()
+	usePriceService(t, nil, clients.PriceServiceOptions{KrakenBaseURL: server.URL})
+
+	now := time.Now()
+	setMaintenance(t, []clients.MaintenanceWindow{
+		{Name: "Funding gateway upgrade", Status: "in_progress", Start: now.Add(-time.Minute), End: now.Add(time.Hour)},
+	})
+
+	_, err := clients.GetBTCPrice(context.Background(), "SEK")
+	if !errors.Is(err, clients.ErrExchangeMaintenance) {
+		t.Errorf("got error %v, want ErrExchangeMaintenance", err)
+	}
+	if calls != 0 {
+		t.Errorf("expected no upstream calls during maintenance, got %d", calls)
+	}
+}
+
+func TestLTPHandlerMaintenanceHeader(t *testing.T) {
+	now := time.Now()
+	setMaintenance(t, []clients.MaintenanceWindow{
+		{Name: "Database migration", Status: "in_progress", Start: now.Add(-time.Minute), End: now.Add(time.Hour)},
+	})
+
+	r := mux.NewRouter()
+	r.HandleFunc("/api/v1/ltp", handlers.LTPHandler(nil)).Methods("GET")
+
+	req := httptest.NewRequest("GET", "/api/v1/ltp?pairs=BTC/SEK", nil)
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	if got := w.Header().Get("X-Exchange-Status"); got != "maintenance" {
+		t.Errorf("got X-Exchange-Status %q, want maintenance", got)
+	}
+}
@@ -0,0 +1,445 @@
+package unit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/chesskiss/btc-service/clients"
+	"github.com/chesskiss/btc-service/internal/cache"
+	"github.com/chesskiss/btc-service/internal/metrics"
+)
+
+func TestMemoryCacheGetSet(t *testing.T) {
+	ctx := context.Background()
+	c := cache.NewMemory(10)
+
+	if _, err := c.Get(ctx, "price:BTC/USD"); !errors.Is(err, cache.ErrMiss) {
+		t.Fatalf("expected a miss on an empty cache, got %v", err)
+	}
+
+	if err := c.Set(ctx, "price:BTC/USD", []byte("65000"), time.Minute); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	val, err := c.Get(ctx, "price:BTC/USD")
+	if err != nil || string(val) != "65000" {
+		t.Errorf("got %q, %v; want 65000", val, err)
+	}
+
+	// A non-positive TTL has already expired
+	c.Set(ctx, "price:BTC/EUR", []byte("60000"), 0)
+	if _, err := c.Get(ctx, "price:BTC/EUR"); !errors.Is(err, cache.ErrMiss) {
+		t.Errorf("expected expired entry to miss, got %v", err)
+	}
+}
+
+func TestMemoryCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	ctx := context.Background()
+	c := cache.NewMemory(2)
+
+	c.Set(ctx, "a", []byte("1"), time.Minute)
+	c.Set(ctx, "b", []byte("2"), time.Minute)
+	c.Get(ctx, "a") // b is now least recently used
+	c.Set(ctx, "c", []byte("3"), time.Minute)
+
+	if _, err := c.Get(ctx, "b"); !errors.Is(err, cache.ErrMiss) {
+		t.Errorf("expected b to be evicted, got %v", err)
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, err := c.Get(ctx, key); err != nil {
+			t.Errorf("expected %s to be cached, got %v", key, err)
+		}
+	}
+	if c.Len() != 2 {
+		t.Errorf("got %d entries, want 2", c.Len())
+	}
+}
+
+func TestGetBTCQuoteWithMemoryCache(t *testing.T) {
+	server := setupFakeKraken(t, map[string]string{"SEK": "700000.5"})
+	service := clients.NewPriceService(cache.NewMemory(100), clients.PriceServiceOptions{KrakenBaseURL: server.URL})
+
+	first, err := service.GetBTCQuote(context.Background(), "SEK")
+	if err != nil {
+		t.Fatalf("first fetch failed: %v", err)
+	}
+	second, err := service.GetBTCQuote(context.Background(), "SEK")
+	if err != nil {
+		t.Fatalf("second fetch failed: %v", err)
+	}
+
+	if first.Cached || !second.Cached {
+		t.Errorf("got cached %v then %v, want false then true", first.Cached, second.Cached)
+	}
+	if second.Decimal != "700000.5" {
+		t.Errorf("got cached price %q, want 700000.5", second.Decimal)
+	}
+}
+
+func TestTieredCacheServesFromL1(t *testing.T) {
+	ctx := context.Background()
+	l1, l2 := cache.NewMemory(10), cache.NewMemory(10)
+	c := cache.NewTiered(l1, l2, time.Minute)
+
+	// Reads through to L2 and keeps a copy in L1
+	l2.Set(ctx, "price:BTC/USD", []byte("65000"), time.Minute)
+	if val, err := c.Get(ctx, "price:BTC/USD"); err != nil || string(val) != "65000" {
+		t.Fatalf("got %q, %v; want 65000 from L2", val, err)
+	}
+
+	// A write made by another replica isn't seen until the L1 copy expires
+	l2.Set(ctx, "price:BTC/USD", []byte("65100"), time.Minute)
+	if val, _ := c.Get(ctx, "price:BTC/USD"); string(val) != "65000" {
+		t.Errorf("got %q, want 65000 from L1", val)
+	}
+
+	// Writes go to both tiers
+	c.Set(ctx, "price:BTC/EUR", []byte("60000"), time.Minute)
+	for name, tier := range map[string]cache.Cache{"L1": l1, "L2": l2} {
+		if val, err := tier.Get(ctx, "price:BTC/EUR"); err != nil || string(val) != "60000" {
+			t.Errorf("%s: got %q, %v; want 60000", name, val, err)
+		}
+	}
+
+	if c.Backend() != cache.BackendMemory {
+		t.Errorf("got backend %q, want the L2's", c.Backend())
+	}
+}
+
+func TestGetBTCQuoteStaleWhileRevalidate(t *testing.T) {
+	server := setupFakeKraken(t, map[string]string{"NOK": "720000.5"})
+	priceCache := cache.NewMemory(100)
+	service := clients.NewPriceService(priceCache, clients.PriceServiceOptions{
+		KrakenBaseURL:        server.URL,
+		StaleWhileRevalidate: time.Minute,
+	})
+
+	// Older than the 60s TTL but inside the stale window
+	stale, _ := json.Marshal(clients.CachedPrice{Price: 700000, Decimal: "700000", Timestamp: time.Now().Add(-90 * time.Second)})
+	priceCache.Set(context.Background(), "price:BTC/NOK", stale, time.Minute)
+
+	quote, err := service.GetBTCQuote(context.Background(), "NOK")
+	if err != nil {
+		t.Fatalf("GetBTCQuote failed: %v", err)
+	}
+	if !quote.Cached || quote.Decimal != "700000" {
+		t.Errorf("got %+v, want the stale cached price", quote)
+	}
+
+	// The background refresh replaces the cached price
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		quote, err = service.GetBTCQuote(context.Background(), "NOK")
+		if err == nil && quote.Decimal == "720000.5" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("cached price was not refreshed, last got %+v, %v", quote, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestGetBTCQuoteLastKnownOnUpstreamFailure(t *testing.T) {
+	server := setupFakeKraken(t, map[string]string{"DKK": "450000.25"})
+	priceCache := cache.NewMemory(100)
+	opts := clients.PriceServiceOptions{KrakenBaseURL: server.URL, LastKnownMaxAge: time.Hour}
+
+	if _, err := clients.NewPriceService(priceCache, opts).GetBTCQuote(context.Background(), "DKK"); err != nil {
+		t.Fatalf("initial fetch failed: %v", err)
+	}
+
+	// Expire the regular entry and take Kraken down
+	priceCache.Set(context.Background(), "price:BTC/DKK", nil, 0)
+	opts.KrakenBaseURL = "http://127.0.0.1:1"
+
+	quote, err := clients.NewPriceService(priceCache, opts).GetBTCQuote(context.Background(), "DKK")
+	if err != nil {
+		t.Fatalf("expected the last known price, got error: %v", err)
+	}
+	if !quote.Stale || quote.Decimal != "450000.25" {
+		t.Errorf("got %+v, want the last known price flagged stale", quote)
+	}
+
+	// Unknown pairs are not an outage and never fall back
+	opts.KrakenBaseURL = setupFakeKraken(t, map[string]string{}).URL
+	if _, err := clients.NewPriceService(priceCache, opts).GetBTCQuote(context.Background(), "DKK"); !errors.Is(err, clients.ErrPairNotSupported) {
+		t.Errorf("got %v, want ErrPairNotSupported", err)
+	}
+}
+
+func TestCacheRefresherRefreshesActivePairs(t *testing.T) {
+	server := setupFakeKraken(t, map[string]string{"SEK": "710000.5"})
+	priceCache := cache.NewMemory(100)
+	service := clients.NewPriceService(priceCache, clients.PriceServiceOptions{KrakenBaseURL: server.URL})
+
+	// Requesting a cached price marks the pair active
+	cached, _ := json.Marshal(clients.CachedPrice{Decimal: "700000.5", Timestamp: time.Now()})
+	priceCache.Set(context.Background(), "price:BTC/SEK", cached, time.Minute)
+	if quote, err := service.GetBTCQuote(context.Background(), "SEK"); err != nil || quote.Decimal != "700000.5" {
+		t.Fatalf("got %+v, %v, want the cached price", quote, err)
+	}
+
+	// A lead as long as the TTL makes every active pair due at once
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	service.StartCacheRefresher(ctx, 10*time.Millisecond, time.Minute, time.Minute)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		quote, err := service.GetBTCQuote(context.Background(), "SEK")
+		if err == nil && quote.Cached && quote.Decimal == "710000.5" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("cached price was not refreshed, last got %+v, %v", quote, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCacheMetrics(t *testing.T) {
+	server := setupFakeKraken(t, map[string]string{"PLN": "260000.5"})
+	usePriceService(t, cache.NewMemory(100), clients.PriceServiceOptions{KrakenBaseURL: server.URL})
+
+	hits := testutil.ToFloat64(metrics.CachePairHitsTotal.WithLabelValues("BTC/PLN"))
+	for range 2 {
+		if _, err := clients.GetBTCQuote(context.Background(), "PLN"); err != nil {
+			t.Fatalf("GetBTCQuote failed: %v", err)
+		}
+	}
+
+	if got := testutil.ToFloat64(metrics.CachePairHitsTotal.WithLabelValues("BTC/PLN")) - hits; got != 1 {
+		t.Errorf("got %v hits for BTC/PLN, want 1", got)
+	}
+	if got := testutil.ToFloat64(metrics.CacheKeys); got != 1 {
+		t.Errorf("got %v cached keys, want 1", got)
+	}
+	if ratio := testutil.ToFloat64(metrics.CacheHitRatio); ratio <= 0 || ratio > 1 {
+		t.Errorf("got hit ratio %v, want within (0, 1]", ratio)
+	}
+}
+
+func TestTieredCacheGetMany(t *testing.T) {
+	ctx := context.Background()
+	l1, l2 := cache.NewMemory(10), cache.NewMemory(10)
+	c := cache.NewTiered(l1, l2, time.Second)
+
+	l1.Set(ctx, "price:BTC/USD", []byte("65000"), time.Minute)
+	l2.Set(ctx, "price:BTC/EUR", []byte("60000"), time.Minute)
+
+	values, err := c.GetMany(ctx, []string{"price:BTC/USD", "price:BTC/EUR", "price:BTC/CHF"})
+	if err != nil {
+		t.Fatalf("GetMany failed: %v", err)
+	}
+	if len(values) != 2 || string(values["price:BTC/USD"]) != "65000" || string(values["price:BTC/EUR"]) != "60000" {
+		t.Errorf("got %v, want the USD and EUR entries only", values)
+	}
+	if _, err := l1.Get(ctx, "price:BTC/EUR"); err != nil {
+		t.Error("expected the L2 hit to be copied into the L1")
+	}
+}
+
+func TestGetBTCQuotesReadsCacheInOneBatch(t *testing.T) {
+	// Kraken has neither price, so both must come from the cache
+	server := setupFakeKraken(t, map[string]string{})
+	priceCache := cache.NewMemory(100)
+	usePriceService(t, priceCache, clients.PriceServiceOptions{KrakenBaseURL: server.URL})
+
+	for currency, price := range map[string]string{"CAD": "90000.5", "AUD": "98000.25"} {
+		data, _ := json.Marshal(clients.CachedPrice{Decimal: price, Timestamp: time.Now()})
+		priceCache.Set(context.Background(), "price:BTC/"+currency, data, time.Minute)
+	}
+
+	quotes, errs := clients.GetBTCQuotes(context.Background(), []string{"CAD", "AUD", "NZD"})
+	if errs[0] != nil || errs[1] != nil || quotes[0].Decimal != "90000.5" || quotes[1].Decimal != "98000.25" {
+		t.Errorf("got %+v, %v, want both cached prices", quotes[:2], errs[:2])
+	}
+	if !errors.Is(errs[2], clients.ErrPairNotSupported) {
+		t.Errorf("got error %v for the uncached pair, want ErrPairNotSupported", errs[2])
+	}
+}
+
+func TestGetBTCQuoteWaitsForFetchLockHolder(t *testing.T) {
+	server := setupFakeKraken(t, map[string]string{"TRY": "2100000.5"})
+	priceCache := cache.NewMemory(100)
+	service := clients.NewPriceService(priceCache, clients.PriceServiceOptions{
+		KrakenBaseURL: server.URL,
+		FetchLockTTL:  time.Second,
+		FetchLockWait: 200 * time.Millisecond,
+	})
+
+	// Another replica holds the lock and caches its price shortly
+	priceCache.SetNX(context.Background(), "lock:price:BTC/TRY", []byte("1"), time.Second)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		data, _ := json.Marshal(clients.CachedPrice{Decimal: "2000000", Timestamp: time.Now()})
+		priceCache.Set(context.Background(), "price:BTC/TRY", data, time.Minute)
+	}()
+
+	quote, err := service.GetBTCQuote(context.Background(), "TRY")
+	if err != nil || quote.Decimal != "2000000" {
+		t.Errorf("got %+v, %v, want the lock holder's price", quote, err)
+	}
+
+	// Once the wait runs out the caller fetches the price itself
+	priceCache.Delete(context.Background(), "price:BTC/TRY")
+	quote, err = service.GetBTCQuote(context.Background(), "TRY")
+	if err != nil || quote.Decimal != "2100000.5" {
+		t.Errorf("got %+v, %v, want the price fetched from Kraken", quote, err)
+	}
+}
+
+func TestNamespacedCachePrefixesKeys(t *testing.T) {
+	ctx := context.Background()
+	shared := cache.NewMemory(10)
+	staging := cache.NewNamespaced(shared, "btc-service:staging")
+	prod := cache.NewNamespaced(shared, "btc-service:prod")
+
+	staging.Set(ctx, "price:BTC/USD", []byte("65000"), time.Minute)
+	prod.Set(ctx, "price:BTC/USD", []byte("65100"), time.Minute)
+
+	if val, err := shared.Get(ctx, "btc-service:staging:price:BTC/USD"); err != nil || string(val) != "65000" {
+		t.Errorf("got %q, %v, want the staging price under its namespace", val, err)
+	}
+	values, err := prod.GetMany(ctx, []string{"price:BTC/USD"})
+	if err != nil || string(values["price:BTC/USD"]) != "65100" {
+		t.Errorf("got %v, %v, want the prod price under its unprefixed key", values, err)
+	}
+}
+
+func TestPriceServicesAreIndependent(t *testing.T) {
+	server := setupFakeKraken(t, map[string]string{"MXN": "1100000.5"})
+	cached := clients.NewPriceService(cache.NewMemory(100), clients.PriceServiceOptions{KrakenBaseURL: server.URL})
+	uncached := clients.NewPriceService(nil, clients.PriceServiceOptions{KrakenBaseURL: server.URL})
+
+	for range 2 {
+		cached.GetBTCQuote(context.Background(), "MXN")
+	}
+	quote, err := cached.GetBTCQuote(context.Background(), "MXN")
+	if err != nil || !quote.Cached {
+		t.Errorf("got %+v, %v, want a cached quote", quote, err)
+	}
+
+	// The other service's cache is never consulted
+	quote, err = uncached.GetBTCQuote(context.Background(), "MXN")
+	if err != nil || quote.Cached {
+		t.Errorf("got %+v, %v, want a quote fetched from Kraken", quote, err)
+	}
+}
+
+// unreachableCache is a Memory cache whose every operation fails while
+// down is set, standing in for a Redis outage
+type unreachableCache struct {
+	*cache.Memory
+	down atomic.Bool
+}
+
+var errUnreachable = errors.New("connection refused")
+
+func (c *unreachableCache) Get(ctx context.Context, key string) ([]byte, error) {
+	if c.down.Load() {
+		return nil, errUnreachable
+	}
+	return c.Memory.Get(ctx, key)
+}
+
+func (c *unreachableCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if c.down.Load() {
+		return errUnreachable
+	}
+	return c.Memory.Set(ctx, key, value, ttl)
+}
+
+func (c *unreachableCache) Ping(ctx context.Context) error {
+	if c.down.Load() {
+		return errUnreachable
+	}
+	return nil
+}
+
+func TestFallbackCacheDuringOutage(t *testing.T) {
+	ctx := context.Background()
+	primary := &unreachableCache{Memory: cache.NewMemory(10)}
+	primary.down.Store(true)
+	c := cache.NewFallback(primary, cache.NewMemory(10), 10*time.Millisecond)
+
+	// The failed write lands in the in-process cache and is served from it
+	if err := c.Set(ctx, "price:BTC/USD", []byte("65000"), time.Minute); err != nil {
+		t.Fatalf("Set failed during the outage: %v", err)
+	}
+	if val, err := c.Get(ctx, "price:BTC/USD"); err != nil || string(val) != "65000" {
+		t.Errorf("got %q, %v, want the locally cached price", val, err)
+	}
+	if !c.Active() {
+		t.Error("expected the fallback to be active")
+	}
+
+	// A successful probe switches back to the primary
+	primary.down.Store(false)
+	deadline := time.Now().Add(2 * time.Second)
+	for c.Active() {
+		if time.Now().After(deadline) {
+			t.Fatal("fallback did not recover once the primary was reachable")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if _, err := c.Get(ctx, "price:BTC/USD"); !errors.Is(err, cache.ErrMiss) {
+		t.Errorf("got %v, want a miss from the primary", err)
+	}
+}
+
+func TestTieredOverFallbackSharesMemory(t *testing.T) {
+	ctx := context.Background()
+	primary := &unreachableCache{Memory: cache.NewMemory(10)}
+	primary.down.Store(true)
+	local := cache.NewMemory(10)
+	c := cache.NewTiered(local, cache.NewFallback(primary, local, time.Hour), 10*time.Millisecond)
+
+	// While Redis is down the L1 is the only cache, so the entry keeps its
+	// full TTL rather than the L1's
+	if err := c.Set(ctx, "price:BTC/USD", []byte("65000"), time.Minute); err != nil {
+		t.Fatalf("Set failed during the outage: %v", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if val, err := c.Get(ctx, "price:BTC/USD"); err != nil || string(val) != "65000" {
+		t.Errorf("got %q, %v, want the locally cached price", val, err)
+	}
+	if size, _ := local.Size(ctx); size != 1 {
+		t.Errorf("got %d entries, want the price cached once", size)
+	}
+}
+
+func TestMemoryCacheSnapshot(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "cache.json")
+
+	// A missing snapshot loads nothing
+	c := cache.NewMemory(10)
+	if loaded, err := c.LoadSnapshot(path); err != nil || loaded != 0 {
+		t.Fatalf("got %d, %v loading a missing snapshot, want 0, nil", loaded, err)
+	}
+
+	c.Set(ctx, "price:BTC/USD", []byte("65000"), time.Minute)
+	c.Set(ctx, "price:BTC/EUR", []byte("60000"), time.Minute)
+	c.Set(ctx, "price:BTC/CHF", []byte("58000"), time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+
+	if saved, err := c.SaveSnapshot(path); err != nil || saved != 2 {
+		t.Fatalf("got %d, %v saving, want the 2 unexpired entries", saved, err)
+	}
+
+	restored := cache.NewMemory(10)
+	if loaded, err := restored.LoadSnapshot(path); err != nil || loaded != 2 {
+		t.Fatalf("got %d, %v loading, want 2", loaded, err)
+	}
+	if val, err := restored.Get(ctx, "price:BTC/EUR"); err != nil || string(val) != "60000" {
+		t.Errorf("got %q, %v, want the saved EUR price", val, err)
+	}
+}
@@ -0,0 +1,265 @@
+package unit
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chesskiss/btc-service/internal/database"
+	"github.com/chesskiss/btc-service/internal/pagination"
+)
+
+// newMySQLTestStore returns a store on an emptied MySQL test database with
+// the built-in migrations applied, and its connection pool
+func newMySQLTestStore(t *testing.T) (*database.MySQLStore, *sql.DB) {
+	db, err := database.InitMySQL(context.Background(), "localhost", "3306", "root", "root", "btc_service_test", database.TLSOptions{})
+	if err != nil {
+		t.Skipf("Skipping test: MySQL not reachable: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	for _, table := range []string{"alert_deliveries", "alert_subscriptions", "api_key_usage", "purge_audit", "request_logs", "schema_migrations"} {
+		if _, err := db.Exec("DROP TABLE IF EXISTS " + table); err != nil {
+			t.Fatalf("Failed to drop %s: %v", table, err)
+		}
+	}
+	if _, err := database.MigrateMySQL(context.Background(), db, 0); err != nil {
+		t.Fatalf("MigrateMySQL: %v", err)
+	}
+	return database.NewMySQLStore(db), db
+}
+
+func TestMySQLMigrationsAreEmbeddedInOrder(t *testing.T) {
+	migrations, err := database.MySQLMigrations()
+	if err != nil {
+		t.Fatalf("MySQLMigrations: %v", err)
+	}
+	if len(migrations) == 0 || migrations[0].Name != "0001_create_schema" {
+		t.Fatalf("got migrations %v, want 0001_create_schema first", migrations)
+	}
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			t.Errorf("migration %d is %s, want version %d", i, m.Name, i+1)
+		}
+	}
+}
+
+func TestInitMySQLRejectsMissingRootCert(t *testing.T) {
+	rootCert := filepath.Join(t.TempDir(), "missing-ca.pem")
+	_, err := database.InitMySQL(context.Background(), "localhost", "3306", "root", "root", "btc_service_test", database.TLSOptions{
+		SSLMode:  "verify-full",
+		RootCert: rootCert,
+	})
+	if err == nil || !strings.Contains(err.Error(), "missing-ca.pem") {
+		t.Errorf("Expected an error naming the root certificate, got %v", err)
+	}
+}
+
+func TestMySQLStoreWithoutDatabase(t *testing.T) {
+	store := database.NewMySQLStore(nil)
+	if err := store.LogRequest(context.Background(), database.RequestLog{RequestID: "no-db"}); err == nil {
+		t.Error("Expected an error without a database")
+	}
+	if _, err := store.QueryRequests(context.Background(), database.RequestLogFilter{Limit: 10}); err == nil {
+		t.Error("Expected an error without a database")
+	}
+}
+
+func TestMySQLStoreRequestLogs(t *testing.T) {
+	store, _ := newMySQLTestStore(t)
+	ctx := context.Background()
+
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	reqLogs := []database.RequestLog{
+		{RequestID: "mysql-1", Timestamp: now.Add(-3 * time.Hour), Method: "GET", Endpoint: "/api/v1/ltp", PairsRequested: "BTC/USD,BTC/EUR", UserIP: "192.0.2.1", StatusCode: 200, APIKeyID: "key-a",
+			PairOutcomes: []database.PairOutcome{{Pair: "BTC/USD", Success: true}, {Pair: "BTC/EUR", Success: true}}},
+		{RequestID: "mysql-2", Timestamp: now.Add(-2 * time.Hour), Method: "GET", Endpoint: "/api/v1/ltp", PairsRequested: "BTC/USD", UserIP: "192.0.2.2", StatusCode: 200,
+			PairOutcomes: []database.PairOutcome{{Pair: "BTC/USD", Success: true, CacheHit: true}}},
+		{RequestID: "mysql-3", Timestamp: now.Add(-time.Hour), Method: "GET", Endpoint: "/api/v1/ltp", PairsRequested: "BTC/CHF", UserIP: "192.0.2.1", StatusCode: 502, ErrorOccurred: true},
+	}
+	if err := store.LogRequests(ctx, reqLogs); err != nil {
+		t.Fatalf("LogRequests: %v", err)
+	}
+	// A retried batch is skipped rather than rejected
+	if err := store.LogRequests(ctx, reqLogs[:2]); err != nil {
+		t.Fatalf("LogRequests with duplicates: %v", err)
+	}
+
+	got, err := store.QueryRequests(ctx, database.RequestLogFilter{Limit: 10})
+	if err != nil {
+		t.Fatalf("QueryRequests: %v", err)
+	}
+	if len(got) != 3 || got[0].RequestID != "mysql-3" {
+		t.Fatalf("got %d request logs starting with %+v, want 3 newest first", len(got), got)
+	}
+	if !got[0].Timestamp.Equal(reqLogs[2].Timestamp) {
+		t.Errorf("got timestamp %v, want %v", got[0].Timestamp, reqLogs[2].Timestamp)
+	}
+	if len(got[1].PairOutcomes) != 1 || !got[1].PairOutcomes[0].CacheHit {
+		t.Errorf("got pair outcomes %+v, want the BTC/USD cache hit", got[1].PairOutcomes)
+	}
+
+	filtered, err := store.QueryRequests(ctx, database.RequestLogFilter{Pair: "BTC/EUR", Limit: 10})
+	if err != nil {
+		t.Fatalf("QueryRequests by pair: %v", err)
+	}
+	if len(filtered) != 1 || filtered[0].APIKeyID != "key-a" {
+		t.Errorf("got %+v, want mysql-1 alone", filtered)
+	}
+
+	// The next page continues after a timestamp cursor
+	sort := database.DefaultRequestLogSort
+	next, err := store.QueryRequests(ctx, database.RequestLogFilter{
+		Limit: 10,
+		Sort:  sort,
+		After: &pagination.Cursor{Sort: sort.String(), Value: database.RequestLogSortValue(got[0], sort), ID: got[0].ID},
+	})
+	if err != nil {
+		t.Fatalf("QueryRequests after cursor: %v", err)
+	}
+	if len(next) != 2 || next[0].RequestID != "mysql-2" {
+		t.Errorf("got %+v after the cursor, want mysql-2 and mysql-1", next)
+	}
+
+	pairs, err := store.FrequentPairs(ctx, now.Add(-24*time.Hour), 2)
+	if err != nil {
+		t.Fatalf("FrequentPairs: %v", err)
+	}
+	if strings.Join(pairs, ",") != "BTC/USD,BTC/CHF" {
+		t.Errorf("got frequent pairs %v, want [BTC/USD BTC/CHF]", pairs)
+	}
+
+	result, err := store.PurgeRequests(ctx, database.PurgeRequest{
+		SubjectType: database.SubjectIPHash,
+		Subject:     strings.ToUpper(database.HashSubject("192.0.2.1")),
+		Mode:        database.PurgeModeSoft,
+	})
+	if err != nil {
+		t.Fatalf("PurgeRequests: %v", err)
+	}
+	if result.RowsAffected != 2 || result.AuditID == 0 {
+		t.Errorf("got purge result %+v, want 2 rows and an audit ID", result)
+	}
+
+	var archived []string
+	deleted, err := store.ArchiveRequestLogsBefore(ctx, now.Add(-90*time.Minute), 1, func(ctx context.Context, reqLogs []database.RequestLog) error {
+		for _, reqLog := range reqLogs {
+			archived = append(archived, reqLog.RequestID)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ArchiveRequestLogsBefore: %v", err)
+	}
+	// mysql-1 was purged, so it is deleted without being archived
+	if deleted != 2 || strings.Join(archived, ",") != "mysql-2" {
+		t.Errorf("got %d deleted and %v archived, want 2 deleted and mysql-2 archived", deleted, archived)
+	}
+}
+
+func TestMySQLStoreLongRequestID(t *testing.T) {
+	store, _ := newMySQLTestStore(t)
+
+	// The longest ID the middleware accepts from a caller
+	requestID := strings.Repeat("r", 128)
+	logRequestWithID(t, store, requestID)
+
+	got, err := store.QueryRequests(context.Background(), database.RequestLogFilter{Limit: 10})
+	if err != nil {
+		t.Fatalf("QueryRequests: %v", err)
+	}
+	if len(got) != 1 || got[0].RequestID != requestID {
+		t.Errorf("got request logs %+v, want one with the supplied request ID", got)
+	}
+}
+
+func TestMySQLStoreAlerts(t *testing.T) {
+	store, _ := newMySQLTestStore(t)
+	ctx := context.Background()
+
+	sub, err := store.CreateAlertSubscription(ctx, database.AlertSubscription{
+		Pair:        "BTC/USD",
+		Threshold:   50000,
+		Direction:   database.AlertAbove,
+		CallbackURL: "https://example.com/hook",
+		Secret:      "secret",
+	})
+	if err != nil {
+		t.Fatalf("CreateAlertSubscription: %v", err)
+	}
+	if sub.ID == 0 || sub.CreatedAt.IsZero() {
+		t.Fatalf("got subscription %+v, want an ID and creation time", sub)
+	}
+	if err := store.RecordAlertEvaluation(ctx, sub.ID, 51000, true); err != nil {
+		t.Fatalf("RecordAlertEvaluation: %v", err)
+	}
+	subs, err := store.ListAlertSubscriptions(ctx)
+	if err != nil {
+		t.Fatalf("ListAlertSubscriptions: %v", err)
+	}
+	if len(subs) != 1 || subs[0].LastPrice == nil || *subs[0].LastPrice != 51000 || subs[0].LastTriggeredAt == nil {
+		t.Fatalf("got subscriptions %+v, want the evaluated one", subs)
+	}
+
+	id, err := store.EnqueueAlertDelivery(ctx, sub.ID, []byte(`{"price":51000}`), 0)
+	if err != nil {
+		t.Fatalf("EnqueueAlertDelivery: %v", err)
+	}
+	due, err := store.ClaimAlertDeliveries(ctx, 10, time.Minute)
+	if err != nil {
+		t.Fatalf("ClaimAlertDeliveries: %v", err)
+	}
+	if len(due) != 1 || due[0].ID != id || due[0].CallbackURL != sub.CallbackURL {
+		t.Fatalf("got due deliveries %+v, want the queued one", due)
+	}
+	if claimed, err := store.ClaimAlertDeliveries(ctx, 10, time.Minute); err != nil || len(claimed) != 0 {
+		t.Fatalf("got deliveries %+v (%v) claimed twice, want none while the claim lasts", claimed, err)
+	}
+
+	if err := store.MarkAlertDeliveryFailed(ctx, id, "timeout", time.Hour); err != nil {
+		t.Fatalf("MarkAlertDeliveryFailed: %v", err)
+	}
+	if due, err := store.ClaimAlertDeliveries(ctx, 10, time.Minute); err != nil || len(due) != 0 {
+		t.Errorf("got due deliveries %+v (%v), want none while the retry waits", due, err)
+	}
+	attempts, err := store.CountAlertDeliveryAttempts(ctx, sub.ID, time.Minute)
+	if err != nil || attempts != 1 {
+		t.Errorf("got %d attempts (%v), want 1", attempts, err)
+	}
+
+	if deleted, err := store.DeleteAlertSubscription(ctx, "key:other", sub.ID); err != nil || deleted {
+		t.Fatalf("got %v, %v deleting another owner's subscription, want it kept", deleted, err)
+	}
+	deleted, err := store.DeleteAlertSubscription(ctx, "", sub.ID)
+	if err != nil || !deleted {
+		t.Fatalf("DeleteAlertSubscription: %v, %v", deleted, err)
+	}
+	history, err := store.ListAlertDeliveries(ctx, "", sub.ID, 10)
+	if err != nil || len(history) != 0 {
+		t.Errorf("got deliveries %+v (%v), want them deleted with the subscription", history, err)
+	}
+}
+
+func TestMySQLStoreAPIKeyUsage(t *testing.T) {
+	store, db := newMySQLTestStore(t)
+	ctx := context.Background()
+
+	day := time.Date(2026, 10, 16, 13, 45, 0, 0, time.UTC)
+	for i := 0; i < 2; i++ {
+		if err := store.RecordAPIKeyUsage(ctx, []database.APIKeyUsage{{APIKeyID: "acme", Day: day, Requests: 5, RateLimited: 1}}); err != nil {
+			t.Fatalf("RecordAPIKeyUsage: %v", err)
+		}
+	}
+
+	var requests, rateLimited int64
+	err := db.QueryRow("SELECT requests, rate_limited FROM api_key_usage WHERE api_key_id = 'acme' AND day = '2026-10-16'").Scan(&requests, &rateLimited)
+	if err != nil {
+		t.Fatalf("Failed to read usage: %v", err)
+	}
+	if requests != 10 || rateLimited != 2 {
+		t.Errorf("got %d requests and %d limited, want the counts added up to 10 and 2", requests, rateLimited)
+	}
+}
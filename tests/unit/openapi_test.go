@@ -0,0 +1,97 @@
+package unit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/chesskiss/btc-service/internal/openapi"
+)
+
+func TestOpenAPIHandler(t *testing.T) {
+	req := httptest.NewRequest("GET", "/openapi.json", nil)
+	w := httptest.NewRecorder()
+
+	openapi.Handler(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusOK)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("got Content-Type %q, want %q", ct, "application/json")
+	}
+
+	var doc map[string]interface{}
+	if err := json.NewDecoder(w.Body).Decode(&doc); err != nil {
+		t.Fatalf("JSON decode failed: %v", err)
+	}
+	if doc["openapi"] != "3.0.3" {
+		t.Errorf("got openapi version %v, want 3.0.3", doc["openapi"])
+	}
+}
+
+func TestOpenAPISpecPaths(t *testing.T) {
+	doc := openapi.Spec()
+
+	for _, path := range []string{"/api/v1/ltp", "/health", "/ready", "/version", "/api/v1/admin/requests"} {
+		item, ok := doc.Paths[path]
+		if !ok {
+			t.Errorf("expected path %s in spec", path)
+			continue
+		}
+		if item.Get == nil {
+			t.Errorf("expected GET operation for %s", path)
+		}
+	}
+}
+
+func TestOpenAPISpecSchemasFromTypes(t *testing.T) {
+	doc := openapi.Spec()
+
+	pairPrice, ok := doc.Components.Schemas["PairPrice"]
+	if !ok {
+		t.Fatal("expected PairPrice component schema")
+	}
+
+	if pairPrice.Properties["pair"] == nil || pairPrice.Properties["pair"].Type != "string" {
+		t.Errorf("expected string property pair, got %+v", pairPrice.Properties["pair"])
+	}
+	if pairPrice.Properties["amount"] == nil || pairPrice.Properties["amount"].Type != "number" {
+		t.Errorf("expected number property amount, got %+v", pairPrice.Properties["amount"])
+	}
+
+	ltp := doc.Components.Schemas["LTPResponse"]
+	if ltp == nil || ltp.Properties["ltp"] == nil || ltp.Properties["ltp"].Items == nil {
+		t.Fatal("expected LTPResponse.ltp to be an array")
+	}
+	if ref := ltp.Properties["ltp"].Items.Ref; ref != "#/components/schemas/PairPrice" {
+		t.Errorf("got items ref %q, want PairPrice reference", ref)
+	}
+
+	reqLog := doc.Components.Schemas["RequestLog"]
+	if reqLog == nil || reqLog.Properties["timestamp"] == nil || reqLog.Properties["timestamp"].Format != "date-time" {
+		t.Error("expected RequestLog.timestamp to be a date-time string")
+	}
+}
+
+func TestDocsHandler(t *testing.T) {
+	req := httptest.NewRequest("GET", "/docs", nil)
+	w := httptest.NewRecorder()
+
+	openapi.DocsHandler(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusOK)
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Errorf("got Content-Type %q, want text/html", ct)
+	}
+	if !strings.Contains(w.Body.String(), "/openapi.json") {
+		t.Error("expected docs page to load /openapi.json")
+	}
+	if strings.Contains(w.Body.String(), "https://") {
+		t.Error("expected docs page to load Swagger UI from /docs/assets/, not a CDN")
+	}
+}
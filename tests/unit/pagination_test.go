@@ -0,0 +1,95 @@
+package unit
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/chesskiss/btc-service/internal/pagination"
+)
+
+var testSorts = pagination.Sorts{"id": pagination.Int64Value, "timestamp": pagination.TimeValue}
+
+func TestPaginationParseDefaults(t *testing.T) {
+	req := httptest.NewRequest("GET", "/items", nil)
+
+	page, err := pagination.Parse(req, testSorts, pagination.Sort{Field: "timestamp", Desc: true})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if page.Limit != pagination.DefaultLimit || page.Sort.String() != "-timestamp" || page.After != nil {
+		t.Errorf("got %+v, want default limit, -timestamp and no cursor", page)
+	}
+}
+
+func TestPaginationParseInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+	}{
+		{name: "Zero limit", query: "limit=0"},
+		{name: "Limit too large", query: "limit=501"},
+		{name: "Unknown sort", query: "sort=-price"},
+		{name: "Malformed cursor", query: "cursor=not-a-cursor"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/items?"+tt.query, nil)
+			if _, err := pagination.Parse(req, testSorts, pagination.Sort{Field: "id"}); err == nil {
+				t.Errorf("expected error for %s", tt.query)
+			}
+		})
+	}
+}
+
+func TestPaginationCursorRoundTrip(t *testing.T) {
+	page := pagination.Page{Limit: 2, Sort: pagination.Sort{Field: "id", Desc: true}}
+
+	if next := page.NextCursor(1, nil); next != "" {
+		t.Errorf("got cursor %q for a short page, want none", next)
+	}
+
+	next := page.NextCursor(2, func() (string, int64) { return "42", 42 })
+	if next == "" {
+		t.Fatal("expected a cursor for a full page")
+	}
+
+	req := httptest.NewRequest("GET", "/items?sort=-id&cursor="+next, nil)
+	parsed, err := pagination.Parse(req, testSorts, pagination.Sort{Field: "id"})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if parsed.After == nil || parsed.After.Value != "42" || parsed.After.ID != 42 {
+		t.Errorf("got cursor %+v, want value 42 and ID 42", parsed.After)
+	}
+
+	// A cursor only continues the sort it was issued for
+	req = httptest.NewRequest("GET", "/items?sort=timestamp&cursor="+next, nil)
+	if _, err := pagination.Parse(req, testSorts, pagination.Sort{Field: "id"}); err == nil {
+		t.Error("expected error for a cursor used with another sort")
+	}
+}
+
+func TestPaginationCursorValueChecked(t *testing.T) {
+	tests := []struct {
+		name  string
+		sort  pagination.Sort
+		value string
+	}{
+		{name: "Non-numeric ID", sort: pagination.Sort{Field: "id"}, value: "1 OR 1=1"},
+		{name: "Fractional ID", sort: pagination.Sort{Field: "id"}, value: "4.2"},
+		{name: "Malformed timestamp", sort: pagination.Sort{Field: "timestamp", Desc: true}, value: "yesterday"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page := pagination.Page{Limit: 1, Sort: tt.sort}
+			cursor := page.NextCursor(1, func() (string, int64) { return tt.value, 1 })
+			req := httptest.NewRequest("GET", "/items?sort="+tt.sort.String()+"&cursor="+cursor, nil)
+
+			if _, err := pagination.Parse(req, testSorts, pagination.Sort{Field: "id"}); err == nil || err.Error() != "invalid cursor" {
+				t.Errorf("got error %v, want invalid cursor", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package unit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/chesskiss/btc-service/internal/handlers"
+	"github.com/chesskiss/btc-service/internal/problem"
+)
+
+func TestPortfolioValueHandler(t *testing.T) {
+	setupFakeKraken(t, map[string]string{"USD": "65000.125", "EUR": "52000.1"})
+
+	tests := []struct {
+		name      string
+		body      string
+		wantTotal string
+		wantRates []string
+	}{
+		{
+			name:      "BTC and fiat in USD",
+			body:      `{"currency":"usd","holdings":[{"asset":"BTC","amount":"1.5"},{"asset":"eur","amount":2000}]}`,
+			wantTotal: "100000.1875",
+			wantRates: []string{"65000.125", "1.25"},
+		},
+		{
+			name:      "Same currency",
+			body:      `{"currency":"USD","holdings":[{"asset":"USD","amount":"10.5"}]}`,
+			wantTotal: "10.5",
+			wantRates: []string{"1"},
+		},
+		{
+			name:      "In BTC",
+			body:      `{"currency":"BTC","holdings":[{"asset":"EUR","amount":"104000.2"}]}`,
+			wantTotal: "2",
+			wantRates: []string{"0.0000192307322486"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/api/v1/portfolio/value", strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+
+			handlers.PortfolioValueHandler(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+			}
+
+			var resp handlers.PortfolioValueResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("JSON decode failed: %v", err)
+			}
+			if resp.Total.String() != tt.wantTotal {
+				t.Errorf("got total %s, want %s", resp.Total, tt.wantTotal)
+			}
+			if len(resp.Holdings) != len(tt.wantRates) {
+				t.Fatalf("got %d holdings, want %d", len(resp.Holdings), len(tt.wantRates))
+			}
+			for i, want := range tt.wantRates {
+				if got := resp.Holdings[i].Rate.String(); got != want {
+					t.Errorf("holding %d: got rate %s, want %s", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestPortfolioValueHandlerInvalid(t *testing.T) {
+	setupFakeKraken(t, map[string]string{"USD": "65000.125"})
+
+	tests := []struct {
+		name     string
+		body     string
+		want     int
+		wantCode string
+	}{
+		{name: "No holdings", body: `{"currency":"USD","holdings":[]}`, want: http.StatusBadRequest, wantCode: problem.CodeInvalidRequestBody},
+		{name: "Missing currency", body: `{"holdings":[{"asset":"BTC","amount":1}]}`, want: http.StatusBadRequest, wantCode: problem.CodeInvalidRequestBody},
+		{name: "Negative amount", body: `{"currency":"USD","holdings":[{"asset":"BTC","amount":-1}]}`, want: http.StatusBadRequest, wantCode: problem.CodeInvalidRequestBody},
+		{name: "Malformed asset", body: `{"currency":"USD","holdings":[{"asset":"BTC/USD","amount":1}]}`, want: http.StatusBadRequest, wantCode: problem.CodeInvalidRequestBody},
+		{name: "Unsupported asset", body: `{"currency":"USD","holdings":[{"asset":"XYZ","amount":1}]}`, want: http.StatusBadRequest, wantCode: problem.CodeInvalidPair},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/api/v1/portfolio/value", strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+
+			handlers.PortfolioValueHandler(w, req)
+
+			if w.Code != tt.want {
+				t.Errorf("got status %d, want %d", w.Code, tt.want)
+			}
+			if !strings.Contains(w.Body.String(), `"code":"`+tt.wantCode+`"`) {
+				t.Errorf("expected code %s in body %s", tt.wantCode, w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package unit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/chesskiss/btc-service/clients"
+	"github.com/chesskiss/btc-service/handlers"
+	"github.com/chesskiss/btc-service/internal/middleware"
+	"github.com/chesskiss/btc-service/internal/problem"
+	"github.com/chesskiss/btc-service/services"
+	"github.com/gorilla/mux"
+)
+
+func serveProblem(t *testing.T, url string) (*httptest.ResponseRecorder, problem.Details) {
+	r := mux.NewRouter()
+	r.HandleFunc("/api/v1/ltp", handlers.LTPHandler(nil)).Methods("GET")
+	handler := middleware.LoggingMiddleware(r)
+
+	req := httptest.NewRequest("GET", url, nil)
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, req)
+
+	if got := w.Header().Get("Content-Type"); got != problem.ContentType {
+		t.Errorf("got Content-Type %q, want %q", got, problem.ContentType)
+	}
+
+	var body problem.Details
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("JSON decode failed: %v", err)
+	}
+	if body.Status != w.Code {
+		t.Errorf("got status %d in body, want %d", body.Status, w.Code)
+	}
+	if body.RequestID == "" {
+		t.Error("expected request_id in problem details")
+	}
+	if body.Instance != "/api/v1/ltp" {
+		t.Errorf("got instance %q, want /api/v1/ltp", body.Instance)
+	}
+
+	return w, body
+}
+
+func TestLTPHandlerInvalidPairProblem(t *testing.T) {
+	setupFakeKraken(t, map[string]string{})
+
+	w, body := serveProblem(t, "/api/v1/ltp?pairs=ETH/USD,BTC/XYZ")
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if body.Code != problem.CodeInvalidPair {
+		t.Errorf("got code %q, want %q", body.Code, problem.CodeInvalidPair)
+	}
+	if want := []string{"ETH/USD", "BTC/XYZ"}; !reflect.DeepEqual(body.FailedPairs, want) {
+		t.Errorf("got failed_pairs %v, want %v", body.FailedPairs, want)
+	}
+}
+
+func TestLTPHandlerUpstreamUnavailableProblem(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"error":["EService:Unavailable"],"result":{}}`)
+	}))
+	defer server.Close()
+
+	usePriceService(t, nil, clients.PriceServiceOptions{KrakenBaseURL: server.URL})
+
+	w, body := serveProblem(t, "/api/v1/ltp?pairs=BTC/NZD")
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+	if body.Code != problem.CodeUpstreamUnavailable {
+		t.Errorf("got code %q, want %q", body.Code, problem.CodeUpstreamUnavailable)
+	}
+	if want := []string{"BTC/NZD"}; !reflect.DeepEqual(body.FailedPairs, want) {
+		t.Errorf("got failed_pairs %v, want %v", body.FailedPairs, want)
+	}
+}
+
+func TestLTPHandlerUpstreamRateLimitedProblem(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	usePriceService(t, nil, clients.PriceServiceOptions{KrakenBaseURL: server.URL})
+
+	w, body := serveProblem(t, "/api/v1/ltp?pairs=BTC/NZD")
+
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+	if body.Code != problem.CodeUpstreamRateLimited {
+		t.Errorf("got code %q, want %q", body.Code, problem.CodeUpstreamRateLimited)
+	}
+}
+
+func TestPriceErrorReasons(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		want    string
+	}{
+		{"rate limited", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusTooManyRequests)
+		}, services.ReasonUpstreamRateLimited},
+		{"timeout", func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(200 * time.Millisecond)
+		}, services.ReasonUpstreamTimeout},
+		{"malformed", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"error":[],"result":{"XXBTZUSD":{"c":["n/a","1"]}}}`)
+		}, services.ReasonUpstreamInvalidResponse},
+		{"html", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, "<html></html>")
+		}, services.ReasonUpstreamInvalidResponse},
+		{"server error", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}, services.ReasonUpstreamUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(tt.handler)
+			defer server.Close()
+			usePriceService(t, nil, clients.PriceServiceOptions{
+				KrakenBaseURL: server.URL,
+				HTTPClient:    clients.NewHTTPClient(clients.HTTPClientOptions{ResponseHeaderTimeout: 50 * time.Millisecond}),
+			})
+
+			result := services.GetPrices(context.Background(), "BTC/USD")
+			if len(result.Errors) != 1 || result.Errors[0].Reason != tt.want {
+				t.Errorf("got errors %+v, want reason %s", result.Errors, tt.want)
+			}
+		})
+	}
+}
+
+func TestLTPHandlerUnsupportedFormatProblem(t *testing.T) {
+	w, body := serveProblem(t, "/api/v1/ltp?format=xml")
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if body.Code != problem.CodeUnsupportedFormat {
+		t.Errorf("got code %q, want %q", body.Code, problem.CodeUnsupportedFormat)
+	}
+}
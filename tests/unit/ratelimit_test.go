@@ -0,0 +1,210 @@
+package unit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chesskiss/btc-service/internal/database"
+	"github.com/chesskiss/btc-service/internal/middleware"
+	"github.com/chesskiss/btc-service/internal/problem"
+	"github.com/chesskiss/btc-service/internal/ratelimit"
+)
+
+// fakeUsage records what the rate limit middleware counts
+type fakeUsage struct {
+	requests map[string]int
+	limited  map[string]int
+}
+
+func (u *fakeUsage) Record(apiKeyID string, limited bool) {
+	u.requests[apiKeyID]++
+	if limited {
+		u.limited[apiKeyID]++
+	}
+}
+
+// failingLimiter is a limiter whose Redis is down
+type failingLimiter struct{}
+
+func (failingLimiter) Allow(ctx context.Context, key string) (ratelimit.Decision, error) {
+	return ratelimit.Decision{}, errors.New("connection refused")
+}
+
+// fakeUsageStore records flushed usage, or fails with err when set
+type fakeUsageStore struct {
+	mu    sync.Mutex
+	usage []database.APIKeyUsage
+	err   error
+}
+
+func (s *fakeUsageStore) RecordAPIKeyUsage(ctx context.Context, usage []database.APIKeyUsage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	s.usage = append(s.usage, usage...)
+	return nil
+}
+
+func TestRateLimit(t *testing.T) {
+	usage := &fakeUsage{requests: map[string]int{}, limited: map[string]int{}}
+	limiter := ratelimit.NewLocal(1, 2)
+	handler := middleware.RateLimit(limiter, usage, problem.Reject)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	send := func(path, apiKeyID, ip string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.RemoteAddr = ip + ":1234"
+		if apiKeyID != "" {
+			req = req.WithContext(middleware.WithAPIKeyID(req.Context(), apiKeyID))
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		w := send("/api/v1/ltp", "acme", "192.0.2.1")
+		if w.Code != want {
+			t.Fatalf("request %d: got status %d, want %d", i+1, w.Code, want)
+		}
+		if w.Header().Get("X-RateLimit-Limit") != "2" {
+			t.Errorf("request %d: got X-RateLimit-Limit %q, want 2", i+1, w.Header().Get("X-RateLimit-Limit"))
+		}
+		if want == http.StatusTooManyRequests {
+			if w.Header().Get("Retry-After") == "" || !strings.Contains(w.Body.String(), `"code":"`+problem.CodeRateLimited+`"`) {
+				t.Errorf("got headers %v and body %s, want Retry-After and code rate_limited", w.Header(), w.Body.String())
+			}
+		}
+	}
+	if usage.requests["acme"] != 3 || usage.limited["acme"] != 1 {
+		t.Errorf("got %d requests and %d limited for acme, want 3 and 1", usage.requests["acme"], usage.limited["acme"])
+	}
+
+	// Other keys, and callers without one, have their own buckets
+	if w := send("/api/v1/ltp", "globex", "192.0.2.1"); w.Code != http.StatusOK {
+		t.Errorf("got status %d for another key, want 200", w.Code)
+	}
+	if w := send("/api/v1/ltp", "", "192.0.2.1"); w.Code != http.StatusOK {
+		t.Errorf("got status %d for an IP, want 200", w.Code)
+	}
+	if len(usage.requests) != 2 {
+		t.Errorf("got usage for %v, want acme and globex only", usage.requests)
+	}
+
+	// Endpoints outside /api/ are not limited
+	for i := 0; i < 3; i++ {
+		if w := send("/health", "acme", "192.0.2.1"); w.Code != http.StatusOK || w.Header().Get("X-RateLimit-Limit") != "" {
+			t.Fatalf("got status %d with headers %v for /health, want 200 without rate limit headers", w.Code, w.Header())
+		}
+	}
+}
+
+func TestRateLimitFailsOpen(t *testing.T) {
+	handler := middleware.RateLimit(failingLimiter{}, nil, problem.Reject)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/ltp", nil))
+
+	if w.Code != http.StatusOK {
+		t.Errorf("got status %d while the limiter fails, want 200", w.Code)
+	}
+}
+
+func TestUsageKeepsCountsUntilWritten(t *testing.T) {
+	store := &fakeUsageStore{err: errStoreUnavailable}
+	usage := ratelimit.StartUsage(store, time.Hour)
+
+	usage.Record("acme", false)
+	usage.Record("acme", true)
+	if err := usage.Flush(context.Background()); err == nil {
+		t.Fatal("Expected the flush to fail while the store is down")
+	}
+	usage.Record("acme", false)
+
+	store.err = nil
+	if err := usage.Close(context.Background()); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if len(store.usage) != 1 || store.usage[0].Requests != 3 || store.usage[0].RateLimited != 1 {
+		t.Errorf("got usage %+v, want 3 requests for acme with 1 limited", store.usage)
+	}
+}
+
+func TestRedisLimiter(t *testing.T) {
+	client := setupTestRedis(t)
+	defer client.Close()
+	limiter := ratelimit.NewRedis(client, "test", 60, 2)
+	ctx := context.Background()
+
+	for i, want := range []bool{true, true, false} {
+		decision, err := limiter.Allow(ctx, "key:acme")
+		if err != nil {
+			t.Fatalf("Allow: %v", err)
+		}
+		if decision.Allowed != want {
+			t.Fatalf("request %d: got allowed %v, want %v", i+1, decision.Allowed, want)
+		}
+		if !want && (decision.RetryAfter <= 0 || decision.RetryAfter > time.Second) {
+			t.Errorf("got retry after %v, want up to a second", decision.RetryAfter)
+		}
+	}
+	if ttl := client.PTTL(ctx, "test:ratelimit:key:acme").Val(); ttl <= 0 || ttl > 2*time.Second {
+		t.Errorf("got bucket TTL %v, want it to expire once refilled", ttl)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	proxies, err := middleware.ParseTrustedProxies([]string{"10.0.0.0/8", "192.0.2.10"})
+	if err != nil {
+		t.Fatalf("ParseTrustedProxies failed: %v", err)
+	}
+	middleware.SetTrustedProxies(proxies)
+	t.Cleanup(func() { middleware.SetTrustedProxies(nil) })
+
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor []string
+		realIP       string
+		want         string
+	}{
+		{name: "Direct connection", remoteAddr: "203.0.113.5:4000", want: "203.0.113.5"},
+		{name: "Forged header from an untrusted peer", remoteAddr: "203.0.113.5:4000", forwardedFor: []string{"198.51.100.1"}, want: "203.0.113.5"},
+		{name: "One trusted proxy", remoteAddr: "10.0.0.2:4000", forwardedFor: []string{"198.51.100.1"}, want: "198.51.100.1"},
+		{name: "Spoofed leftmost hop", remoteAddr: "10.0.0.2:4000", forwardedFor: []string{"1.2.3.4, 198.51.100.1"}, want: "198.51.100.1"},
+		{name: "Chain of trusted proxies", remoteAddr: "10.0.0.2:4000", forwardedFor: []string{"198.51.100.1, 192.0.2.10, 10.1.2.3"}, want: "198.51.100.1"},
+		{name: "Repeated headers", remoteAddr: "10.0.0.2:4000", forwardedFor: []string{"1.2.3.4", "198.51.100.1, 10.1.2.3"}, want: "198.51.100.1"},
+		{name: "Only trusted hops", remoteAddr: "10.0.0.2:4000", forwardedFor: []string{"10.9.9.9, 10.1.2.3"}, want: "10.9.9.9"},
+		{name: "X-Real-IP from a trusted proxy", remoteAddr: "10.0.0.2:4000", realIP: "198.51.100.7", want: "198.51.100.7"},
+		{name: "X-Real-IP from an untrusted peer", remoteAddr: "203.0.113.5:4000", realIP: "198.51.100.7", want: "203.0.113.5"},
+		{name: "IPv6 connection", remoteAddr: "[2001:db8::1]:4000", want: "2001:db8::1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/v1/ltp", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for _, header := range tt.forwardedFor {
+				req.Header.Add("X-Forwarded-For", header)
+			}
+			if tt.realIP != "" {
+				req.Header.Set("X-Real-IP", tt.realIP)
+			}
+
+			if got := middleware.ClientIP(req); got != tt.want {
+				t.Errorf("ClientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
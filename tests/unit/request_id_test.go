@@ -0,0 +1,139 @@
+package unit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/chesskiss/btc-service/internal/middleware"
+)
+
+func TestRequestIDHeader(t *testing.T) {
+	var seen string
+	handler := middleware.LoggingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = middleware.GetRequestID(r.Context())
+	}))
+
+	tests := []struct {
+		name     string
+		supplied string
+		wantSame bool
+	}{
+		{name: "Generated", supplied: "", wantSame: false},
+		{name: "Supplied", supplied: "support-ticket-1234", wantSame: true},
+		{name: "Supplied with spaces", supplied: "bad id", wantSame: false},
+		{name: "Supplied too long", supplied: strings.Repeat("x", 129), wantSame: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/v1/ltp", nil)
+			if tt.supplied != "" {
+				req.Header.Set(middleware.RequestIDHeader, tt.supplied)
+			}
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, req)
+
+			got := w.Header().Get(middleware.RequestIDHeader)
+			if got == "" || got != seen {
+				t.Fatalf("header %q does not match context request ID %q", got, seen)
+			}
+			if (got == tt.supplied) != tt.wantSame {
+				t.Errorf("got request ID %q for supplied %q", got, tt.supplied)
+			}
+		})
+	}
+}
+
+func TestTenantIDHeader(t *testing.T) {
+	proxies, err := middleware.ParseTrustedProxies([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatalf("ParseTrustedProxies failed: %v", err)
+	}
+	middleware.SetTrustedProxies(proxies)
+	t.Cleanup(func() { middleware.SetTrustedProxies(nil) })
+
+	var seen string
+	handler := middleware.LoggingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = middleware.GetTenantID(r.Context())
+	}))
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		supplied   string
+		want       string
+	}{
+		{name: "Absent", supplied: "", want: ""},
+		{name: "Supplied", supplied: "tenant-42", want: "tenant-42"},
+		{name: "Longest", supplied: strings.Repeat("t", 64), want: strings.Repeat("t", 64)},
+		{name: "Too long", supplied: strings.Repeat("t", 65), want: ""},
+		{name: "With spaces", supplied: "tenant 42", want: ""},
+		{name: "Non-ASCII", supplied: "ténant", want: ""},
+		{name: "Untrusted client", remoteAddr: "203.0.113.7:4321", supplied: "tenant-42", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seen = "unset"
+			req := httptest.NewRequest("GET", "/api/v1/ltp", nil)
+			req.RemoteAddr = "10.0.0.5:4321"
+			if tt.remoteAddr != "" {
+				req.RemoteAddr = tt.remoteAddr
+			}
+			if tt.supplied != "" {
+				req.Header.Set(middleware.TenantIDHeader, tt.supplied)
+			}
+
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			if seen != tt.want {
+				t.Errorf("got tenant ID %q for supplied %q, want %q", seen, tt.supplied, tt.want)
+			}
+		})
+	}
+}
+
+func TestTraceparentContinued(t *testing.T) {
+	var seen trace.SpanContext
+	handler := middleware.LoggingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, span := otel.Tracer("test").Start(r.Context(), "handle")
+		defer span.End()
+		seen = span.SpanContext()
+	}))
+
+	tests := []struct {
+		name        string
+		traceparent string
+		wantTraceID string
+	}{
+		{name: "Supplied", traceparent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", wantTraceID: "4bf92f3577b34da6a3ce929d0e0e4736"},
+		{name: "Malformed", traceparent: "00-not-a-trace-01"},
+		{name: "Absent"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seen = trace.SpanContext{}
+			req := httptest.NewRequest("GET", "/api/v1/ltp", nil)
+			if tt.traceparent != "" {
+				req.Header.Set("traceparent", tt.traceparent)
+			}
+
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			got := ""
+			if seen.HasTraceID() {
+				got = seen.TraceID().String()
+			}
+			if got != tt.wantTraceID {
+				t.Errorf("got trace ID %q, want %q", got, tt.wantTraceID)
+			}
+		})
+	}
+}
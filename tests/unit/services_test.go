@@ -2,8 +2,14 @@ package unit
 
 import (
 	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
 	"testing"
+	"time"
 
+	"github.com/chesskiss/btc-service/clients"
+	"github.com/chesskiss/btc-service/internal/cache"
 	"github.com/chesskiss/btc-service/services"
 )
 
@@ -46,3 +52,108 @@ func TestPairPriceStruct(t *testing.T) {
 		t.Errorf("got %f, want 50000.0", p.Amount)
 	}
 }
+
+func TestNormalizePair(t *testing.T) {
+	tests := []struct {
+		pair   string
+		want   string
+		wantOK bool
+	}{
+		{pair: "BTC/USD", want: "BTC/USD", wantOK: true},
+		{pair: "XBT/USD", want: "BTC/USD", wantOK: true},
+		{pair: "btc-usd", want: "BTC/USD", wantOK: true},
+		{pair: "BTCUSD", want: "BTC/USD", wantOK: true},
+		{pair: "xbteur", want: "BTC/EUR", wantOK: true},
+		{pair: " btc_chf ", want: "BTC/CHF", wantOK: true},
+		{pair: "ETH/USD", wantOK: false},
+		{pair: "ETHUSD", wantOK: false},
+		{pair: "BTC/", wantOK: false},
+		{pair: "BTC", wantOK: false},
+		{pair: "BTC/U5D", wantOK: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pair, func(t *testing.T) {
+			got, ok := services.NormalizePair(tt.pair)
+			if ok != tt.wantOK || got != tt.want {
+				t.Errorf("NormalizePair(%q) = %q, %v; want %q, %v", tt.pair, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestGetPricesPairAliases(t *testing.T) {
+	setupFakeKraken(t, map[string]string{"USD": "65000.1", "EUR": "60000.2"})
+
+	result := services.GetPrices(context.Background(), "XBT/USD,btc-eur")
+
+	if len(result.Prices) != 2 || len(result.InvalidPairs) != 0 {
+		t.Fatalf("got prices %+v, invalid %v", result.Prices, result.InvalidPairs)
+	}
+	if result.Prices[0].Pair != "BTC/USD" || result.Prices[1].Pair != "BTC/EUR" {
+		t.Errorf("expected canonical pair names, got %s and %s", result.Prices[0].Pair, result.Prices[1].Pair)
+	}
+}
+
+func TestWarmCache(t *testing.T) {
+	server := setupFakeKraken(t, map[string]string{"USD": "65000.1", "EUR": "60000.2", "CHF": "58000.3", "JPY": "9500000"})
+	usePriceService(t, cache.NewMemory(100), clients.PriceServiceOptions{KrakenBaseURL: server.URL})
+
+	// Duplicates of the defaults and unparseable pairs are skipped
+	warmed := services.WarmCache(context.Background(), []string{"btc-jpy", "XBT/USD", "not-a-pair"})
+	if warmed != 4 {
+		t.Errorf("got %d pairs warmed, want 4", warmed)
+	}
+
+	quote, err := clients.GetBTCQuote(context.Background(), "JPY")
+	if err != nil || !quote.Cached {
+		t.Errorf("got %+v, %v, want a cached quote", quote, err)
+	}
+}
+
+func TestGetPricesReportsCachedPairs(t *testing.T) {
+	server := setupFakeKraken(t, map[string]string{"USD": "65000.1", "EUR": "60000.2"})
+	usePriceService(t, cache.NewMemory(100), clients.PriceServiceOptions{KrakenBaseURL: server.URL})
+
+	if _, err := clients.GetBTCQuote(context.Background(), "EUR"); err != nil {
+		t.Fatalf("failed to prime the cache: %v", err)
+	}
+
+	result := services.GetPrices(context.Background(), "BTC/USD,BTC/EUR")
+	if len(result.CachedPairs) != 1 || result.CachedPairs[0] != "BTC/EUR" {
+		t.Errorf("got cached pairs %v, want only BTC/EUR", result.CachedPairs)
+	}
+}
+
+func TestGetPricesReturnsWhatCompletesWithinBudget(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("pair") == "XBTEUR" {
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"error":[],"result":{"XXBTZUSD":{"c":["65000.1","1"]}}}`))
+	}))
+	t.Cleanup(server.Close)
+	t.Cleanup(func() { close(release) })
+	usePriceService(t, nil, clients.PriceServiceOptions{KrakenBaseURL: server.URL, MaxConcurrentFetches: 2})
+
+	services.SetUpstreamBudget(100 * time.Millisecond)
+	t.Cleanup(func() { services.SetUpstreamBudget(0) })
+
+	start := time.Now()
+	result := services.GetPrices(context.Background(), "BTC/USD,BTC/EUR")
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("took %v, want about the 100ms budget", elapsed)
+	}
+	if len(result.Prices) != 1 || result.Prices[0].Pair != "BTC/USD" {
+		t.Errorf("got prices %+v, want BTC/USD only", result.Prices)
+	}
+	want := []services.PairError{{Pair: "BTC/EUR", Reason: services.ReasonDeadlineExceeded}}
+	if !reflect.DeepEqual(result.Errors, want) || !result.BudgetExceeded {
+		t.Errorf("got errors %+v, budget exceeded %v, want %+v", result.Errors, result.BudgetExceeded, want)
+	}
+}
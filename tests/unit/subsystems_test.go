@@ -0,0 +1,73 @@
+package unit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	internalHandlers "github.com/chesskiss/btc-service/internal/handlers"
+	"github.com/chesskiss/btc-service/internal/subsystems"
+)
+
+func subsystemsRouter() *mux.Router {
+	r := mux.NewRouter()
+	r.HandleFunc("/api/v1/admin/subsystems", internalHandlers.SubsystemsHandler).Methods("GET")
+	r.HandleFunc("/api/v1/admin/subsystems/{name}/pause", internalHandlers.PauseSubsystemHandler).Methods("POST")
+	r.HandleFunc("/api/v1/admin/subsystems/{name}/resume", internalHandlers.ResumeSubsystemHandler).Methods("POST")
+	return r
+}
+
+func TestPauseAndResumeSubsystem(t *testing.T) {
+	r := subsystemsRouter()
+
+	pausedStates := func(path string) map[string]bool {
+		method := "POST"
+		if path == "/api/v1/admin/subsystems" {
+			method = "GET"
+		}
+		req := httptest.NewRequest(method, path, nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s %s: got status %d, want %d", method, path, w.Code, http.StatusOK)
+		}
+		var resp internalHandlers.SubsystemsResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("JSON decode failed: %v", err)
+		}
+		states := map[string]bool{}
+		for _, state := range resp.Subsystems {
+			states[state.Name] = state.Paused
+		}
+		return states
+	}
+
+	if states := pausedStates("/api/v1/admin/subsystems"); len(states) != len(subsystems.Names) {
+		t.Errorf("got %d subsystems, want %d", len(states), len(subsystems.Names))
+	}
+
+	states := pausedStates("/api/v1/admin/subsystems/alert_delivery/pause")
+	if !states[subsystems.AlertDelivery] || states[subsystems.AlertEvaluator] {
+		t.Errorf("got %v, want only alert_delivery paused", states)
+	}
+
+	states = pausedStates("/api/v1/admin/subsystems/alert_delivery/resume")
+	if states[subsystems.AlertDelivery] {
+		t.Errorf("got %v, want alert_delivery resumed", states)
+	}
+}
+
+func TestPauseUnknownSubsystem(t *testing.T) {
+	req := httptest.NewRequest("POST", "/api/v1/admin/subsystems/poller/pause", nil)
+	w := httptest.NewRecorder()
+
+	subsystemsRouter().ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
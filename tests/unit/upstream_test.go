@@ -0,0 +1,422 @@
+package unit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/chesskiss/btc-service/clients"
+	"github.com/chesskiss/btc-service/internal/breaker"
+	"github.com/chesskiss/btc-service/internal/cache"
+	"github.com/chesskiss/btc-service/internal/middleware"
+	"github.com/chesskiss/btc-service/internal/retry"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestGetBTCQuoteTimesOutOnHungKraken(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	t.Cleanup(server.Close)
+	t.Cleanup(func() { close(release) })
+
+	service := clients.NewPriceService(nil, clients.PriceServiceOptions{
+		KrakenBaseURL: server.URL,
+		HTTPClient:    clients.NewHTTPClient(clients.HTTPClientOptions{ResponseHeaderTimeout: 50 * time.Millisecond}),
+	})
+
+	start := time.Now()
+	if _, err := service.GetBTCQuote(context.Background(), "USD"); err == nil {
+		t.Fatal("expected an error from a hung Kraken")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("took %v to give up, want about 50ms", elapsed)
+	}
+}
+
+func TestKrakenCallsAreTracedAsHTTPClientSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previousProvider, previousPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previousProvider)
+		otel.SetTextMapPropagator(previousPropagator)
+	})
+
+	var traceparent atomic.Value
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent.Store(r.Header.Get("traceparent"))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"error":[],"result":{"XXBTZUSD":{"c":["65000.1","1"]}}}`))
+	}))
+	t.Cleanup(server.Close)
+
+	service := clients.NewPriceService(nil, clients.PriceServiceOptions{KrakenBaseURL: server.URL})
+	if _, err := service.GetBTCQuote(context.Background(), "USD"); err != nil {
+		t.Fatalf("GetBTCQuote: %v", err)
+	}
+
+	var client, quote sdktrace.ReadOnlySpan
+	for _, span := range recorder.Ended() {
+		switch {
+		case span.SpanKind() == trace.SpanKindClient:
+			client = span
+		case span.Name() == "get_btc_price":
+			quote = span
+		}
+	}
+	if client == nil || quote == nil {
+		t.Fatalf("got spans %v, want an HTTP client span under get_btc_price", recorder.Ended())
+	}
+	if client.Parent().SpanID() != quote.SpanContext().SpanID() {
+		t.Errorf("HTTP client span is not a child of get_btc_price")
+	}
+	statusCode := ""
+	for _, attr := range client.Attributes() {
+		if attr.Key == "http.response.status_code" {
+			statusCode = attr.Value.Emit()
+		}
+	}
+	if statusCode != "200" {
+		t.Errorf("got status code attribute %q, want 200", statusCode)
+	}
+
+	// Kraken receives the trace in the W3C traceparent header
+	got, _ := traceparent.Load().(string)
+	if want := client.SpanContext().TraceID().String(); !strings.Contains(got, want) {
+		t.Errorf("got traceparent %q, want trace %s", got, want)
+	}
+}
+
+func TestGetBTCQuoteRejectsMalformedPrices(t *testing.T) {
+	var price atomic.Value
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"error":[],"result":{"XXBTZUSD":{"c":[%q,"1"]}}}`, price.Load())
+	}))
+	t.Cleanup(server.Close)
+	service := clients.NewPriceService(nil, clients.PriceServiceOptions{KrakenBaseURL: server.URL})
+
+	for _, value := range []string{"65000.1abc", "", "0", "-65000", "NaN", "Inf", "1e5", "0x1p16", "1.2.3", " 65000"} {
+		price.Store(value)
+		var parseErr *clients.PriceParseError
+		if _, err := service.GetBTCQuote(context.Background(), "USD"); !errors.As(err, &parseErr) || parseErr.Value != value {
+			t.Errorf("price %q: got %v, want a parse error", value, err)
+		}
+	}
+
+	price.Store("65000.10")
+	if quote, err := service.GetBTCQuote(context.Background(), "USD"); err != nil || quote.Price != 65000.1 || quote.Decimal != "65000.10" {
+		t.Errorf("got %+v, %v, want 65000.10", quote, err)
+	}
+}
+
+func TestKrakenSymbolMapping(t *testing.T) {
+	if got := clients.KrakenSymbolFor("USD"); got.Request != "XBTUSD" || got.Result != "XXBTZUSD" {
+		t.Errorf("KrakenSymbolFor(USD) = %+v", got)
+	}
+	if got := clients.KrakenSymbolFor("PLN"); got.Request != "XBTPLN" || got.Result != "XBTPLN" {
+		t.Errorf("KrakenSymbolFor(PLN) = %+v, want the XBT naming", got)
+	}
+
+	for name, want := range map[string]string{"XXBTZUSD": "BTC/USD", "XBTUSD": "BTC/USD", "XBTCHF": "BTC/CHF", "XXBTZSEK": "BTC/SEK", "XETHZUSD": ""} {
+		if got, ok := clients.CanonicalPair(name); got != want || ok != (want != "") {
+			t.Errorf("CanonicalPair(%s) = %q, %v, want %q", name, got, ok, want)
+		}
+	}
+}
+
+func TestGetBTCQuotePicksRequestedPairFromBatch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"error":[],"result":{"XXBTZEUR":{"c":["60000.5","1"]},"XBTCHF":{"c":["58000.2","1"]},"XXBTZUSD":{"c":["65000.1","1"]}}}`))
+	}))
+	t.Cleanup(server.Close)
+	service := clients.NewPriceService(nil, clients.PriceServiceOptions{KrakenBaseURL: server.URL})
+
+	for currency, want := range map[string]string{"USD": "65000.1", "EUR": "60000.5", "CHF": "58000.2"} {
+		if quote, err := service.GetBTCQuote(context.Background(), currency); err != nil || quote.Decimal != want {
+			t.Errorf("%s: got %+v, %v, want %s", currency, quote, err, want)
+		}
+	}
+	if _, err := service.GetBTCQuote(context.Background(), "GBP"); err == nil {
+		t.Error("expected an error for a pair missing from the response")
+	}
+}
+
+func TestGetBTCQuoteRejectsNonJSONResponses(t *testing.T) {
+	var status atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(int(status.Load()))
+		w.Write([]byte("<html><body>Bad Gateway</body></html>"))
+	}))
+	t.Cleanup(server.Close)
+	service := clients.NewPriceService(nil, clients.PriceServiceOptions{KrakenBaseURL: server.URL})
+
+	for _, code := range []int{http.StatusBadGateway, http.StatusForbidden} {
+		status.Store(int32(code))
+		var statusErr *clients.StatusError
+		if _, err := service.GetBTCQuote(context.Background(), "USD"); !errors.As(err, &statusErr) || statusErr.StatusCode != code {
+			t.Errorf("got %v, want a %d status error", err, code)
+		}
+	}
+
+	status.Store(http.StatusOK)
+	var contentTypeErr *clients.ContentTypeError
+	if _, err := service.GetBTCQuote(context.Background(), "USD"); !errors.As(err, &contentTypeErr) || contentTypeErr.ContentType != "text/html" {
+		t.Errorf("got %v, want a content type error", err)
+	}
+}
+
+func TestGetBTCQuotesFetchesConcurrently(t *testing.T) {
+	var inFlight, maxInFlight atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			seen := maxInFlight.Load()
+			if n <= seen || maxInFlight.CompareAndSwap(seen, n) {
+				break
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+
+		currency := strings.TrimPrefix(r.URL.Query().Get("pair"), "XBT")
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"error":[],"result":{"XBT%s":{"c":["1000.5","1"]}}}`, currency)
+	}))
+	t.Cleanup(server.Close)
+
+	service := clients.NewPriceService(nil, clients.PriceServiceOptions{KrakenBaseURL: server.URL, MaxConcurrentFetches: 2})
+	currencies := []string{"AUD", "CHF", "USDT", "USDC", "PLN"}
+	quotes, errs := service.GetBTCQuotes(context.Background(), currencies)
+	for i, currency := range currencies {
+		if errs[i] != nil || quotes[i].Decimal != "1000.5" {
+			t.Errorf("%s: got %+v, %v", currency, quotes[i], errs[i])
+		}
+	}
+	if got := maxInFlight.Load(); got != 2 {
+		t.Errorf("got %d concurrent Kraken calls, want 2", got)
+	}
+}
+
+func TestGetBTCQuoteRejectsOversizedResponses(t *testing.T) {
+	var padding atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"error":[],"result":{"XXBTZUSD":{"c":["65000.1","1"]}},"pad":%q}`, strings.Repeat("x", int(padding.Load())))
+	}))
+	t.Cleanup(server.Close)
+	service := clients.NewPriceService(nil, clients.PriceServiceOptions{
+		KrakenBaseURL: server.URL,
+		HTTPClient:    clients.NewHTTPClient(clients.HTTPClientOptions{MaxResponseBytes: 1024}),
+	})
+
+	padding.Store(512)
+	if _, err := service.GetBTCQuote(context.Background(), "USD"); err != nil {
+		t.Fatalf("got %v for a response under the limit", err)
+	}
+
+	padding.Store(4096)
+	if _, err := service.GetBTCQuote(context.Background(), "USD"); !errors.Is(err, clients.ErrResponseTooLarge) {
+		t.Errorf("got %v, want ErrResponseTooLarge", err)
+	}
+}
+
+func TestKrakenCallsIdentifyServiceAndRequest(t *testing.T) {
+	var headers atomic.Value
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers.Store(r.Header.Clone())
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"error":[],"result":{"XXBTZUSD":{"c":["65000.1","1"]}}}`))
+	}))
+	t.Cleanup(server.Close)
+	service := clients.NewPriceService(nil, clients.PriceServiceOptions{KrakenBaseURL: server.URL})
+
+	ctx := context.WithValue(context.Background(), middleware.RequestIDKey, "req-123")
+	if _, err := service.GetBTCQuote(ctx, "USD"); err != nil {
+		t.Fatalf("GetBTCQuote: %v", err)
+	}
+	got := headers.Load().(http.Header)
+	if ua := got.Get("User-Agent"); !strings.HasPrefix(ua, "btc-service/") {
+		t.Errorf("got User-Agent %q, want btc-service/<version>", ua)
+	}
+	if id := got.Get("X-Request-ID"); id != "req-123" {
+		t.Errorf("got X-Request-ID %q, want req-123", id)
+	}
+}
+
+func TestGetBTCQuoteRetriesTransientFailures(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"error":[],"result":{"XXBTZUSD":{"c":["65000.1","1"]}}}`))
+	}))
+	t.Cleanup(server.Close)
+
+	policy := clients.RetryPolicy{
+		Policy:            retry.Policy{MaxAttempts: 3, BaseBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond},
+		RetryableStatuses: clients.DefaultRetryableStatuses,
+	}
+	service := clients.NewPriceService(nil, clients.PriceServiceOptions{KrakenBaseURL: server.URL, Retry: policy})
+
+	quote, err := service.GetBTCQuote(context.Background(), "USD")
+	if err != nil || quote.Decimal != "65000.1" {
+		t.Fatalf("got %+v, %v, want the price from the third call", quote, err)
+	}
+
+	// Without retries the first 502 is reported
+	calls.Store(0)
+	service = clients.NewPriceService(nil, clients.PriceServiceOptions{KrakenBaseURL: server.URL})
+	var statusErr *clients.StatusError
+	if _, err := service.GetBTCQuote(context.Background(), "USD"); !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusBadGateway {
+		t.Errorf("got %v, want a 502 status error", err)
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := retry.Policy{BaseBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	for attempts, want := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 4: 800 * time.Millisecond, 5: time.Second, 50: time.Second} {
+		if got := policy.Backoff(attempts); got != want {
+			t.Errorf("Backoff(%d) = %v, want %v", attempts, got, want)
+		}
+	}
+}
+
+func TestCircuitBreakerStopsCallingFailingKraken(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(server.Close)
+
+	priceCache := cache.NewMemory(100)
+	service := clients.NewPriceService(priceCache, clients.PriceServiceOptions{
+		KrakenBaseURL:           server.URL,
+		BreakerFailureThreshold: 2,
+		BreakerOpenDuration:     time.Hour,
+	})
+
+	for range 2 {
+		service.GetBTCQuote(context.Background(), "USD")
+	}
+	if _, err := service.GetBTCQuote(context.Background(), "USD"); !errors.Is(err, breaker.ErrOpen) {
+		t.Errorf("got %v, want the breaker to be open", err)
+	}
+	if got := calls.Load(); got != 2 {
+		t.Errorf("got %d Kraken calls, want 2", got)
+	}
+
+	// While open, a cached price past its TTL is served rather than failing
+	old, _ := json.Marshal(clients.CachedPrice{Decimal: "64000", Timestamp: time.Now().Add(-10 * time.Minute)})
+	priceCache.Set(context.Background(), "price:BTC/USD", old, time.Minute)
+	if quote, err := service.GetBTCQuote(context.Background(), "USD"); err != nil || quote.Decimal != "64000" {
+		t.Errorf("got %+v, %v, want the cached price", quote, err)
+	}
+}
+
+func TestBreakerHalfOpenTrial(t *testing.T) {
+	b := breaker.New("test", 1, 20*time.Millisecond)
+	b.Record(false)
+	if err := b.Allow(); !errors.Is(err, breaker.ErrOpen) {
+		t.Fatalf("got %v, want ErrOpen after the threshold", err)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if err := b.Allow(); err != nil {
+		t.Fatalf("got %v, want a trial call once the open duration passed", err)
+	}
+	if err := b.Allow(); !errors.Is(err, breaker.ErrOpen) {
+		t.Errorf("got %v, want a single trial call", err)
+	}
+	b.Record(true)
+	if b.State() != breaker.StateClosed {
+		t.Errorf("got state %d, want closed after a successful trial", b.State())
+	}
+}
+
+func TestGetBTCQuoteCancelsKrakenCallWithCaller(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	t.Cleanup(server.Close)
+	t.Cleanup(func() { close(release) })
+
+	service := clients.NewPriceService(nil, clients.PriceServiceOptions{
+		KrakenBaseURL:           server.URL,
+		BreakerFailureThreshold: 1,
+		BreakerOpenDuration:     time.Hour,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := service.GetBTCQuote(ctx, "USD"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want the caller's deadline", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("took %v to give up, want about 50ms", elapsed)
+	}
+
+	// The abandoned call doesn't count against Kraken, so the breaker
+	// hasn't opened
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := service.GetBTCQuote(ctx, "USD"); errors.Is(err, breaker.ErrOpen) {
+		t.Errorf("got %v, want Kraken to be called again", err)
+	}
+}
+
+// replayTransport answers every request with a canned Ticker response and
+// records the URLs requested
+type replayTransport struct {
+	body string
+	urls []string
+}
+
+func (rt *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt.urls = append(rt.urls, req.URL.String())
+	rec := httptest.NewRecorder()
+	rec.Header().Set("Content-Type", "application/json")
+	rec.WriteString(rt.body)
+	return rec.Result(), nil
+}
+
+func TestPriceServiceUsesInjectedTransport(t *testing.T) {
+	transport := &replayTransport{body: `{"error":[],"result":{"XXBTZGBP":{"c":["51000.7","1"]}}}`}
+	service := clients.NewPriceService(nil, clients.PriceServiceOptions{
+		KrakenBaseURL: "https://kraken.invalid",
+		HTTPClient:    clients.NewHTTPClient(clients.HTTPClientOptions{Transport: transport}),
+	})
+
+	quote, err := service.GetBTCQuote(context.Background(), "GBP")
+	if err != nil || quote.Decimal != "51000.7" {
+		t.Fatalf("got %+v, %v, want the replayed price", quote, err)
+	}
+	if len(transport.urls) != 1 || transport.urls[0] != "https://kraken.invalid/0/public/Ticker?pair=XBTGBP" {
+		t.Errorf("got requests %v, want one Ticker call", transport.urls)
+	}
+}